@@ -0,0 +1,274 @@
+// Package activity tells users about activity on their posts and comments.
+//
+// The handlers call Hooks after writing a comment or a like. A comment
+// notifies the author of the post and, for a reply, the author of the
+// comment it answers; replies from admins and experts are sent as a
+// distinct, high-priority message. Likes are queued in the LikeQueue
+// instead and announced together once LikeDelay has passed since the first
+// one, so a popular post yields one notification rather than one per like.
+package activity
+
+import (
+	"backend/middleware"
+	"backend/model"
+	"backend/notify"
+	"backend/repository"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultLikeDelay is how long likes are collected before they are
+// announced.
+const DefaultLikeDelay = 10 * time.Minute
+
+// batchSize is the number of queued likes announced per poll.
+const batchSize = 100
+
+// pollInterval is how often queued likes are checked.
+const pollInterval = time.Minute
+
+// titleLength is the number of characters of a post title quoted in a
+// notification.
+const titleLength = 40
+
+// Hooks sends notifications for new comments and likes.
+type Hooks struct {
+	repos      *repository.Repositories
+	dispatcher *notify.Dispatcher
+	// LikeDelay is how long likes are collected before they are announced.
+	LikeDelay time.Duration
+	// Now returns the current time; tests may replace it.
+	Now func() time.Time
+}
+
+// NewHooks returns Hooks with the default like delay.
+func NewHooks(repos *repository.Repositories, dispatcher *notify.Dispatcher) *Hooks {
+	return &Hooks{repos: repos, dispatcher: dispatcher, LikeDelay: DefaultLikeDelay, Now: time.Now}
+}
+
+// CommentAdded notifies the author of post, and the author of the comment
+// being replied to, about comment. The commenter is never notified.
+func (h *Hooks) CommentAdded(ctx context.Context, post model.Post, comment model.Comment) {
+	expert := comment.IsAdmin || comment.Role == middleware.RoleExpert
+	data := map[string]string{"post_id": post.ID, "comment_id": comment.ID}
+
+	notified := map[string]bool{comment.UID: true}
+	if comment.ParentID != "" {
+		if parent, ok := post.Comments[comment.ParentID]; ok {
+			uid := h.author(ctx, parent.UID, parent.Username)
+			if uid != "" && !notified[uid] {
+				notified[uid] = true
+				m := notify.Message{
+					Category: model.CategoryComments,
+					Type:     model.NotificationComment,
+					Title:    comment.Username + " replied to your comment",
+					Body:     excerpt(comment.Content),
+					Data:     data,
+				}
+				if expert {
+					m.Type = model.NotificationReply
+					m.Title = "An expert replied to your comment on " + quote(post.Title, "a post")
+					m.HighPriority = true
+				}
+				h.send(ctx, uid, m)
+			}
+		}
+	}
+
+	uid := h.author(ctx, post.UID, post.Username)
+	if uid == "" || notified[uid] {
+		return
+	}
+	m := notify.Message{
+		Category: model.CategoryComments,
+		Type:     model.NotificationComment,
+		Title:    comment.Username + " commented on " + yourPost(post.Title),
+		Body:     excerpt(comment.Content),
+		Data:     data,
+	}
+	if expert {
+		m.Type = model.NotificationReply
+		m.Title = "An expert answered " + yourPost(post.Title)
+		m.HighPriority = true
+	}
+	h.send(ctx, uid, m)
+}
+
+// PostLiked queues the like, or drops the queued like, of post by username.
+func (h *Hooks) PostLiked(ctx context.Context, post model.Post, username string, liked bool) {
+	if username == post.Username {
+		return
+	}
+	uid := h.author(ctx, post.UID, post.Username)
+	like := repository.PendingLike{UID: uid, PostID: post.ID, Title: post.Title}
+	h.queue(ctx, like, username, liked)
+}
+
+// CommentLiked queues the like, or drops the queued like, of a comment on
+// the post postID by username.
+func (h *Hooks) CommentLiked(ctx context.Context, postID string, comment model.Comment, username string, liked bool) {
+	if username == comment.Username {
+		return
+	}
+	uid := h.author(ctx, comment.UID, comment.Username)
+	like := repository.PendingLike{UID: uid, PostID: postID, CommentID: comment.ID}
+	if post, err := h.repos.Posts.Get(ctx, postID); err == nil {
+		like.Title = post.Title
+	}
+	h.queue(ctx, like, username, liked)
+}
+
+func (h *Hooks) queue(ctx context.Context, like repository.PendingLike, username string, liked bool) {
+	if like.UID == "" {
+		return
+	}
+
+	key := like.UID + "_" + like.PostID
+	if like.CommentID != "" {
+		key += "_" + like.CommentID
+	}
+	var err error
+	if liked {
+		err = h.repos.LikeQueue.Add(ctx, key, like, username, h.Now().Unix())
+	} else {
+		err = h.repos.LikeQueue.Remove(ctx, key, username)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to queue like notification", "uid", like.UID, "error", err)
+	}
+}
+
+// Run announces queued likes every pollInterval until ctx is cancelled.
+func (h *Hooks) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		h.flush(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// flush announces every queued like older than LikeDelay.
+func (h *Hooks) flush(ctx context.Context) {
+	for {
+		due, err := h.repos.LikeQueue.Due(ctx, h.Now().Add(-h.LikeDelay).Unix(), batchSize)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list queued likes", "error", err)
+			return
+		}
+		for key := range due {
+			if ctx.Err() != nil {
+				return
+			}
+			// Take the entry first so that likes arriving meanwhile start a new one
+			like, err := h.repos.LikeQueue.Take(ctx, key)
+			if errors.Is(err, repository.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to take queued likes", "key", key, "error", err)
+				return
+			}
+			h.announce(ctx, like)
+		}
+		if len(due) < batchSize {
+			return
+		}
+	}
+}
+
+// announce sends one notification for the likes collected in like.
+func (h *Hooks) announce(ctx context.Context, like repository.PendingLike) {
+	latest, at := "", int64(-1)
+	for username, t := range like.Likers {
+		if t > at || (t == at && username < latest) {
+			latest, at = username, t
+		}
+	}
+	if latest == "" {
+		return
+	}
+
+	who := latest + " liked"
+	if others := len(like.Likers) - 1; others == 1 {
+		who = latest + " and 1 other liked"
+	} else if others > 1 {
+		who = fmt.Sprintf("%s and %d others liked", latest, others)
+	}
+	target := yourPost(like.Title)
+	data := map[string]string{"post_id": like.PostID}
+	if like.CommentID != "" {
+		target = "your comment on " + quote(like.Title, "a post")
+		data["comment_id"] = like.CommentID
+	}
+
+	h.send(ctx, like.UID, notify.Message{
+		Category: model.CategoryLikes,
+		Type:     model.NotificationLike,
+		Title:    who + " " + target,
+		Data:     data,
+	})
+}
+
+func (h *Hooks) send(ctx context.Context, uid string, m notify.Message) {
+	if _, err := h.dispatcher.Send(ctx, uid, m); err != nil {
+		slog.ErrorContext(ctx, "Failed to send notification", "type", m.Type, "uid", uid, "error", err)
+	}
+}
+
+// author returns the UID of the author of a post or comment. Content that
+// predates author UIDs is looked up by username; deleted authors yield "".
+func (h *Hooks) author(ctx context.Context, uid, username string) string {
+	if uid != "" {
+		return uid
+	}
+	if username == "" || username == repository.DeletedUsername {
+		return ""
+	}
+	uid, _, err := h.repos.Users.FindByUsername(ctx, username)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			slog.ErrorContext(ctx, "Failed to look up author", "username", username, "error", err)
+		}
+		return ""
+	}
+	return uid
+}
+
+// quote returns the title in quotes, shortened to titleLength characters,
+// or untitled when it is empty.
+func quote(title, untitled string) string {
+	if title == "" {
+		return untitled
+	}
+	return `"` + shorten(title, titleLength) + `"`
+}
+
+// yourPost names the reader's post with the given title.
+func yourPost(title string) string {
+	if title == "" {
+		return "your post"
+	}
+	return "your post " + quote(title, "")
+}
+
+// excerpt returns the start of a comment for the notification body.
+func excerpt(content string) string {
+	return shorten(content, 2*titleLength)
+}
+
+func shorten(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,232 @@
+package activity
+
+import (
+	"backend/model"
+	"backend/notify"
+	"backend/push"
+	"backend/repository"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/messaging"
+)
+
+// fakeClient records the pushed messages by device token.
+type fakeClient struct {
+	mu   sync.Mutex
+	sent map[string][]*messaging.Message
+}
+
+func (c *fakeClient) Send(ctx context.Context, m *messaging.Message) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent[m.Token] = append(c.sent[m.Token], m)
+	return "projects/test/messages/1", nil
+}
+
+// setup returns Hooks on memory stores whose clock is at *now. The users
+// alice, bob and carol have a device each, named token-<uid>.
+func setup(t *testing.T, now *time.Time) (*Hooks, *repository.Repositories, *fakeClient) {
+	t.Helper()
+	ctx := context.Background()
+	repos := repository.NewMemory()
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if err := repos.Users.Update(ctx, "uid-"+name, map[string]interface{}{"username": name}); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Devices.Register(ctx, "uid-"+name, repository.Device{Token: "token-uid-" + name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := &fakeClient{sent: map[string][]*messaging.Message{}}
+	h := NewHooks(repos, notify.NewDispatcher(repos, push.NewNotifier(client, repos.Devices)))
+	h.Now = func() time.Time { return *now }
+	return h, repos, client
+}
+
+// inbox returns the titles in the inbox of uid, newest first.
+func inbox(t *testing.T, repos *repository.Repositories, uid string) []string {
+	t.Helper()
+	list, err := repos.Inbox.List(context.Background(), uid, "", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := []string{}
+	for _, n := range list {
+		titles = append(titles, n.Title)
+	}
+	return titles
+}
+
+func assertInbox(t *testing.T, repos *repository.Repositories, uid string, want ...string) {
+	t.Helper()
+	got := inbox(t, repos, uid)
+	if len(got) != len(want) {
+		t.Errorf("inbox of %s = %q, want %q", uid, got, want)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("inbox of %s = %q, want %q", uid, got, want)
+			return
+		}
+	}
+}
+
+// post is by alice and has a comment by bob.
+var post = model.Post{
+	ID:       "p1",
+	UID:      "uid-alice",
+	Username: "alice",
+	Title:    "Sleep training",
+	Comments: map[string]model.Comment{
+		"c1": {ID: "c1", UID: "uid-bob", Username: "bob", Content: "Try white noise"},
+	},
+}
+
+func TestCommentAdded(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment model.Comment
+		want    map[string][]string
+	}{
+		{
+			name:    "comment on the post",
+			comment: model.Comment{ID: "c2", UID: "uid-carol", Username: "carol", Content: "Same here"},
+			want: map[string][]string{
+				"uid-alice": {`carol commented on your post "Sleep training"`},
+				"uid-bob":   {},
+				"uid-carol": {},
+			},
+		},
+		{
+			name:    "reply to a comment",
+			comment: model.Comment{ID: "c2", UID: "uid-carol", Username: "carol", Content: "Thanks", ParentID: "c1"},
+			want: map[string][]string{
+				"uid-alice": {`carol commented on your post "Sleep training"`},
+				"uid-bob":   {"carol replied to your comment"},
+				"uid-carol": {},
+			},
+		},
+		{
+			name:    "post author replies",
+			comment: model.Comment{ID: "c2", UID: "uid-alice", Username: "alice", Content: "Thanks", ParentID: "c1"},
+			want: map[string][]string{
+				"uid-alice": {},
+				"uid-bob":   {"alice replied to your comment"},
+			},
+		},
+		{
+			name:    "reply to own comment",
+			comment: model.Comment{ID: "c2", UID: "uid-bob", Username: "bob", Content: "Also", ParentID: "c1"},
+			want: map[string][]string{
+				"uid-alice": {`bob commented on your post "Sleep training"`},
+				"uid-bob":   {},
+			},
+		},
+		{
+			name:    "comment on own post",
+			comment: model.Comment{ID: "c2", UID: "uid-alice", Username: "alice", Content: "Update"},
+			want: map[string][]string{
+				"uid-alice": {},
+				"uid-bob":   {},
+			},
+		},
+		{
+			name:    "reply to a deleted comment",
+			comment: model.Comment{ID: "c2", UID: "uid-carol", Username: "carol", Content: "Hm", ParentID: "gone"},
+			want: map[string][]string{
+				"uid-alice": {`carol commented on your post "Sleep training"`},
+				"uid-bob":   {},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			h, repos, _ := setup(t, &now)
+			h.CommentAdded(context.Background(), post, tt.comment)
+			for uid, want := range tt.want {
+				assertInbox(t, repos, uid, want...)
+			}
+		})
+	}
+}
+
+func TestCommentAddedByExpert(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	h, repos, client := setup(t, &now)
+	reply := model.Comment{ID: "c2", UID: "uid-carol", Username: "carol", Role: "expert", Content: "Keep a routine", ParentID: "c1"}
+	h.CommentAdded(context.Background(), post, reply)
+
+	assertInbox(t, repos, "uid-alice", `An expert answered your post "Sleep training"`)
+	assertInbox(t, repos, "uid-bob", `An expert replied to your comment on "Sleep training"`)
+	for _, token := range []string{"token-uid-alice", "token-uid-bob"} {
+		sent := client.sent[token]
+		if len(sent) != 1 || sent[0].Data["type"] != model.NotificationReply || sent[0].Android == nil || sent[0].Android.Priority != "high" {
+			t.Errorf("push to %s = %+v, want one high-priority reply", token, sent)
+		}
+	}
+}
+
+func TestCommentAddedFindsLegacyAuthor(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	h, repos, _ := setup(t, &now)
+	legacy := model.Post{ID: "p2", Username: "alice", Title: "Teething"}
+	h.CommentAdded(context.Background(), legacy, model.Comment{ID: "c1", UID: "uid-bob", Username: "bob"})
+	assertInbox(t, repos, "uid-alice", `bob commented on your post "Teething"`)
+
+	deleted := model.Post{ID: "p3", Username: repository.DeletedUsername, Title: "Gone"}
+	h.CommentAdded(context.Background(), deleted, model.Comment{ID: "c1", UID: "uid-bob", Username: "bob"})
+	assertInbox(t, repos, "uid-alice", `bob commented on your post "Teething"`)
+}
+
+func TestLikesAreDebounced(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	h, repos, _ := setup(t, &now)
+
+	h.PostLiked(ctx, post, "alice", true) // Own post
+	h.PostLiked(ctx, post, "bob", true)
+	now = now.Add(time.Minute)
+	h.PostLiked(ctx, post, "carol", true)
+	h.PostLiked(ctx, post, "dave", true)
+	h.PostLiked(ctx, post, "dave", false)
+
+	now = now.Add(h.LikeDelay - 2*time.Minute)
+	h.flush(ctx)
+	assertInbox(t, repos, "uid-alice")
+
+	now = now.Add(time.Minute)
+	h.flush(ctx)
+	assertInbox(t, repos, "uid-alice", `carol and 1 other liked your post "Sleep training"`)
+
+	// The next like starts a new batch
+	h.PostLiked(ctx, post, "erin", true)
+	h.flush(ctx)
+	assertInbox(t, repos, "uid-alice", `carol and 1 other liked your post "Sleep training"`)
+	now = now.Add(h.LikeDelay)
+	h.flush(ctx)
+	assertInbox(t, repos, "uid-alice", `erin liked your post "Sleep training"`, `carol and 1 other liked your post "Sleep training"`)
+}
+
+func TestCommentLikes(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	h, repos, _ := setup(t, &now)
+	if err := repos.Posts.Save(ctx, post); err != nil {
+		t.Fatal(err)
+	}
+	comment := post.Comments["c1"]
+
+	h.CommentLiked(ctx, post.ID, comment, "bob", true) // Own comment
+	h.CommentLiked(ctx, post.ID, comment, "alice", true)
+	h.PostLiked(ctx, post, "carol", true)
+
+	now = now.Add(h.LikeDelay)
+	h.flush(ctx)
+	assertInbox(t, repos, "uid-bob", `alice liked your comment on "Sleep training"`)
+	assertInbox(t, repos, "uid-alice", `carol liked your post "Sleep training"`)
+}
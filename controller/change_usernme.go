@@ -1,65 +1,122 @@
 package controller
 
 import (
+	"backend/middleware"
+	"backend/repository"
 	"backend/utils"
-	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 )
 
 // ChangeUsernameRequest defines the request payload
 type ChangeUsernameRequest struct {
-	UID      string `json:"uid"`
 	Username string `json:"username"`
 }
 
 // ChangeUsernameHandler allows users to update their username
-func ChangeUsernameHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) ChangeUsernameHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
 	var req ChangeUsernameRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
-		log.Printf("Bad Request: %v\n", err)
+		slog.InfoContext(r.Context(), "Bad Request", "error", err)
 		return
 	}
 
 	// Validate request fields
-	if req.UID == "" || req.Username == "" {
-		http.Error(w, "UID and Username are required", http.StatusBadRequest)
-		log.Println("Missing UID or Username in request")
+	if req.Username == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		slog.InfoContext(r.Context(), "Missing Username in request")
+		return
+	}
+	username, err := utils.NormalizeUsername(req.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Check if the username is unique (if present in the database)
-	existingUsers := make(map[string]interface{})
-	err := utils.FirebaseDB.NewRef("users").OrderByChild("username").EqualTo(req.Username).Get(context.Background(), &existingUsers)
+	profile, err := h.repos.Users.Get(r.Context(), caller.UID)
 	if err != nil {
-		http.Error(w, "Failed to check username uniqueness", http.StatusInternalServerError)
-		log.Printf("Error checking username uniqueness: %v\n", err)
+		http.Error(w, "Failed to retrieve user profile", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error retrieving profile", "error", err)
 		return
 	}
 
-	// If the username is already taken, return a conflict
-	if len(existingUsers) > 0 {
-		http.Error(w, "Username already exists", http.StatusConflict)
-		log.Printf("Username conflict: %s", req.Username)
+	// Reserve the new name in the usernames index
+	if err := h.repos.Usernames.Claim(r.Context(), username, caller.UID); err != nil {
+		if errors.Is(err, repository.ErrUsernameTaken) {
+			http.Error(w, "Username already exists", http.StatusConflict)
+			slog.InfoContext(r.Context(), "Username conflict", "username", username)
+			return
+		}
+		http.Error(w, "Failed to reserve username", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error reserving username", "error", err)
 		return
 	}
 
 	// Update the user's username in the database
-	userRef := utils.FirebaseDB.NewRef("users/" + req.UID)
-	if err := userRef.Update(context.Background(), map[string]interface{}{
-		"username": req.Username,
+	if err := h.repos.Users.Update(r.Context(), caller.UID, map[string]interface{}{
+		"username": username,
 	}); err != nil {
 		http.Error(w, "Failed to update username", http.StatusInternalServerError)
-		log.Printf("Error updating username: %v\n", err)
+		slog.ErrorContext(r.Context(), "Error updating username", "error", err)
+		if err := h.repos.Usernames.Release(r.Context(), username, caller.UID); err != nil {
+			slog.ErrorContext(r.Context(), "Error releasing username", "username", username, "error", err)
+		}
 		return
 	}
 
+	// Rewrite the old name in existing content in the background; the job
+	// releases the old name once it is done
+	response := map[string]string{"message": "Username updated successfully"}
+	if profile.Username != "" && profile.Username != username {
+		job, err := h.renamer.Enqueue(r.Context(), caller.UID, profile.Username, username)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error queueing rename", "from", profile.Username, "to", username, "error", err)
+		} else {
+			response["job_id"] = job.ID
+		}
+	}
+
 	// Respond with success
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(map[string]string{"message": "Username updated successfully"}); err != nil {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		http.Error(w, "Failed to write response", http.StatusInternalServerError)
-		log.Printf("Failed to encode response: %v\n", err)
+		slog.ErrorContext(r.Context(), "Failed to encode response", "error", err)
+	}
+}
+
+// GetRenameJobHandler reports the progress of the job that rewrites content
+// after a username change. Only the renamed user and admins may see it.
+func (h *Handlers) GetRenameJobHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Job ID is required", http.StatusBadRequest)
+		return
 	}
+
+	job, err := h.repos.RenameJobs.Get(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && job.UID != caller.UID && caller.Role != middleware.RoleAdmin) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve job", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error retrieving rename job", "id", id, "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
 }
@@ -0,0 +1,296 @@
+package controller
+
+import (
+	"backend/model"
+	"backend/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// maxChildren is the number of child profiles a parent may keep.
+const maxChildren = 10
+
+// legacyChildID is the ID of the child created from the old child_dob field.
+const legacyChildID = "legacy"
+
+// ChildRequest defines the request payload for creating or updating a child
+type ChildRequest struct {
+	Name   string `json:"name"`
+	DOB    string `json:"dob"`    // YYYY-MM-DD
+	Gender string `json:"gender"` // 'male', 'female', 'others'
+	Avatar int    `json:"avatar"` // Optional avatar number (1-10)
+}
+
+// ListChildrenHandler returns the caller's children, oldest profile first.
+func (h *Handlers) ListChildrenHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	children, err := h.listChildren(r.Context(), caller.UID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve children", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to retrieve children", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(children)
+}
+
+// CreateChildHandler adds a child to the caller's account.
+func (h *Handlers) CreateChildHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req ChildRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	child, msg := childFromRequest(req)
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	existing, err := h.repos.Children.List(r.Context(), caller.UID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve children", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to retrieve children", "error", err)
+		return
+	}
+	if len(existing) >= maxChildren {
+		http.Error(w, "Too many child profiles", http.StatusConflict)
+		return
+	}
+
+	child.ID = uuid.New().String()
+	child.CreatedAt = time.Now().Unix()
+	if err := h.repos.Children.Save(r.Context(), caller.UID, child); err != nil {
+		http.Error(w, "Failed to save child", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to save child", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(child)
+}
+
+// GetChildHandler returns one of the caller's children.
+func (h *Handlers) GetChildHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	child, ok := h.loadChild(w, r, caller.UID)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(child)
+}
+
+// UpdateChildHandler replaces the details of one of the caller's children.
+func (h *Handlers) UpdateChildHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req ChildRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	updated, msg := childFromRequest(req)
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	child, ok := h.loadChild(w, r, caller.UID)
+	if !ok {
+		return
+	}
+	updated.ID = child.ID
+	updated.CreatedAt = child.CreatedAt
+	if err := h.repos.Children.Save(r.Context(), caller.UID, updated); err != nil {
+		http.Error(w, "Failed to save child", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to save child", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteChildHandler removes one of the caller's children.
+func (h *Handlers) DeleteChildHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	child, ok := h.loadChild(w, r, caller.UID)
+	if !ok {
+		return
+	}
+	if err := h.repos.Vaccines.DeleteChild(r.Context(), caller.UID, child.ID); err != nil {
+		http.Error(w, "Failed to delete child", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to delete vaccinations", "child_id", child.ID, "error", err)
+		return
+	}
+	if err := h.repos.Growth.DeleteChild(r.Context(), caller.UID, child.ID); err != nil {
+		http.Error(w, "Failed to delete child", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to delete measurements", "child_id", child.ID, "error", err)
+		return
+	}
+	if err := h.repos.Milestones.DeleteChild(r.Context(), caller.UID, child.ID); err != nil {
+		http.Error(w, "Failed to delete child", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to delete milestones", "child_id", child.ID, "error", err)
+		return
+	}
+	if err := h.repos.Children.Delete(r.Context(), caller.UID, child.ID); err != nil {
+		http.Error(w, "Failed to delete child", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to delete child", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Child deleted successfully"})
+}
+
+// loadChild returns the child named by the {id} route variable. It writes a
+// 404 or 500 response and returns false when the child cannot be loaded.
+func (h *Handlers) loadChild(w http.ResponseWriter, r *http.Request, uid string) (model.Child, bool) {
+	id := mux.Vars(r)["id"]
+	child, err := h.repos.Children.Get(r.Context(), uid, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Child not found", http.StatusNotFound)
+		return model.Child{}, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve child", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to retrieve child", "child_id", id, "error", err)
+		return model.Child{}, false
+	}
+	child.ID = id
+	return child, true
+}
+
+// childFromRequest validates req. It returns an error message for the
+// client when the request is invalid.
+func childFromRequest(req ChildRequest) (model.Child, string) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 50 {
+		return model.Child{}, "Name must be 1-50 characters"
+	}
+	dob, err := model.ParseDate(req.DOB)
+	if err != nil {
+		return model.Child{}, "Date of birth must be a date like 2023-04-30"
+	}
+	if dob.After(time.Now()) {
+		return model.Child{}, "Date of birth cannot be in the future"
+	}
+	if req.Gender != "male" && req.Gender != "female" && req.Gender != "others" && req.Gender != "" {
+		return model.Child{}, "Invalid gender option"
+	}
+	if req.Avatar != 0 && (req.Avatar < 1 || req.Avatar > 10) {
+		return model.Child{}, "Invalid avatar value; must be between 1 and 10"
+	}
+	return model.Child{Name: name, DOB: dob, Gender: req.Gender, Avatar: req.Avatar}, ""
+}
+
+// listChildren returns the children of uid sorted by creation time.
+func (h *Handlers) listChildren(ctx context.Context, uid string) ([]model.Child, error) {
+	stored, err := h.repos.Children.List(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	children := make([]model.Child, 0, len(stored))
+	for id, child := range stored {
+		child.ID = id
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].CreatedAt != children[j].CreatedAt {
+			return children[i].CreatedAt < children[j].CreatedAt
+		}
+		return children[i].ID < children[j].ID
+	})
+	return children, nil
+}
+
+// legacyDOBLayouts are the formats the old child_dob field was written in.
+var legacyDOBLayouts = []string{model.DateLayout, "02-01-2006", "02/01/2006", "2006/01/02", time.RFC3339}
+
+// parseLegacyDOB parses a child_dob value in any of legacyDOBLayouts.
+func parseLegacyDOB(value string) (model.Date, error) {
+	value = strings.TrimSpace(value)
+	var err error
+	for _, layout := range legacyDOBLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, value); err == nil {
+			return model.Date{Time: time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)}, nil
+		}
+	}
+	return model.Date{}, err
+}
+
+// saveLegacyChild stores dob as the date of birth of the child created from
+// child_dob, keeping any name or avatar added since. The fixed ID makes the
+// migration safe to repeat.
+func (h *Handlers) saveLegacyChild(ctx context.Context, uid string, dob model.Date) error {
+	child, err := h.repos.Children.Get(ctx, uid, legacyChildID)
+	if errors.Is(err, repository.ErrNotFound) {
+		child = model.Child{CreatedAt: time.Now().Unix()}
+	} else if err != nil {
+		return err
+	}
+	child.ID = legacyChildID
+	child.DOB = dob
+	return h.repos.Children.Save(ctx, uid, child)
+}
+
+// migrateLegacyChild moves the old single child_dob field of a profile into
+// the children collection and reports whether it did. Values that cannot be
+// parsed are left in place.
+func (h *Handlers) migrateLegacyChild(ctx context.Context, uid string, profile model.User) (bool, error) {
+	if profile.ChildDOB == "" {
+		return false, nil
+	}
+	dob, err := parseLegacyDOB(profile.ChildDOB)
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot migrate child_dob", "child_dob", profile.ChildDOB, "uid", uid, "error", err)
+		return false, nil
+	}
+
+	// Only create the child; a later edit through /children wins
+	if _, err := h.repos.Children.Get(ctx, uid, legacyChildID); errors.Is(err, repository.ErrNotFound) {
+		if err := h.saveLegacyChild(ctx, uid, dob); err != nil {
+			return false, err
+		}
+	} else if err != nil {
+		return false, err
+	}
+	if err := h.repos.Users.Update(ctx, uid, map[string]interface{}{"child_dob": nil}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -2,15 +2,14 @@ package controller
 
 import (
 	"backend/model"
-	"backend/utils"
-	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
-// SaveContestHandler allows only admins to update the contest
-func SaveContestHandler(w http.ResponseWriter, r *http.Request) {
+// SaveContestHandler replaces the current contest. Access is restricted to
+// admins by the route policy in main.go.
+func (h *Handlers) SaveContestHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse request body
 	var contest model.Contest
 	if err := json.NewDecoder(r.Body).Decode(&contest); err != nil {
@@ -18,33 +17,8 @@ func SaveContestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Extract user_id from request
-	userID := r.Header.Get("user_id")
-	if userID == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	// Retrieve user role
-	var role string
-	err := utils.FirebaseDB.NewRef("users/"+userID+"/role").Get(context.Background(), &role)
-	if err != nil || role != "admin" {
-		http.Error(w, "Permission denied", http.StatusForbidden)
-		return
-	}
-
-	log.Printf("Retrieved role: %s", role)
-
-	// Clear existing contest before adding the new one
-	err = utils.FirebaseDB.NewRef("contest").Delete(context.Background())
-	if err != nil {
-		http.Error(w, "Failed to delete existing contest", http.StatusInternalServerError)
-		return
-	}
-	log.Println("Existing contest data has been deleted")
-
-	// Save new contest details
-	err = utils.FirebaseDB.NewRef("contest").Set(context.Background(), contest)
+	// Replace the existing contest with the new one
+	err := h.repos.Contest.Replace(r.Context(), contest)
 	if err != nil {
 		http.Error(w, "Failed to save contest details", http.StatusInternalServerError)
 		return
@@ -55,9 +29,8 @@ func SaveContestHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetContestHandler retrieves the current contest details
-func GetContestHandler(w http.ResponseWriter, r *http.Request) {
-	var contest model.Contest
-	err := utils.FirebaseDB.NewRef("contest").Get(context.Background(), &contest)
+func (h *Handlers) GetContestHandler(w http.ResponseWriter, r *http.Request) {
+	contest, err := h.repos.Contest.Get(r.Context())
 	if err != nil {
 		http.Error(w, "Failed to retrieve contest details", http.StatusInternalServerError)
 		return
@@ -66,6 +39,6 @@ func GetContestHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(contest); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		log.Printf("Failed to encode response: %v\n", err)
+		slog.ErrorContext(r.Context(), "Failed to encode response", "error", err)
 	}
 }
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"backend/activity"
+	"backend/deletion"
+	"backend/export"
+	"backend/lockout"
+	"backend/middleware"
+	"backend/notify"
+	"backend/rename"
+	"backend/repository"
+	"backend/session"
+	"backend/vaccination"
+	"context"
+	"net/http"
+	"time"
+)
+
+// Dependencies groups everything the handlers need.
+type Dependencies struct {
+	Repos      *repository.Repositories
+	Sessions   *session.Manager
+	LoginGuard *lockout.Guard
+	Renamer    *rename.Worker
+	Deletions  *deletion.Worker
+	Exports    *export.Worker
+	Dispatcher *notify.Dispatcher
+	Hooks      *activity.Hooks
+	// VaccineSchedule is the national immunization schedule.
+	VaccineSchedule *vaccination.Schedule
+	// TwoFactorRoles lists the roles that must enable two-factor
+	// authentication.
+	TwoFactorRoles []string
+	// EmailVerificationGrace is how long after the first verification email
+	// an unverified account may still sign in.
+	EmailVerificationGrace time.Duration
+}
+
+// Handlers serves the API. Each handler is a method so it reads its
+// dependencies from the Handlers it was built with.
+type Handlers struct {
+	// repos holds the storage dependencies shared by every handler.
+	repos *repository.Repositories
+	// sessions issues and verifies the tokens returned by LoginHandler.
+	sessions *session.Manager
+	// loginGuard throttles repeated failed logins.
+	loginGuard *lockout.Guard
+	// renamer rewrites content after a username change.
+	renamer *rename.Worker
+	// deletions schedules and runs account deletions.
+	deletions *deletion.Worker
+	// exports builds personal data exports.
+	exports *export.Worker
+	// dispatcher notifies users according to their preferences.
+	dispatcher *notify.Dispatcher
+	// hooks notifies authors about comments and likes.
+	hooks *activity.Hooks
+	// vaccineSchedule is the national immunization schedule.
+	vaccineSchedule *vaccination.Schedule
+	// twoFactorRoles is the set of roles that must use two-factor
+	// authentication.
+	twoFactorRoles map[string]bool
+	// emailVerificationGrace is how long unverified accounts may sign in.
+	emailVerificationGrace time.Duration
+}
+
+// New returns the handlers built from d.
+func New(d Dependencies) *Handlers {
+	h := &Handlers{
+		repos:                  d.Repos,
+		sessions:               d.Sessions,
+		loginGuard:             d.LoginGuard,
+		renamer:                d.Renamer,
+		deletions:              d.Deletions,
+		exports:                d.Exports,
+		dispatcher:             d.Dispatcher,
+		hooks:                  d.Hooks,
+		vaccineSchedule:        d.VaccineSchedule,
+		emailVerificationGrace: d.EmailVerificationGrace,
+		twoFactorRoles:         make(map[string]bool, len(d.TwoFactorRoles)),
+	}
+	for _, role := range d.TwoFactorRoles {
+		h.twoFactorRoles[role] = true
+	}
+	return h
+}
+
+// currentUser returns the authenticated caller of the request. It writes a
+// 401 response and returns false when the request is anonymous.
+func currentUser(w http.ResponseWriter, r *http.Request) (middleware.Identity, bool) {
+	id, ok := middleware.IdentityFrom(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}
+	return id, ok
+}
+
+// emailLanguage returns the language uid receives emails in, or "" for the
+// default.
+func (h *Handlers) emailLanguage(ctx context.Context, uid string) string {
+	profile, err := h.repos.Users.Get(ctx, uid)
+	if err != nil {
+		return ""
+	}
+	return profile.Language
+}
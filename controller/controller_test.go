@@ -0,0 +1,32 @@
+package controller
+
+import (
+	"backend/lockout"
+	"backend/middleware"
+	"backend/notify"
+	"backend/push"
+	"backend/repository"
+	"backend/session"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestHandlers returns Handlers on memory repositories.
+func newTestHandlers(t *testing.T) (*Handlers, *repository.Repositories) {
+	t.Helper()
+	repos := repository.NewMemory()
+	h := New(Dependencies{
+		Repos:                  repos,
+		Sessions:               session.NewManager(repos.Sessions, []byte("test-secret")),
+		LoginGuard:             lockout.NewGuard(repos.Attempts),
+		Dispatcher:             notify.NewDispatcher(repos, push.NewNotifier(nil, repos.Devices)),
+		EmailVerificationGrace: 72 * time.Hour,
+	})
+	return h, repos
+}
+
+// asUser returns r as made by the signed-in user uid.
+func asUser(r *http.Request, uid string) *http.Request {
+	return r.WithContext(middleware.WithIdentity(r.Context(), middleware.Identity{UID: uid, Role: "parent"}))
+}
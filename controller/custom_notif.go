@@ -1,34 +1,65 @@
 package controller
 
 import (
-	"backend/utils"
+	"backend/model"
+	"backend/notify"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
+// broadcastTopic is the topic that reaches every user, and the only one
+// /custom-notif accepts.
+const broadcastTopic = "new-videos"
+
+// NotificationRequest defines the request payload for /custom-notif. UIDs
+// sends to those users instead of everyone.
 type NotificationRequest struct {
-	Topic string `json:"topic"`
-	Title string `json:"title"`
-	Body  string `json:"body"`
+	Topic string   `json:"topic"` // Only broadcastTopic, the default
+	UIDs  []string `json:"uids,omitempty"`
+	Title string   `json:"title"`
+	Body  string   `json:"body"`
 }
 
-func CustomNotifHandler(w http.ResponseWriter, r *http.Request) {
-	var notification struct {
-		Title string `json:"title"`
-		Body  string `json:"body"`
-	}
-
+// CustomNotifHandler sends an admin-written notification to every user or
+// to a list of users.
+func (h *Handlers) CustomNotifHandler(w http.ResponseWriter, r *http.Request) {
+	var notification NotificationRequest
 	if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
-		log.Printf("Failed to decode notification body: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to decode notification body", "error", err)
 		return
 	}
+	if notification.Title == "" {
+		http.Error(w, "Title is required", http.StatusBadRequest)
+		return
+	}
+	n := notify.Message{
+		Type:  model.NotificationAnnouncement,
+		Title: notification.Title,
+		Body:  notification.Body,
+	}
 
-	err := utils.SendNotificationToTopic("new-videos", notification.Title, notification.Body)
-	if err != nil {
-		log.Printf("Failed to send notification: %v\n", err)
+	if len(notification.UIDs) > 0 {
+		// SendToUsers logs the users it failed to reach
+		reached, err := h.dispatcher.SendToUsers(r.Context(), notification.UIDs, n)
+		if err != nil && reached == 0 {
+			http.Error(w, "Failed to send notification", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "Notification sent to %d of %d users", reached, len(notification.UIDs))
+		return
+	}
+
+	// Preferences cannot be checked for devices subscribed to other topics
+	if notification.Topic != "" && notification.Topic != broadcastTopic {
+		http.Error(w, "Unknown topic", http.StatusBadRequest)
+		return
+	}
+	if err := h.dispatcher.Broadcast(r.Context(), n); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to send notification", "error", err)
 		http.Error(w, "Failed to send notification", http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// inboxTypes returns the types in the inbox of uid after syncing the
+// broadcasts, newest first.
+func inboxTypes(t *testing.T, h *Handlers, uid string) []string {
+	t.Helper()
+	ctx := context.Background()
+	if err := h.dispatcher.SyncInbox(ctx, uid, time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	list, err := h.repos.Inbox.List(ctx, uid, "", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, n := range list {
+		types = append(types, n.Type)
+	}
+	return types
+}
+
+func TestCustomNotifWithoutPush(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"broadcast", `{"title": "Maintenance tonight"}`, "Notification sent successfully!"},
+		{"users", `{"title": "Maintenance tonight", "uids": ["uid-1"]}`, "Notification sent to 1 of 1 users"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHandlers(t)
+			rec := httptest.NewRecorder()
+			h.CustomNotifHandler(rec, httptest.NewRequest(http.MethodPost, "/custom-notif", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusOK || rec.Body.String() != tt.want {
+				t.Fatalf("POST /custom-notif = %d %q, want 200 %q", rec.Code, rec.Body, tt.want)
+			}
+			if got := inboxTypes(t, h, "uid-1"); len(got) != 1 || got[0] != "announcement" {
+				t.Errorf("inbox = %v, want the announcement", got)
+			}
+		})
+	}
+}
@@ -1,54 +1,126 @@
 package controller
 
 import (
-	"backend/utils"
-	"context"
+	"backend/middleware"
+	"backend/repository"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 )
 
 // DeleteAccountRequest defines the request payload structure.
 type DeleteAccountRequest struct {
-	UID string `json:"uid"` // The UID of the user to be deleted
+	UID string `json:"uid"` // The UID of the user to be deleted; defaults to the caller
+	// Mode is "anonymize" (default) to keep posts and comments under
+	// "deleted user", or "delete" to remove them.
+	Mode string `json:"mode"`
+	// Schedule delays the deletion by the undo window instead of running it now.
+	Schedule bool `json:"schedule"`
 }
 
-// DeleteAccountHandler handles user account deletion.
-func DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+// CancelDeletionRequest defines the request payload for /delete_account/cancel
+type CancelDeletionRequest struct {
+	UID string `json:"uid"` // Defaults to the caller
+}
+
+// DeleteAccountHandler handles user account deletion. Users may delete their
+// own account; admins may delete any account. The deletion runs in the
+// background, either right away or after the undo window.
+func (h *Handlers) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
 	var req DeleteAccountRequest
 
 	// Decode request body
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
-		log.Printf("Bad Request: %v\n", err)
+		slog.InfoContext(r.Context(), "Bad Request", "error", err)
 		return
 	}
 
-	// Ensure UID is provided
+	// Default to the caller's own account
 	if req.UID == "" {
-		http.Error(w, "UID is required", http.StatusBadRequest)
-		log.Println("UID is required for account deletion")
+		req.UID = caller.UID
+	}
+
+	if req.UID != caller.UID && caller.Role != middleware.RoleAdmin {
+		middleware.Forbidden(w, "You can only delete your own account")
+		slog.WarnContext(r.Context(), "User attempted to delete another account", "target", req.UID)
 		return
 	}
 
-	// Delete user from Firebase Authentication
-	err := utils.FirebaseAuth.DeleteUser(context.Background(), req.UID)
-	if err != nil {
-		http.Error(w, "Failed to delete user from authentication", http.StatusInternalServerError)
-		log.Printf("Error deleting user from Firebase Auth: %v\n", err)
+	switch req.Mode {
+	case "":
+		req.Mode = repository.DeletionAnonymize
+	case repository.DeletionAnonymize, repository.DeletionPurge:
+	default:
+		http.Error(w, `Mode must be "anonymize" or "delete"`, http.StatusBadRequest)
 		return
 	}
 
-	// Remove user data from Firebase Realtime Database
-	userRef := utils.FirebaseDB.NewRef("users/" + req.UID)
-	err = userRef.Delete(context.Background())
+	d, err := h.deletions.Schedule(r.Context(), req.UID, caller.UID, req.Mode, req.Schedule)
+	if errors.Is(err, repository.ErrConflict) {
+		http.Error(w, "Account deletion is already scheduled", http.StatusConflict)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to delete user data", http.StatusInternalServerError)
-		log.Printf("Error deleting user data: %v\n", err)
+		http.Error(w, "Failed to schedule account deletion", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error scheduling deletion", "target", req.UID, "error", err)
+		return
+	}
+
+	message := "Account deletion started"
+	if req.Schedule {
+		message = "Account deletion scheduled"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    message,
+		"mode":       d.Mode,
+		"execute_at": d.ExecuteAt,
+	})
+}
+
+// CancelDeletionHandler cancels a scheduled account deletion during its undo
+// window. The same users who may delete an account may cancel its deletion.
+func (h *Handlers) CancelDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req CancelDeletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if req.UID == "" {
+		req.UID = caller.UID
+	}
+	if req.UID != caller.UID && caller.Role != middleware.RoleAdmin {
+		middleware.Forbidden(w, "You can only cancel the deletion of your own account")
+		return
+	}
+
+	err := h.deletions.Cancel(r.Context(), req.UID, caller.UID)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		http.Error(w, "No account deletion is scheduled", http.StatusNotFound)
+		return
+	case errors.Is(err, repository.ErrConflict):
+		http.Error(w, "Account deletion has already started", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to cancel account deletion", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error cancelling deletion", "target", req.UID, "error", err)
 		return
 	}
 
-	// Successfully deleted user
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Account deleted successfully"})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Account deletion cancelled"})
 }
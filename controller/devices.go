@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"backend/repository"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DeviceRequest defines the request payload for /devices
+type DeviceRequest struct {
+	Token    string `json:"token"`    // FCM registration token
+	Platform string `json:"platform"` // 'android', 'ios' or 'web'
+}
+
+// RegisterDeviceHandler stores the FCM token of the caller's app install so
+// that notifications meant for the caller reach it. Registering a token
+// again refreshes it.
+func (h *Handlers) RegisterDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req DeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || len(req.Token) > 4096 {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+	if req.Platform != "android" && req.Platform != "ios" && req.Platform != "web" && req.Platform != "" {
+		http.Error(w, "Invalid platform option", http.StatusBadRequest)
+		return
+	}
+
+	device := repository.Device{Token: req.Token, Platform: req.Platform, UpdatedAt: time.Now().Unix()}
+	if err := h.repos.Devices.Register(r.Context(), caller.UID, device); err != nil {
+		http.Error(w, "Failed to register device", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to register device", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Device registered successfully"})
+}
+
+// UnregisterDeviceHandler removes one of the caller's FCM tokens, e.g. when
+// they sign out on that device.
+func (h *Handlers) UnregisterDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	token := mux.Vars(r)["token"]
+	err := h.repos.Devices.Unregister(r.Context(), caller.UID, token)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to unregister device", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to unregister device", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Device unregistered successfully"})
+}
@@ -1,68 +1,72 @@
 package controller
 
 import (
-	"backend/model"
-	"backend/utils"
-	"context"
+	"backend/mail"
+	"backend/repository"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
+	"time"
 )
 
 // EnterDataRequest structure for the request body
 type EnterDataRequest struct {
-	UID          string `json:"uid"`
 	PhoneNumber  string `json:"phone_number,omitempty"` // Make phone number optional
 	Name         string `json:"name"`
 	Gender       string `json:"gender"` // 'male', 'female', 'others'
 	City         string `json:"city"`
 	ChildDOB     string `json:"child_dob"`
-	ProfileImage int    `json:"profile_image"` // Optional field for profile image number (1-10)
+	ProfileImage int    `json:"profile_image"`      // Optional field for profile image number (1-10)
+	Language     string `json:"language,omitempty"` // Optional language of emails, e.g. "hi"
 }
 
 // EnterDataHandler function to update user data
-func EnterDataHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) EnterDataHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
 	var req EnterDataRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
-		log.Printf("Bad Request: %v\n", err)
+		slog.InfoContext(r.Context(), "Bad Request", "error", err)
 		return
 	}
 
 	// Validate gender
 	if req.Gender != "male" && req.Gender != "female" && req.Gender != "others" && req.Gender != "" {
 		http.Error(w, "Invalid gender option", http.StatusBadRequest)
-		log.Println("Invalid gender option:", req.Gender)
+		slog.InfoContext(r.Context(), "Invalid gender option", "gender", req.Gender)
 		return
 	}
 
 	// If the phone number is provided and it needs to be updated, check if it's unique
 	if req.PhoneNumber != "" {
-		existingUsers := make(map[string]model.User) // Use a map to hold existing users
-		err := utils.FirebaseDB.NewRef("users").OrderByChild("phone_number").EqualTo(req.PhoneNumber).Get(context.Background(), &existingUsers)
-		if err != nil {
+		ownerUID, _, err := h.repos.Users.FindByPhone(r.Context(), req.PhoneNumber)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
 			http.Error(w, "Failed to check phone number uniqueness", http.StatusInternalServerError)
-			log.Printf("Failed to check phone number uniqueness: %v\n", err)
+			slog.ErrorContext(r.Context(), "Failed to check phone number uniqueness", "error", err)
 			return
 		}
 
-		// If a user with the specified phone number exists, return a conflict status
-		if len(existingUsers) > 0 {
+		// If another user with the specified phone number exists, return a conflict status
+		if err == nil && ownerUID != caller.UID {
 			http.Error(w, "Phone number already exists", http.StatusConflict)
-			log.Println("Phone number already exists:", req.PhoneNumber)
+			slog.InfoContext(r.Context(), "Phone number already exists")
 			return
 		}
 	}
 
-	// Retrieve the UID from the request
-	uid := req.UID
+	// Always update the authenticated caller's own profile
+	uid := caller.UID
 
 	// Create a map to update only non-empty fields
 	updateData := map[string]interface{}{
-		"name":      req.Name,
-		"gender":    req.Gender,
-		"city":      req.City,
-		"child_dob": req.ChildDOB,
+		"name":   req.Name,
+		"gender": req.Gender,
+		"city":   req.City,
 	}
 
 	// If a new phone number is provided, include it in the update data
@@ -79,18 +83,46 @@ func EnterDataHandler(w http.ResponseWriter, r *http.Request) {
 		updateData["profile_image"] = req.ProfileImage
 	}
 
-	// Update the user's details in Firebase Database
-	userRef := utils.FirebaseDB.NewRef("users/" + uid)
-	if err := userRef.Update(context.Background(), updateData); err != nil {
+	// A language, if provided, must be one emails are available in
+	if req.Language != "" {
+		if !mail.SupportsLanguage(req.Language) {
+			http.Error(w, "Unsupported language", http.StatusBadRequest)
+			return
+		}
+		updateData["language"] = req.Language
+	}
+
+	// A child_dob sent by older clients sets the date of birth of the child
+	// migrated from it, and the field itself is cleared
+	if req.ChildDOB != "" {
+		dob, err := parseLegacyDOB(req.ChildDOB)
+		if err != nil || dob.After(time.Now()) {
+			http.Error(w, "Invalid child date of birth", http.StatusBadRequest)
+			return
+		}
+		if err := h.saveLegacyChild(r.Context(), uid, dob); err != nil {
+			http.Error(w, "Failed to update user data", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Failed to save child", "error", err)
+			return
+		}
+		updateData["child_dob"] = nil
+	} else if profile, err := h.repos.Users.Get(r.Context(), uid); err == nil {
+		if _, err := h.migrateLegacyChild(r.Context(), uid, profile); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to migrate child_dob", "error", err)
+		}
+	}
+
+	// Update the user's details
+	if err := h.repos.Users.Update(r.Context(), uid, updateData); err != nil {
 		http.Error(w, "Failed to update user data", http.StatusInternalServerError)
-		log.Printf("Error updating user data: %v\n", err)
+		slog.ErrorContext(r.Context(), "Error updating user data", "error", err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(map[string]string{"message": "User data updated successfully"}); err != nil {
 		http.Error(w, "Failed to write response", http.StatusInternalServerError)
-		log.Printf("Failed to encode response: %v\n", err)
+		slog.ErrorContext(r.Context(), "Failed to encode response", "error", err)
 		return
 	}
 }
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"backend/export"
+	"backend/repository"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RequestExportHandler queues a copy of the caller's data. The download link
+// is emailed when the archive is ready.
+func (h *Handlers) RequestExportHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	next, err := h.exports.Request(r.Context(), caller.UID)
+	if errors.Is(err, export.ErrTooSoon) {
+		wait := time.Until(next)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Only one data export can be requested per day", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to request data export", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to request data export", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Your data export has been requested; we will email you a download link",
+	})
+}
+
+// GetExportHandler reports the state of the caller's latest data export.
+func (h *Handlers) GetExportHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	e, err := h.repos.Exports.Get(r.Context(), caller.UID)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "No data export requested", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve data export", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to retrieve data export", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":       e.Status,
+		"requested_at": e.RequestedAt,
+		"ready_at":     e.ReadyAt,
+		"expires_at":   e.ExpiresAt,
+	})
+}
+
+// DownloadExportHandler serves the archive for the token in the emailed
+// link. The token is the only credential needed.
+func (h *Handlers) DownloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	archive, err := h.exports.Download(r.Context(), token)
+	switch {
+	case errors.Is(err, export.ErrInvalidToken):
+		http.Error(w, "Invalid download link", http.StatusNotFound)
+		return
+	case errors.Is(err, export.ErrExpired):
+		http.Error(w, "Download link expired", http.StatusGone)
+		return
+	case err != nil:
+		http.Error(w, "Failed to retrieve data export", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to retrieve data export archive", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="wegrow-data-export.json"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(archive))
+}
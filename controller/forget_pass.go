@@ -1,25 +1,68 @@
 package controller
 
 import (
+	"backend/repository"
 	"backend/utils"
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"time"
 )
 
+// passwordResetTTL is how long an emailed reset link stays valid.
+const passwordResetTTL = time.Hour
+
 type ForgotPasswordRequest struct {
 	Email string `json:"email"`
 }
 
-func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+// ForgotPasswordHandler emails a single-use password reset link. It answers
+// the same way whether or not the email is registered.
+func (h *Handlers) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	var req ForgotPasswordRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
 
-	err := utils.SendPasswordResetEmail(req.Email)
+	account, err := h.repos.Accounts.GetByEmail(r.Context(), req.Email)
+	if errors.Is(err, repository.ErrNotFound) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Password reset email sent successfully"))
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to send password reset email", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to look up account for password reset", "error", err)
+		return
+	}
+
+	// Only the hash of the token is stored; the token itself goes in the email
+	token, err := utils.RandomToken()
 	if err != nil {
 		http.Error(w, "Failed to send password reset email", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to generate reset token", "error", err)
+		return
+	}
+	now := time.Now()
+	reset := repository.PasswordReset{
+		TokenHash: utils.HashToken(token),
+		UID:       account.UID,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(passwordResetTTL).Unix(),
+	}
+	if err := h.repos.Resets.Create(r.Context(), reset); err != nil {
+		http.Error(w, "Failed to send password reset email", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to save reset token", "error", err)
+		return
+	}
+
+	link := utils.AppBaseURL() + "/reset-password?token=" + url.QueryEscape(token)
+	if err := utils.SendPasswordResetEmail(account.Email, h.emailLanguage(r.Context(), account.UID), link); err != nil {
+		http.Error(w, "Failed to send password reset email", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to send password reset email", "error", err)
 		return
 	}
 
@@ -1,15 +1,15 @@
 package controller
 
 import (
-	"backend/utils" // Make sure this package exposes your initialized Firebase Database (FirebaseDB)
-	"context"
+	"backend/repository"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 )
 
 // GetProfileImageHandler retrieves the profile image number for a given username.
-func GetProfileImageHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetProfileImageHandler(w http.ResponseWriter, r *http.Request) {
 	// Get the "username" parameter from the query string.
 	username := r.URL.Query().Get("username")
 	if username == "" {
@@ -17,34 +17,21 @@ func GetProfileImageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Reference the "users" node.
-	ref := utils.FirebaseDB.NewRef("users")
-
 	// Query for the user with the specified username.
-	var users map[string]map[string]interface{}
-	err := ref.OrderByChild("username").EqualTo(username).Get(context.Background(), &users)
-	if err != nil {
-		log.Printf("Error querying user by username %s: %v", username, err)
-		http.Error(w, "Error querying user", http.StatusInternalServerError)
-		return
-	}
-
-	// Check if any user was found.
-	if users == nil || len(users) == 0 {
+	_, user, err := h.repos.Users.FindByUsername(r.Context(), username)
+	if errors.Is(err, repository.ErrNotFound) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
-
-	// Retrieve the profile_image field from the first matching user.
-	var profileImage interface{}
-	for _, user := range users {
-		if v, ok := user["profile_image"]; ok {
-			profileImage = v
-			break
-		}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error querying user", "username", username, "error", err)
+		http.Error(w, "Error querying user", http.StatusInternalServerError)
+		return
 	}
 
-	if profileImage == nil {
+	// Retrieve the profile_image field from the matching user.
+	profileImage := user.ProfileImage
+	if profileImage == 0 {
 		http.Error(w, "Profile image not set for user", http.StatusNotFound)
 		return
 	}
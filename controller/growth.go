@@ -0,0 +1,263 @@
+package controller
+
+import (
+	"backend/growth"
+	"backend/model"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// MeasurementRequest defines the request payload for recording a growth
+// measurement
+type MeasurementRequest struct {
+	Date     string  `json:"date"` // YYYY-MM-DD, today if empty
+	WeightKG float64 `json:"weight_kg"`
+	HeightCM float64 `json:"height_cm"`
+	HeadCM   float64 `json:"head_cm"`
+}
+
+// AssessedMeasurement is a measurement with the child's age and the scores
+// of each measured value.
+type AssessedMeasurement struct {
+	model.Measurement
+	AgeMonths float64                 `json:"age_months"`
+	Scores    map[string]growth.Score `json:"scores,omitempty"`
+}
+
+// ChartPoint is one measurement on a growth chart.
+type ChartPoint struct {
+	Date      model.Date `json:"date"`
+	AgeMonths float64    `json:"age_months"`
+	Value     float64    `json:"value"`
+	*growth.Score
+}
+
+// ListGrowthHandler returns a child's measurements, oldest first, with WHO
+// z-scores and percentiles.
+func (h *Handlers) ListGrowthHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	child, ok := h.loadChild(w, r, caller.UID)
+	if !ok {
+		return
+	}
+	measurements, ok := h.loadMeasurements(w, r, caller.UID, child)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(measurements)
+}
+
+// RecordGrowthHandler stores a measurement of one of the caller's children
+// and returns it with its scores.
+func (h *Handlers) RecordGrowthHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req MeasurementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if req.WeightKG == 0 && req.HeightCM == 0 && req.HeadCM == 0 {
+		http.Error(w, "At least one of weight_kg, height_cm and head_cm is required", http.StatusBadRequest)
+		return
+	}
+	if req.WeightKG != 0 && (req.WeightKG < 0.5 || req.WeightKG > 60) ||
+		req.HeightCM != 0 && (req.HeightCM < 30 || req.HeightCM > 150) ||
+		req.HeadCM != 0 && (req.HeadCM < 20 || req.HeadCM > 65) {
+		http.Error(w, "Measurement out of range", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	date := model.Date{Time: time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)}
+	if req.Date != "" {
+		d, err := model.ParseDate(req.Date)
+		if err != nil {
+			http.Error(w, "Date must be a date like 2023-04-30", http.StatusBadRequest)
+			return
+		}
+		date = d
+	}
+
+	child, ok := h.loadChild(w, r, caller.UID)
+	if !ok {
+		return
+	}
+	if date.Before(child.DOB.Time) || date.After(now) {
+		http.Error(w, "Date must be between the date of birth and today", http.StatusBadRequest)
+		return
+	}
+
+	m := model.Measurement{
+		ID:         uuid.New().String(),
+		Date:       date,
+		WeightKG:   req.WeightKG,
+		HeightCM:   req.HeightCM,
+		HeadCM:     req.HeadCM,
+		RecordedAt: now.Unix(),
+	}
+	if err := h.repos.Growth.Save(r.Context(), caller.UID, child.ID, m); err != nil {
+		http.Error(w, "Failed to save measurement", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to save measurement", "child_id", child.ID, "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(assess(child, m))
+}
+
+// DeleteGrowthHandler removes a measurement recorded by mistake.
+func (h *Handlers) DeleteGrowthHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	child, ok := h.loadChild(w, r, caller.UID)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["measurement_id"]
+	if err := h.repos.Growth.Delete(r.Context(), caller.UID, child.ID, id); err != nil {
+		http.Error(w, "Failed to delete measurement", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to delete measurement", "measurement_id", id, "child_id", child.ID, "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Measurement deleted successfully"})
+}
+
+// GrowthChartHandler returns the data for a growth chart of one indicator
+// (?indicator=weight, height or head): the child's measurements and the WHO
+// 3rd, 15th, 50th, 85th and 97th percentile curves.
+func (h *Handlers) GrowthChartHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	indicator := r.URL.Query().Get("indicator")
+	child, ok := h.loadChild(w, r, caller.UID)
+	if !ok {
+		return
+	}
+	curves, err := growth.Curves(indicator, child.Gender)
+	switch {
+	case errors.Is(err, growth.ErrUnknownIndicator):
+		http.Error(w, "Indicator must be weight, height or head", http.StatusBadRequest)
+		return
+	case errors.Is(err, growth.ErrUnknownSex):
+		http.Error(w, "Growth charts need the child's gender to be male or female", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to build growth chart", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to build growth curves", "indicator", indicator, "error", err)
+		return
+	}
+
+	measurements, ok := h.loadMeasurements(w, r, caller.UID, child)
+	if !ok {
+		return
+	}
+	series := []ChartPoint{}
+	for _, m := range measurements {
+		value := measuredValue(m.Measurement, indicator)
+		if value == 0 {
+			continue
+		}
+		point := ChartPoint{Date: m.Date, AgeMonths: m.AgeMonths, Value: value}
+		// Measurements beyond the reference range are plotted without a score
+		if score, ok := m.Scores[indicator]; ok {
+			point.Score = &score
+		}
+		series = append(series, point)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"indicator": indicator,
+		"sex":       child.Gender,
+		"series":    series,
+		"curves":    curves,
+	})
+}
+
+// loadMeasurements returns the assessed measurements of child sorted by
+// date. It writes a 500 response and returns false when they cannot be
+// loaded.
+func (h *Handlers) loadMeasurements(w http.ResponseWriter, r *http.Request, uid string, child model.Child) ([]AssessedMeasurement, bool) {
+	stored, err := h.repos.Growth.List(r.Context(), uid, child.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve measurements", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to retrieve measurements", "child_id", child.ID, "error", err)
+		return nil, false
+	}
+	measurements := make([]AssessedMeasurement, 0, len(stored))
+	for id, m := range stored {
+		m.ID = id
+		measurements = append(measurements, assess(child, m))
+	}
+	sort.Slice(measurements, func(i, j int) bool {
+		if !measurements[i].Date.Equal(measurements[j].Date.Time) {
+			return measurements[i].Date.Before(measurements[j].Date.Time)
+		}
+		return measurements[i].RecordedAt < measurements[j].RecordedAt
+	})
+	return measurements, true
+}
+
+// assess scores each value of m against the WHO reference for the child's
+// sex and age. Values that cannot be scored, for example because the child
+// is older than five years or has no gender set, are left without a score.
+func assess(child model.Child, m model.Measurement) AssessedMeasurement {
+	days := m.Date.Sub(child.DOB.Time).Hours() / 24
+	a := AssessedMeasurement{
+		Measurement: m,
+		AgeMonths:   math.Round(days/growth.DaysPerMonth*10) / 10,
+	}
+	for _, indicator := range growth.Indicators {
+		value := measuredValue(m, indicator)
+		if value == 0 {
+			continue
+		}
+		score, err := growth.Assess(indicator, child.Gender, days/growth.DaysPerMonth, value)
+		if err != nil {
+			continue
+		}
+		if a.Scores == nil {
+			a.Scores = make(map[string]growth.Score)
+		}
+		a.Scores[indicator] = score
+	}
+	return a
+}
+
+func measuredValue(m model.Measurement, indicator string) float64 {
+	switch indicator {
+	case growth.Weight:
+		return m.WeightKG
+	case growth.Height:
+		return m.HeightCM
+	case growth.Head:
+		return m.HeadCM
+	}
+	return 0
+}
@@ -2,19 +2,22 @@ package controller
 
 import (
 	"backend/model"
+	"backend/repository"
 	"backend/utils"
 	"bytes"
-	"context"
 	"encoding/json"
 	"io"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	// Read the request body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -34,25 +37,35 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Refuse the attempt while the email or IP is backing off or locked
+	ip := utils.ClientIP(r)
+	status, err := h.loginGuard.Check(r.Context(), user.Email, ip)
+	if err != nil {
+		http.Error(w, "Failed to check login attempts", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to check login attempts", "error", err)
+		return
+	}
+	if status.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(status.RetryAfter.Seconds()))))
+		if status.Locked {
+			http.Error(w, "Account temporarily locked due to too many failed attempts", http.StatusTooManyRequests)
+		} else {
+			http.Error(w, "Too many failed attempts, please try again later", http.StatusTooManyRequests)
+		}
+		return
+	}
+
 	// Authenticate user by email
-	u, err := utils.FirebaseAuth.GetUserByEmail(context.Background(), user.Email)
+	u, err := h.repos.Accounts.GetByEmail(r.Context(), user.Email)
 	if err != nil {
+		h.recordLoginFailure(r, user.Email, ip, repository.Account{})
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	// Email verification check is commented out.
-	/*
-		if !u.EmailVerified {
-			http.Error(w, "Email not verified", http.StatusUnauthorized)
-			return
-		}
-	*/
-
-	// Retrieve hashed password from Firebase Database
-	var hashedPassword string
-	err = utils.FirebaseDB.NewRef("users/"+u.UID+"/hashed_password").Get(context.Background(), &hashedPassword)
-	if err != nil || hashedPassword == "" {
+	// Retrieve hashed password
+	hashedPassword, err := h.repos.Users.HashedPassword(r.Context(), u.UID)
+	if err != nil {
 		http.Error(w, "Failed to retrieve user password", http.StatusInternalServerError)
 		return
 	}
@@ -62,46 +75,128 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Compare stored hashed password with the provided password
 	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(inputPassword)); err != nil {
+		h.recordLoginFailure(r, user.Email, ip, u)
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	// Retrieve user's role from Firebase Database
-	var role string
-	err = utils.FirebaseDB.NewRef("users/"+u.UID+"/role").Get(context.Background(), &role)
-	if err != nil || role == "" {
-		http.Error(w, "Failed to retrieve user role", http.StatusInternalServerError)
-		return
+	if err := h.loginGuard.Succeed(r.Context(), user.Email); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to clear login attempts", "uid", u.UID, "error", err)
 	}
 
-	// Retrieve username from Firebase Database
-	var username string
-	err = utils.FirebaseDB.NewRef("users/"+u.UID+"/username").Get(context.Background(), &username)
+	// Unverified accounts may sign in, read-only, during the grace period
+	allowed, err := h.checkEmailVerification(r.Context(), u)
 	if err != nil {
-		log.Printf("Username not found for user %s. Defaulting to empty string.", u.UID)
-		username = ""
+		http.Error(w, "Failed to check email verification", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to check email verification", "uid", u.UID, "error", err)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Email not verified", http.StatusUnauthorized)
+		return
 	}
 
-	// Retrieve profile image from Firebase Database; default to 1 if retrieval fails.
-	var profileImage int
-	err = utils.FirebaseDB.NewRef("users/"+u.UID+"/profile_image").Get(context.Background(), &profileImage)
+	// With two-factor authentication the password only earns a challenge
+	// that must be completed at /login/2fa
+	tf, err := h.repos.TwoFactor.Get(r.Context(), u.UID)
 	if err != nil {
-		log.Printf("Failed to retrieve profile image for user %s. Defaulting to 1.", u.UID)
+		http.Error(w, "Failed to retrieve two-factor settings", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to retrieve two-factor settings", "uid", u.UID, "error", err)
+		return
+	}
+	if tf.Enabled {
+		challenge, err := h.sessions.IssueChallenge(u.UID)
+		if err != nil {
+			http.Error(w, "Failed to create login challenge", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Failed to create login challenge", "uid", u.UID, "error", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"user_id":             u.UID,
+			"two_factor_required": true,
+			"challenge":           challenge,
+		})
+		return
+	}
+
+	h.writeLoginResponse(w, r, u.UID, false)
+}
+
+// writeLoginResponse starts a session for uid and writes the profile summary
+// and tokens returned by the login endpoints.
+func (h *Handlers) writeLoginResponse(w http.ResponseWriter, r *http.Request, uid string, mfa bool) {
+	// Retrieve user's profile
+	profile, err := h.repos.Users.Get(r.Context(), uid)
+	if err != nil || profile.Role == "" {
+		http.Error(w, "Failed to retrieve user role", http.StatusInternalServerError)
+		return
+	}
+	role := profile.Role
+
+	username := profile.Username
+	if username == "" {
+		slog.InfoContext(r.Context(), "Username not found; defaulting to empty string", "uid", uid)
+	}
+
+	// Default the profile image to 1 if it was never set.
+	profileImage := profile.ProfileImage
+	if profileImage == 0 {
+		slog.ErrorContext(r.Context(), "Failed to retrieve profile image; defaulting to 1", "uid", uid)
 		profileImage = 1
 	}
 
-	// Prepare response payload with UID, username, role, and profile image
+	// Start a session for this device
+	tokens, err := h.sessions.Issue(r.Context(), uid, r.UserAgent(), mfa)
+	if err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to create session", "uid", uid, "error", err)
+		return
+	}
+
+	// Prepare response payload with UID, username, role, profile image and tokens
 	response := map[string]interface{}{
-		"user_id":       u.UID,
-		"username":      username,
-		"role":          role,
-		"profile_image": profileImage,
+		"user_id":        uid,
+		"username":       username,
+		"role":           role,
+		"profile_image":  profileImage,
+		"access_token":   tokens.AccessToken,
+		"refresh_token":  tokens.RefreshToken,
+		"token_type":     tokens.TokenType,
+		"expires_in":     tokens.ExpiresIn,
+		"email_verified": profile.EmailVerified,
+	}
+	// Roles that must use two-factor authentication are told to enrol
+	if !profile.TwoFactorEnabled && h.twoFactorRoles[role] {
+		response["two_factor_setup_required"] = true
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		http.Error(w, "Failed to write response", http.StatusInternalServerError)
-		log.Printf("Failed to encode response: %v\n", err)
+		slog.ErrorContext(r.Context(), "Failed to encode response", "error", err)
 	}
 }
+
+// recordLoginFailure counts a failed login. When the failure locks the
+// account, its owner is notified (the zero Account for unknown emails).
+func (h *Handlers) recordLoginFailure(r *http.Request, email, ip string, owner repository.Account) {
+	locked, err := h.loginGuard.Fail(r.Context(), email, ip)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to record login failure", "error", err)
+		return
+	}
+	if !locked || owner.Email == "" {
+		return
+	}
+
+	until := time.Now().Add(h.loginGuard.LockDuration)
+	lang := h.emailLanguage(r.Context(), owner.UID)
+	go func() {
+		if err := utils.SendAccountLockedEmail(owner.Email, lang, until); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to send account locked email", "error", err)
+		}
+	}()
+}
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"backend/lockout"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// createTestAccount registers parent@example.com with the given password and
+// profile fields, and returns its UID.
+func createTestAccount(t *testing.T, h *Handlers, password string, profile map[string]interface{}) string {
+	t.Helper()
+	ctx := context.Background()
+	account, err := h.repos.Accounts.Create(ctx, "parent@example.com", password, "parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]interface{}{"username": "parent", "role": "parent", "hashed_password": string(hash)}
+	for k, v := range profile {
+		fields[k] = v
+	}
+	if err := h.repos.Users.Update(ctx, account.UID, fields); err != nil {
+		t.Fatal(err)
+	}
+	return account.UID
+}
+
+func login(h *Handlers, email, password string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"email": "` + email + `", "password": "` + password + `"}`)
+	h.LoginHandler(rec, httptest.NewRequest(http.MethodPost, "/login", body))
+	return rec
+}
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	h, repos := newTestHandlers(t)
+	uid := createTestAccount(t, h, "secret-password", nil)
+	if err := repos.Accounts.SetEmailVerified(ctx, uid, true); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := login(h, "parent@example.com", "secret-password")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /login = %d %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		UserID        string `json:"user_id"`
+		AccessToken   string `json:"access_token"`
+		RefreshToken  string `json:"refresh_token"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.UserID != uid || resp.AccessToken == "" || resp.RefreshToken == "" || !resp.EmailVerified {
+		t.Errorf("login response = %+v", resp)
+	}
+	if claims, err := h.sessions.Verify(ctx, resp.AccessToken); err != nil || claims.Subject != uid {
+		t.Errorf("access token claims = %+v, %v", claims, err)
+	}
+	// The middleware reads the verification from the profile
+	if profile, err := repos.Users.Get(ctx, uid); err != nil || !profile.EmailVerified {
+		t.Errorf("profile after login = %+v, %v", profile, err)
+	}
+}
+
+func TestLoginAfterVerificationGrace(t *testing.T) {
+	h, _ := newTestHandlers(t)
+	createTestAccount(t, h, "secret-password", map[string]interface{}{
+		"verification_sent_at": time.Now().Add(-73 * time.Hour).Unix(),
+	})
+	if rec := login(h, "parent@example.com", "secret-password"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("POST /login = %d %s, want %d", rec.Code, rec.Body, http.StatusUnauthorized)
+	}
+}
+
+func TestLoginLocksAfterRepeatedFailures(t *testing.T) {
+	h, _ := newTestHandlers(t)
+	createTestAccount(t, h, "secret-password", nil)
+	now := time.Now()
+	h.loginGuard.Now = func() time.Time { return now }
+
+	for i := 0; i < lockout.DefaultMaxFailures; i++ {
+		if rec := login(h, "parent@example.com", "wrong-password"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failed login %d = %d %s", i+1, rec.Code, rec.Body)
+		}
+		// Wait out the backoff
+		now = now.Add(lockout.DefaultMaxDelay)
+	}
+
+	rec := login(h, "parent@example.com", "secret-password")
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "locked") || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("login while locked = %d %q", rec.Code, rec.Body)
+	}
+
+	now = now.Add(lockout.DefaultLockDuration)
+	if rec := login(h, "parent@example.com", "secret-password"); rec.Code != http.StatusOK {
+		t.Errorf("login after the lock = %d %s", rec.Code, rec.Body)
+	}
+}
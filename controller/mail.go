@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"backend/mail"
+	"backend/utils"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// maxDeadLetters is the largest page of ListDeadLettersHandler.
+const maxDeadLetters = 500
+
+// DeadLetter is an email that could not be sent, as shown to admins. Bodies
+// are never shown as they may hold links that sign in as the recipient.
+type DeadLetter struct {
+	ID        string `json:"id"`
+	To        string `json:"to"`
+	Subject   string `json:"subject"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+	FailedAt  int64  `json:"failed_at"`
+}
+
+// ListDeadLettersHandler returns the emails that could not be sent, most
+// recent failure first. Access is restricted to admins by the route policy
+// in main.go.
+func (h *Handlers) ListDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		l, err := strconv.Atoi(limitParam)
+		if err != nil || l < 1 || l > maxDeadLetters {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	letters, err := h.repos.Outbox.DeadLetters(r.Context(), limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch dead letters", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to fetch dead letters", "error", err)
+		return
+	}
+
+	list := make([]DeadLetter, 0, len(letters))
+	for _, e := range letters {
+		list = append(list, DeadLetter{
+			ID:        e.ID,
+			To:        e.To,
+			Subject:   e.Subject,
+			Attempts:  e.Attempts,
+			LastError: e.LastError,
+			FailedAt:  e.FailedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// DeleteDeadLetterHandler discards a dead letter.
+func (h *Handlers) DeleteDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := h.repos.Outbox.DeleteDead(r.Context(), id); err != nil {
+		http.Error(w, "Failed to delete dead letter", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to delete dead letter", "id", id, "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Dead letter deleted"})
+}
+
+// ListEmailTemplatesHandler returns the names of the email templates and
+// the languages they are available in.
+func (h *Handlers) ListEmailTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{
+		"templates": mail.Templates(),
+		"languages": mail.Languages(),
+	})
+}
+
+// PreviewEmailHandler renders an email template with sample data. The
+// language is taken from ?lang= and ?format=text shows the plain-text
+// version instead of the HTML one.
+func (h *Handlers) PreviewEmailHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["template"]
+	lang := r.URL.Query().Get("lang")
+	if lang == "" {
+		lang = mail.DefaultLanguage
+	}
+	if !mail.SupportsLanguage(lang) {
+		http.Error(w, "Unsupported language", http.StatusBadRequest)
+		return
+	}
+
+	m, err := utils.PreviewEmail(name, lang)
+	if errors.Is(err, mail.ErrUnknownTemplate) {
+		http.Error(w, "Email template not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to render email", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to render email preview", "template", name, "error", err)
+		return
+	}
+
+	w.Header().Set("X-Email-Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	switch r.URL.Query().Get("format") {
+	case "", "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(m.HTML))
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(m.Subject + "\n\n" + m.Text))
+	default:
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"backend/milestone"
+	"backend/model"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AchieveMilestoneRequest defines the request payload for marking a milestone
+// as achieved
+type AchieveMilestoneRequest struct {
+	MilestoneID string `json:"milestone_id"`
+	AchievedOn  string `json:"achieved_on"` // YYYY-MM-DD, today if empty
+}
+
+// GetMilestonesHandler returns the milestone catalogue ordered by age,
+// optionally filtered with ?category=.
+func (h *Handlers) GetMilestonesHandler(w http.ResponseWriter, r *http.Request) {
+	catalogue, err := h.repos.Milestones.Catalogue(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to retrieve milestones", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to retrieve milestones", "error", err)
+		return
+	}
+
+	category := r.URL.Query().Get("category")
+	milestones := make([]model.Milestone, 0, len(catalogue))
+	for id, m := range catalogue {
+		if category != "" && m.Category != category {
+			continue
+		}
+		m.ID = id
+		milestones = append(milestones, m)
+	}
+	sort.Slice(milestones, func(i, j int) bool {
+		if milestones[i].AgeMonths != milestones[j].AgeMonths {
+			return milestones[i].AgeMonths < milestones[j].AgeMonths
+		}
+		return milestones[i].ID < milestones[j].ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(milestones)
+}
+
+// GetChildMilestonesHandler returns the status of every milestone for one of
+// the caller's children, flagging those that are overdue for the child's age.
+func (h *Handlers) GetChildMilestonesHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	child, ok := h.loadChild(w, r, caller.UID)
+	if !ok {
+		return
+	}
+	catalogue, err := h.repos.Milestones.Catalogue(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to retrieve milestones", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to retrieve milestones", "error", err)
+		return
+	}
+	achievements, err := h.repos.Milestones.Achievements(r.Context(), caller.UID, child.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve milestones", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to retrieve milestones", "child_id", child.ID, "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Child model.Child `json:"child"`
+		milestone.Progress
+	}{child, milestone.Track(catalogue, achievements, child.DOB, time.Now())})
+}
+
+// AchieveMilestoneHandler records that one of the caller's children reached a
+// milestone. Recording it again replaces the date.
+func (h *Handlers) AchieveMilestoneHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req AchieveMilestoneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MilestoneID == "" {
+		http.Error(w, "Milestone ID is required", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	achievedOn := model.Date{Time: time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)}
+	if req.AchievedOn != "" {
+		d, err := model.ParseDate(req.AchievedOn)
+		if err != nil {
+			http.Error(w, "Achieved date must be a date like 2023-04-30", http.StatusBadRequest)
+			return
+		}
+		achievedOn = d
+	}
+
+	child, ok := h.loadChild(w, r, caller.UID)
+	if !ok {
+		return
+	}
+	if achievedOn.Before(child.DOB.Time) || achievedOn.After(now) {
+		http.Error(w, "Achieved date must be between the date of birth and today", http.StatusBadRequest)
+		return
+	}
+
+	catalogue, err := h.repos.Milestones.Catalogue(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to retrieve milestones", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to retrieve milestones", "error", err)
+		return
+	}
+	if _, ok := catalogue[req.MilestoneID]; !ok {
+		http.Error(w, "Milestone not found", http.StatusNotFound)
+		return
+	}
+
+	achievement := model.Achievement{AchievedOn: achievedOn, RecordedAt: now.Unix()}
+	if err := h.repos.Milestones.Achieve(r.Context(), caller.UID, child.ID, req.MilestoneID, achievement); err != nil {
+		http.Error(w, "Failed to save milestone", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to save milestone", "milestone_id", req.MilestoneID, "child_id", child.ID, "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"milestone_id": req.MilestoneID,
+		"achieved_on":  achievement.AchievedOn,
+	})
+}
+
+// UnachieveMilestoneHandler removes a milestone recorded by mistake.
+func (h *Handlers) UnachieveMilestoneHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	child, ok := h.loadChild(w, r, caller.UID)
+	if !ok {
+		return
+	}
+	milestoneID := mux.Vars(r)["milestone_id"]
+	if err := h.repos.Milestones.Unachieve(r.Context(), caller.UID, child.ID, milestoneID); err != nil {
+		http.Error(w, "Failed to remove milestone", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to remove milestone", "milestone_id", milestoneID, "child_id", child.ID, "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Milestone removed successfully"})
+}
@@ -0,0 +1,132 @@
+package controller
+
+import (
+	"backend/model"
+	"backend/repository"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// broadcastBacklog is how far back a new inbox picks up broadcasts.
+const broadcastBacklog = 30 * 24 * time.Hour
+
+// maxNotificationsPage is the largest page /notifications returns.
+const maxNotificationsPage = 100
+
+// NotificationsResponse is a page of the caller's inbox. Next is the
+// "before" value of the following page and is empty on the last one.
+type NotificationsResponse struct {
+	Notifications []model.Notification `json:"notifications"`
+	Next          string               `json:"next,omitempty"`
+}
+
+// syncInbox copies the broadcasts the caller has not seen yet, and keeps in
+// the inbox, into their inbox. Failures are logged; the inbox is still usable without them.
+func (h *Handlers) syncInbox(r *http.Request, uid string) {
+	since := time.Now().Add(-broadcastBacklog)
+	if err := h.dispatcher.SyncInbox(r.Context(), uid, since); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to sync inbox", "error", err)
+	}
+}
+
+// ListNotificationsHandler returns the caller's notifications, newest first.
+// Pass the returned next value as ?before= to get the following page.
+func (h *Handlers) ListNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 20
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		l, err := strconv.Atoi(limitParam)
+		if err != nil || l < 1 || l > maxNotificationsPage {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	h.syncInbox(r, caller.UID)
+	// Fetch one extra notification to tell whether another page follows
+	notifications, err := h.repos.Inbox.List(r.Context(), caller.UID, r.URL.Query().Get("before"), limit+1)
+	if err != nil {
+		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to list notifications", "error", err)
+		return
+	}
+
+	resp := NotificationsResponse{Notifications: notifications}
+	if len(notifications) > limit {
+		resp.Notifications = notifications[:limit]
+		resp.Next = notifications[limit-1].ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// UnreadNotificationsHandler returns the number of unread notifications, for
+// the badge on the app's bell icon.
+func (h *Handlers) UnreadNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	h.syncInbox(r, caller.UID)
+	unread, err := h.repos.Inbox.UnreadCount(r.Context(), caller.UID)
+	if err != nil {
+		http.Error(w, "Failed to count notifications", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to count unread notifications", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"unread": unread})
+}
+
+// ReadNotificationHandler marks one of the caller's notifications read.
+func (h *Handlers) ReadNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.repos.Inbox.MarkRead(r.Context(), caller.UID, mux.Vars(r)["id"])
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update notification", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to mark notification read", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Notification marked as read"})
+}
+
+// ReadAllNotificationsHandler marks every notification of the caller read.
+func (h *Handlers) ReadAllNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	h.syncInbox(r, caller.UID)
+	if err := h.repos.Inbox.MarkAllRead(r.Context(), caller.UID); err != nil {
+		http.Error(w, "Failed to update notifications", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to mark notifications read", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "All notifications marked as read"})
+}
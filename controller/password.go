@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"backend/lockout"
+	"backend/repository"
+	"backend/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ChangePasswordRequest defines the request payload for /password/change
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// ResetPasswordRequest defines the request payload for /password/reset
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// ChangePasswordHandler lets a signed-in user change their password after
+// confirming the current one. Every other session of the user is signed out
+// afterwards.
+func (h *Handlers) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Confirm the current password, throttled like logins so a stolen
+	// session cannot be used to guess it
+	ip := utils.ClientIP(r)
+	key := lockout.PasswordKey(caller.UID)
+	status, err := h.loginGuard.Check(r.Context(), key, ip)
+	if err != nil {
+		http.Error(w, "Failed to check login attempts", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to check login attempts", "error", err)
+		return
+	}
+	if status.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(status.RetryAfter.Seconds()))))
+		http.Error(w, "Too many failed attempts, please try again later", http.StatusTooManyRequests)
+		return
+	}
+	hashedPassword, err := h.repos.Users.HashedPassword(r.Context(), caller.UID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve user password", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to retrieve password hash", "error", err)
+		return
+	}
+	oldPassword := strings.TrimSpace(req.OldPassword)
+	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(oldPassword)); err != nil {
+		if _, err := h.loginGuard.Fail(r.Context(), key, ip); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to record login failure", "error", err)
+		}
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	if err := h.loginGuard.Succeed(r.Context(), key); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to clear login attempts", "error", err)
+	}
+
+	if err := utils.ValidatePassword(req.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.setPassword(r.Context(), caller.UID, req.NewPassword); err != nil {
+		// Put the auth password back so it keeps matching the stored hash
+		if rollbackErr := h.repos.Accounts.UpdatePassword(r.Context(), caller.UID, oldPassword); rollbackErr != nil {
+			slog.ErrorContext(r.Context(), "Failed to roll back auth password", "error", rollbackErr)
+		}
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to change password", "error", err)
+		return
+	}
+
+	if err := h.sessions.RevokeOthers(r.Context(), caller.UID, caller.SessionID); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to revoke other sessions", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password changed successfully"})
+}
+
+// ResetPasswordHandler completes a reset started by ForgotPasswordHandler.
+// Every session of the user is signed out afterwards.
+func (h *Handlers) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Reset token is required", http.StatusBadRequest)
+		return
+	}
+
+	// Check the policy first so a weak password does not burn the token
+	if err := utils.ValidatePassword(req.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reset, err := h.repos.Resets.Consume(r.Context(), utils.HashToken(req.Token))
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && time.Now().Unix() >= reset.ExpiresAt) {
+		http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to consume reset token", "error", err)
+		return
+	}
+
+	if err := h.setPassword(r.Context(), reset.UID, req.NewPassword); err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to reset password", "uid", reset.UID, "error", err)
+		return
+	}
+
+	if err := h.sessions.RevokeAll(r.Context(), reset.UID); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to revoke sessions", "uid", reset.UID, "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password reset successfully"})
+}
+
+// setPassword updates the auth password and the bcrypt hash checked by
+// LoginHandler, so the two never disagree.
+func (h *Handlers) setPassword(ctx context.Context, uid, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := h.repos.Accounts.UpdatePassword(ctx, uid, password); err != nil {
+		return err
+	}
+	return h.repos.Users.Update(ctx, uid, map[string]interface{}{
+		"hashed_password": string(hashedPassword),
+	})
+}
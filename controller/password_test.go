@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"backend/middleware"
+	"backend/session"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangePasswordSignsOutOtherSessions(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestHandlers(t)
+	uid := createTestAccount(t, h, "old-password1", nil)
+
+	current, err := h.sessions.Issue(ctx, uid, "phone", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := h.sessions.Issue(ctx, uid, "tablet", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := h.sessions.VerifyToken(ctx, current.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"old_password": "old-password1", "new_password": "new-password2"}`)
+	r := httptest.NewRequest(http.MethodPost, "/password/change", body)
+	h.ChangePasswordHandler(rec, r.WithContext(middleware.WithIdentity(r.Context(), id)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /password/change = %d %s", rec.Code, rec.Body)
+	}
+
+	if _, err := h.sessions.Verify(ctx, current.AccessToken); err != nil {
+		t.Errorf("current session after the change: %v", err)
+	}
+	if _, err := h.sessions.Refresh(ctx, other.RefreshToken); !errors.Is(err, session.ErrRevoked) {
+		t.Errorf("refreshing another session = %v, want ErrRevoked", err)
+	}
+	if rec := login(h, "parent@example.com", "new-password2"); rec.Code != http.StatusOK {
+		t.Errorf("login with the new password = %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestChangePasswordRejectsWrongPassword(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestHandlers(t)
+	uid := createTestAccount(t, h, "old-password1", nil)
+	other, err := h.sessions.Issue(ctx, uid, "tablet", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"old_password": "not-my-password1", "new_password": "new-password2"}`)
+	h.ChangePasswordHandler(rec, asUser(httptest.NewRequest(http.MethodPost, "/password/change", body), uid))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("POST /password/change = %d %s", rec.Code, rec.Body)
+	}
+	if _, err := h.sessions.Verify(ctx, other.AccessToken); err != nil {
+		t.Errorf("session after a rejected change: %v", err)
+	}
+}
+
+func TestChangePasswordLockout(t *testing.T) {
+	h, _ := newTestHandlers(t)
+	uid := createTestAccount(t, h, "old-password1", nil)
+	now := time.Now()
+	h.loginGuard.Now = func() time.Time { return now }
+	change := func(oldPassword string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		body := strings.NewReader(`{"old_password": "` + oldPassword + `", "new_password": "new-password2"}`)
+		h.ChangePasswordHandler(rec, asUser(httptest.NewRequest(http.MethodPost, "/password/change", body), uid))
+		return rec
+	}
+
+	if rec := change("guess-1"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password = %d %s, want 401", rec.Code, rec.Body)
+	}
+	if rec := change("old-password1"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("attempt during the backoff = %d %s, want 429 with Retry-After", rec.Code, rec.Body)
+	}
+
+	for i := 1; i < h.loginGuard.MaxFailures; i++ {
+		now = now.Add(h.loginGuard.MaxDelay)
+		change("guess-1")
+	}
+	now = now.Add(h.loginGuard.MaxDelay)
+	if rec := change("old-password1"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("right password after %d failures = %d %s, want 429", h.loginGuard.MaxFailures, rec.Code, rec.Body)
+	}
+	// Guessing through a session does not lock the user out of logging in
+	if rec := login(h, "parent@example.com", "old-password1"); rec.Code != http.StatusOK {
+		t.Errorf("login while changes are locked = %d %s", rec.Code, rec.Body)
+	}
+
+	now = now.Add(h.loginGuard.LockDuration)
+	if rec := change("old-password1"); rec.Code != http.StatusOK {
+		t.Errorf("right password after the lock = %d %s", rec.Code, rec.Body)
+	}
+}
@@ -1,11 +1,13 @@
 package controller
 
 import (
+	"backend/middleware"
 	"backend/model"
-	"backend/utils"
+	"backend/repository"
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -15,18 +17,24 @@ import (
 )
 
 // CreatePostHandler creates a new post and stores it in the database
-func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	var post model.Post
 	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
 		http.Error(w, "Bad Request: Unable to decode JSON", http.StatusBadRequest)
 		return
 	}
 
-	// Ensure that the username is provided
-	if post.Username == "" {
+	// The author is always the authenticated caller
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	if caller.Username == "" {
 		http.Error(w, "Username is required", http.StatusBadRequest)
 		return
 	}
+	post.Username = caller.Username
+	post.UID = caller.UID
 
 	// Ensure tags are provided and valid
 	if len(post.Tags) == 0 {
@@ -44,9 +52,8 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	post.CreatedAt = -time.Now().UnixNano()
 	post.IsResolved = false
 
-	// Save post to Firebase
-	postRef := utils.FirebaseDB.NewRef("posts/" + post.ID)
-	if err := postRef.Set(context.Background(), post); err != nil {
+	// Save post
+	if err := h.repos.Posts.Save(r.Context(), post); err != nil {
 		http.Error(w, "Failed to create post", http.StatusInternalServerError)
 		return
 	}
@@ -55,7 +62,7 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetPostsByTagsHandler fetches posts that match specific tags
-func GetPostsByTagsHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetPostsByTagsHandler(w http.ResponseWriter, r *http.Request) {
 	// Get tags from query parameters
 	tagsParam := r.URL.Query().Get("tags")
 	if tagsParam == "" {
@@ -75,33 +82,30 @@ func GetPostsByTagsHandler(w http.ResponseWriter, r *http.Request) {
 		if l, err := strconv.Atoi(limitParam); err == nil {
 			limit = l
 		} else {
-			log.Println("Invalid limit parameter:", limitParam)
+			slog.InfoContext(r.Context(), "Invalid limit parameter", "limit", limitParam)
 		}
 	}
 
 	// Retrieve starting timestamp for pagination if provided.
 	startAfterParam := r.URL.Query().Get("startAfter")
 
-	// Begin Firebase query ordered by "created_at".
-	ref := utils.FirebaseDB.NewRef("posts")
-	query := ref.OrderByChild("created_at")
+	// Build a query ordered by "created_at" and limited to the desired number of posts.
+	query := repository.PostQuery{Limit: limit}
 
 	// If a valid startAfter parameter is provided, update the query.
 	if startAfterParam != "" {
 		if startAfter, err := strconv.ParseInt(startAfterParam, 10, 64); err == nil {
 			// Add 1 to the timestamp to avoid including the last fetched post again.
-			query = query.StartAt(startAfter + 1)
+			startAt := startAfter + 1
+			query.StartAt = &startAt
 		} else {
-			log.Println("Invalid startAfter parameter:", startAfterParam)
+			slog.InfoContext(r.Context(), "Invalid startAfter parameter", "start_after", startAfterParam)
 		}
 	}
 
-	// Limit the query to the desired number of posts.
-	query = query.LimitToFirst(limit)
-
 	// Execute the query.
-	var posts map[string]model.Post
-	if err := query.Get(context.Background(), &posts); err != nil {
+	posts, err := h.repos.Posts.List(r.Context(), query)
+	if err != nil {
 		http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
 		return
 	}
@@ -118,49 +122,36 @@ func GetPostsByTagsHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
+	h.authorNames().posts(r.Context(), matchingPosts)
 
 	json.NewEncoder(w).Encode(matchingPosts)
 }
 
 // AddCommentHandler adds a comment to a specific post
-func AddCommentHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) AddCommentHandler(w http.ResponseWriter, r *http.Request) {
 	var comment model.Comment
 	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
 		http.Error(w, "Bad Request: Unable to decode JSON", http.StatusBadRequest)
 		return
 	}
 
-	// Check if username is empty or missing
-	if comment.Username == "" {
-		http.Error(w, "Username is required", http.StatusBadRequest)
+	// The author is always the authenticated caller
+	caller, ok := currentUser(w, r)
+	if !ok {
 		return
 	}
-
-	// Query to get the user by username
-	var users map[string]model.User
-	err := utils.FirebaseDB.NewRef("users").
-		OrderByChild("username").
-		EqualTo(comment.Username).
-		LimitToFirst(1).
-		Get(context.Background(), &users)
-
-	if err != nil || len(users) == 0 {
-		http.Error(w, "Failed to verify user role", http.StatusInternalServerError)
+	if caller.Username == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
 		return
 	}
 
-	// Get the first user (should be unique due to username being unique)
-	var user model.User
-	for _, u := range users {
-		user = u
-		break
-	}
-
 	// Set comment metadata
 	comment.ID = uuid.New().String()
+	comment.Username = caller.Username
+	comment.UID = caller.UID
 	comment.CreatedAt = time.Now().Unix()
-	comment.IsAdmin = user.Role == "admin"
-	comment.Role = user.Role
+	comment.IsAdmin = caller.Role == middleware.RoleAdmin
+	comment.Role = caller.Role
 
 	// Get post ID from query parameters
 	postID := r.URL.Query().Get("post_id")
@@ -170,16 +161,18 @@ func AddCommentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Retrieve post to update CommentCount
-	var post model.Post
-	postRef := utils.FirebaseDB.NewRef("posts/" + postID)
-	if err := postRef.Get(context.Background(), &post); err != nil {
+	post, err := h.repos.Posts.Get(r.Context(), postID)
+	if err != nil {
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
+	if _, ok := post.Comments[comment.ParentID]; comment.ParentID != "" && !ok {
+		http.Error(w, "Parent comment not found", http.StatusBadRequest)
+		return
+	}
 
 	// Save the comment directly inside the post under the "comments" field
-	commentRef := utils.FirebaseDB.NewRef("posts/" + postID + "/comments/" + comment.ID)
-	if err := commentRef.Set(context.Background(), comment); err != nil {
+	if err := h.repos.Comments.Save(r.Context(), postID, comment); err != nil {
 		http.Error(w, "Failed to add comment", http.StatusInternalServerError)
 		return
 	}
@@ -187,58 +180,52 @@ func AddCommentHandler(w http.ResponseWriter, r *http.Request) {
 	// Increment the CommentCount
 	post.CommentCount++
 
-	if err := postRef.Update(context.Background(), map[string]interface{}{
+	if err := h.repos.Posts.Update(r.Context(), postID, map[string]interface{}{
 		"comment_count": post.CommentCount,
 	}); err != nil {
-		log.Println("Failed to update comment count:", err)
+		slog.ErrorContext(r.Context(), "Failed to update comment count", "error", err)
 		http.Error(w, "Failed to update comment count", http.StatusInternalServerError)
 		return
 	}
 
-	// Save updated post back to Firebase
-	// if err := postRef.Set(context.Background(), post); err != nil {
-	// 	http.Error(w, "Failed to update comment count", http.StatusInternalServerError)
-	// 	return
-	// }
+	// Tell the post author and, for a reply, the parent comment's author
+	go h.hooks.CommentAdded(context.WithoutCancel(r.Context()), post, comment)
 
 	json.NewEncoder(w).Encode(comment)
 }
 
-func GetPostsHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetPostsHandler(w http.ResponseWriter, r *http.Request) {
 	// Set a default limit of 5 posts.
 	limit := 4
 	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
 		if l, err := strconv.Atoi(limitParam); err == nil {
 			limit = l
 		} else {
-			log.Println("Invalid limit parameter:", limitParam)
+			slog.InfoContext(r.Context(), "Invalid limit parameter", "limit", limitParam)
 		}
 	}
 
 	// Retrieve the starting timestamp for pagination (if provided).
 	startAfterParam := r.URL.Query().Get("startAfter")
 
-	// Begin the Firebase query ordering by the "created_at" field.
-	ref := utils.FirebaseDB.NewRef("posts")
-	query := ref.OrderByChild("created_at")
+	// Build a query ordered by "created_at" and limited to the desired number of posts.
+	query := repository.PostQuery{Limit: limit}
 
 	// If a valid startAfter parameter is provided, update the query.
 	if startAfterParam != "" {
 		if startAfter, err := strconv.ParseInt(startAfterParam, 10, 64); err == nil {
 			// Add 1 to the timestamp to avoid including the last fetched post again.
-			query = query.StartAt(startAfter + 1)
+			startAt := startAfter + 1
+			query.StartAt = &startAt
 		} else {
-			log.Println("Invalid startAfter parameter:", startAfterParam)
+			slog.InfoContext(r.Context(), "Invalid startAfter parameter", "start_after", startAfterParam)
 		}
 	}
 
-	// Limit the query to the desired number of posts.
-	query = query.LimitToFirst(limit)
-
 	// Execute the query.
-	var posts map[string]model.Post
-	if err := query.Get(context.Background(), &posts); err != nil {
-		log.Println("Error fetching posts:", err)
+	posts, err := h.repos.Posts.List(r.Context(), query)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching posts", "error", err)
 		http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
 		return
 	}
@@ -247,9 +234,9 @@ func GetPostsHandler(w http.ResponseWriter, r *http.Request) {
 	includeComments := r.URL.Query().Get("includeComments") == "true"
 	if includeComments && posts != nil {
 		for postID, post := range posts {
-			var comments map[string]model.Comment
-			if err := utils.FirebaseDB.NewRef("posts/"+postID+"/comments").Get(context.Background(), &comments); err != nil {
-				log.Println("Error fetching comments for post", postID, ":", err)
+			comments, err := h.repos.Comments.List(r.Context(), postID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error fetching comments", "post_id", postID, "error", err)
 				http.Error(w, "Failed to fetch comments for post "+postID, http.StatusInternalServerError)
 				return
 			}
@@ -257,39 +244,39 @@ func GetPostsHandler(w http.ResponseWriter, r *http.Request) {
 			posts[postID] = post
 		}
 	}
+	h.authorNames().posts(r.Context(), posts)
 
 	// Return the fetched posts as JSON.
 	json.NewEncoder(w).Encode(posts)
 }
 
-func FlagPostHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) FlagPostHandler(w http.ResponseWriter, r *http.Request) {
 	postID := r.URL.Query().Get("post_id")
 	if postID == "" {
 		http.Error(w, "Post ID is required", http.StatusBadRequest)
 		return
 	}
 
-	var request struct {
-		Username string `json:"username"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	// Likes and flags are recorded against the authenticated caller
+	caller, ok := currentUser(w, r)
+	if !ok {
 		return
 	}
-
-	if request.Username == "" {
+	if caller.Username == "" {
 		http.Error(w, "Username is required", http.StatusBadRequest)
 		return
 	}
 
 	// Retrieve the post
-	var post model.Post
-	postRef := utils.FirebaseDB.NewRef("posts/" + postID)
-	if err := postRef.Get(context.Background(), &post); err != nil {
+	post, err := h.repos.Posts.Get(r.Context(), postID)
+	if errors.Is(err, repository.ErrNotFound) {
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve post", http.StatusInternalServerError)
+		return
+	}
 
 	// Initialize flags map if nil
 	if post.Flags == nil {
@@ -297,13 +284,13 @@ func FlagPostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// If user hasn't already flagged, increase count
-	if !post.Flags[request.Username] {
-		post.Flags[request.Username] = true
+	if !post.Flags[caller.Username] {
+		post.Flags[caller.Username] = true
 		post.FlagCount++
 	}
 
-	// Save back to Firebase
-	if err := postRef.Set(context.Background(), post); err != nil {
+	// Save back to the database
+	if err := h.repos.Posts.Save(r.Context(), post); err != nil {
 		http.Error(w, "Failed to flag post", http.StatusInternalServerError)
 		return
 	}
@@ -315,7 +302,7 @@ func FlagPostHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // FlagCommentHandler flags a comment by increasing its flag count and storing the username of the flagger
-func FlagCommentHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) FlagCommentHandler(w http.ResponseWriter, r *http.Request) {
 	postID := r.URL.Query().Get("post_id")
 	commentID := r.URL.Query().Get("comment_id")
 
@@ -324,27 +311,26 @@ func FlagCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var request struct {
-		Username string `json:"username"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	// Likes and flags are recorded against the authenticated caller
+	caller, ok := currentUser(w, r)
+	if !ok {
 		return
 	}
-
-	if request.Username == "" {
+	if caller.Username == "" {
 		http.Error(w, "Username is required", http.StatusBadRequest)
 		return
 	}
 
 	// Retrieve the post
-	var post model.Post
-	postRef := utils.FirebaseDB.NewRef("posts/" + postID)
-	if err := postRef.Get(context.Background(), &post); err != nil {
+	post, err := h.repos.Posts.Get(r.Context(), postID)
+	if errors.Is(err, repository.ErrNotFound) {
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve post", http.StatusInternalServerError)
+		return
+	}
 
 	// Retrieve the comment
 	comment, exists := post.Comments[commentID]
@@ -359,16 +345,16 @@ func FlagCommentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// If user hasn't already flagged, increase count
-	if !comment.Flags[request.Username] {
-		comment.Flags[request.Username] = true
+	if !comment.Flags[caller.Username] {
+		comment.Flags[caller.Username] = true
 		comment.FlagCount++
 	}
 
 	// Save updated comment back into post
 	post.Comments[commentID] = comment
 
-	// Save back to Firebase
-	if err := postRef.Set(context.Background(), post); err != nil {
+	// Save back to the database
+	if err := h.repos.Posts.Save(r.Context(), post); err != nil {
 		http.Error(w, "Failed to flag comment", http.StatusInternalServerError)
 		return
 	}
@@ -380,9 +366,9 @@ func FlagCommentHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetFlaggedPostsHandler fetches all posts that have been flagged
-func GetFlaggedPostsHandler(w http.ResponseWriter, r *http.Request) {
-	var posts map[string]model.Post
-	if err := utils.FirebaseDB.NewRef("posts").Get(context.Background(), &posts); err != nil {
+func (h *Handlers) GetFlaggedPostsHandler(w http.ResponseWriter, r *http.Request) {
+	posts, err := h.repos.Posts.All(r.Context())
+	if err != nil {
 		http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
 		return
 	}
@@ -394,19 +380,21 @@ func GetFlaggedPostsHandler(w http.ResponseWriter, r *http.Request) {
 			flaggedPosts[postID] = post
 		}
 	}
+	h.authorNames().posts(r.Context(), flaggedPosts)
 
 	json.NewEncoder(w).Encode(flaggedPosts)
 }
 
 // GetFlaggedCommentsHandler fetches all comments that have been flagged
-func GetFlaggedCommentsHandler(w http.ResponseWriter, r *http.Request) {
-	var posts map[string]model.Post
-	if err := utils.FirebaseDB.NewRef("posts").Get(context.Background(), &posts); err != nil {
+func (h *Handlers) GetFlaggedCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	posts, err := h.repos.Posts.All(r.Context())
+	if err != nil {
 		http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
 		return
 	}
 
 	flaggedComments := make(map[string]map[string]model.Comment)
+	names := h.authorNames()
 
 	// Iterate over posts and collect flagged comments
 	for postID, post := range posts {
@@ -415,6 +403,7 @@ func GetFlaggedCommentsHandler(w http.ResponseWriter, r *http.Request) {
 				if _, exists := flaggedComments[postID]; !exists {
 					flaggedComments[postID] = make(map[string]model.Comment)
 				}
+				comment.Username = names.name(r.Context(), comment.UID, comment.Username)
 				flaggedComments[postID][commentID] = comment
 			}
 		}
@@ -424,8 +413,9 @@ func GetFlaggedCommentsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetPostsByUsernameHandler fetches all posts created by a specific user,
-// with pagination and ordering by created_at (default limit is 4).
-func GetPostsByUsernameHandler(w http.ResponseWriter, r *http.Request) {
+// with pagination and ordering by created_at (default limit is 4). The user
+// is found by their current username.
+func (h *Handlers) GetPostsByUsernameHandler(w http.ResponseWriter, r *http.Request) {
 	// Get username from query parameters
 	username := r.URL.Query().Get("username")
 	if username == "" {
@@ -439,50 +429,55 @@ func GetPostsByUsernameHandler(w http.ResponseWriter, r *http.Request) {
 		if l, err := strconv.Atoi(limitParam); err == nil {
 			limit = l
 		} else {
-			log.Println("Invalid limit parameter:", limitParam)
+			slog.InfoContext(r.Context(), "Invalid limit parameter", "limit", limitParam)
 		}
 	}
 
 	// Retrieve starting timestamp for pagination if provided.
 	startAfterParam := r.URL.Query().Get("startAfter")
 
-	// Begin Firebase query ordered by "created_at".
-	ref := utils.FirebaseDB.NewRef("posts")
-	query := ref.OrderByChild("created_at")
+	// Build a query ordered by "created_at" and limited to the desired number of posts.
+	query := repository.PostQuery{Limit: limit}
 
 	// If a valid startAfter parameter is provided, update the query.
 	if startAfterParam != "" {
 		if startAfter, err := strconv.ParseInt(startAfterParam, 10, 64); err == nil {
 			// Add 1 to the timestamp to avoid including the last fetched post again.
-			query = query.StartAt(startAfter + 1)
+			startAt := startAfter + 1
+			query.StartAt = &startAt
 		} else {
-			log.Println("Invalid startAfter parameter:", startAfterParam)
+			slog.InfoContext(r.Context(), "Invalid startAfter parameter", "start_after", startAfterParam)
 		}
 	}
 
-	// Limit the query to the desired number of posts.
-	query = query.LimitToFirst(limit)
-
 	// Execute the query.
-	var posts map[string]model.Post
-	if err := query.Get(context.Background(), &posts); err != nil {
+	posts, err := h.repos.Posts.List(r.Context(), query)
+	if err != nil {
 		http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
 		return
 	}
 
-	// Filter posts by username.
+	// Filter posts by the UID holding username. Posts from before UIDs
+	// were recorded only have the name they were written under.
+	uid, err := h.repos.Usernames.Lookup(r.Context(), strings.ToLower(strings.TrimSpace(username)))
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		slog.ErrorContext(r.Context(), "Failed to look up username", "error", err)
+		http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
+		return
+	}
 	userPosts := make(map[string]model.Post)
 	for postID, post := range posts {
-		if post.Username == username {
+		if (uid != "" && post.UID == uid) || (post.UID == "" && post.Username == username) {
 			userPosts[postID] = post
 		}
 	}
+	h.authorNames().posts(r.Context(), userPosts)
 
 	json.NewEncoder(w).Encode(userPosts)
 }
 
 // LikeCommentHandler handles liking and unliking a comment
-func LikeCommentHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) LikeCommentHandler(w http.ResponseWriter, r *http.Request) {
 	postID := r.URL.Query().Get("post_id")
 	commentID := r.URL.Query().Get("comment_id")
 
@@ -491,27 +486,26 @@ func LikeCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var request struct {
-		Username string `json:"username"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	// Likes and flags are recorded against the authenticated caller
+	caller, ok := currentUser(w, r)
+	if !ok {
 		return
 	}
-
-	if request.Username == "" {
+	if caller.Username == "" {
 		http.Error(w, "Username is required", http.StatusBadRequest)
 		return
 	}
 
 	// Retrieve the comment
-	var comment model.Comment
-	commentRef := utils.FirebaseDB.NewRef("posts/" + postID + "/comments/" + commentID)
-	if err := commentRef.Get(context.Background(), &comment); err != nil {
+	comment, err := h.repos.Comments.Get(r.Context(), postID, commentID)
+	if errors.Is(err, repository.ErrNotFound) {
 		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve comment", http.StatusInternalServerError)
+		return
+	}
 
 	// Initialize likes map if nil
 	if comment.Likes == nil {
@@ -519,19 +513,20 @@ func LikeCommentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Toggle like status
-	if comment.Likes[request.Username] {
-		delete(comment.Likes, request.Username)
+	if comment.Likes[caller.Username] {
+		delete(comment.Likes, caller.Username)
 		comment.LikeCount--
 	} else {
-		comment.Likes[request.Username] = true
+		comment.Likes[caller.Username] = true
 		comment.LikeCount++
 	}
 
-	// Save back to Firebase
-	if err := commentRef.Set(context.Background(), comment); err != nil {
+	// Save back to the database
+	if err := h.repos.Comments.Save(r.Context(), postID, comment); err != nil {
 		http.Error(w, "Failed to update like status", http.StatusInternalServerError)
 		return
 	}
+	go h.hooks.CommentLiked(context.WithoutCancel(r.Context()), postID, comment, caller.Username, comment.Likes[caller.Username])
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message":    "Like status updated",
@@ -540,34 +535,33 @@ func LikeCommentHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // LikePostHandler handles liking and unliking a post
-func LikePostHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) LikePostHandler(w http.ResponseWriter, r *http.Request) {
 	postID := r.URL.Query().Get("post_id")
 	if postID == "" {
 		http.Error(w, "Post ID is required", http.StatusBadRequest)
 		return
 	}
 
-	var request struct {
-		Username string `json:"username"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	// Likes and flags are recorded against the authenticated caller
+	caller, ok := currentUser(w, r)
+	if !ok {
 		return
 	}
-
-	if request.Username == "" {
+	if caller.Username == "" {
 		http.Error(w, "Username is required", http.StatusBadRequest)
 		return
 	}
 
 	// Retrieve the post
-	var post model.Post
-	postRef := utils.FirebaseDB.NewRef("posts/" + postID)
-	if err := postRef.Get(context.Background(), &post); err != nil {
+	post, err := h.repos.Posts.Get(r.Context(), postID)
+	if errors.Is(err, repository.ErrNotFound) {
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve post", http.StatusInternalServerError)
+		return
+	}
 
 	// Initialize likes map if nil
 	if post.Likes == nil {
@@ -575,22 +569,73 @@ func LikePostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Toggle like status
-	if post.Likes[request.Username] {
-		delete(post.Likes, request.Username)
+	if post.Likes[caller.Username] {
+		delete(post.Likes, caller.Username)
 		post.LikeCount--
 	} else {
-		post.Likes[request.Username] = true
+		post.Likes[caller.Username] = true
 		post.LikeCount++
 	}
 
-	// Save back to Firebase
-	if err := postRef.Set(context.Background(), post); err != nil {
+	// Save back to the database
+	if err := h.repos.Posts.Save(r.Context(), post); err != nil {
 		http.Error(w, "Failed to update like status", http.StatusInternalServerError)
 		return
 	}
+	go h.hooks.PostLiked(context.WithoutCancel(r.Context()), post, caller.Username, post.Likes[caller.Username])
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message":    "Like status updated",
 		"like_count": post.LikeCount,
 	})
 }
+
+// authorNames resolves author UIDs to their current usernames, so posts and
+// comments show the new name as soon as it changes rather than when the
+// rename job reaches them. Lookups are cached for one request.
+type authorNames struct {
+	users repository.UserStore
+	names map[string]string
+}
+
+func (h *Handlers) authorNames() *authorNames {
+	return &authorNames{users: h.repos.Users, names: make(map[string]string)}
+}
+
+// name returns the current username of uid, or stored when the content
+// predates UIDs or the profile has no username.
+func (a *authorNames) name(ctx context.Context, uid, stored string) string {
+	if uid == "" {
+		return stored
+	}
+	name, ok := a.names[uid]
+	if !ok {
+		user, err := a.users.Get(ctx, uid)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			slog.ErrorContext(ctx, "Failed to resolve author", "target", uid, "error", err)
+		}
+		name = user.Username
+		a.names[uid] = name
+	}
+	if name == "" {
+		return stored
+	}
+	return name
+}
+
+// post returns post with the usernames of its author and comments resolved.
+func (a *authorNames) post(ctx context.Context, post model.Post) model.Post {
+	post.Username = a.name(ctx, post.UID, post.Username)
+	for id, comment := range post.Comments {
+		comment.Username = a.name(ctx, comment.UID, comment.Username)
+		post.Comments[id] = comment
+	}
+	return post
+}
+
+// posts resolves the usernames in every post of posts in place.
+func (a *authorNames) posts(ctx context.Context, posts map[string]model.Post) {
+	for id, post := range posts {
+		posts[id] = a.post(ctx, post)
+	}
+}
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"backend/model"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// getPosts calls handler and returns the posts it answered with.
+func getPosts(t *testing.T, handler http.HandlerFunc, target string) map[string]model.Post {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d %s", target, rec.Code, rec.Body)
+	}
+	var posts map[string]model.Post
+	if err := json.Unmarshal(rec.Body.Bytes(), &posts); err != nil {
+		t.Fatal(err)
+	}
+	return posts
+}
+
+func TestPostsShowCurrentUsername(t *testing.T) {
+	ctx := context.Background()
+	h, repos := newTestHandlers(t)
+	if err := repos.Usernames.Claim(ctx, "alice", "uid-alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Users.Update(ctx, "uid-alice", map[string]interface{}{"username": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	posts := []model.Post{
+		{
+			ID: "p1", UID: "uid-alice", Username: "alice", Tags: []string{"sleep"}, CreatedAt: -3,
+			Comments: map[string]model.Comment{"c1": {ID: "c1", UID: "uid-alice", Username: "alice", FlagCount: 1}},
+		},
+		{ID: "p2", Username: "alice", Tags: []string{"sleep"}, CreatedAt: -2}, // Written before UIDs were recorded
+		{ID: "p3", UID: "uid-bob", Username: "bob", Tags: []string{"sleep"}, CreatedAt: -1, FlagCount: 1},
+	}
+	for _, post := range posts {
+		if err := repos.Posts.Save(ctx, post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// alice becomes alicia; the rename job has not run yet
+	if err := repos.Usernames.Claim(ctx, "alicia", "uid-alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Users.Update(ctx, "uid-alice", map[string]interface{}{"username": "alicia"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := getPosts(t, h.GetPostsHandler, "/posts?limit=10&includeComments=true")
+	if got["p1"].Username != "alicia" || got["p1"].Comments["c1"].Username != "alicia" {
+		t.Errorf("GET /posts p1 = %+v, want the new name on the post and its comment", got["p1"])
+	}
+	if got["p2"].Username != "alice" || got["p3"].Username != "bob" {
+		t.Errorf("GET /posts changed other authors: p2 %q, p3 %q", got["p2"].Username, got["p3"].Username)
+	}
+	if got := getPosts(t, h.GetPostsByTagsHandler, "/posts-by-tags?tags=sleep&limit=10"); got["p1"].Username != "alicia" {
+		t.Errorf("GET /posts-by-tags p1 by %q, want alicia", got["p1"].Username)
+	}
+
+	got = getPosts(t, h.GetPostsByUsernameHandler, "/posts-by-username?username=alicia&limit=10")
+	if len(got) != 1 || got["p1"].Username != "alicia" {
+		t.Errorf("posts by alicia = %v, want p1", got)
+	}
+	got = getPosts(t, h.GetPostsByUsernameHandler, "/posts-by-username?username=alice&limit=10")
+	if _, ok := got["p2"]; !ok {
+		t.Errorf("posts by alice = %v, want the legacy post p2", got)
+	}
+
+	if got := getPosts(t, h.GetFlaggedPostsHandler, "/flagged-posts"); len(got) != 1 || got["p3"].Username != "bob" {
+		t.Errorf("flagged posts = %v, want p3", got)
+	}
+	rec := httptest.NewRecorder()
+	h.GetFlaggedCommentsHandler(rec, httptest.NewRequest(http.MethodGet, "/flagged-comments", nil))
+	var flagged map[string]map[string]model.Comment
+	if err := json.Unmarshal(rec.Body.Bytes(), &flagged); err != nil {
+		t.Fatal(err)
+	}
+	if flagged["p1"]["c1"].Username != "alicia" {
+		t.Errorf("flagged comment by %q, want alicia", flagged["p1"]["c1"].Username)
+	}
+}
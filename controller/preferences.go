@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"backend/model"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// GetPreferencesHandler returns the caller's notification preferences with
+// every category and channel filled in.
+func (h *Handlers) GetPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	prefs, err := h.repos.Prefs.Get(r.Context(), caller.UID)
+	if err != nil {
+		http.Error(w, "Failed to fetch preferences", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to fetch notification preferences", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prefs.WithDefaults())
+}
+
+// UpdatePreferencesHandler replaces the caller's notification preferences.
+// Categories and channels left out take their defaults.
+func (h *Handlers) UpdatePreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var prefs model.NotificationPreferences
+	if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := prefs.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !prefs.QuietHours.Enabled {
+		prefs.QuietHours = model.QuietHours{}
+	}
+	slices.Sort(prefs.Tags)
+	prefs.Tags = slices.Compact(prefs.Tags)
+	prefs.UpdatedAt = time.Now().Unix()
+
+	if err := h.repos.Prefs.Save(r.Context(), caller.UID, prefs); err != nil {
+		http.Error(w, "Failed to save preferences", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to save notification preferences", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prefs.WithDefaults())
+}
+
+// UnsubscribeDigestRequest is the payload of UnsubscribeDigestHandler.
+type UnsubscribeDigestRequest struct {
+	Token string `json:"token"`
+}
+
+// UnsubscribeDigestHandler turns the weekly digest off for the user named
+// by the token of an unsubscribe link. It needs no sign-in.
+func (h *Handlers) UnsubscribeDigestHandler(w http.ResponseWriter, r *http.Request) {
+	var req UnsubscribeDigestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Unsubscribe token is required", http.StatusBadRequest)
+		return
+	}
+	uid, err := h.sessions.VerifyUnsubscribe(req.Token)
+	if err != nil {
+		http.Error(w, "Invalid or expired unsubscribe link", http.StatusBadRequest)
+		return
+	}
+
+	prefs, err := h.repos.Prefs.Get(r.Context(), uid)
+	if err != nil {
+		http.Error(w, "Failed to unsubscribe", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to fetch notification preferences", "uid", uid, "error", err)
+		return
+	}
+	if prefs.Digest {
+		prefs.Digest = false
+		prefs.UpdatedAt = time.Now().Unix()
+		if err := h.repos.Prefs.Save(r.Context(), uid, prefs); err != nil {
+			http.Error(w, "Failed to unsubscribe", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Failed to unsubscribe user from the digest", "uid", uid, "error", err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Unsubscribed from the weekly digest"))
+}
@@ -1,16 +1,15 @@
 package controller
 
 import (
+	"backend/middleware"
 	"backend/model"
-	"backend/utils"
-	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
 // GetProfileHandler handles fetching a user's profile by UID
-func GetProfileHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetProfileHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the UID from query parameters
 	uid := r.URL.Query().Get("uid")
 	if uid == "" {
@@ -18,20 +17,44 @@ func GetProfileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Fetch the user's profile from Firebase using the UID
-	var user model.User
-	userRef := utils.FirebaseDB.NewRef("users/" + uid)
-	if err := userRef.Get(context.Background(), &user); err != nil {
+	// Fetch the user's profile using the UID
+	user, err := h.repos.Users.Get(r.Context(), uid)
+	if err != nil {
 		http.Error(w, "Failed to retrieve user profile", http.StatusInternalServerError)
-		log.Printf("Failed to retrieve user profile for UID %s: %v\n", uid, err)
+		slog.ErrorContext(r.Context(), "Failed to retrieve user profile", "uid", uid, "error", err)
 		return
 	}
 
-	// No need to parse ChildDOB if it's already a string in Firebase
+	// Move a legacy child_dob into the children collection when the owner
+	// reads their profile; others see the legacy field as it is
+	id, signedIn := middleware.IdentityFrom(r.Context())
+	if signedIn && id.UID == uid {
+		if migrated, err := h.migrateLegacyChild(r.Context(), uid, user); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to migrate child_dob", "error", err)
+		} else if migrated {
+			user.ChildDOB = ""
+		}
+	}
+
+	// Children are only shown to their parent and to admins
+	response := struct {
+		model.User
+		Children []model.Child `json:"children,omitempty"`
+	}{User: user}
+	if signedIn && (id.UID == uid || id.Role == middleware.RoleAdmin) {
+		children, err := h.listChildren(r.Context(), uid)
+		if err != nil {
+			http.Error(w, "Failed to retrieve children", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Failed to retrieve children", "uid", uid, "error", err)
+			return
+		}
+		response.Children = children
+	}
+
 	// Return the user's profile as JSON
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(user); err != nil {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		log.Printf("Failed to encode response: %v\n", err)
+		slog.ErrorContext(r.Context(), "Failed to encode response", "error", err)
 	}
 }
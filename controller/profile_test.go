@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetProfileMigratesLegacyChildForOwnerOnly(t *testing.T) {
+	ctx := context.Background()
+	h, repos := newTestHandlers(t)
+	if err := repos.Users.Update(ctx, "parent", map[string]interface{}{
+		"username":  "parent",
+		"role":      "parent",
+		"child_dob": "2023-01-15",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(caller string) map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.GetProfileHandler(rec, asUser(httptest.NewRequest(http.MethodGet, "/profile?uid=parent", nil), caller))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /profile as %s = %d %s", caller, rec.Code, rec.Body)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	// Another user sees the legacy field and nothing is migrated
+	if body := get("someone-else"); body["child_dob"] != "2023-01-15" || body["children"] != nil {
+		t.Errorf("profile seen by another user = %v", body)
+	}
+	if children, err := repos.Children.List(ctx, "parent"); err != nil || len(children) != 0 {
+		t.Fatalf("children after another user's read = %v, %v", children, err)
+	}
+	if profile, err := repos.Users.Get(ctx, "parent"); err != nil || profile.ChildDOB != "2023-01-15" {
+		t.Fatalf("child_dob after another user's read = %q, %v", profile.ChildDOB, err)
+	}
+
+	// The owner's read moves it into the children collection
+	body := get("parent")
+	if body["child_dob"] != "" {
+		t.Errorf("child_dob after the owner's read = %v", body["child_dob"])
+	}
+	children, _ := body["children"].([]interface{})
+	if len(children) != 1 || children[0].(map[string]interface{})["id"] != legacyChildID {
+		t.Errorf("children = %v, want the legacy child", body["children"])
+	}
+	if profile, err := repos.Users.Get(ctx, "parent"); err != nil || profile.ChildDOB != "" {
+		t.Errorf("stored child_dob after the owner's read = %q, %v", profile.ChildDOB, err)
+	}
+}
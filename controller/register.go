@@ -1,36 +1,56 @@
 package controller
 
 import (
+	"backend/mail"
+	"backend/middleware"
 	"backend/model"
+	"backend/repository"
 	"backend/utils"
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"math/rand"
 	"net/http"
+	"strings"
 	"time"
 
-	"firebase.google.com/go/auth"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	var user model.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
 
-	// Create the Firebase user with the raw password
-	params := (&auth.UserToCreate{}).
-		Email(user.Email).
-		Password(user.Password). // Use raw password here
-		DisplayName(user.Role)   // Assuming Role is used as DisplayName for simplicity
+	// Privileged roles are granted by an admin through /users/role
+	if user.Role == "" {
+		user.Role = middleware.RoleParent
+	}
+	if user.Role != middleware.RoleParent {
+		middleware.Forbidden(w, "Only parent accounts can be registered directly")
+		return
+	}
 
-	newUser, err := utils.FirebaseAuth.CreateUser(context.Background(), params)
+	// Reject malformed input before anything is written
+	user.Email = strings.TrimSpace(user.Email)
+	if err := utils.ValidateEmail(user.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := utils.ValidatePassword(user.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	username, err := utils.NormalizeUsername(user.Username)
 	if err != nil {
-		http.Error(w, "Email already exists", http.StatusInternalServerError)
-		log.Printf("Failed to create user: %v\n", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if user.Language != "" && !mail.SupportsLanguage(user.Language) {
+		http.Error(w, "Unsupported language", http.StatusBadRequest)
 		return
 	}
 
@@ -38,42 +58,37 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
 		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
-		log.Printf("Failed to hash password: %v\n", err)
+		slog.ErrorContext(r.Context(), "Failed to hash password", "error", err)
 		return
 	}
 
-	// Assign role to the user in Firebase Database
-	err = utils.FirebaseDB.NewRef("users/"+newUser.UID+"/role").Set(context.Background(), user.Role)
+	// Create the auth account with the raw password.
+	// Role is used as the display name for simplicity.
+	newUser, err := h.repos.Accounts.Create(r.Context(), user.Email, user.Password, user.Role)
 	if err != nil {
-		http.Error(w, "Failed to assign role to user", http.StatusInternalServerError)
-		log.Printf("Failed to assign role to user: %v\n", err)
+		switch {
+		case errors.Is(err, repository.ErrEmailExists):
+			http.Error(w, "Email already exists", http.StatusConflict)
+		case errors.Is(err, repository.ErrInvalidEmail):
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to create user", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Failed to create user", "error", err)
+		}
 		return
 	}
 
-	// Save other user details conditionally
-	if user.Gender != "" {
-		err = utils.FirebaseDB.NewRef("users/"+newUser.UID+"/gender").Set(context.Background(), user.Gender)
-		if err != nil {
-			http.Error(w, "Failed to save gender", http.StatusInternalServerError)
-			log.Printf("Failed to save gender: %v\n", err)
-			return
+	// Reserve the username; the auth account goes if it is taken
+	if err := h.repos.Usernames.Claim(r.Context(), username, newUser.UID); err != nil {
+		if errors.Is(err, repository.ErrUsernameTaken) {
+			http.Error(w, "Username already exists", http.StatusConflict)
+		} else {
+			http.Error(w, "Failed to reserve username", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Failed to reserve username", "error", err)
 		}
-	}
-
-	if user.PhoneNumber != "" {
-		err = utils.FirebaseDB.NewRef("users/"+newUser.UID+"/phone_number").Set(context.Background(), user.PhoneNumber)
-		if err != nil {
-			http.Error(w, "Failed to save phone number", http.StatusInternalServerError)
-			log.Printf("Failed to save phone number: %v\n", err)
-			return
+		if err := h.repos.Accounts.Delete(r.Context(), newUser.UID); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to roll back auth account", "uid", newUser.UID, "error", err)
 		}
-	}
-
-	// Save hashed password in Firebase Database
-	err = utils.FirebaseDB.NewRef("users/"+newUser.UID+"/hashed_password").Set(context.Background(), string(hashedPassword))
-	if err != nil {
-		http.Error(w, "Failed to save user password", http.StatusInternalServerError)
-		log.Printf("Failed to save user password: %v\n", err)
 		return
 	}
 
@@ -81,14 +96,48 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	rand.Seed(time.Now().UnixNano())
 	profileImage := rand.Intn(10) + 1 // random number in [1,10]
 
-	// Save the profile image number in Firebase Database
-	err = utils.FirebaseDB.NewRef("users/"+newUser.UID+"/profile_image").Set(context.Background(), profileImage)
-	if err != nil {
-		http.Error(w, "Failed to save profile image", http.StatusInternalServerError)
-		log.Printf("Failed to save profile image: %v\n", err)
+	// Write the whole profile in one update so it is never left half-created
+	profile := map[string]interface{}{
+		"role":            user.Role,
+		"username":        username,
+		"hashed_password": string(hashedPassword),
+		"profile_image":   profileImage,
+	}
+	if user.Gender != "" {
+		profile["gender"] = user.Gender
+	}
+	if user.PhoneNumber != "" {
+		profile["phone_number"] = user.PhoneNumber
+	}
+	if user.Language != "" {
+		profile["language"] = user.Language
+	}
+	if err := h.repos.Users.Update(r.Context(), newUser.UID, profile); err != nil {
+		http.Error(w, "Failed to save user profile", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to save profile", "uid", newUser.UID, "error", err)
+		// Remove the auth account and username so both can be registered again
+		if err := h.repos.Usernames.Release(r.Context(), username, newUser.UID); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to release username", "username", username, "error", err)
+		}
+		if err := h.repos.Accounts.Delete(r.Context(), newUser.UID); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to roll back auth account", "uid", newUser.UID, "error", err)
+		}
 		return
 	}
 
+	// Send the verification email; unverified users get a grace period
+	// from when it is sent
+	ctx := context.WithoutCancel(r.Context())
+	go func() {
+		if err := utils.SendVerificationEmail(newUser.Email, user.Language); err != nil {
+			slog.ErrorContext(ctx, "Failed to send verification email", "error", err)
+			return
+		}
+		if err := h.recordVerificationSent(ctx, newUser.UID); err != nil {
+			slog.ErrorContext(ctx, "Failed to record verification email", "uid", newUser.UID, "error", err)
+		}
+	}()
+
 	// Successfully created user
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("User registered successfully."))
@@ -1,28 +1,82 @@
 package controller
 
 import (
+	"backend/repository"
 	"backend/utils"
+	"context"
 	"encoding/json"
+	"log/slog"
 	"net/http"
+	"time"
 )
 
 type ResendVerificationRequest struct {
 	Email string `json:"email"`
 }
 
-func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
 	var req ResendVerificationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
 
-	err := utils.ResendVerificationEmail(req.Email)
-	if err != nil {
+	var lang string
+	account, err := h.repos.Accounts.GetByEmail(r.Context(), req.Email)
+	if err == nil {
+		lang = h.emailLanguage(r.Context(), account.UID)
+	}
+	if err := utils.ResendVerificationEmail(req.Email, lang); err != nil {
 		http.Error(w, "Failed to resend verification email", http.StatusInternalServerError)
 		return
 	}
+	if account.UID != "" {
+		if err := h.recordVerificationSent(r.Context(), account.UID); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to record verification email", "uid", account.UID, "error", err)
+		}
+	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Verification email sent successfully"))
 }
+
+// checkEmailVerification reports whether account may sign in: verified
+// accounts always, unverified ones during the grace period that starts with
+// their first verification email. Accounts that never got one, e.g. created
+// before verification was required, are sent one now. The verification
+// status is copied to the profile read by the middleware.
+func (h *Handlers) checkEmailVerification(ctx context.Context, account repository.Account) (bool, error) {
+	if account.EmailVerified {
+		if err := h.repos.Users.Update(ctx, account.UID, map[string]interface{}{"email_verified": true}); err != nil {
+			slog.ErrorContext(ctx, "Failed to record email verification", "uid", account.UID, "error", err)
+		}
+		return true, nil
+	}
+
+	profile, err := h.repos.Users.Get(ctx, account.UID)
+	if err != nil {
+		return false, err
+	}
+	if profile.VerificationSentAt == 0 {
+		if err := utils.SendVerificationEmail(account.Email, profile.Language); err != nil {
+			slog.ErrorContext(ctx, "Failed to send verification email", "uid", account.UID, "error", err)
+		} else if err := h.recordVerificationSent(ctx, account.UID); err != nil {
+			slog.ErrorContext(ctx, "Failed to record verification email", "uid", account.UID, "error", err)
+		}
+		return true, nil
+	}
+	return time.Since(time.Unix(profile.VerificationSentAt, 0)) <= h.emailVerificationGrace, nil
+}
+
+// recordVerificationSent starts the verification grace period of uid unless
+// an earlier verification email already started it.
+func (h *Handlers) recordVerificationSent(ctx context.Context, uid string) error {
+	profile, err := h.repos.Users.Get(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if profile.VerificationSentAt != 0 {
+		return nil
+	}
+	return h.repos.Users.Update(ctx, uid, map[string]interface{}{"verification_sent_at": time.Now().Unix()})
+}
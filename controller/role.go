@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"backend/middleware"
+	"backend/repository"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// SetRoleRequest defines the request payload
+type SetRoleRequest struct {
+	UID  string `json:"uid"`
+	Role string `json:"role"`
+}
+
+// SetRoleHandler assigns a role to a user. Access is restricted to admins by
+// the route policy in main.go.
+func (h *Handlers) SetRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var req SetRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Role {
+	case middleware.RoleAdmin, middleware.RoleModerator, middleware.RoleExpert, middleware.RoleParent:
+	default:
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	// Make sure the user exists before assigning the role
+	if _, err := h.repos.Users.Get(r.Context(), req.UID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to retrieve user", "target", req.UID, "error", err)
+		return
+	}
+
+	if err := h.repos.Users.Update(r.Context(), req.UID, map[string]interface{}{"role": req.Role}); err != nil {
+		http.Error(w, "Failed to update role", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to update role", "target", req.UID, "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Role updated successfully"})
+}
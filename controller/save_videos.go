@@ -2,33 +2,33 @@ package controller
 
 import (
 	"backend/model" // Import the Video model from models/video.go
-	"backend/utils"
-	"context"
+	"backend/notify"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"sort"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 // SaveVideoHandler handles saving videos to the database
-func SaveVideoHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) SaveVideoHandler(w http.ResponseWriter, r *http.Request) {
 	var video model.Video
 	if err := json.NewDecoder(r.Body).Decode(&video); err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
-		log.Printf("Bad Request: %v\n", err)
+		slog.InfoContext(r.Context(), "Bad Request", "error", err)
 		return
 	}
 
 	// Generate a random UUID as the video ID
 	videoID := uuid.New().String()
+	video.CreatedAt = time.Now().Unix()
 
-	// Reference to save the video by the random UUID
-	videoRef := utils.FirebaseDB.NewRef("videos/" + videoID)
-	if err := videoRef.Set(context.Background(), video); err != nil {
+	// Save the video by the random UUID
+	if err := h.repos.Videos.Save(r.Context(), videoID, video); err != nil {
 		http.Error(w, "Failed to save video", http.StatusInternalServerError)
-		log.Printf("Failed to save video: %v\n", err)
+		slog.ErrorContext(r.Context(), "Failed to save video", "error", err)
 		return
 	}
 
@@ -36,29 +36,33 @@ func SaveVideoHandler(w http.ResponseWriter, r *http.Request) {
 	title := "New Video Posted: " + video.Title
 	body := "Check out " + video.Creator + "'s latest video on " + video.Title + "!"
 
-	err := utils.SendNotificationToTopic("new-videos", title, body)
+	err := h.dispatcher.Broadcast(r.Context(), notify.Message{
+		Category: model.CategoryVideos,
+		Type:     model.NotificationVideo,
+		Title:    title,
+		Body:     body,
+		Data:     map[string]string{"video_id": videoID},
+	})
 	if err != nil {
-		log.Printf("Failed to send notification: %v\n", err)
+		slog.ErrorContext(r.Context(), "Failed to send notification", "error", err)
 		http.Error(w, "Failed to send notification", http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Video saved successfully and notification sent for video ID: %s", videoID)
+	slog.InfoContext(r.Context(), "Video saved and notification sent", "video_id", videoID)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Video saved and notification sent successfully"))
 }
 
 // GetVideosHandler handles fetching videos from the database for a given user or by tags
-func GetVideosHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetVideosHandler(w http.ResponseWriter, r *http.Request) {
 	creator := r.URL.Query().Get("creator")
 	tag := r.URL.Query().Get("tag")
 
-	var videos map[string]model.Video
-
-	videosRef := utils.FirebaseDB.NewRef("videos")
-	if err := videosRef.Get(context.Background(), &videos); err != nil {
+	videos, err := h.repos.Videos.List(r.Context())
+	if err != nil {
 		http.Error(w, "Failed to retrieve videos", http.StatusInternalServerError)
-		log.Printf("Failed to retrieve videos: %v\n", err)
+		slog.ErrorContext(r.Context(), "Failed to retrieve videos", "error", err)
 		return
 	}
 
@@ -102,6 +106,6 @@ func GetVideosHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(videoList); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		log.Printf("Failed to encode response: %v\n", err)
+		slog.ErrorContext(r.Context(), "Failed to encode response", "error", err)
 	}
 }
@@ -0,0 +1,27 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSaveVideoWithoutPush(t *testing.T) {
+	h, repos := newTestHandlers(t)
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"title": "Bath time", "creator": "Dr. Rao"}`)
+	h.SaveVideoHandler(rec, httptest.NewRequest(http.MethodPost, "/videos", body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /videos = %d %s", rec.Code, rec.Body)
+	}
+
+	videos, err := repos.Videos.List(context.Background())
+	if err != nil || len(videos) != 1 {
+		t.Fatalf("videos = %v, %v, want the new video", videos, err)
+	}
+	if got := inboxTypes(t, h, "uid-1"); len(got) != 1 || got[0] != "video" {
+		t.Errorf("inbox = %v, want the video", got)
+	}
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"backend/session"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// RefreshTokenRequest defines the request payload for /token/refresh
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// LogoutRequest defines the request payload for /logout
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+	All          bool   `json:"all"` // Sign out of every device
+}
+
+// RefreshTokenHandler exchanges a refresh token for a new token pair.
+func (h *Handlers) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req RefreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+
+	// Sessions end with the email verification grace period, as logins do
+	uid, err := h.sessions.Owner(r.Context(), req.RefreshToken)
+	if err != nil {
+		writeRefreshError(w, r, err)
+		return
+	}
+	account, err := h.repos.Accounts.Get(r.Context(), uid)
+	if err != nil {
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to retrieve account", "uid", uid, "error", err)
+		return
+	}
+	allowed, err := h.checkEmailVerification(r.Context(), account)
+	if err != nil {
+		http.Error(w, "Failed to check email verification", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to check email verification", "uid", uid, "error", err)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Email not verified", http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := h.sessions.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		writeRefreshError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// writeRefreshError writes the response to a refresh token that session
+// rejected with err.
+func writeRefreshError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, session.ErrReused):
+		slog.WarnContext(r.Context(), "Refresh token reuse detected; session revoked")
+		http.Error(w, "Session revoked", http.StatusUnauthorized)
+	case errors.Is(err, session.ErrInvalidToken), errors.Is(err, session.ErrExpired), errors.Is(err, session.ErrRevoked):
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+	default:
+		slog.ErrorContext(r.Context(), "Failed to refresh session", "error", err)
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+	}
+}
+
+// LogoutHandler revokes the session of the given refresh token, or every
+// session of the caller when "all" is set.
+func (h *Handlers) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req LogoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	switch {
+	case req.All:
+		err = h.sessions.RevokeAll(r.Context(), caller.UID)
+	case req.RefreshToken != "":
+		err = h.sessions.Revoke(r.Context(), caller.UID, req.RefreshToken)
+	default:
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, session.ErrInvalidToken) {
+		http.Error(w, "Invalid refresh token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to revoke session", "error", err)
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out successfully"})
+}
@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenEnforcesVerificationGrace(t *testing.T) {
+	tests := []struct {
+		name     string
+		sentAt   time.Duration // Time since the first verification email; 0 for none
+		verified bool
+		want     int
+	}{
+		{"within grace", -time.Hour, false, http.StatusOK},
+		{"grace over", -73 * time.Hour, false, http.StatusUnauthorized},
+		{"verified after the grace", -73 * time.Hour, true, http.StatusOK},
+		// Accounts from before verification start their grace now
+		{"never sent", 0, false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			h, repos := newTestHandlers(t)
+			account, err := repos.Accounts.Create(ctx, "parent@example.com", "secret-password", "parent")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := repos.Accounts.SetEmailVerified(ctx, account.UID, tt.verified); err != nil {
+				t.Fatal(err)
+			}
+			profile := map[string]interface{}{"username": "parent", "role": "parent"}
+			if tt.sentAt != 0 {
+				profile["verification_sent_at"] = time.Now().Add(tt.sentAt).Unix()
+			}
+			if err := repos.Users.Update(ctx, account.UID, profile); err != nil {
+				t.Fatal(err)
+			}
+			tokens, err := h.sessions.Issue(ctx, account.UID, "phone", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			body := strings.NewReader(`{"refresh_token": "` + tokens.RefreshToken + `"}`)
+			h.RefreshTokenHandler(rec, httptest.NewRequest(http.MethodPost, "/token/refresh", body))
+			if rec.Code != tt.want {
+				t.Fatalf("POST /token/refresh = %d %s, want %d", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenRejectsUnknownToken(t *testing.T) {
+	h, _ := newTestHandlers(t)
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"refresh_token": "missing.secret"}`)
+	h.RefreshTokenHandler(rec, httptest.NewRequest(http.MethodPost, "/token/refresh", body))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("POST /token/refresh = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
@@ -3,10 +3,8 @@ package controller
 
 import (
 	"backend/model"
-	"backend/utils"
-	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"sort"
 
@@ -14,11 +12,11 @@ import (
 )
 
 // SaveTopVideoHandler handles saving top videos to the database
-func SaveTopVideoHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) SaveTopVideoHandler(w http.ResponseWriter, r *http.Request) {
 	var video model.Video
 	if err := json.NewDecoder(r.Body).Decode(&video); err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
-		log.Printf("Bad Request: %v\n", err)
+		slog.InfoContext(r.Context(), "Bad Request", "error", err)
 		return
 	}
 
@@ -28,11 +26,10 @@ func SaveTopVideoHandler(w http.ResponseWriter, r *http.Request) {
 	// Set the IsTopVideo attribute to true
 	video.IsTopVideo = true
 
-	// Reference to save the video as a top video by the random UUID
-	topVideoRef := utils.FirebaseDB.NewRef("top_videos/" + videoID)
-	if err := topVideoRef.Set(context.Background(), video); err != nil {
+	// Save the video as a top video by the random UUID
+	if err := h.repos.Videos.SaveTop(r.Context(), videoID, video); err != nil {
 		http.Error(w, "Failed to save top video", http.StatusInternalServerError)
-		log.Printf("Failed to save top video: %v\n", err)
+		slog.ErrorContext(r.Context(), "Failed to save top video", "error", err)
 		return
 	}
 
@@ -41,14 +38,12 @@ func SaveTopVideoHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetTopVideosHandler handles fetching top videos from the database
-func GetTopVideosHandler(w http.ResponseWriter, r *http.Request) {
-	var topVideos map[string]model.Video
-
+func (h *Handlers) GetTopVideosHandler(w http.ResponseWriter, r *http.Request) {
 	// Fetch all top videos from the database
-	topVideosRef := utils.FirebaseDB.NewRef("top_videos")
-	if err := topVideosRef.Get(context.Background(), &topVideos); err != nil {
+	topVideos, err := h.repos.Videos.ListTop(r.Context())
+	if err != nil {
 		http.Error(w, "Failed to retrieve top videos", http.StatusInternalServerError)
-		log.Printf("Failed to retrieve top videos: %v\n", err)
+		slog.ErrorContext(r.Context(), "Failed to retrieve top videos", "error", err)
 		return
 	}
 
@@ -67,6 +62,6 @@ func GetTopVideosHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(videoList); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		log.Printf("Failed to encode response: %v\n", err)
+		slog.ErrorContext(r.Context(), "Failed to encode response", "error", err)
 	}
 }
@@ -0,0 +1,310 @@
+package controller
+
+import (
+	"backend/lockout"
+	"backend/middleware"
+	"backend/repository"
+	"backend/totp"
+	"backend/utils"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// twoFactorIssuer is the account issuer shown by authenticator apps.
+const twoFactorIssuer = "We Grow"
+
+// recoveryCodeCount is the number of recovery codes issued on enrolment.
+const recoveryCodeCount = 10
+
+// errInvalidCode is returned when a TOTP or recovery code does not match.
+var errInvalidCode = errors.New("invalid two-factor code")
+
+// TwoFactorCodeRequest defines the request payload for /2fa/verify and /2fa/disable
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// TwoFactorLoginRequest defines the request payload for /login/2fa
+type TwoFactorLoginRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"` // TOTP code or recovery code
+}
+
+// TwoFactorLoginHandler completes a login started by LoginHandler for users
+// with two-factor authentication enabled.
+func (h *Handlers) TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Challenge == "" || req.Code == "" {
+		http.Error(w, "Challenge and code are required", http.StatusBadRequest)
+		return
+	}
+
+	uid, err := h.sessions.VerifyChallenge(req.Challenge)
+	if err != nil {
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+
+	// Codes are throttled like passwords, keyed by user rather than email
+	ip := utils.ClientIP(r)
+	key := lockout.TwoFactorKey(uid)
+	status, err := h.loginGuard.Check(r.Context(), key, ip)
+	if err != nil {
+		http.Error(w, "Failed to check login attempts", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to check login attempts", "error", err)
+		return
+	}
+	if status.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(status.RetryAfter.Seconds()))))
+		http.Error(w, "Too many failed attempts, please try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	err = h.repos.TwoFactor.Update(r.Context(), uid, func(tf repository.TwoFactor) (repository.TwoFactor, error) {
+		if !tf.Enabled {
+			return tf, errInvalidCode
+		}
+		return checkSecondFactor(tf, req.Code, time.Now())
+	})
+	if errors.Is(err, errInvalidCode) {
+		if _, err := h.loginGuard.Fail(r.Context(), key, ip); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to record login failure", "error", err)
+		}
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to verify two-factor code", "uid", uid, "error", err)
+		return
+	}
+	if err := h.loginGuard.Succeed(r.Context(), key); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to clear login attempts", "uid", uid, "error", err)
+	}
+
+	h.writeLoginResponse(w, r, uid, true)
+}
+
+// SetupTwoFactorHandler starts TOTP enrolment. It returns a new secret and
+// the otpauth:// URI to show as a QR code; the secret only becomes active
+// once a code is confirmed at /2fa/verify.
+func (h *Handlers) SetupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to generate TOTP secret", "error", err)
+		return
+	}
+
+	err = h.repos.TwoFactor.Update(r.Context(), caller.UID, func(tf repository.TwoFactor) (repository.TwoFactor, error) {
+		if tf.Enabled {
+			return tf, repository.ErrConflict
+		}
+		tf.PendingSecret = secret
+		return tf, nil
+	})
+	if errors.Is(err, repository.ErrConflict) {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to start two-factor setup", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to store pending TOTP secret", "error", err)
+		return
+	}
+
+	// Label the entry in the authenticator app with the username
+	account := caller.Username
+	if account == "" {
+		account = caller.UID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":           secret,
+		"provisioning_uri": totp.ProvisioningURI(secret, account, twoFactorIssuer),
+	})
+}
+
+// VerifyTwoFactorHandler confirms enrolment with a code from the
+// authenticator app. It returns the one-time recovery codes and a new token
+// pair that satisfies the second factor.
+func (h *Handlers) VerifyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to generate recovery codes", "error", err)
+		return
+	}
+
+	err = h.repos.TwoFactor.Update(r.Context(), caller.UID, func(tf repository.TwoFactor) (repository.TwoFactor, error) {
+		if tf.Enabled {
+			return tf, repository.ErrConflict
+		}
+		if tf.PendingSecret == "" {
+			return tf, repository.ErrNotFound
+		}
+		step, ok := totp.Validate(tf.PendingSecret, req.Code, time.Now())
+		if !ok {
+			return tf, errInvalidCode
+		}
+		return repository.TwoFactor{
+			Secret:        tf.PendingSecret,
+			Enabled:       true,
+			RecoveryCodes: hashes,
+			LastStep:      step,
+		}, nil
+	})
+	switch {
+	case errors.Is(err, repository.ErrConflict):
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	case errors.Is(err, repository.ErrNotFound):
+		http.Error(w, "Start two-factor setup first", http.StatusBadRequest)
+		return
+	case errors.Is(err, errInvalidCode):
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to enable two-factor authentication", "error", err)
+		return
+	}
+
+	if err := h.repos.Users.Update(r.Context(), caller.UID, map[string]interface{}{"two_factor_enabled": true}); err != nil {
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to set two-factor flag", "error", err)
+		return
+	}
+
+	tokens, err := h.sessions.Issue(r.Context(), caller.UID, r.UserAgent(), true)
+	if err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to create session", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+		"access_token":   tokens.AccessToken,
+		"refresh_token":  tokens.RefreshToken,
+		"token_type":     tokens.TokenType,
+		"expires_in":     tokens.ExpiresIn,
+	})
+}
+
+// DisableTwoFactorHandler turns two-factor authentication off after checking
+// a current TOTP or recovery code. Roles that require it cannot disable it.
+func (h *Handlers) DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	if h.twoFactorRoles[caller.Role] {
+		middleware.Forbidden(w, "Two-factor authentication is required for your role")
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+
+	err := h.repos.TwoFactor.Update(r.Context(), caller.UID, func(tf repository.TwoFactor) (repository.TwoFactor, error) {
+		if !tf.Enabled {
+			return tf, repository.ErrNotFound
+		}
+		return checkSecondFactor(tf, req.Code, time.Now())
+	})
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	case errors.Is(err, errInvalidCode):
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to verify two-factor code", "error", err)
+		return
+	}
+
+	if err := h.repos.TwoFactor.Delete(r.Context(), caller.UID); err != nil {
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to delete two-factor enrolment", "error", err)
+		return
+	}
+	if err := h.repos.Users.Update(r.Context(), caller.UID, map[string]interface{}{"two_factor_enabled": false}); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to clear two-factor flag", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// checkSecondFactor accepts a TOTP code newer than the last one used, or an
+// unused recovery code, and returns the enrolment with the code consumed.
+func checkSecondFactor(tf repository.TwoFactor, code string, now time.Time) (repository.TwoFactor, error) {
+	if step, ok := totp.Validate(tf.Secret, code, now); ok {
+		if step <= tf.LastStep {
+			return tf, errInvalidCode
+		}
+		tf.LastStep = step
+		return tf, nil
+	}
+
+	hash := utils.HashToken(normalizeRecoveryCode(code))
+	if !tf.RecoveryCodes[hash] {
+		return tf, errInvalidCode
+	}
+	delete(tf.RecoveryCodes, hash)
+	return tf, nil
+}
+
+// newRecoveryCodes returns fresh recovery codes formatted as "xxxxx-xxxxx"
+// along with the set of their hashes to store.
+func newRecoveryCodes() ([]string, map[string]bool, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make(map[string]bool, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[utils.HashToken(code)] = true
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
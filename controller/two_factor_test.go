@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"backend/repository"
+	"backend/totp"
+	"backend/utils"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTOTPSecret is the TOTP secret of the accounts enrolled by
+// enrolTwoFactor.
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// testRecoveryCode is the one recovery code of those accounts.
+const testRecoveryCode = "abcde-12345"
+
+// enrolTwoFactor creates the test account with two-factor authentication
+// enabled and returns its UID.
+func enrolTwoFactor(t *testing.T, h *Handlers) string {
+	t.Helper()
+	ctx := context.Background()
+	uid := createTestAccount(t, h, "secret-password", map[string]interface{}{"two_factor_enabled": true})
+	if err := h.repos.Accounts.SetEmailVerified(ctx, uid, true); err != nil {
+		t.Fatal(err)
+	}
+	err := h.repos.TwoFactor.Update(ctx, uid, func(repository.TwoFactor) (repository.TwoFactor, error) {
+		return repository.TwoFactor{
+			Secret:        testTOTPSecret,
+			Enabled:       true,
+			RecoveryCodes: map[string]bool{utils.HashToken(normalizeRecoveryCode(testRecoveryCode)): true},
+		}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uid
+}
+
+func currentCode(t *testing.T) string {
+	t.Helper()
+	code, err := totp.Code(testTOTPSecret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func loginTwoFactor(h *Handlers, challenge, code string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"challenge": "` + challenge + `", "code": "` + code + `"}`)
+	h.TwoFactorLoginHandler(rec, httptest.NewRequest(http.MethodPost, "/login/2fa", body))
+	return rec
+}
+
+func challengeFor(t *testing.T, h *Handlers, uid string) string {
+	t.Helper()
+	challenge, err := h.sessions.IssueChallenge(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestHandlers(t)
+	uid := enrolTwoFactor(t, h)
+
+	rec := login(h, "parent@example.com", "secret-password")
+	var started struct {
+		Required    bool   `json:"two_factor_required"`
+		Challenge   string `json:"challenge"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &started); err != nil {
+		t.Fatalf("POST /login = %d %s", rec.Code, rec.Body)
+	}
+	if !started.Required || started.Challenge == "" || started.AccessToken != "" {
+		t.Fatalf("password alone signed in: %s", rec.Body)
+	}
+
+	rec = loginTwoFactor(h, started.Challenge, currentCode(t))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /login/2fa = %d %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	id, err := h.sessions.VerifyToken(ctx, resp.AccessToken)
+	if err != nil || id.UID != uid || !id.SecondFactor {
+		t.Errorf("identity after the second step = %+v, %v", id, err)
+	}
+}
+
+func TestTwoFactorLoginRejectsReplayedCode(t *testing.T) {
+	h, _ := newTestHandlers(t)
+	uid := enrolTwoFactor(t, h)
+	code := currentCode(t)
+
+	if rec := loginTwoFactor(h, challengeFor(t, h, uid), code); rec.Code != http.StatusOK {
+		t.Fatalf("first use = %d %s", rec.Code, rec.Body)
+	}
+	tf, err := h.repos.TwoFactor.Get(context.Background(), uid)
+	if err != nil || tf.LastStep == 0 {
+		t.Fatalf("LastStep not recorded: %+v, %v", tf, err)
+	}
+	if rec := loginTwoFactor(h, challengeFor(t, h, uid), code); rec.Code != http.StatusUnauthorized {
+		t.Errorf("replayed code = %d %s, want 401", rec.Code, rec.Body)
+	}
+}
+
+func TestTwoFactorLoginRecoveryCodeIsSingleUse(t *testing.T) {
+	h, _ := newTestHandlers(t)
+	uid := enrolTwoFactor(t, h)
+	// Codes are accepted however the user types them
+	if rec := loginTwoFactor(h, challengeFor(t, h, uid), " ABCDE12345 "); rec.Code != http.StatusOK {
+		t.Fatalf("recovery code = %d %s", rec.Code, rec.Body)
+	}
+
+	h.loginGuard.Now = func() time.Time { return time.Now().Add(time.Hour) }
+	if rec := loginTwoFactor(h, challengeFor(t, h, uid), testRecoveryCode); rec.Code != http.StatusUnauthorized {
+		t.Errorf("reused recovery code = %d %s, want 401", rec.Code, rec.Body)
+	}
+}
+
+func TestTwoFactorLoginLockout(t *testing.T) {
+	h, _ := newTestHandlers(t)
+	uid := enrolTwoFactor(t, h)
+	now := time.Now()
+	h.loginGuard.Now = func() time.Time { return now }
+
+	rec := loginTwoFactor(h, challengeFor(t, h, uid), "000000")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong code = %d %s, want 401", rec.Code, rec.Body)
+	}
+	if rec := loginTwoFactor(h, challengeFor(t, h, uid), currentCode(t)); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("attempt during the backoff = %d %s, want 429 with Retry-After", rec.Code, rec.Body)
+	}
+
+	for i := 1; i < h.loginGuard.MaxFailures; i++ {
+		now = now.Add(h.loginGuard.MaxDelay)
+		loginTwoFactor(h, challengeFor(t, h, uid), "000000")
+	}
+	now = now.Add(h.loginGuard.MaxDelay)
+	if rec := loginTwoFactor(h, challengeFor(t, h, uid), currentCode(t)); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("right code after %d failures = %d %s, want 429", h.loginGuard.MaxFailures, rec.Code, rec.Body)
+	}
+	// The lock is on the second factor of uid, not on the email
+	if rec := login(h, "parent@example.com", "secret-password"); rec.Code != http.StatusOK {
+		t.Errorf("password step while the codes are locked = %d %s", rec.Code, rec.Body)
+	}
+
+	now = now.Add(h.loginGuard.LockDuration)
+	if rec := loginTwoFactor(h, challengeFor(t, h, uid), currentCode(t)); rec.Code != http.StatusOK {
+		t.Errorf("right code after the lock = %d %s", rec.Code, rec.Body)
+	}
+}
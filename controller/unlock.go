@@ -0,0 +1,31 @@
+package controller
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// UnlockAccountRequest defines the request payload
+type UnlockAccountRequest struct {
+	Email string `json:"email"`
+}
+
+// UnlockAccountHandler clears the failed login counters and lock of an
+// account. Access is restricted to admins by the route policy in main.go.
+func (h *Handlers) UnlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var req UnlockAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.loginGuard.Unlock(r.Context(), req.Email); err != nil {
+		http.Error(w, "Failed to unlock account", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to unlock account", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Account unlocked successfully"})
+}
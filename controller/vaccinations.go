@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"backend/model"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RecordVaccinationRequest defines the request payload for marking a dose
+// as given
+type RecordVaccinationRequest struct {
+	DoseID  string `json:"dose_id"`
+	GivenOn string `json:"given_on"` // YYYY-MM-DD, today if empty
+}
+
+// GetVaccineScheduleHandler returns the national immunization schedule.
+func (h *Handlers) GetVaccineScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.vaccineSchedule)
+}
+
+// GetChildVaccinationsHandler returns the personal immunization schedule of
+// one of the caller's children, with the dates each dose is due and overdue.
+func (h *Handlers) GetChildVaccinationsHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	child, ok := h.loadChild(w, r, caller.UID)
+	if !ok {
+		return
+	}
+	given, err := h.repos.Vaccines.Given(r.Context(), caller.UID, child.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve vaccinations", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to retrieve vaccinations", "child_id", child.ID, "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"schedule": h.vaccineSchedule.Name,
+		"doses":    h.vaccineSchedule.Plan(child.DOB, given, time.Now()),
+	})
+}
+
+// RecordVaccinationHandler marks a dose as given to one of the caller's
+// children, which stops its reminders. Recording it again replaces the date.
+func (h *Handlers) RecordVaccinationHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req RecordVaccinationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DoseID == "" {
+		http.Error(w, "Dose ID is required", http.StatusBadRequest)
+		return
+	}
+	if _, ok := h.vaccineSchedule.Dose(req.DoseID); !ok {
+		http.Error(w, "Dose not found", http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	givenOn := model.Date{Time: time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)}
+	if req.GivenOn != "" {
+		d, err := model.ParseDate(req.GivenOn)
+		if err != nil {
+			http.Error(w, "Given date must be a date like 2023-04-30", http.StatusBadRequest)
+			return
+		}
+		givenOn = d
+	}
+
+	child, ok := h.loadChild(w, r, caller.UID)
+	if !ok {
+		return
+	}
+	if givenOn.Before(child.DOB.Time) || givenOn.After(now) {
+		http.Error(w, "Given date must be between the date of birth and today", http.StatusBadRequest)
+		return
+	}
+
+	v := model.Vaccination{GivenOn: givenOn, RecordedAt: now.Unix()}
+	if err := h.repos.Vaccines.Record(r.Context(), caller.UID, child.ID, req.DoseID, v); err != nil {
+		http.Error(w, "Failed to save vaccination", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to save dose", "dose_id", req.DoseID, "child_id", child.ID, "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dose_id":  req.DoseID,
+		"given_on": v.GivenOn,
+	})
+}
+
+// UnrecordVaccinationHandler removes a dose recorded by mistake.
+func (h *Handlers) UnrecordVaccinationHandler(w http.ResponseWriter, r *http.Request) {
+	caller, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	child, ok := h.loadChild(w, r, caller.UID)
+	if !ok {
+		return
+	}
+	doseID := mux.Vars(r)["dose_id"]
+	if err := h.repos.Vaccines.Unrecord(r.Context(), caller.UID, child.ID, doseID); err != nil {
+		http.Error(w, "Failed to remove vaccination", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to remove dose", "dose_id", doseID, "child_id", child.ID, "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Vaccination removed successfully"})
+}
@@ -0,0 +1,353 @@
+// Package deletion carries out account deletions.
+//
+// A deletion is requested through Schedule and runs once its ExecuteAt time
+// has passed, so it can be cancelled during the undo window. The Worker then
+// walks every post: the user's own posts and comments are anonymized or
+// removed depending on the mode, and their likes and flags are removed with
+// the counters fixed. Progress is saved after every batch and each step is
+// idempotent, so an interrupted deletion resumes safely. Finally everything
+// else kept about the user is removed, from the data export and pending
+// jobs to the profile, sessions and auth account, and an audit entry is
+// written.
+package deletion
+
+import (
+	"backend/lockout"
+	"backend/model"
+	"backend/repository"
+	"backend/utils"
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// DefaultBatchSize is the number of posts read per batch.
+const DefaultBatchSize = 100
+
+// DefaultUndoWindow is how long a scheduled deletion can be cancelled.
+const DefaultUndoWindow = 14 * 24 * time.Hour
+
+// pollInterval is how often the worker looks for deletions that became due.
+const pollInterval = time.Minute
+
+// SessionRevoker signs a user out everywhere.
+type SessionRevoker interface {
+	RevokeAll(ctx context.Context, uid string) error
+}
+
+// Worker schedules and runs account deletions.
+type Worker struct {
+	repos    *repository.Repositories
+	sessions SessionRevoker
+	attempts *lockout.Guard
+	wake     chan struct{}
+	// BatchSize is the number of posts processed between progress updates.
+	BatchSize int
+	// UndoWindow is the delay of deletions scheduled with undo.
+	UndoWindow time.Duration
+	// Now returns the current time; tests may replace it.
+	Now func() time.Time
+}
+
+// NewWorker returns a Worker with the default batch size and undo window.
+func NewWorker(repos *repository.Repositories, sessions SessionRevoker) *Worker {
+	return &Worker{
+		repos:      repos,
+		sessions:   sessions,
+		attempts:   lockout.NewGuard(repos.Attempts),
+		wake:       make(chan struct{}, 1),
+		BatchSize:  DefaultBatchSize,
+		UndoWindow: DefaultUndoWindow,
+		Now:        time.Now,
+	}
+}
+
+// Schedule records the deletion of uid requested by actor. With undo set it
+// runs after UndoWindow, otherwise as soon as possible. It returns
+// repository.ErrConflict when a deletion is already scheduled.
+func (w *Worker) Schedule(ctx context.Context, uid, actor, mode string, undo bool) (repository.AccountDeletion, error) {
+	profile, err := w.repos.Users.Get(ctx, uid)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return repository.AccountDeletion{}, err
+	}
+
+	now := w.Now()
+	d := repository.AccountDeletion{
+		UID:         uid,
+		Username:    profile.Username,
+		RequestedBy: actor,
+		Mode:        mode,
+		Status:      repository.DeletionScheduled,
+		ExecuteAt:   now.Unix(),
+		CreatedAt:   now.Unix(),
+		UpdatedAt:   now.Unix(),
+	}
+	if undo {
+		d.ExecuteAt = now.Add(w.UndoWindow).Unix()
+	}
+	if err := w.repos.Deletions.Create(ctx, d); err != nil {
+		return repository.AccountDeletion{}, err
+	}
+	w.audit(ctx, "account_deletion_scheduled", actor, uid, map[string]string{
+		"mode":       mode,
+		"execute_at": time.Unix(d.ExecuteAt, 0).UTC().Format(time.RFC3339),
+	})
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return d, nil
+}
+
+// Cancel withdraws the scheduled deletion of uid. It returns
+// repository.ErrNotFound when there is none, and repository.ErrConflict when
+// the deletion has already started.
+func (w *Worker) Cancel(ctx context.Context, uid, actor string) error {
+	d, err := w.repos.Deletions.Get(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if d.Status != repository.DeletionScheduled {
+		return repository.ErrNotFound
+	}
+	if d.ExecuteAt <= w.Now().Unix() {
+		return repository.ErrConflict
+	}
+	if err := w.repos.Deletions.Delete(ctx, uid); err != nil {
+		return err
+	}
+	w.audit(ctx, "account_deletion_cancelled", actor, uid, nil)
+	return nil
+}
+
+// Run executes due deletions until ctx is cancelled, starting with any left
+// over from a previous run.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		w.runDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDue runs every scheduled deletion whose time has come, oldest first.
+func (w *Worker) runDue(ctx context.Context) {
+	scheduled, err := w.repos.Deletions.ListScheduled(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list account deletions", "error", err)
+		return
+	}
+	now := w.Now().Unix()
+	due := make([]repository.AccountDeletion, 0, len(scheduled))
+	for uid, d := range scheduled {
+		if d.ExecuteAt <= now {
+			d.UID = uid
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ExecuteAt < due[j].ExecuteAt })
+
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := w.run(ctx, d); err != nil {
+			slog.WarnContext(ctx, "Account deletion failed, will retry", "uid", d.UID, "error", err)
+			if err := w.repos.Deletions.Update(ctx, d.UID, map[string]interface{}{
+				"error":      err.Error(),
+				"updated_at": w.Now().Unix(),
+			}); err != nil {
+				slog.ErrorContext(ctx, "Failed to record error of account deletion", "uid", d.UID, "error", err)
+			}
+		}
+	}
+}
+
+// run removes the user's traces from every post after the cursor, then the
+// account itself.
+func (w *Worker) run(ctx context.Context, d repository.AccountDeletion) error {
+	// Sign the user out first so nothing new is written meanwhile
+	if err := w.sessions.RevokeAll(ctx, d.UID); err != nil {
+		return err
+	}
+	// Follow a username change made during the undo window
+	if profile, err := w.repos.Users.Get(ctx, d.UID); err == nil && profile.Username != "" {
+		d.Username = profile.Username
+	}
+
+	for {
+		page, err := w.repos.Posts.ListAfter(ctx, d.Cursor, w.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, post := range page {
+			if err := w.scrub(ctx, post, d); err != nil {
+				return err
+			}
+		}
+		d.Cursor = page[len(page)-1].ID
+		if err := w.repos.Deletions.Update(ctx, d.UID, map[string]interface{}{
+			"cursor":     d.Cursor,
+			"username":   d.Username,
+			"updated_at": w.Now().Unix(),
+		}); err != nil {
+			return err
+		}
+		if len(page) < w.BatchSize {
+			break
+		}
+	}
+
+	if name, err := utils.NormalizeUsername(d.Username); err == nil {
+		if err := w.repos.Usernames.Release(ctx, name, d.UID); err != nil {
+			return err
+		}
+	}
+	if err := w.repos.TwoFactor.Delete(ctx, d.UID); err != nil {
+		return err
+	}
+	if err := w.repos.Devices.DeleteAll(ctx, d.UID); err != nil {
+		return err
+	}
+	if err := w.repos.Inbox.DeleteAll(ctx, d.UID); err != nil {
+		return err
+	}
+	if err := w.repos.Prefs.Delete(ctx, d.UID); err != nil {
+		return err
+	}
+	if err := w.repos.LikeQueue.DeleteAll(ctx, d.UID); err != nil {
+		return err
+	}
+	if err := w.repos.Digests.DeleteAll(ctx, d.UID); err != nil {
+		return err
+	}
+	if err := w.repos.Vaccines.DeleteAll(ctx, d.UID); err != nil {
+		return err
+	}
+	if err := w.repos.Growth.DeleteAll(ctx, d.UID); err != nil {
+		return err
+	}
+	if err := w.repos.Milestones.DeleteAll(ctx, d.UID); err != nil {
+		return err
+	}
+	if err := w.repos.Children.DeleteAll(ctx, d.UID); err != nil {
+		return err
+	}
+	if err := w.repos.Exports.Delete(ctx, d.UID); err != nil {
+		return err
+	}
+	if err := w.repos.RenameJobs.DeleteAll(ctx, d.UID); err != nil {
+		return err
+	}
+	if err := w.repos.Resets.DeleteAll(ctx, d.UID); err != nil {
+		return err
+	}
+	// The login counters are keyed by email, which goes with the account
+	account, err := w.repos.Accounts.Get(ctx, d.UID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	if err := w.attempts.Forget(ctx, account.Email, d.UID); err != nil {
+		return err
+	}
+	if err := w.repos.Users.Delete(ctx, d.UID); err != nil {
+		return err
+	}
+	if err := w.repos.Accounts.Delete(ctx, d.UID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	if err := w.repos.Sessions.DeleteAll(ctx, d.UID); err != nil {
+		return err
+	}
+
+	w.audit(ctx, "account_deleted", d.RequestedBy, d.UID, map[string]string{"mode": d.Mode})
+	return w.repos.Deletions.Update(ctx, d.UID, map[string]interface{}{
+		"status":     repository.DeletionDone,
+		"error":      nil,
+		"updated_at": w.Now().Unix(),
+	})
+}
+
+// scrub removes the user's traces from a single post.
+func (w *Worker) scrub(ctx context.Context, post model.Post, d repository.AccountDeletion) error {
+	if authored(post.UID, post.Username, d) && d.Mode == repository.DeletionPurge {
+		return w.repos.Posts.Delete(ctx, post.ID)
+	}
+
+	fields := make(map[string]interface{})
+	if authored(post.UID, post.Username, d) {
+		fields["username"] = repository.DeletedUsername
+		fields["uid"] = nil
+	}
+	removeVoter(fields, "", "likes", "like_count", post.Likes, post.LikeCount, d.Username)
+	removeVoter(fields, "", "flags", "flag_count", post.Flags, post.FlagCount, d.Username)
+
+	removed := 0
+	for id, comment := range post.Comments {
+		prefix := "comments/" + id + "/"
+		if authored(comment.UID, comment.Username, d) {
+			if d.Mode == repository.DeletionPurge {
+				fields["comments/"+id] = nil
+				removed++
+				continue
+			}
+			fields[prefix+"username"] = repository.DeletedUsername
+			fields[prefix+"uid"] = nil
+		}
+		removeVoter(fields, prefix, "likes", "like_count", comment.Likes, comment.LikeCount, d.Username)
+		removeVoter(fields, prefix, "flags", "flag_count", comment.Flags, comment.FlagCount, d.Username)
+	}
+	if removed > 0 {
+		fields["comment_count"] = max(post.CommentCount-removed, 0)
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return w.repos.Posts.Update(ctx, post.ID, fields)
+}
+
+// authored reports whether content with the given author UID and username
+// belongs to the user being deleted. Content that predates author UIDs is
+// matched by username.
+func authored(uid, username string, d repository.AccountDeletion) bool {
+	if uid != "" {
+		return uid == d.UID
+	}
+	return d.Username != "" && username == d.Username
+}
+
+// removeVoter drops username from a likes or flags map and lowers the
+// counter.
+func removeVoter(fields map[string]interface{}, prefix, set, counter string, voters map[string]bool, count int, username string) {
+	if username == "" || !voters[username] {
+		return
+	}
+	fields[prefix+set+"/"+username] = nil
+	fields[prefix+counter] = max(count-1, 0)
+}
+
+func (w *Worker) audit(ctx context.Context, action, actor, target string, details map[string]string) {
+	entry := repository.AuditEntry{
+		Action:    action,
+		ActorUID:  actor,
+		TargetUID: target,
+		Details:   details,
+		CreatedAt: w.Now().Unix(),
+	}
+	if err := w.repos.Audit.Record(ctx, entry); err != nil {
+		slog.ErrorContext(ctx, "Failed to record audit entry", "action", action, "target", target, "error", err)
+	}
+}
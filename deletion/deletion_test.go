@@ -0,0 +1,198 @@
+package deletion
+
+import (
+	"backend/lockout"
+	"backend/model"
+	"backend/repository"
+	"backend/session"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// populate stores something in every per-user store for the account it
+// creates, named alice, and returns its UID. Alice also comments on, likes
+// and flags a post of bob's and likes his comment on her own post.
+func populate(t *testing.T, repos *repository.Repositories, sessions *session.Manager, guard *lockout.Guard) string {
+	t.Helper()
+	ctx := context.Background()
+	account, err := repos.Accounts.Create(ctx, "alice@example.com", "secret123", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := account.UID
+	dob, _ := model.ParseDate("2024-01-15")
+	now := time.Now().Unix()
+
+	steps := []func() error{
+		func() error {
+			return repos.Users.Update(ctx, uid, map[string]interface{}{"username": "alice", "email": "alice@example.com"})
+		},
+		func() error { return repos.Usernames.Claim(ctx, "alice", uid) },
+		func() error { _, err := sessions.Issue(ctx, uid, "phone", false); return err },
+		func() error {
+			return repos.Exports.Request(ctx, repository.DataExport{UID: uid, Status: repository.ExportReady, RequestedAt: now, TokenHash: "hash"}, now-1)
+		},
+		func() error { return repos.Exports.SaveArchive(ctx, uid, `{"profile":{}}`) },
+		func() error {
+			return repos.RenameJobs.Create(ctx, repository.RenameJob{ID: "job-1", UID: uid, OldUsername: "al", NewUsername: "alice", Status: repository.RenameJobPending})
+		},
+		func() error {
+			return repos.Resets.Create(ctx, repository.PasswordReset{TokenHash: "reset-1", UID: uid, ExpiresAt: now + 3600})
+		},
+		func() error { _, err := guard.Fail(ctx, "alice@example.com", "203.0.113.1"); return err },
+		func() error { _, err := guard.Fail(ctx, lockout.TwoFactorKey(uid), "203.0.113.1"); return err },
+		func() error { _, err := guard.Fail(ctx, lockout.PasswordKey(uid), "203.0.113.1"); return err },
+		func() error {
+			return repos.TwoFactor.Update(ctx, uid, func(tf repository.TwoFactor) (repository.TwoFactor, error) {
+				tf.Secret, tf.Enabled = "SECRET", true
+				return tf, nil
+			})
+		},
+		func() error { return repos.Devices.Register(ctx, uid, repository.Device{Token: "device-1"}) },
+		func() error {
+			_, err := repos.Inbox.Add(ctx, uid, model.Notification{Type: model.NotificationComment, Title: "Hi"})
+			return err
+		},
+		func() error { return repos.Prefs.Save(ctx, uid, model.NotificationPreferences{Digest: true}) },
+		func() error { return repos.Children.Save(ctx, uid, model.Child{ID: "c1", Name: "Sam", DOB: dob}) },
+		func() error {
+			return repos.Growth.Save(ctx, uid, "c1", model.Measurement{ID: "m1", Date: dob, WeightKG: 3.4})
+		},
+		func() error {
+			return repos.Milestones.Achieve(ctx, uid, "c1", "smile", model.Achievement{AchievedOn: dob})
+		},
+		func() error { return repos.Vaccines.Record(ctx, uid, "c1", "bcg", model.Vaccination{GivenOn: dob}) },
+		func() error { return repos.Digests.Claim(ctx, uid, "2024-05-06", now) },
+		func() error {
+			return repos.LikeQueue.Add(ctx, "p1", repository.PendingLike{UID: uid, PostID: "p1"}, "bob", now)
+		},
+		func() error {
+			return repos.Posts.Save(ctx, model.Post{
+				ID: "p1", Username: "alice", UID: uid, Title: "Sleep",
+				Comments: map[string]model.Comment{
+					"c1": {ID: "c1", Username: "bob", UID: "uid-bob", Likes: map[string]bool{"alice": true}, LikeCount: 1},
+				},
+				CommentCount: 1,
+			})
+		},
+		func() error {
+			return repos.Posts.Save(ctx, model.Post{
+				ID: "p2", Username: "bob", UID: "uid-bob", Title: "Food",
+				Comments: map[string]model.Comment{
+					"c2": {ID: "c2", Username: "alice", UID: uid, Content: "Try rice"},
+				},
+				CommentCount: 1,
+				Likes:        map[string]bool{"alice": true},
+				LikeCount:    1,
+				Flags:        map[string]bool{"alice": true},
+				FlagCount:    1,
+			})
+		},
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("populate step %d: %v", i, err)
+		}
+	}
+	return uid
+}
+
+func TestRunLeavesNothingBehind(t *testing.T) {
+	ctx := context.Background()
+	repos := repository.NewMemory()
+	sessions := session.NewManager(repos.Sessions, []byte("test-secret"))
+	guard := lockout.NewGuard(repos.Attempts)
+	guard.BaseDelay, guard.MaxDelay = time.Hour, time.Hour
+	uid := populate(t, repos, sessions, guard)
+
+	w := NewWorker(repos, sessions)
+	if _, err := w.Schedule(ctx, uid, uid, repository.DeletionAnonymize, false); err != nil {
+		t.Fatal(err)
+	}
+	w.runDue(ctx)
+
+	d, err := repos.Deletions.Get(ctx, uid)
+	if err != nil || d.Status != repository.DeletionDone {
+		t.Fatalf("deletion = %+v, %v, want done", d, err)
+	}
+
+	notFound := func(name string, err error) {
+		t.Helper()
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("%s: error = %v, want ErrNotFound", name, err)
+		}
+	}
+	empty := func(name string, n int, err error) {
+		t.Helper()
+		if err != nil || n != 0 {
+			t.Errorf("%s: %d left, error %v", name, n, err)
+		}
+	}
+
+	_, err = repos.Accounts.Get(ctx, uid)
+	notFound("account", err)
+	_, err = repos.Users.Get(ctx, uid)
+	notFound("profile", err)
+	_, err = repos.Usernames.Lookup(ctx, "alice")
+	notFound("username", err)
+	_, err = repos.Exports.Get(ctx, uid)
+	notFound("export", err)
+	_, err = repos.Exports.Archive(ctx, uid)
+	notFound("export archive", err)
+	_, err = repos.RenameJobs.Get(ctx, "job-1")
+	notFound("rename job", err)
+	_, err = repos.Resets.Consume(ctx, "reset-1")
+	notFound("password reset", err)
+
+	sessionList, err := repos.Sessions.ListByUser(ctx, uid)
+	empty("sessions", len(sessionList), err)
+	devices, err := repos.Devices.List(ctx, uid)
+	empty("devices", len(devices), err)
+	inbox, err := repos.Inbox.List(ctx, uid, "", 10)
+	empty("inbox", len(inbox), err)
+	children, err := repos.Children.List(ctx, uid)
+	empty("children", len(children), err)
+	growth, err := repos.Growth.List(ctx, uid, "c1")
+	empty("growth", len(growth), err)
+	achievements, err := repos.Milestones.Achievements(ctx, uid, "c1")
+	empty("milestones", len(achievements), err)
+	given, err := repos.Vaccines.Given(ctx, uid, "c1")
+	empty("vaccinations", len(given), err)
+	likes, err := repos.LikeQueue.Due(ctx, time.Now().Unix()+1, 10)
+	empty("pending likes", len(likes), err)
+
+	tf, err := repos.TwoFactor.Get(ctx, uid)
+	if err != nil || tf.Secret != "" || tf.Enabled {
+		t.Errorf("two-factor enrolment left: %+v, %v", tf, err)
+	}
+	prefs, err := repos.Prefs.Get(ctx, uid)
+	if err != nil || prefs.Digest {
+		t.Errorf("preferences left: %+v, %v", prefs, err)
+	}
+	if err := repos.Digests.Claim(ctx, uid, "2024-05-06", time.Now().Unix()); err != nil {
+		t.Errorf("digest history left: Claim error = %v", err)
+	}
+	for _, key := range []string{"alice@example.com", lockout.TwoFactorKey(uid), lockout.PasswordKey(uid)} {
+		status, err := guard.Check(ctx, key, "198.51.100.1")
+		if err != nil || status.RetryAfter != 0 {
+			t.Errorf("login attempts for %s left: %+v, %v", key, status, err)
+		}
+	}
+
+	posts, err := repos.Posts.ListAfter(ctx, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, post := range posts {
+		if post.UID == uid || post.Username == "alice" || post.Likes["alice"] || post.Flags["alice"] {
+			t.Errorf("post %s still references the user: %+v", post.ID, post)
+		}
+		for id, c := range post.Comments {
+			if c.UID == uid || c.Username == "alice" || c.Likes["alice"] {
+				t.Errorf("comment %s still references the user: %+v", id, c)
+			}
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// Package digest emails a weekly summary of community activity to the users
+// who subscribed to it in their notification preferences.
+//
+// Once a week, at Hour on Weekday (UTC), the Worker collects the activity of
+// the past seven days: new comments on each user's posts, the top posts in
+// the tags they follow, new videos and the current tip and contest. Each
+// send is claimed in the DigestStore first, so a user receives at most one
+// digest per week even across restarts. Every email carries an unsubscribe
+// link with a signed token.
+package digest
+
+import (
+	"backend/model"
+	"backend/repository"
+	"backend/utils"
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"slices"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// Default schedule values.
+const (
+	DefaultWeekday = time.Sunday
+	DefaultHour    = 4
+)
+
+// WeekLayout formats the date of a digest, which identifies its week.
+const WeekLayout = "2006-01-02"
+
+// window is the period a digest covers.
+const window = 7 * 24 * time.Hour
+
+// batchSize is the number of posts or users read per batch.
+const batchSize = 100
+
+// pollInterval is how often the worker checks whether a digest is due.
+const pollInterval = time.Hour
+
+// maxItems is the number of entries shown per section of a digest.
+const maxItems = 5
+
+// excerptLength is the number of characters of a comment quoted in a digest.
+const excerptLength = 120
+
+// TokenIssuer issues the tokens of unsubscribe links.
+type TokenIssuer interface {
+	IssueUnsubscribe(uid string) (string, error)
+}
+
+// Worker sends the weekly digest.
+type Worker struct {
+	repos  *repository.Repositories
+	tokens TokenIssuer
+	// Weekday and Hour (UTC) are when the digest goes out.
+	Weekday time.Weekday
+	Hour    int
+	// Now returns the current time; tests may replace it.
+	Now func() time.Time
+
+	// done is the last week every subscriber was handled for.
+	done string
+}
+
+// NewWorker returns a Worker that sends the digest at the default time.
+func NewWorker(repos *repository.Repositories, tokens TokenIssuer) *Worker {
+	return &Worker{repos: repos, tokens: tokens, Weekday: DefaultWeekday, Hour: DefaultHour, Now: time.Now}
+}
+
+// Run sends the digest of each week once it is due, checking every
+// pollInterval until ctx is cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		w.runOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// slot returns the most recent time the digest was due.
+func (w *Worker) slot(now time.Time) time.Time {
+	now = now.UTC()
+	t := time.Date(now.Year(), now.Month(), now.Day(), w.Hour, 0, 0, 0, time.UTC)
+	t = t.AddDate(0, 0, -int((7+t.Weekday()-w.Weekday)%7))
+	if t.After(now) {
+		t = t.AddDate(0, 0, -7)
+	}
+	return t
+}
+
+func (w *Worker) runOnce(ctx context.Context) {
+	slot := w.slot(w.Now())
+	week := slot.Format(WeekLayout)
+	if w.done == week {
+		return
+	}
+
+	// Activity is only collected once a subscriber needs it
+	var activity *activity
+	failed := false
+	cursor := ""
+	for {
+		page, err := w.repos.Prefs.ListAfter(ctx, cursor, batchSize)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list notification preferences for the digest", "error", err)
+			return
+		}
+		for _, entry := range page {
+			if ctx.Err() != nil {
+				return
+			}
+			if !entry.Preferences.Digest {
+				continue
+			}
+			if activity == nil {
+				if activity, err = w.collect(ctx, slot.Add(-window), slot); err != nil {
+					slog.ErrorContext(ctx, "Failed to collect activity for the digest", "week", week, "error", err)
+					return
+				}
+			}
+			if err := w.send(ctx, entry.UID, entry.Preferences, week, activity); err != nil {
+				slog.WarnContext(ctx, "Failed to send the digest, will retry", "week", week, "uid", entry.UID, "error", err)
+				failed = true
+			}
+		}
+		if len(page) < batchSize {
+			break
+		}
+		cursor = page[len(page)-1].UID
+	}
+	if !failed {
+		w.done = week
+	}
+}
+
+// send emails the digest of week to uid unless it was sent before.
+func (w *Worker) send(ctx context.Context, uid string, prefs model.NotificationPreferences, week string, a *activity) error {
+	user, err := w.repos.Users.Get(ctx, uid)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	d := a.digest(uid, user.Username, prefs.Tags)
+	if len(d.Comments) == 0 && len(d.TopPosts) == 0 && len(d.Videos) == 0 && d.Tip == nil && d.Contest == nil {
+		return nil
+	}
+	account, err := w.repos.Accounts.Get(ctx, uid)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	token, err := w.tokens.IssueUnsubscribe(uid)
+	if err != nil {
+		return err
+	}
+	d.Username = user.Username
+	d.UnsubscribeLink = utils.AppBaseURL() + "/unsubscribe?token=" + url.QueryEscape(token)
+
+	err = w.repos.Digests.Claim(ctx, uid, week, w.Now().Unix())
+	if errors.Is(err, repository.ErrConflict) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := utils.SendDigestEmail(account.Email, user.Language, d); err != nil {
+		if rerr := w.repos.Digests.Release(ctx, uid, week); rerr != nil {
+			slog.ErrorContext(ctx, "Failed to release the digest", "week", week, "uid", uid, "error", rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// activity is the community activity of one digest window.
+type activity struct {
+	// comments are the new comments by others on each author's posts, keyed
+	// by author UID, or by username for posts that predate author UIDs.
+	comments map[string][]utils.DigestItem
+	// tags holds the new posts of each tag, best first.
+	tags    map[string][]scoredPost
+	videos  []utils.DigestItem
+	tip     *utils.DigestItem
+	contest *utils.DigestItem
+}
+
+type scoredPost struct {
+	id    string
+	item  utils.DigestItem
+	score int
+}
+
+// collect gathers the activity between from and to.
+func (w *Worker) collect(ctx context.Context, from, to time.Time) (*activity, error) {
+	a := &activity{comments: make(map[string][]utils.DigestItem), tags: make(map[string][]scoredPost)}
+	in := func(t int64) bool { return t >= from.Unix() && t < to.Unix() }
+
+	cursor := ""
+	for {
+		page, err := w.repos.Posts.ListAfter(ctx, cursor, batchSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, post := range page {
+			author := post.UID
+			if author == "" {
+				author = "@" + post.Username
+			}
+			comments := make([]model.Comment, 0, len(post.Comments))
+			for _, comment := range post.Comments {
+				if !in(comment.CreatedAt) || (comment.UID != "" && comment.UID == post.UID) || comment.Username == post.Username {
+					continue
+				}
+				comments = append(comments, comment)
+			}
+			sort.Slice(comments, func(i, j int) bool { return comments[i].CreatedAt < comments[j].CreatedAt })
+			for _, comment := range comments {
+				a.comments[author] = append(a.comments[author], utils.DigestItem{
+					Title:  post.Title,
+					Text:   shorten(comment.Content, excerptLength),
+					Author: comment.Username,
+				})
+			}
+
+			// Posts store their creation time as negated nanoseconds
+			if in(-post.CreatedAt / int64(time.Second)) {
+				p := scoredPost{
+					id:    post.ID,
+					item:  utils.DigestItem{Title: post.Title, Text: shorten(post.Content, excerptLength), Author: post.Username},
+					score: post.LikeCount + 2*post.CommentCount,
+				}
+				for _, tag := range post.Tags {
+					a.tags[tag] = append(a.tags[tag], p)
+				}
+			}
+		}
+		if len(page) < batchSize {
+			break
+		}
+		cursor = page[len(page)-1].ID
+	}
+	for _, posts := range a.tags {
+		sort.SliceStable(posts, func(i, j int) bool {
+			if posts[i].score != posts[j].score {
+				return posts[i].score > posts[j].score
+			}
+			return posts[i].id > posts[j].id
+		})
+	}
+
+	videos, err := w.repos.Videos.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(videos))
+	for id, video := range videos {
+		if in(video.CreatedAt) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return videos[ids[i]].CreatedAt > videos[ids[j]].CreatedAt })
+	for _, id := range ids {
+		video := videos[id]
+		a.videos = append(a.videos, utils.DigestItem{Title: video.Title, Author: video.Creator})
+	}
+
+	tips, err := w.repos.Tips.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Tips are keyed by push IDs, which sort by creation time; the newest
+	// is the current one
+	newest := ""
+	for id := range tips {
+		if id > newest {
+			newest = id
+		}
+	}
+	if tip, ok := tips[newest]; ok {
+		a.tip = &utils.DigestItem{Title: tip.Title, Text: tip.Content}
+	}
+	contest, err := w.repos.Contest.Get(ctx)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+	if contest.Title != "" || contest.Content != "" {
+		a.contest = &utils.DigestItem{Title: contest.Title, Text: contest.Content}
+	}
+	return a, nil
+}
+
+// digest returns the digest of a user who follows tags.
+func (a *activity) digest(uid, username string, tags []string) utils.DigestEmail {
+	d := utils.DigestEmail{Videos: a.videos, Tip: a.tip, Contest: a.contest}
+
+	comments := slices.Concat(a.comments[uid], a.comments["@"+username])
+	d.MoreComments = max(len(comments)-maxItems, 0)
+	d.Comments = limit(comments)
+
+	seen := make(map[string]bool)
+	var top []scoredPost
+	for _, tag := range tags {
+		for _, p := range a.tags[tag] {
+			if !seen[p.id] {
+				seen[p.id] = true
+				top = append(top, p)
+			}
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool { return top[i].score > top[j].score })
+	for _, p := range top {
+		d.TopPosts = append(d.TopPosts, p.item)
+	}
+	d.TopPosts = limit(d.TopPosts)
+	d.Videos = limit(d.Videos)
+	return d
+}
+
+func limit(items []utils.DigestItem) []utils.DigestItem {
+	if len(items) > maxItems {
+		return items[:maxItems]
+	}
+	return items
+}
+
+func shorten(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,247 @@
+package digest
+
+import (
+	"backend/mail"
+	"backend/model"
+	"backend/repository"
+	"backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sunday is a digest slot at the default time.
+var sunday = time.Date(2024, 5, 5, DefaultHour, 0, 0, 0, time.UTC)
+
+type fakeTokens struct{}
+
+func (fakeTokens) IssueUnsubscribe(uid string) (string, error) { return "token-" + uid, nil }
+
+// fakeMailer fails the first failures sends and records the recipients of
+// the others.
+type fakeMailer struct {
+	mu       sync.Mutex
+	failures int
+	sent     []string
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("connection refused")
+	}
+	m.sent = append(m.sent, msg.To)
+	return nil
+}
+
+// staticTips is a TipStore holding several tips, as stores written before
+// Replace removed the old ones do.
+type staticTips map[string]model.Tip
+
+func (s staticTips) Replace(ctx context.Context, tip model.Tip) error { return errors.New("read only") }
+
+func (s staticTips) List(ctx context.Context) (map[string]model.Tip, error) { return s, nil }
+
+// setup returns a Worker on memory stores whose clock is at *now, and the
+// mailer the digests go to. uid-1 is subscribed and has a comment on their
+// post from the week before sunday.
+func setup(t *testing.T, now *time.Time) (*Worker, *repository.Repositories, *fakeMailer) {
+	t.Helper()
+	ctx := context.Background()
+	repos := repository.NewMemory()
+	account, err := repos.Accounts.Create(ctx, "parent@example.com", "secret123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := account.UID
+	if err := repos.Users.Update(ctx, uid, map[string]interface{}{"username": "parent"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Prefs.Save(ctx, uid, model.NotificationPreferences{Digest: true}); err != nil {
+		t.Fatal(err)
+	}
+	post := model.Post{
+		ID: "p1", UID: uid, Username: "parent", Title: "Sleep training",
+		CreatedAt: -sunday.AddDate(0, 0, -30).UnixNano(),
+		Comments: map[string]model.Comment{
+			"c1": {ID: "c1", UID: "uid-2", Username: "bob", Content: "Try white noise", CreatedAt: sunday.Add(-time.Hour).Unix()},
+		},
+	}
+	if err := repos.Posts.Save(ctx, post); err != nil {
+		t.Fatal(err)
+	}
+
+	mailer := &fakeMailer{}
+	previous := utils.Mailer
+	utils.Mailer = mailer
+	t.Cleanup(func() { utils.Mailer = previous })
+
+	w := NewWorker(repos, fakeTokens{})
+	w.Now = func() time.Time { return *now }
+	return w, repos, mailer
+}
+
+func TestSlot(t *testing.T) {
+	w := NewWorker(nil, nil)
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{sunday, sunday},
+		{sunday.Add(-time.Minute), sunday.AddDate(0, 0, -7)},
+		{time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), sunday.AddDate(0, 0, -7)},
+		{time.Date(2024, 5, 11, 23, 59, 0, 0, time.UTC), sunday},
+		{time.Date(2024, 5, 12, 3, 0, 0, 0, time.UTC), sunday},
+		// 03:30 UTC, before the slot
+		{time.Date(2024, 5, 5, 9, 0, 0, 0, time.FixedZone("IST", 5*3600+1800)), sunday.AddDate(0, 0, -7)},
+	}
+	for _, tt := range tests {
+		if got := w.slot(tt.now); !got.Equal(tt.want) {
+			t.Errorf("slot(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+
+	w.Weekday, w.Hour = time.Wednesday, 18
+	if got, want := w.slot(sunday), time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("slot on Wednesdays at 18 = %v, want %v", got, want)
+	}
+}
+
+func TestRunOnceSendsOncePerWeek(t *testing.T) {
+	ctx := context.Background()
+	now := sunday.Add(time.Minute)
+	w, repos, mailer := setup(t, &now)
+
+	w.runOnce(ctx)
+	if len(mailer.sent) != 1 {
+		t.Fatalf("sent %v, want one digest", mailer.sent)
+	}
+
+	// A restarted worker finds the send claimed
+	restarted := NewWorker(repos, fakeTokens{})
+	restarted.Now = w.Now
+	restarted.runOnce(ctx)
+	now = now.Add(time.Hour)
+	w.runOnce(ctx)
+	if len(mailer.sent) != 1 {
+		t.Errorf("sent %v, want the digest once per week", mailer.sent)
+	}
+
+	// The next week's digest only has the tip
+	if err := repos.Tips.Replace(ctx, model.Tip{Title: "Tummy time"}); err != nil {
+		t.Fatal(err)
+	}
+	now = now.AddDate(0, 0, 7)
+	w.runOnce(ctx)
+	if len(mailer.sent) != 2 {
+		t.Errorf("sent %v, want a second digest the next week", mailer.sent)
+	}
+}
+
+func TestRunOnceRetriesFailedSend(t *testing.T) {
+	ctx := context.Background()
+	now := sunday.Add(time.Minute)
+	w, _, mailer := setup(t, &now)
+	mailer.failures = 1
+
+	w.runOnce(ctx)
+	if len(mailer.sent) != 0 || w.done != "" {
+		t.Fatalf("after a failed send: sent %v, done %q", mailer.sent, w.done)
+	}
+
+	// The claim was released, so the next run sends
+	now = now.Add(pollInterval)
+	w.runOnce(ctx)
+	if len(mailer.sent) != 1 || w.done != sunday.Format(WeekLayout) {
+		t.Errorf("after the retry: sent %v, done %q", mailer.sent, w.done)
+	}
+}
+
+func TestRunOnceSkipsEmptyDigest(t *testing.T) {
+	ctx := context.Background()
+	now := sunday.AddDate(0, 0, 7).Add(time.Minute) // The comment is two weeks old
+	w, _, mailer := setup(t, &now)
+
+	w.runOnce(ctx)
+	if len(mailer.sent) != 0 {
+		t.Errorf("sent %v, want no digest without activity", mailer.sent)
+	}
+}
+
+func TestDigestSections(t *testing.T) {
+	ctx := context.Background()
+	repos := repository.NewMemory()
+	w := NewWorker(repos, fakeTokens{})
+	from := sunday.Add(-window)
+
+	comments := make(map[string]model.Comment)
+	for i := 0; i < maxItems+2; i++ {
+		id := fmt.Sprintf("c%d", i)
+		comments[id] = model.Comment{ID: id, UID: "uid-2", Username: "bob", Content: id, CreatedAt: from.Add(time.Duration(i) * time.Hour).Unix()}
+	}
+	comments["own"] = model.Comment{ID: "own", UID: "uid-1", Username: "parent", CreatedAt: from.Unix()}
+	comments["old"] = model.Comment{ID: "old", UID: "uid-2", Username: "bob", CreatedAt: from.Add(-time.Second).Unix()}
+	posts := []model.Post{
+		{ID: "mine", UID: "uid-1", Username: "parent", Title: "Mine", CreatedAt: -from.AddDate(0, 0, -30).UnixNano(), Comments: comments},
+		{ID: "old", Title: "Old", Tags: []string{"sleep"}, CreatedAt: -from.Add(-time.Second).UnixNano(), LikeCount: 100},
+		{ID: "other", Title: "Other tag", Tags: []string{"food"}, CreatedAt: -from.UnixNano(), LikeCount: 100},
+	}
+	for i := 0; i < maxItems+2; i++ {
+		posts = append(posts, model.Post{ID: fmt.Sprintf("t%d", i), Title: fmt.Sprintf("t%d", i), Tags: []string{"sleep"}, CreatedAt: -from.Add(time.Hour).UnixNano(), LikeCount: i})
+	}
+	for _, post := range posts {
+		if err := repos.Posts.Save(ctx, post); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < maxItems+2; i++ {
+		video := model.Video{Title: fmt.Sprintf("v%d", i), CreatedAt: from.Add(time.Duration(i) * time.Hour).Unix()}
+		if err := repos.Videos.Save(ctx, video.Title, video); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repos.Tips = staticTips{"-b": {Title: "Newest"}, "-a": {Title: "Oldest"}}
+
+	a, err := w.collect(ctx, from, sunday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := a.digest("uid-1", "parent", []string{"sleep"})
+
+	if got := titles(d.Comments, true); got != "[c0 c1 c2 c3 c4]" || d.MoreComments != 2 {
+		t.Errorf("comments = %s and %d more, want the oldest %d and 2 more", got, d.MoreComments, maxItems)
+	}
+	if got := titles(d.TopPosts, false); got != "[t6 t5 t4 t3 t2]" {
+		t.Errorf("top posts = %s, want the %d most liked in the week", got, maxItems)
+	}
+	if got := titles(d.Videos, false); got != "[v6 v5 v4 v3 v2]" {
+		t.Errorf("videos = %s, want the %d newest", got, maxItems)
+	}
+	for i := 0; i < 10; i++ {
+		a, err := w.collect(ctx, from, sunday)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.tip == nil || a.tip.Title != "Newest" {
+			t.Fatalf("tip = %+v, want the newest", a.tip)
+		}
+	}
+}
+
+// titles lists the titles of items, or their texts.
+func titles(items []utils.DigestItem, text bool) string {
+	var s []string
+	for _, item := range items {
+		if text {
+			s = append(s, item.Text)
+		} else {
+			s = append(s, item.Title)
+		}
+	}
+	return fmt.Sprint(s)
+}
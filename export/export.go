@@ -0,0 +1,429 @@
+// Package export builds the personal data archives requested at /me/export.
+//
+// A request is recorded as a pending export and picked up by the Worker,
+// which collects the user's profile, children with their milestones, growth
+// measurements and vaccinations, content, likes, flags, login history,
+// notification inbox and preferences into a JSON archive. The archive is
+// stored until the download link emailed to the user expires. Each user may
+// request one export per RequestInterval.
+package export
+
+import (
+	"backend/model"
+	"backend/repository"
+	"backend/utils"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default policy values.
+const (
+	DefaultRequestInterval = 24 * time.Hour
+	DefaultLinkTTL         = 48 * time.Hour
+)
+
+// batchSize is the number of posts read per batch while collecting content.
+const batchSize = 100
+
+// pollInterval is how often the worker looks for pending and expired exports.
+const pollInterval = time.Minute
+
+var (
+	// ErrTooSoon is returned when the previous export is too recent.
+	ErrTooSoon = errors.New("export requested too recently")
+	// ErrInvalidToken is returned for unknown or malformed download tokens.
+	ErrInvalidToken = errors.New("invalid download token")
+	// ErrExpired is returned for download tokens past their expiry time.
+	ErrExpired = errors.New("download link expired")
+)
+
+// Archive is the document handed to the user.
+type Archive struct {
+	GeneratedAt  time.Time                               `json:"generated_at"`
+	Account      Account                                 `json:"account"`
+	Profile      map[string]interface{}                  `json:"profile"`
+	Children     []model.Child                           `json:"children"`
+	Milestones   map[string]map[string]model.Achievement `json:"milestones"`   // By child ID, then milestone ID
+	Growth       map[string][]model.Measurement          `json:"growth"`       // By child ID
+	Vaccinations map[string]map[string]model.Vaccination `json:"vaccinations"` // By child ID, then dose ID
+	Posts        []model.Post                            `json:"posts"`
+	Comments     []Comment                               `json:"comments"`
+	Likes        []Reference                             `json:"likes"`
+	Flags        []Reference                             `json:"flags"`
+	LoginHistory []Login                                 `json:"login_history"`
+	Inbox        []model.Notification                    `json:"inbox"`
+	Preferences  model.NotificationPreferences           `json:"notification_preferences"`
+}
+
+// Account is the authentication record of the user.
+type Account struct {
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Comment is a comment written by the user.
+type Comment struct {
+	PostID string `json:"post_id"`
+	model.Comment
+}
+
+// Reference points at a liked or flagged post, or a comment of it.
+type Reference struct {
+	PostID    string `json:"post_id"`
+	CommentID string `json:"comment_id,omitempty"`
+}
+
+// Login is a signed-in device.
+type Login struct {
+	Device     string    `json:"device"`
+	SignedInAt time.Time `json:"signed_in_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Revoked    bool      `json:"revoked"`
+}
+
+// Worker queues, builds and serves data exports.
+type Worker struct {
+	repos *repository.Repositories
+	wake  chan struct{}
+	// RequestInterval is the minimum time between two exports of a user.
+	RequestInterval time.Duration
+	// LinkTTL is how long the download link stays valid.
+	LinkTTL time.Duration
+	// Now returns the current time; tests may replace it.
+	Now func() time.Time
+}
+
+// NewWorker returns a Worker with the default policy.
+func NewWorker(repos *repository.Repositories) *Worker {
+	return &Worker{
+		repos:           repos,
+		wake:            make(chan struct{}, 1),
+		RequestInterval: DefaultRequestInterval,
+		LinkTTL:         DefaultLinkTTL,
+		Now:             time.Now,
+	}
+}
+
+// Request queues an export for uid. When the previous export is too recent
+// it returns ErrTooSoon along with the time the next one may be requested.
+func (w *Worker) Request(ctx context.Context, uid string) (time.Time, error) {
+	now := w.Now()
+	export := repository.DataExport{
+		UID:         uid,
+		Status:      repository.ExportPending,
+		RequestedAt: now.Unix(),
+	}
+	err := w.repos.Exports.Request(ctx, export, now.Add(-w.RequestInterval).Unix())
+	if errors.Is(err, repository.ErrConflict) {
+		previous, err := w.repos.Exports.Get(ctx, uid)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(previous.RequestedAt, 0).Add(w.RequestInterval), ErrTooSoon
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return now.Add(w.RequestInterval), nil
+}
+
+// Download returns the archive for a token from the emailed link.
+func (w *Worker) Download(ctx context.Context, token string) (string, error) {
+	uid, _, ok := strings.Cut(token, ".")
+	if !ok || uid == "" {
+		return "", ErrInvalidToken
+	}
+	export, err := w.repos.Exports.Get(ctx, uid)
+	if errors.Is(err, repository.ErrNotFound) {
+		return "", ErrInvalidToken
+	}
+	if err != nil {
+		return "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(export.TokenHash), []byte(utils.HashToken(token))) != 1 {
+		return "", ErrInvalidToken
+	}
+	if export.Status != repository.ExportReady || w.Now().Unix() >= export.ExpiresAt {
+		return "", ErrExpired
+	}
+
+	archive, err := w.repos.Exports.Archive(ctx, uid)
+	if errors.Is(err, repository.ErrNotFound) {
+		return "", ErrExpired
+	}
+	return archive, err
+}
+
+// Run builds pending exports and removes expired archives until ctx is
+// cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		w.runPending(ctx)
+		w.removeExpired(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) runPending(ctx context.Context) {
+	pending, err := w.repos.Exports.ListByStatus(ctx, repository.ExportPending)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list data exports", "error", err)
+		return
+	}
+	for uid := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := w.run(ctx, uid); err != nil {
+			slog.WarnContext(ctx, "Data export failed, will retry", "uid", uid, "error", err)
+			if err := w.repos.Exports.Update(ctx, uid, map[string]interface{}{"error": err.Error()}); err != nil {
+				slog.ErrorContext(ctx, "Failed to record error of data export", "uid", uid, "error", err)
+			}
+		}
+	}
+}
+
+// run builds and stores the archive of uid and emails the download link.
+func (w *Worker) run(ctx context.Context, uid string) error {
+	account, err := w.repos.Accounts.Get(ctx, uid)
+	if err != nil {
+		return err
+	}
+	archive, err := w.build(ctx, uid, account)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := w.repos.Exports.SaveArchive(ctx, uid, string(data)); err != nil {
+		return err
+	}
+
+	secret, err := utils.RandomToken()
+	if err != nil {
+		return err
+	}
+	token := uid + "." + secret
+	now := w.Now()
+	expiresAt := now.Add(w.LinkTTL)
+	if err := w.repos.Exports.Update(ctx, uid, map[string]interface{}{
+		"status":     repository.ExportReady,
+		"ready_at":   now.Unix(),
+		"expires_at": expiresAt.Unix(),
+		"token_hash": utils.HashToken(token),
+		"error":      nil,
+	}); err != nil {
+		return err
+	}
+
+	// The export is ready either way; a failed email is only logged
+	link := utils.AppBaseURL() + "/data-export?token=" + token
+	var lang string
+	if profile, err := w.repos.Users.Get(ctx, uid); err == nil {
+		lang = profile.Language
+	}
+	if err := utils.SendDataExportEmail(account.Email, lang, link, expiresAt); err != nil {
+		slog.ErrorContext(ctx, "Failed to send data export email", "uid", uid, "error", err)
+	}
+	return nil
+}
+
+// build collects everything stored about uid.
+func (w *Worker) build(ctx context.Context, uid string, account repository.Account) (Archive, error) {
+	archive := Archive{
+		GeneratedAt: w.Now().UTC(),
+		Account: Account{
+			Email:         account.Email,
+			EmailVerified: account.EmailVerified,
+			CreatedAt:     time.Unix(account.CreatedAt, 0).UTC(),
+		},
+		Children:     []model.Child{},
+		Milestones:   map[string]map[string]model.Achievement{},
+		Growth:       map[string][]model.Measurement{},
+		Vaccinations: map[string]map[string]model.Vaccination{},
+		Posts:        []model.Post{},
+		Comments:     []Comment{},
+		Likes:        []Reference{},
+		Flags:        []Reference{},
+		LoginHistory: []Login{},
+		Inbox:        []model.Notification{},
+	}
+
+	profile, err := w.repos.Users.Get(ctx, uid)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return Archive{}, err
+	}
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return Archive{}, err
+	}
+	if err := json.Unmarshal(data, &archive.Profile); err != nil {
+		return Archive{}, err
+	}
+	delete(archive.Profile, "password")
+
+	children, err := w.repos.Children.List(ctx, uid)
+	if err != nil {
+		return Archive{}, err
+	}
+	for id, child := range children {
+		child.ID = id
+		archive.Children = append(archive.Children, child)
+		achievements, err := w.repos.Milestones.Achievements(ctx, uid, id)
+		if err != nil {
+			return Archive{}, err
+		}
+		if len(achievements) > 0 {
+			archive.Milestones[id] = achievements
+		}
+		measurements, err := w.repos.Growth.List(ctx, uid, id)
+		if err != nil {
+			return Archive{}, err
+		}
+		for mid, m := range measurements {
+			m.ID = mid
+			archive.Growth[id] = append(archive.Growth[id], m)
+		}
+		given, err := w.repos.Vaccines.Given(ctx, uid, id)
+		if err != nil {
+			return Archive{}, err
+		}
+		if len(given) > 0 {
+			archive.Vaccinations[id] = given
+		}
+	}
+	sort.Slice(archive.Children, func(i, j int) bool { return archive.Children[i].CreatedAt < archive.Children[j].CreatedAt })
+
+	// Walk every post for the user's content and votes
+	cursor := ""
+	for {
+		page, err := w.repos.Posts.ListAfter(ctx, cursor, batchSize)
+		if err != nil {
+			return Archive{}, err
+		}
+		for _, post := range page {
+			collect(&archive, post, uid, profile.Username)
+		}
+		if len(page) < batchSize {
+			break
+		}
+		cursor = page[len(page)-1].ID
+	}
+
+	sessions, err := w.repos.Sessions.ListByUser(ctx, uid)
+	if err != nil {
+		return Archive{}, err
+	}
+	for _, s := range sessions {
+		archive.LoginHistory = append(archive.LoginHistory, Login{
+			Device:     s.Device,
+			SignedInAt: time.Unix(s.CreatedAt, 0).UTC(),
+			ExpiresAt:  time.Unix(s.ExpiresAt, 0).UTC(),
+			Revoked:    s.Revoked,
+		})
+	}
+
+	prefs, err := w.repos.Prefs.Get(ctx, uid)
+	if err != nil {
+		return Archive{}, err
+	}
+	archive.Preferences = prefs.WithDefaults()
+
+	before := ""
+	for {
+		page, err := w.repos.Inbox.List(ctx, uid, before, batchSize)
+		if err != nil {
+			return Archive{}, err
+		}
+		archive.Inbox = append(archive.Inbox, page...)
+		if len(page) < batchSize {
+			break
+		}
+		before = page[len(page)-1].ID
+	}
+	return archive, nil
+}
+
+// collect adds the parts of post that belong to the user to archive. Other
+// people's comments, likes and flags are left out.
+func collect(archive *Archive, post model.Post, uid, username string) {
+	mine := func(authorUID, author string) bool {
+		if authorUID != "" {
+			return authorUID == uid
+		}
+		return username != "" && author == username
+	}
+
+	if mine(post.UID, post.Username) {
+		own := post
+		own.Comments, own.Likes, own.Flags = nil, nil, nil
+		archive.Posts = append(archive.Posts, own)
+	}
+	if username != "" && post.Likes[username] {
+		archive.Likes = append(archive.Likes, Reference{PostID: post.ID})
+	}
+	if username != "" && post.Flags[username] {
+		archive.Flags = append(archive.Flags, Reference{PostID: post.ID})
+	}
+
+	for id, comment := range post.Comments {
+		if mine(comment.UID, comment.Username) {
+			own := comment
+			own.ID = id
+			own.Likes, own.Flags = nil, nil
+			archive.Comments = append(archive.Comments, Comment{PostID: post.ID, Comment: own})
+		}
+		if username != "" && comment.Likes[username] {
+			archive.Likes = append(archive.Likes, Reference{PostID: post.ID, CommentID: id})
+		}
+		if username != "" && comment.Flags[username] {
+			archive.Flags = append(archive.Flags, Reference{PostID: post.ID, CommentID: id})
+		}
+	}
+}
+
+// removeExpired deletes the archives whose download link has expired.
+func (w *Worker) removeExpired(ctx context.Context) {
+	ready, err := w.repos.Exports.ListByStatus(ctx, repository.ExportReady)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list data exports", "error", err)
+		return
+	}
+	now := w.Now().Unix()
+	for uid, export := range ready {
+		if export.ExpiresAt > now {
+			continue
+		}
+		if err := w.repos.Exports.DeleteArchive(ctx, uid); err != nil {
+			slog.ErrorContext(ctx, "Failed to delete data export archive", "uid", uid, "error", err)
+			continue
+		}
+		if err := w.repos.Exports.Update(ctx, uid, map[string]interface{}{
+			"status":     repository.ExportExpired,
+			"token_hash": nil,
+		}); err != nil {
+			slog.ErrorContext(ctx, "Failed to expire data export", "uid", uid, "error", err)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Package growth computes z-scores and percentiles of child measurements
+// with the WHO LMS method.
+//
+// The WHO Child Growth Standards (2006) for weight-for-age,
+// length/height-for-age and head circumference-for-age from birth to 60
+// months are bundled in tables/, one row of L, M and S per month, so no
+// network access is needed. Ages between whole months are interpolated
+// linearly. The height tables hold recumbent length below 24 months and
+// standing height from 24 months, as the WHO standards do; ages between 23
+// and 24 months use the length row rather than mixing the two.
+package growth
+
+import (
+	"embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Indicators.
+const (
+	Weight = "weight" // kg
+	Height = "height" // cm
+	Head   = "head"   // head circumference, cm
+)
+
+// Sexes of the reference tables, matching model.Child.Gender.
+const (
+	Male   = "male"
+	Female = "female"
+)
+
+// MaxAgeMonths is the oldest age covered by the tables.
+const MaxAgeMonths = 60
+
+// HeightFromMonths is the age from which Height is standing height rather
+// than recumbent length.
+const HeightFromMonths = 24
+
+// DaysPerMonth converts ages in days to months the way the WHO does.
+const DaysPerMonth = 30.4375
+
+var (
+	// ErrUnknownIndicator is returned for indicators without a table.
+	ErrUnknownIndicator = errors.New("unknown growth indicator")
+	// ErrUnknownSex is returned when sex is neither Male nor Female.
+	ErrUnknownSex = errors.New("growth references need a sex of male or female")
+	// ErrOutOfRange is returned for ages outside 0 to MaxAgeMonths.
+	ErrOutOfRange = errors.New("age outside the growth reference range")
+)
+
+// Indicators lists the supported indicators.
+var Indicators = []string{Weight, Height, Head}
+
+// LMS holds the Box-Cox power (L), median (M) and coefficient of variation
+// (S) of a reference distribution.
+type LMS struct {
+	L, M, S float64
+}
+
+// Score is a measurement compared with the reference population.
+type Score struct {
+	Z          float64 `json:"z"`
+	Percentile float64 `json:"percentile"`
+}
+
+//go:embed tables/*.csv
+var tableFiles embed.FS
+
+// tables holds one LMS row per month, keyed by indicator and sex.
+var tables = map[string][]LMS{}
+
+func init() {
+	for _, indicator := range Indicators {
+		for _, sex := range []string{Male, Female} {
+			rows, err := loadTable("tables/" + indicator + "_" + sex + ".csv")
+			if err != nil {
+				panic(err)
+			}
+			tables[indicator+"_"+sex] = rows
+		}
+	}
+}
+
+// loadTable reads a "month,l,m,s" file with one row for every month from 0
+// to MaxAgeMonths.
+func loadTable(name string) ([]LMS, error) {
+	f, err := tableFiles.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(records) != MaxAgeMonths+2 {
+		return nil, fmt.Errorf("%s: expected %d months, got %d", name, MaxAgeMonths+1, len(records)-1)
+	}
+	rows := make([]LMS, 0, MaxAgeMonths+1)
+	for i, record := range records[1:] {
+		var values [4]float64
+		for j := range values {
+			if values[j], err = strconv.ParseFloat(record[j], 64); err != nil {
+				return nil, fmt.Errorf("%s line %d: %w", name, i+2, err)
+			}
+		}
+		if int(values[0]) != i {
+			return nil, fmt.Errorf("%s line %d: expected month %d", name, i+2, i)
+		}
+		rows = append(rows, LMS{L: values[1], M: values[2], S: values[3]})
+	}
+	return rows, nil
+}
+
+// Reference returns the LMS parameters of indicator for the given sex at
+// ageMonths, interpolating between whole months.
+func Reference(indicator, sex string, ageMonths float64) (LMS, error) {
+	if sex != Male && sex != Female {
+		return LMS{}, ErrUnknownSex
+	}
+	rows, ok := tables[indicator+"_"+sex]
+	if !ok {
+		return LMS{}, ErrUnknownIndicator
+	}
+	if ageMonths < 0 || ageMonths > MaxAgeMonths {
+		return LMS{}, ErrOutOfRange
+	}
+	month := int(ageMonths)
+	if month == MaxAgeMonths || (indicator == Height && month == HeightFromMonths-1) {
+		return rows[month], nil
+	}
+	f := ageMonths - float64(month)
+	a, b := rows[month], rows[month+1]
+	return LMS{
+		L: a.L + f*(b.L-a.L),
+		M: a.M + f*(b.M-a.M),
+		S: a.S + f*(b.S-a.S),
+	}, nil
+}
+
+// Value returns the measurement at z-score z.
+func (p LMS) Value(z float64) float64 {
+	if p.L == 0 {
+		return p.M * math.Exp(p.S*z)
+	}
+	return p.M * math.Pow(1+p.L*p.S*z, 1/p.L)
+}
+
+// ZScore returns the z-score of value. Beyond ±3 the distance is measured in
+// units of the gap between the 2 and 3 SD values, the WHO's restricted
+// application of the LMS method that keeps skewed tails from inflating
+// extreme scores.
+func (p LMS) ZScore(value float64) float64 {
+	var z float64
+	if p.L == 0 {
+		z = math.Log(value/p.M) / p.S
+	} else {
+		z = (math.Pow(value/p.M, p.L) - 1) / (p.L * p.S)
+	}
+	switch {
+	case z > 3:
+		sd3 := p.Value(3)
+		return 3 + (value-sd3)/(sd3-p.Value(2))
+	case z < -3:
+		sd3 := p.Value(-3)
+		return -3 + (value-sd3)/(p.Value(-2)-sd3)
+	}
+	return z
+}
+
+// Percentile returns the percentage of the reference population below z.
+func Percentile(z float64) float64 {
+	return 50 * (1 + math.Erf(z/math.Sqrt2))
+}
+
+// Assess compares a measurement of indicator taken at ageMonths with the
+// reference for sex. Scores are rounded to two decimals and percentiles to
+// one.
+func Assess(indicator, sex string, ageMonths, value float64) (Score, error) {
+	p, err := Reference(indicator, sex, ageMonths)
+	if err != nil {
+		return Score{}, err
+	}
+	z := p.ZScore(value)
+	rounded := math.Round(z*100) / 100
+	if rounded == 0 {
+		rounded = 0 // Drop the sign of -0
+	}
+	return Score{
+		Z:          rounded,
+		Percentile: math.Round(Percentile(z)*10) / 10,
+	}, nil
+}
+
+// CurvePoint gives the reference values of the percentiles shown on growth
+// charts at one age.
+type CurvePoint struct {
+	AgeMonths int     `json:"age_months"`
+	P3        float64 `json:"p3"`
+	P15       float64 `json:"p15"`
+	P50       float64 `json:"p50"`
+	P85       float64 `json:"p85"`
+	P97       float64 `json:"p97"`
+}
+
+// z-scores of the 3rd and 15th percentiles; the 85th and 97th mirror them.
+const (
+	z3  = -1.880793608151251
+	z15 = -1.036433389493790
+)
+
+// Curves returns the monthly reference curves of indicator for sex.
+func Curves(indicator, sex string) ([]CurvePoint, error) {
+	if sex != Male && sex != Female {
+		return nil, ErrUnknownSex
+	}
+	rows, ok := tables[indicator+"_"+sex]
+	if !ok {
+		return nil, ErrUnknownIndicator
+	}
+	round := func(v float64) float64 { return math.Round(v*100) / 100 }
+	points := make([]CurvePoint, len(rows))
+	for month, p := range rows {
+		points[month] = CurvePoint{
+			AgeMonths: month,
+			P3:        round(p.Value(z3)),
+			P15:       round(p.Value(z15)),
+			P50:       round(p.M),
+			P85:       round(p.Value(-z15)),
+			P97:       round(p.Value(-z3)),
+		}
+	}
+	return points, nil
+}
@@ -0,0 +1,165 @@
+package growth
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// Values from the WHO Child Growth Standards: the LMS tables and the
+// published z-score tables, which give each SD line to one decimal.
+func TestReferenceMatchesWHO(t *testing.T) {
+	tests := []struct {
+		indicator, sex string
+		month          float64
+		want           LMS
+	}{
+		{Weight, Male, 0, LMS{0.3487, 3.3464, 0.14602}},
+		{Weight, Male, 12, LMS{0.0644, 9.6479, 0.10925}},
+		{Weight, Female, 24, LMS{-0.2941, 11.4775, 0.12390}},
+		{Height, Male, 0, LMS{1, 49.8842, 0.03795}},
+		{Height, Female, 12, LMS{1, 74.0150, 0.03479}},
+		{Height, Male, 24, LMS{1, 87.1161, 0.03507}},
+		{Head, Male, 0, LMS{1, 34.4618, 0.03686}},
+		{Head, Female, 60, LMS{1, 49.8533, 0.02852}},
+	}
+	for _, tt := range tests {
+		got, err := Reference(tt.indicator, tt.sex, tt.month)
+		if err != nil {
+			t.Fatalf("Reference(%s, %s, %v): %v", tt.indicator, tt.sex, tt.month, err)
+		}
+		if got != tt.want {
+			t.Errorf("Reference(%s, %s, %v) = %+v, want %+v", tt.indicator, tt.sex, tt.month, got, tt.want)
+		}
+	}
+}
+
+func TestSDLinesMatchWHO(t *testing.T) {
+	tests := []struct {
+		indicator, sex string
+		month          float64
+		// -3, -2, 0, +2 and +3 SD
+		want [5]float64
+	}{
+		{Weight, Male, 0, [5]float64{2.1, 2.5, 3.3, 4.4, 5.0}},
+		{Weight, Male, 12, [5]float64{6.9, 7.7, 9.6, 12.0, 13.3}},
+		{Weight, Female, 12, [5]float64{6.3, 7.0, 8.9, 11.5, 13.1}},
+		{Weight, Female, 60, [5]float64{12.1, 13.7, 18.2, 24.9, 29.5}},
+		{Height, Male, 0, [5]float64{44.2, 46.1, 49.9, 53.7, 55.6}},
+		{Height, Female, 12, [5]float64{66.3, 68.9, 74.0, 79.2, 81.7}},
+		{Height, Male, 24, [5]float64{78.0, 81.0, 87.1, 93.2, 96.3}},
+		{Head, Male, 0, [5]float64{30.7, 31.9, 34.5, 37.0, 38.3}},
+		{Head, Female, 0, [5]float64{30.3, 31.5, 33.9, 36.2, 37.4}},
+	}
+	for _, tt := range tests {
+		p, err := Reference(tt.indicator, tt.sex, tt.month)
+		if err != nil {
+			t.Fatalf("Reference(%s, %s, %v): %v", tt.indicator, tt.sex, tt.month, err)
+		}
+		for i, z := range []float64{-3, -2, 0, 2, 3} {
+			// The published values are rounded to one decimal
+			if got := p.Value(z); math.Abs(got-tt.want[i]) > 0.051 {
+				t.Errorf("%s %s month %v: %+v SD = %.3f, want %.1f", tt.indicator, tt.sex, tt.month, z, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestAssess(t *testing.T) {
+	tests := []struct {
+		name           string
+		indicator, sex string
+		month, value   float64
+		wantZ          float64
+		wantPercentile float64
+	}{
+		{"median weight at birth", Weight, Male, 0, 3.3464, 0, 50},
+		{"median length at 12 months", Height, Female, 12, 74.0150, 0, 50},
+		{"-2 SD weight at 12 months", Weight, Male, 12, 7.7, -2.05, 2},
+		{"+2 SD head at birth", Head, Female, 0, 36.2, 1.96, 97.5},
+		{"+2 SD height at 24 months", Height, Male, 24, 93.2, 1.99, 97.7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Assess(tt.indicator, tt.sex, tt.month, tt.value)
+			if err != nil {
+				t.Fatalf("Assess: %v", err)
+			}
+			if got.Z != tt.wantZ || got.Percentile != tt.wantPercentile {
+				t.Errorf("Assess = %+v, want z %v percentile %v", got, tt.wantZ, tt.wantPercentile)
+			}
+		})
+	}
+}
+
+func TestZScoreBeyondThreeSD(t *testing.T) {
+	p, err := Reference(Weight, Male, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One SD2-SD3 gap above +3 SD is +4 under the WHO's restricted method
+	sd2, sd3 := p.Value(2), p.Value(3)
+	if got := p.ZScore(sd3 + (sd3 - sd2)); math.Abs(got-4) > 1e-9 {
+		t.Errorf("ZScore above +3 SD = %v, want 4", got)
+	}
+	sd2, sd3 = p.Value(-2), p.Value(-3)
+	if got := p.ZScore(sd3 - (sd2 - sd3)); math.Abs(got+4) > 1e-9 {
+		t.Errorf("ZScore below -3 SD = %v, want -4", got)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct{ z, want float64 }{
+		{0, 50},
+		{z3, 3},
+		{z15, 15},
+		{-z15, 85},
+		{-z3, 97},
+		{2, 97.725},
+	}
+	for _, tt := range tests {
+		if got := Percentile(tt.z); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("Percentile(%v) = %v, want %v", tt.z, got, tt.want)
+		}
+	}
+}
+
+func TestReferenceDoesNotMixLengthAndHeight(t *testing.T) {
+	length := tables[Height+"_"+Male][HeightFromMonths-1]
+	for _, age := range []float64{23, 23.5, 23.99} {
+		got, err := Reference(Height, Male, age)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != length {
+			t.Errorf("Reference(height, male, %v) = %+v, want the 23 month length row %+v", age, got, length)
+		}
+	}
+	// Other indicators still interpolate across 23 to 24 months
+	w23, w24 := tables[Weight+"_"+Male][23], tables[Weight+"_"+Male][24]
+	got, err := Reference(Weight, Male, 23.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (w23.M + w24.M) / 2; math.Abs(got.M-want) > 1e-9 {
+		t.Errorf("Reference(weight, male, 23.5).M = %v, want %v", got.M, want)
+	}
+}
+
+func TestReferenceErrors(t *testing.T) {
+	tests := []struct {
+		indicator, sex string
+		month          float64
+		want           error
+	}{
+		{"bmi", Male, 12, ErrUnknownIndicator},
+		{Weight, "", 12, ErrUnknownSex},
+		{Weight, Male, -1, ErrOutOfRange},
+		{Weight, Male, MaxAgeMonths + 0.5, ErrOutOfRange},
+	}
+	for _, tt := range tests {
+		if _, err := Reference(tt.indicator, tt.sex, tt.month); !errors.Is(err, tt.want) {
+			t.Errorf("Reference(%q, %q, %v) error = %v, want %v", tt.indicator, tt.sex, tt.month, err, tt.want)
+		}
+	}
+}
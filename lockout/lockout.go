@@ -0,0 +1,172 @@
+// Package lockout slows down password guessing on /login.
+//
+// Failed attempts are counted per email and per client IP. After each
+// failure the key must wait an exponentially growing delay before the next
+// attempt, and an email is locked outright after MaxFailures failures.
+// Counters expire after Window without failures.
+package lockout
+
+import (
+	"backend/repository"
+	"backend/utils"
+	"context"
+	"strings"
+	"time"
+)
+
+// Default policy values.
+const (
+	DefaultMaxFailures   = 5
+	DefaultMaxIPFailures = 50
+	DefaultLockDuration  = 15 * time.Minute
+	DefaultBaseDelay     = time.Second
+	DefaultMaxDelay      = time.Minute
+	DefaultWindow        = 24 * time.Hour
+)
+
+// Guard tracks failed logins and decides when a login may be attempted.
+type Guard struct {
+	store repository.LoginAttemptStore
+	// MaxFailures is the number of failures that locks an email.
+	MaxFailures int
+	// MaxIPFailures is the number of failures after which an IP is blocked
+	// for LockDuration.
+	MaxIPFailures int
+	// LockDuration is how long a lock lasts.
+	LockDuration time.Duration
+	// BaseDelay is the wait after the first failure; it doubles with every
+	// further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Window is how long counters are kept after the last failure.
+	Window time.Duration
+	// Now returns the current time; tests may replace it.
+	Now func() time.Time
+}
+
+// NewGuard returns a Guard with the default policy.
+func NewGuard(store repository.LoginAttemptStore) *Guard {
+	return &Guard{
+		store:         store,
+		MaxFailures:   DefaultMaxFailures,
+		MaxIPFailures: DefaultMaxIPFailures,
+		LockDuration:  DefaultLockDuration,
+		BaseDelay:     DefaultBaseDelay,
+		MaxDelay:      DefaultMaxDelay,
+		Window:        DefaultWindow,
+		Now:           time.Now,
+	}
+}
+
+// Status is the outcome of Check.
+type Status struct {
+	// Locked is set when the email is locked rather than merely backing off.
+	Locked bool
+	// RetryAfter is how long the client must wait; zero means go ahead.
+	RetryAfter time.Duration
+}
+
+// Check reports whether a login for email from ip may be attempted now.
+func (g *Guard) Check(ctx context.Context, email, ip string) (Status, error) {
+	now := g.Now()
+	var status Status
+	for _, key := range []string{emailKey(email), ipKey(ip)} {
+		attempts, err := g.store.Get(ctx, key, now.Unix())
+		if err != nil {
+			return Status{}, err
+		}
+		if wait := time.Unix(attempts.LockedUntil, 0).Sub(now); wait > 0 {
+			status.Locked = status.Locked || key == emailKey(email)
+			status.RetryAfter = max(status.RetryAfter, wait)
+		}
+		if wait := time.Unix(attempts.NextAllowed, 0).Sub(now); wait > 0 {
+			status.RetryAfter = max(status.RetryAfter, wait)
+		}
+	}
+	return status, nil
+}
+
+// Fail records a failed login. It returns true when this failure locked the
+// email, so the caller can notify the owner exactly once.
+func (g *Guard) Fail(ctx context.Context, email, ip string) (bool, error) {
+	now := g.Now()
+	locked := false
+	_, err := g.store.Update(ctx, emailKey(email), now.Unix(), func(a repository.LoginAttempts) repository.LoginAttempts {
+		wasLocked := a.LockedUntil > now.Unix()
+		a = g.fail(a, now, g.MaxFailures)
+		locked = !wasLocked && a.LockedUntil > now.Unix()
+		return a
+	})
+	if err != nil {
+		return false, err
+	}
+	_, err = g.store.Update(ctx, ipKey(ip), now.Unix(), func(a repository.LoginAttempts) repository.LoginAttempts {
+		return g.fail(a, now, g.MaxIPFailures)
+	})
+	return locked, err
+}
+
+// Succeed clears the failure counter of email after a successful login. The
+// IP counter is left alone so one valid account cannot reset it.
+func (g *Guard) Succeed(ctx context.Context, email string) error {
+	return g.store.Delete(ctx, emailKey(email))
+}
+
+// Unlock clears the counters and any lock of email.
+func (g *Guard) Unlock(ctx context.Context, email string) error {
+	return g.store.Delete(ctx, emailKey(email))
+}
+
+// Forget clears every counter kept for the user uid with the given email,
+// when the account is deleted.
+func (g *Guard) Forget(ctx context.Context, email, uid string) error {
+	if email != "" {
+		if err := g.store.Delete(ctx, emailKey(email)); err != nil {
+			return err
+		}
+	}
+	for _, key := range []string{TwoFactorKey(uid), PasswordKey(uid)} {
+		if err := g.store.Delete(ctx, emailKey(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TwoFactorKey is passed in place of an email to throttle the second login
+// step of uid, so codes are counted per user.
+func TwoFactorKey(uid string) string {
+	return "2fa:" + uid
+}
+
+// PasswordKey is passed in place of an email to throttle the current
+// password checks of signed-in uid, such as before a password change.
+func PasswordKey(uid string) string {
+	return "password:" + uid
+}
+
+func (g *Guard) fail(a repository.LoginAttempts, now time.Time, limit int) repository.LoginAttempts {
+	a.Failures++
+	a.LastFailure = now.Unix()
+	a.ExpiresAt = now.Add(g.Window).Unix()
+
+	delay := g.BaseDelay << min(a.Failures-1, 30)
+	if delay > g.MaxDelay || delay <= 0 {
+		delay = g.MaxDelay
+	}
+	a.NextAllowed = now.Add(delay).Unix()
+
+	if a.Failures >= limit {
+		a.LockedUntil = now.Add(g.LockDuration).Unix()
+		a.Failures = 0
+	}
+	return a
+}
+
+func emailKey(email string) string {
+	return "email-" + utils.HashToken(strings.ToLower(strings.TrimSpace(email)))
+}
+
+func ipKey(ip string) string {
+	return "ip-" + utils.HashToken(ip)
+}
@@ -0,0 +1,132 @@
+package lockout
+
+import (
+	"backend/repository"
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestGuard returns a Guard on the memory store whose clock is *now.
+func newTestGuard(now *time.Time) *Guard {
+	g := NewGuard(repository.NewMemory().Attempts)
+	g.Now = func() time.Time { return *now }
+	return g
+}
+
+func TestBackoffAndLock(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	g := newTestGuard(&now)
+
+	// Each failure doubles the wait until the fifth locks the email
+	for i, wait := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		locked, err := g.Fail(ctx, "parent@example.com", "203.0.113.1")
+		if err != nil || locked {
+			t.Fatalf("Fail %d = %v, %v", i+1, locked, err)
+		}
+		status, err := g.Check(ctx, "parent@example.com", "203.0.113.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Locked || status.RetryAfter != wait {
+			t.Errorf("after %d failures status = %+v, want a wait of %v", i+1, status, wait)
+		}
+		now = now.Add(wait)
+	}
+
+	locked, err := g.Fail(ctx, "parent@example.com", "203.0.113.1")
+	if err != nil || !locked {
+		t.Fatalf("fifth Fail = %v, %v, want the email locked", locked, err)
+	}
+	// The lock is only reported once
+	if locked, err := g.Fail(ctx, "PARENT@example.com ", "203.0.113.1"); err != nil || locked {
+		t.Errorf("Fail while locked = %v, %v", locked, err)
+	}
+	status, err := g.Check(ctx, "parent@example.com", "198.51.100.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Locked || status.RetryAfter != DefaultLockDuration {
+		t.Errorf("status while locked = %+v", status)
+	}
+
+	now = now.Add(DefaultLockDuration)
+	if status, err := g.Check(ctx, "parent@example.com", "198.51.100.7"); err != nil || status != (Status{}) {
+		t.Errorf("status after the lock = %+v, %v", status, err)
+	}
+}
+
+func TestSucceedAndUnlock(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	g := newTestGuard(&now)
+
+	for i := 0; i < DefaultMaxFailures; i++ {
+		if _, err := g.Fail(ctx, "parent@example.com", "203.0.113.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.Unlock(ctx, "parent@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	// The IP still backs off; another IP may try at once
+	if status, err := g.Check(ctx, "parent@example.com", "198.51.100.7"); err != nil || status != (Status{}) {
+		t.Errorf("status after Unlock = %+v, %v", status, err)
+	}
+	if status, err := g.Check(ctx, "other@example.com", "203.0.113.1"); err != nil || status.RetryAfter == 0 || status.Locked {
+		t.Errorf("status of the failing IP = %+v, %v", status, err)
+	}
+
+	if _, err := g.Fail(ctx, "parent@example.com", "198.51.100.7"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Succeed(ctx, "parent@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := g.Check(ctx, "parent@example.com", "192.0.2.1"); err != nil || status != (Status{}) {
+		t.Errorf("status after Succeed = %+v, %v", status, err)
+	}
+}
+
+func TestIPBlock(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	g := newTestGuard(&now)
+	g.MaxIPFailures = 3
+
+	// Failures spread over many emails still block the IP
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if _, err := g.Fail(ctx, email, "203.0.113.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status, err := g.Check(ctx, "d@example.com", "203.0.113.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Locked || status.RetryAfter != DefaultLockDuration {
+		t.Errorf("status of the blocked IP = %+v", status)
+	}
+}
+
+func TestCountersExpire(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	g := newTestGuard(&now)
+
+	for i := 0; i < DefaultMaxFailures-1; i++ {
+		if _, err := g.Fail(ctx, "parent@example.com", "203.0.113.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now = now.Add(DefaultWindow)
+	// A fresh count: one failure waits the base delay and does not lock
+	locked, err := g.Fail(ctx, "parent@example.com", "203.0.113.1")
+	if err != nil || locked {
+		t.Fatalf("Fail after the window = %v, %v", locked, err)
+	}
+	if status, err := g.Check(ctx, "parent@example.com", "203.0.113.1"); err != nil || status.RetryAfter != DefaultBaseDelay {
+		t.Errorf("status after the window = %+v, %v", status, err)
+	}
+}
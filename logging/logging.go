@@ -0,0 +1,103 @@
+// Package logging sets up structured logging with log/slog.
+//
+// Records pass through Redact before they are written, so secrets never
+// reach the logs: the values of attributes such as "token" or "password"
+// are dropped, and links, tokens, email addresses and phone numbers are
+// masked wherever else they appear. Attributes added to a context with
+// With, such as the request ID, are added to every record logged with that
+// context, e.g. by slog.ErrorContext.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Formats accepted in LOG_FORMAT.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// FromEnv returns a logger that writes to w. LOG_LEVEL sets the minimum
+// level (debug, info, warn or error; info by default) and LOG_FORMAT the
+// format (text or json; text by default).
+func FromEnv(w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if s := os.Getenv("LOG_LEVEL"); s != "" {
+		if err := level.UnmarshalText([]byte(s)); err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL %q", s)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "", FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown LOG_FORMAT %q", format)
+	}
+	return slog.New(withContext(Redact(h))), nil
+}
+
+// Fatal logs msg at error level and exits.
+func Fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type attrsKey struct{}
+
+// With returns a copy of ctx whose log records carry the given attributes,
+// in addition to those ctx already carries. args are key-value pairs as in
+// slog.Logger.With.
+func With(ctx context.Context, args ...interface{}) context.Context {
+	r := slog.NewRecord(time.Time{}, 0, "", 0)
+	r.Add(args...)
+	attrs := append([]slog.Attr(nil), attrsFrom(ctx)...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return context.WithValue(ctx, attrsKey{}, attrs)
+}
+
+func attrsFrom(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// contextHandler adds the attributes of the context to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func withContext(h slog.Handler) slog.Handler {
+	return contextHandler{h}
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs := attrsFrom(ctx); len(attrs) > 0 {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces values that must not be logged.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are parts of attribute keys whose values are never logged.
+var sensitiveKeys = []string{"password", "secret", "token", "code", "link", "authorization", "cookie", "phone"}
+
+// patterns mask secrets and personal data in free text, in order.
+var patterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Links may carry action codes anywhere after the host
+	{regexp.MustCompile(`(?i)\b(https?://[^/\s?#"'<>]+)[/?#][^\s"'<>]*`), "$1/" + Redacted},
+	{regexp.MustCompile(`(?i)\b(bearer\s+)[^\s"']+`), "${1}" + Redacted},
+	{regexp.MustCompile(`(?i)\b(token|password|secret|code|oobcode)=[^\s&"']+`), "${1}=" + Redacted},
+	// JWTs, then anything long enough to be a random token
+	{regexp.MustCompile(`\beyJ[\w-]*\.[\w-]*\.[\w-]*`), Redacted},
+	{regexp.MustCompile(`[A-Za-z0-9_-]{40,}`), Redacted},
+	// Emails keep their first letter and domain to tell them apart
+	{regexp.MustCompile(`\b([A-Za-z0-9._%+-])[A-Za-z0-9._%+-]*@([A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,})\b`), "$1***@$2"},
+	// International numbers, and Indian mobile numbers without a prefix
+	{regexp.MustCompile(`\+\d[\d ().-]{6,}\d`), Redacted},
+	{regexp.MustCompile(`\b[6-9]\d{9}\b`), Redacted},
+}
+
+// RedactString masks the links, tokens, email addresses and phone numbers
+// in s.
+func RedactString(s string) string {
+	for _, p := range patterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+// Redact returns a handler that masks secrets in the message and attributes
+// of each record before passing it to h.
+func Redact(h slog.Handler) slog.Handler {
+	return redactHandler{h}
+}
+
+type redactHandler struct {
+	next slog.Handler
+}
+
+func (h redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, RedactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+	return redactHandler{h.next.WithAttrs(redacted)}
+}
+
+func (h redactHandler) WithGroup(name string) slog.Handler {
+	return redactHandler{h.next.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	key := strings.ToLower(a.Key)
+	for _, k := range sensitiveKeys {
+		if strings.Contains(key, k) {
+			return slog.String(a.Key, Redacted)
+		}
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(RedactString(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, g := range group {
+			redacted[i] = redactAttr(g)
+		}
+		a.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		// Errors and structs may quote anything; log them as redacted text
+		a.Value = slog.StringValue(RedactString(fmt.Sprint(a.Value.Any())))
+	}
+	return a
+}
@@ -0,0 +1,224 @@
+// Package mail sends email through a pluggable Mailer.
+//
+// SMTPMailer delivers through an SMTP server and keeps its connection open
+// between messages. FileMailer writes each message to a directory and
+// LogMailer only logs it, which suits development; MemoryMailer keeps the
+// messages for inspection. FromEnv picks one from the environment.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an HTML email to one recipient.
+type Message struct {
+	To      string
+	Subject string
+	HTML    string
+	// Text is the plain-text alternative of HTML, if any.
+	Text string
+	// Headers are added to the standard ones, e.g. List-Unsubscribe.
+	Headers map[string]string
+}
+
+// Mailer sends email.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Permanent reports whether err is a rejection that retrying the same
+// message cannot fix, such as an unknown recipient.
+func Permanent(err error) bool {
+	var tpErr *textproto.Error
+	return errors.As(err, &tpErr) && tpErr.Code >= 500
+}
+
+// Transports accepted in MAIL_TRANSPORT.
+const (
+	TransportSMTP   = "smtp"
+	TransportFile   = "file"
+	TransportLog    = "log"
+	TransportMemory = "memory"
+)
+
+// FromEnv returns the Mailer selected by MAIL_TRANSPORT. SMTP, the default,
+// is configured with SMTP_HOST (smtp.gmail.com), SMTP_PORT (587),
+// SMTP_USERNAME, SMTP_PASSWORD and MAIL_FROM (the username); without a
+// username messages are only logged. The file transport writes to MAIL_DIR,
+// "mail" by default.
+func FromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = os.Getenv("SMTP_USERNAME")
+	}
+	switch transport := os.Getenv("MAIL_TRANSPORT"); transport {
+	case "", TransportSMTP:
+		m := &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+		if m.Host == "" {
+			m.Host = "smtp.gmail.com"
+		}
+		m.Port = 587
+		if port := os.Getenv("SMTP_PORT"); port != "" {
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SMTP_PORT %q", port)
+			}
+			m.Port = p
+		}
+		if transport == "" && m.Username == "" {
+			slog.Warn("SMTP_USERNAME not set; emails will only be logged")
+			return LogMailer{}, nil
+		}
+		return m, nil
+	case TransportFile:
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return &FileMailer{Dir: dir, From: from}, nil
+	case TransportLog:
+		return LogMailer{}, nil
+	case TransportMemory:
+		return &MemoryMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_TRANSPORT %q", transport)
+	}
+}
+
+// format renders m as an RFC 5322 message from the given sender. Messages
+// with a Text alternative are sent as multipart/alternative.
+func format(m Message, from string, date time.Time) []byte {
+	headers := map[string]string{
+		"From":         from,
+		"To":           m.To,
+		"Subject":      mime.QEncoding.Encode("utf-8", m.Subject),
+		"Date":         date.Format(time.RFC1123Z),
+		"MIME-Version": "1.0",
+	}
+	var body bytes.Buffer
+	if m.Text == "" {
+		headers["Content-Type"] = `text/html; charset="utf-8"`
+		headers["Content-Transfer-Encoding"] = "quoted-printable"
+		writeQuotedPrintable(&body, m.HTML)
+	} else {
+		parts := multipart.NewWriter(&body)
+		headers["Content-Type"] = "multipart/alternative; boundary=" + parts.Boundary()
+		// The preferred alternative goes last
+		for _, part := range []struct{ contentType, content string }{
+			{`text/plain; charset="utf-8"`, m.Text},
+			{`text/html; charset="utf-8"`, m.HTML},
+		} {
+			w, _ := parts.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.contentType},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			writeQuotedPrintable(w, part.content)
+		}
+		parts.Close()
+	}
+	for key, value := range m.Headers {
+		headers[textproto.CanonicalMIMEHeaderKey(key)] = value
+	}
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		// Header values must not break out of their line
+		value := strings.NewReplacer("\r", "", "\n", "").Replace(headers[key])
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+func writeQuotedPrintable(w io.Writer, s string) {
+	qp := quotedprintable.NewWriter(w)
+	qp.Write([]byte(s))
+	qp.Close()
+}
+
+// address returns the bare address of a recipient such as
+// "Asha <asha@example.com>".
+func address(to string) (string, error) {
+	addr, err := netmail.ParseAddress(to)
+	if err != nil {
+		return "", &textproto.Error{Code: 553, Msg: "invalid recipient " + strconv.Quote(to)}
+	}
+	return addr.Address, nil
+}
+
+// FileMailer writes each message as an .eml file to Dir.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (f *FileMailer) Send(ctx context.Context, m Message) error {
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%d-%s.eml", now.UnixNano(), strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, m.To))
+	return os.WriteFile(filepath.Join(f.Dir, name), format(m, f.From, now), 0o600)
+}
+
+// LogMailer logs the recipient and subject of each message instead of
+// sending it.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, m Message) error {
+	slog.InfoContext(ctx, "Email not sent (no mail transport)", "to", m.To, "subject", m.Subject)
+	return nil
+}
+
+// MemoryMailer keeps the messages it is given.
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (mm *MemoryMailer) Send(ctx context.Context, m Message) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.sent = append(mm.sent, m)
+	return nil
+}
+
+// Sent returns the messages sent so far, oldest first.
+func (mm *MemoryMailer) Sent() []Message {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	return append([]Message(nil), mm.sent...)
+}
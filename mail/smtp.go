@@ -0,0 +1,162 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"sync"
+	"time"
+)
+
+// Default SMTP timeouts.
+const (
+	DefaultIdleTimeout = time.Minute
+	DefaultSendTimeout = 30 * time.Second
+)
+
+// SMTPMailer sends messages through an SMTP server. Port 465 uses implicit
+// TLS; other ports upgrade with STARTTLS when the server offers it. One
+// connection is reused for consecutive messages and closed once it has
+// been idle for IdleTimeout.
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// IdleTimeout and SendTimeout default to DefaultIdleTimeout and
+	// DefaultSendTimeout.
+	IdleTimeout time.Duration
+	SendTimeout time.Duration
+
+	mu       sync.Mutex
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+// Send delivers m. A reused connection that fails is replaced once, since
+// the server may have dropped it while idle.
+func (s *SMTPMailer) Send(ctx context.Context, m Message) error {
+	to, err := address(m.To)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idle := s.IdleTimeout
+	if idle == 0 {
+		idle = DefaultIdleTimeout
+	}
+	if s.client != nil && time.Since(s.lastUsed) > idle {
+		s.close()
+	}
+
+	reused := s.client != nil
+	err = s.send(ctx, to, m)
+	if err != nil && reused && !Permanent(err) {
+		err = s.send(ctx, to, m)
+	}
+	return err
+}
+
+func (s *SMTPMailer) send(ctx context.Context, to string, m Message) error {
+	if s.client == nil {
+		if err := s.connect(ctx); err != nil {
+			return err
+		}
+	}
+	timeout := s.SendTimeout
+	if timeout == 0 {
+		timeout = DefaultSendTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	s.conn.SetDeadline(deadline)
+
+	err := s.deliver(to, format(m, s.From, time.Now()))
+	if err != nil {
+		// The server answers rejections without dropping the session
+		if !Permanent(err) {
+			s.close()
+		}
+		return err
+	}
+	s.lastUsed = time.Now()
+	return nil
+}
+
+func (s *SMTPMailer) deliver(to string, msg []byte) error {
+	if err := s.client.Mail(s.From); err != nil {
+		s.client.Reset()
+		return err
+	}
+	if err := s.client.Rcpt(to); err != nil {
+		s.client.Reset()
+		return err
+	}
+	w, err := s.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func (s *SMTPMailer) connect(ctx context.Context) error {
+	addr := net.JoinHostPort(s.Host, fmt.Sprint(s.Port))
+	config := &tls.Config{ServerName: s.Host}
+	dialer := &net.Dialer{Timeout: DefaultSendTimeout}
+
+	var conn net.Conn
+	var err error
+	if s.Port == 465 {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: config}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(DefaultSendTimeout))
+
+	// Errors are not wrapped, so a rejected login is retried rather than
+	// treated as a permanent failure of the message
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to greet %s: %v", addr, err)
+	}
+	if ok, _ := client.Extension("STARTTLS"); ok && s.Port != 465 {
+		if err := client.StartTLS(config); err != nil {
+			client.Close()
+			return fmt.Errorf("failed to start TLS with %s: %v", addr, err)
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			client.Close()
+			return fmt.Errorf("failed to log in to %s: %v", addr, err)
+		}
+	}
+	s.conn, s.client = conn, client
+	return nil
+}
+
+func (s *SMTPMailer) close() {
+	if s.client == nil {
+		return
+	}
+	if err := s.client.Quit(); err != nil {
+		s.client.Close()
+	}
+	s.conn, s.client = nil, nil
+}
@@ -0,0 +1,161 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// DefaultLanguage is used for users without a language, and for templates
+// not translated into theirs.
+const DefaultLanguage = "en"
+
+// ErrUnknownTemplate is returned by Render for names without a template.
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+// Each language is a directory of templates. layout.tmpl wraps every email
+// and may be overridden per language like any other file. An email template
+// defines "subject", "html" and "text", and optionally "footer_html" and
+// "footer_text".
+//
+//go:embed templates
+var templateFS embed.FS
+
+// dateLayouts formats the dates shown in emails, by language: "date" for
+// a time and "day" for a day of the coming weeks.
+var dateLayouts = map[string]struct{ date, day string }{
+	"en": {"2 Jan 2006 15:04 MST", "2 Jan"},
+	"hi": {"02-01-2006, 15:04 MST", "02-01"},
+}
+
+type templateSet struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+var (
+	languages []string
+	names     []string
+	sets      map[string]map[string]templateSet // By language, then name
+)
+
+func init() {
+	var err error
+	if sets, err = loadTemplates(); err != nil {
+		panic(fmt.Sprintf("invalid email templates: %v", err))
+	}
+	for lang := range sets {
+		languages = append(languages, lang)
+	}
+	slices.Sort(languages)
+	for name := range sets[DefaultLanguage] {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+}
+
+// loadTemplates parses the templates of every language. Templates missing
+// from a language are taken from DefaultLanguage.
+func loadTemplates() (map[string]map[string]templateSet, error) {
+	dirs, err := fs.ReadDir(templateFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+	files, err := fs.Glob(templateFS, "templates/"+DefaultLanguage+"/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]map[string]templateSet)
+	for _, dir := range dirs {
+		lang := dir.Name()
+		// find returns the file of lang, or the default one
+		find := func(file string) string {
+			localized := path.Join("templates", lang, file)
+			if _, err := fs.Stat(templateFS, localized); err == nil {
+				return localized
+			}
+			return path.Join("templates", DefaultLanguage, file)
+		}
+		layouts, ok := dateLayouts[lang]
+		if !ok {
+			layouts = dateLayouts[DefaultLanguage]
+		}
+		funcs := map[string]interface{}{
+			"lang": func() string { return lang },
+			"date": func(t time.Time) string { return t.UTC().Format(layouts.date) },
+			"day":  func(t time.Time) string { return t.Format(layouts.day) },
+		}
+
+		all[lang] = make(map[string]templateSet)
+		for _, file := range files {
+			name := strings.TrimSuffix(path.Base(file), ".tmpl")
+			if name == "layout" {
+				continue
+			}
+			paths := []string{find("layout.tmpl"), find(name + ".tmpl")}
+			html, err := htmltemplate.New(name).Funcs(funcs).ParseFS(templateFS, paths...)
+			if err != nil {
+				return nil, err
+			}
+			text, err := texttemplate.New(name).Funcs(funcs).ParseFS(templateFS, paths...)
+			if err != nil {
+				return nil, err
+			}
+			all[lang][name] = templateSet{html: html, text: text}
+		}
+	}
+	return all, nil
+}
+
+// Languages returns the languages emails can be sent in.
+func Languages() []string {
+	return slices.Clone(languages)
+}
+
+// SupportsLanguage reports whether emails can be sent in lang.
+func SupportsLanguage(lang string) bool {
+	return slices.Contains(languages, lang)
+}
+
+// Templates returns the names of the email templates.
+func Templates() []string {
+	return slices.Clone(names)
+}
+
+// Render fills the template name in lang with data and returns the email to
+// send to to. Unsupported languages fall back to DefaultLanguage.
+func Render(to, lang, name string, data interface{}) (Message, error) {
+	set, ok := sets[lang][name]
+	if !ok {
+		set, ok = sets[DefaultLanguage][name]
+	}
+	if !ok {
+		return Message{}, ErrUnknownTemplate
+	}
+
+	var subject, html, text bytes.Buffer
+	if err := set.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := set.html.ExecuteTemplate(&html, "layout_html", data); err != nil {
+		return Message{}, err
+	}
+	if err := set.text.ExecuteTemplate(&text, "layout_text", data); err != nil {
+		return Message{}, err
+	}
+	return Message{
+		To:      to,
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		HTML:    html.String(),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}, nil
+}
@@ -1,57 +1,342 @@
 package main
 
 import (
+	"backend/activity"
 	"backend/controller"
+	"backend/deletion"
+	"backend/digest"
+	"backend/export"
+	"backend/lockout"
+	"backend/logging"
+	"backend/mail"
 	"backend/middleware"
+	"backend/milestone"
+	"backend/notify"
+	"backend/outbox"
+	"backend/push"
+	"backend/rename"
+	"backend/repository"
+	"backend/session"
 	"backend/utils"
-	"fmt"
+	"backend/vaccination"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io/fs"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Quiet hours need time zones even without system tzdata
 
 	"github.com/gorilla/mux"
+	"github.com/joho/godotenv"
 )
 
 func main() {
-	// Initialize Firebase Auth and Database clients
-	utils.InitFirebase()
+	// Load .env if present so STORAGE_BACKEND can be set there
+	_ = godotenv.Load()
+
+	// Log as configured by LOG_LEVEL and LOG_FORMAT, with secrets redacted
+	logger, err := logging.FromEnv(os.Stderr)
+	if err != nil {
+		log.Fatalf("Failed to configure logging: %v\n", err)
+	}
+	slog.SetDefault(logger)
+
+	// Choose the storage backend; "memory" runs without a Firebase project
+	var repos *repository.Repositories
+	if os.Getenv("STORAGE_BACKEND") == "memory" {
+		slog.Info("Using in-memory storage")
+		repos = repository.NewMemory()
+	} else {
+		// Initialize Firebase Auth and Database clients
+		utils.InitFirebase()
+		repos = repository.NewFirebase(utils.FirebaseAuth, utils.FirebaseDB)
+	}
+
+	// Index the usernames of profiles created before the usernames index
+	skipped, err := repos.BackfillUsernames(context.Background())
+	if err != nil {
+		logging.Fatal("Failed to index existing usernames", "error", err)
+	}
+	for uid, username := range skipped {
+		slog.Warn("Username not indexed; it is invalid or held by another user", "uid", uid, "username", username)
+	}
+
+	// Server-issued session tokens are signed with SESSION_SECRET
+	secret := []byte(os.Getenv("SESSION_SECRET"))
+	if len(secret) == 0 {
+		slog.Warn("SESSION_SECRET not set; using a random key, sessions will not survive a restart")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			logging.Fatal("Failed to generate session secret", "error", err)
+		}
+	}
+	sessions := session.NewManager(repos.Sessions, secret)
+
+	// Accept our own access tokens, and Firebase ID tokens when Firebase is configured
+	var verifier middleware.TokenVerifier = sessions
+	if utils.FirebaseAuth != nil {
+		verifier = middleware.ChainVerifier(sessions, middleware.FirebaseVerifier)
+	}
+
+	// Roles that must enable two-factor authentication, "admin" by default
+	twoFactorRoles := []string{middleware.RoleAdmin}
+	if roles, ok := os.LookupEnv("REQUIRE_2FA_ROLES"); ok {
+		twoFactorRoles = nil
+		for _, role := range strings.Split(roles, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				twoFactorRoles = append(twoFactorRoles, role)
+			}
+		}
+	}
+
+	// Unverified accounts may sign in for EMAIL_VERIFICATION_GRACE, 72h by default
+	grace := 72 * time.Hour
+	if value := os.Getenv("EMAIL_VERIFICATION_GRACE"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			logging.Fatal("Invalid EMAIL_VERIFICATION_GRACE", "error", err)
+		}
+		grace = d
+	}
+
+	// Replace the milestone catalogue with MILESTONES_FILE, data/milestones.json by default
+	catalogueFile := os.Getenv("MILESTONES_FILE")
+	if catalogueFile == "" {
+		catalogueFile = "data/milestones.json"
+	}
+	if milestones, err := milestone.Load(catalogueFile); errors.Is(err, fs.ErrNotExist) {
+		slog.Info("Milestone catalogue not found; keeping the stored catalogue", "file", catalogueFile)
+	} else if err != nil {
+		logging.Fatal("Invalid milestone catalogue", "error", err)
+	} else if err := repos.Milestones.Seed(context.Background(), milestones); err != nil {
+		logging.Fatal("Failed to seed milestone catalogue", "error", err)
+	}
+
+	// Email is queued in the outbox and sent through MAIL_TRANSPORT in the background
+	mailer, err := mail.FromEnv()
+	if err != nil {
+		logging.Fatal("Failed to configure email", "error", err)
+	}
+	mails := outbox.New(repos.Outbox, mailer)
+	if value := os.Getenv("MAIL_WORKERS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			logging.Fatal("Invalid MAIL_WORKERS", "value", value)
+		}
+		mails.Workers = n
+	}
+	utils.Mailer = mails
+	go mails.Run(context.Background())
+
+	// Push notifications need Firebase Cloud Messaging
+	var fcm push.Client
+	if utils.FirebaseMessaging != nil {
+		fcm = utils.FirebaseMessaging
+	}
+	// Notifications go out through the channels each user enabled
+	dispatcher := notify.NewDispatcher(repos, push.NewNotifier(fcm, repos.Devices))
+	// Tell authors about comments and, batched, likes
+	hooks := activity.NewHooks(repos, dispatcher)
+	go hooks.Run(context.Background())
+
+	// Remind parents of vaccinations from VACCINE_SCHEDULE_FILE, data/vaccines.json by default
+	scheduleFile := os.Getenv("VACCINE_SCHEDULE_FILE")
+	if scheduleFile == "" {
+		scheduleFile = "data/vaccines.json"
+	}
+	schedule, err := vaccination.Load(scheduleFile)
+	if err != nil {
+		logging.Fatal("Failed to load vaccine schedule", "error", err)
+	}
+	reminders := vaccination.NewWorker(repos, schedule, dispatcher)
+	go reminders.Run(context.Background())
+
+	// Rewrite old usernames in posts and comments in the background
+	renamer := rename.NewWorker(repos.RenameJobs, repos.Posts, repos.Usernames, repos.Users)
+	go renamer.Run(context.Background())
+	// Carry out account deletions once their undo window has passed
+	deletions := deletion.NewWorker(repos, sessions)
+	go deletions.Run(context.Background())
+	// Build personal data exports
+	exports := export.NewWorker(repos)
+	go exports.Run(context.Background())
+	// Email the weekly digest to subscribers
+	digests := digest.NewWorker(repos, sessions)
+	go digests.Run(context.Background())
+
+	handlers := controller.New(controller.Dependencies{
+		Repos:                  repos,
+		Sessions:               sessions,
+		LoginGuard:             lockout.NewGuard(repos.Attempts),
+		Renamer:                renamer,
+		Deletions:              deletions,
+		Exports:                exports,
+		Dispatcher:             dispatcher,
+		Hooks:                  hooks,
+		VaccineSchedule:        schedule,
+		TwoFactorRoles:         twoFactorRoles,
+		EmailVerificationGrace: grace,
+	})
+
+	// Roles allowed on each protected route; an empty list admits any
+	// signed-in user and unlisted routes are public.
+	staff := []string{middleware.RoleAdmin, middleware.RoleModerator}
+	admins := []string{middleware.RoleAdmin}
+	policy := middleware.Policy{
+		"POST /logout":                                    {},
+		"POST /password/change":                           {},
+		"POST /2fa/setup":                                 {},
+		"POST /2fa/verify":                                {},
+		"POST /2fa/disable":                               {},
+		"POST /delete_account":                            {},
+		"POST /delete_account/cancel":                     {},
+		"POST /devices":                                   {},
+		"DELETE /devices/{token}":                         {},
+		"GET /notifications":                              {},
+		"GET /notifications/unread":                       {},
+		"POST /notifications/read_all":                    {},
+		"POST /notifications/{id}/read":                   {},
+		"GET /notifications/preferences":                  {},
+		"PUT /notifications/preferences":                  {},
+		"POST /enter_data":                                {},
+		"GET /children":                                   {},
+		"POST /children":                                  {},
+		"GET /children/{id}":                              {},
+		"PUT /children/{id}":                              {},
+		"DELETE /children/{id}":                           {},
+		"GET /children/{id}/milestones":                   {},
+		"POST /children/{id}/milestones":                  {},
+		"DELETE /children/{id}/milestones/{milestone_id}": {},
+		"GET /children/{id}/growth":                       {},
+		"POST /children/{id}/growth":                      {},
+		"DELETE /children/{id}/growth/{measurement_id}":   {},
+		"GET /children/{id}/growth/chart":                 {},
+		"GET /children/{id}/vaccinations":                 {},
+		"POST /children/{id}/vaccinations":                {},
+		"DELETE /children/{id}/vaccinations/{dose_id}":    {},
+		"POST /username":                                  {},
+		"GET /username/job":                               {},
+		"POST /me/export":                                 {},
+		"GET /me/export":                                  {},
+		"POST /users/role":                                admins,
+		"POST /admin/unlock":                              admins,
+		"GET /admin/mail/dead":                            admins,
+		"DELETE /admin/mail/dead/{id}":                    admins,
+		"GET /admin/mail/templates":                       admins,
+		"GET /admin/mail/templates/{template}":            admins,
+		"POST /videos":                                    admins,
+		"POST /videos/top":                                admins,
+		"POST /posts":                                     {},
+		"POST /posts/comment":                             {},
+		"POST /posts/like":                                {},
+		"POST /comments/like":                             {},
+		"POST /posts/flag":                                {},
+		"POST /comments/flag":                             {},
+		"GET /posts/flag":                                 staff,
+		"GET /comments/flag":                              staff,
+		"POST /custom-notif":                              admins,
+		"POST /tips":                                      admins,
+		"POST /contest":                                   admins,
+	}
 
 	r := mux.NewRouter()
 
+	// Tag requests with an ID and log them
+	r.Use(middleware.LogRequests)
 	// Apply CORS middleware
 	r.Use(middleware.CORS)
+	// Identify the caller from the Authorization header
+	r.Use(middleware.Authenticate(verifier, repos.Users))
+	// Enforce the role policy
+	r.Use(middleware.Authorize(policy))
+	// Require the second factor where enrolled, and enrolment for twoFactorRoles
+	r.Use(middleware.RequireTwoFactor(twoFactorRoles, "POST /2fa/setup", "POST /2fa/verify", "POST /logout"))
+	// Unverified accounts are read-only
+	r.Use(middleware.RequireVerifiedEmail("POST /posts", "POST /posts/comment", "POST /posts/like", "POST /comments/like"))
 	// Register routes
-	r.HandleFunc("/register", controller.RegisterHandler).Methods("POST")
-	r.HandleFunc("/login", controller.LoginHandler).Methods("POST")
-	r.HandleFunc("/forget-password", controller.ForgotPasswordHandler).Methods("POST")
-	r.HandleFunc("/delete_account", controller.DeleteAccountHandler).Methods("POST")
-	r.HandleFunc("/resend-verification", controller.ResendVerificationHandler).Methods("POST")
-	r.HandleFunc("/enter_data", controller.EnterDataHandler).Methods("POST")
-	r.HandleFunc("/username", controller.ChangeUsernameHandler).Methods("POST")
-	r.HandleFunc("/videos", controller.SaveVideoHandler).Methods("POST")
-	r.HandleFunc("/videos", controller.GetVideosHandler).Methods("GET")
-	r.HandleFunc("/videos/top", controller.SaveTopVideoHandler).Methods("POST")
-	r.HandleFunc("/videos/top", controller.GetTopVideosHandler).Methods("GET")
-	r.HandleFunc("/profile", controller.GetProfileHandler).Methods("GET")
-	r.HandleFunc("/posts", controller.CreatePostHandler).Methods("POST")
-	r.HandleFunc("/posts", controller.GetPostsHandler).Methods("GET")
-	r.HandleFunc("/comments/like", controller.LikeCommentHandler).Methods("POST")
-	r.HandleFunc("/posts/like", controller.LikePostHandler).Methods("POST")
-	r.HandleFunc("/posts/flag", controller.FlagPostHandler).Methods("POST")
-	r.HandleFunc("/comments/flag", controller.FlagCommentHandler).Methods("POST")
-	r.HandleFunc("/posts/flag", controller.GetFlaggedPostsHandler).Methods("GET")
-	r.HandleFunc("/comments/flag", controller.GetFlaggedCommentsHandler).Methods("GET")
-	r.HandleFunc("/posts/comment", controller.AddCommentHandler).Methods("POST")
-	r.HandleFunc("/posts/tags", controller.GetPostsByTagsHandler).Methods("GET")
-	r.HandleFunc("/posts/username", controller.GetPostsByUsernameHandler).Methods("GET")
-	r.HandleFunc("/custom-notif", controller.CustomNotifHandler).Methods("POST")
-	r.HandleFunc("/tips", controller.SaveTipHandler).Methods("POST")
-	r.HandleFunc("/tips", controller.GetTipsHandler).Methods("GET")
-	r.HandleFunc("/contest", controller.SaveContestHandler).Methods("POST")
-	r.HandleFunc("/contest", controller.GetContestHandler).Methods("GET")
-	r.HandleFunc("/profile_image", controller.GetProfileImageHandler).Methods("GET")
+	r.HandleFunc("/register", handlers.RegisterHandler).Methods("POST")
+	r.HandleFunc("/login", handlers.LoginHandler).Methods("POST")
+	r.HandleFunc("/login/2fa", handlers.TwoFactorLoginHandler).Methods("POST")
+	r.HandleFunc("/token/refresh", handlers.RefreshTokenHandler).Methods("POST")
+	r.HandleFunc("/logout", handlers.LogoutHandler).Methods("POST")
+	r.HandleFunc("/forget-password", handlers.ForgotPasswordHandler).Methods("POST")
+	r.HandleFunc("/password/reset", handlers.ResetPasswordHandler).Methods("POST")
+	r.HandleFunc("/password/change", handlers.ChangePasswordHandler).Methods("POST")
+	r.HandleFunc("/2fa/setup", handlers.SetupTwoFactorHandler).Methods("POST")
+	r.HandleFunc("/2fa/verify", handlers.VerifyTwoFactorHandler).Methods("POST")
+	r.HandleFunc("/2fa/disable", handlers.DisableTwoFactorHandler).Methods("POST")
+	r.HandleFunc("/delete_account", handlers.DeleteAccountHandler).Methods("POST")
+	r.HandleFunc("/delete_account/cancel", handlers.CancelDeletionHandler).Methods("POST")
+	r.HandleFunc("/resend-verification", handlers.ResendVerificationHandler).Methods("POST")
+	r.HandleFunc("/devices", handlers.RegisterDeviceHandler).Methods("POST")
+	r.HandleFunc("/devices/{token}", handlers.UnregisterDeviceHandler).Methods("DELETE")
+	r.HandleFunc("/notifications", handlers.ListNotificationsHandler).Methods("GET")
+	r.HandleFunc("/notifications/unread", handlers.UnreadNotificationsHandler).Methods("GET")
+	r.HandleFunc("/notifications/read_all", handlers.ReadAllNotificationsHandler).Methods("POST")
+	r.HandleFunc("/notifications/{id}/read", handlers.ReadNotificationHandler).Methods("POST")
+	r.HandleFunc("/notifications/preferences", handlers.GetPreferencesHandler).Methods("GET")
+	r.HandleFunc("/notifications/preferences", handlers.UpdatePreferencesHandler).Methods("PUT")
+	r.HandleFunc("/digest/unsubscribe", handlers.UnsubscribeDigestHandler).Methods("POST")
+	r.HandleFunc("/enter_data", handlers.EnterDataHandler).Methods("POST")
+	r.HandleFunc("/children", handlers.ListChildrenHandler).Methods("GET")
+	r.HandleFunc("/children", handlers.CreateChildHandler).Methods("POST")
+	r.HandleFunc("/children/{id}", handlers.GetChildHandler).Methods("GET")
+	r.HandleFunc("/children/{id}", handlers.UpdateChildHandler).Methods("PUT")
+	r.HandleFunc("/children/{id}", handlers.DeleteChildHandler).Methods("DELETE")
+	r.HandleFunc("/milestones", handlers.GetMilestonesHandler).Methods("GET")
+	r.HandleFunc("/children/{id}/milestones", handlers.GetChildMilestonesHandler).Methods("GET")
+	r.HandleFunc("/children/{id}/milestones", handlers.AchieveMilestoneHandler).Methods("POST")
+	r.HandleFunc("/children/{id}/milestones/{milestone_id}", handlers.UnachieveMilestoneHandler).Methods("DELETE")
+	r.HandleFunc("/children/{id}/growth", handlers.ListGrowthHandler).Methods("GET")
+	r.HandleFunc("/children/{id}/growth", handlers.RecordGrowthHandler).Methods("POST")
+	r.HandleFunc("/children/{id}/growth/chart", handlers.GrowthChartHandler).Methods("GET")
+	r.HandleFunc("/children/{id}/growth/{measurement_id}", handlers.DeleteGrowthHandler).Methods("DELETE")
+	r.HandleFunc("/vaccines", handlers.GetVaccineScheduleHandler).Methods("GET")
+	r.HandleFunc("/children/{id}/vaccinations", handlers.GetChildVaccinationsHandler).Methods("GET")
+	r.HandleFunc("/children/{id}/vaccinations", handlers.RecordVaccinationHandler).Methods("POST")
+	r.HandleFunc("/children/{id}/vaccinations/{dose_id}", handlers.UnrecordVaccinationHandler).Methods("DELETE")
+	r.HandleFunc("/username", handlers.ChangeUsernameHandler).Methods("POST")
+	r.HandleFunc("/username/job", handlers.GetRenameJobHandler).Methods("GET")
+	r.HandleFunc("/me/export", handlers.RequestExportHandler).Methods("POST")
+	r.HandleFunc("/me/export", handlers.GetExportHandler).Methods("GET")
+	r.HandleFunc("/me/export/download", handlers.DownloadExportHandler).Methods("GET")
+	r.HandleFunc("/users/role", handlers.SetRoleHandler).Methods("POST")
+	r.HandleFunc("/admin/unlock", handlers.UnlockAccountHandler).Methods("POST")
+	r.HandleFunc("/admin/mail/dead", handlers.ListDeadLettersHandler).Methods("GET")
+	r.HandleFunc("/admin/mail/dead/{id}", handlers.DeleteDeadLetterHandler).Methods("DELETE")
+	r.HandleFunc("/admin/mail/templates", handlers.ListEmailTemplatesHandler).Methods("GET")
+	r.HandleFunc("/admin/mail/templates/{template}", handlers.PreviewEmailHandler).Methods("GET")
+	r.HandleFunc("/videos", handlers.SaveVideoHandler).Methods("POST")
+	r.HandleFunc("/videos", handlers.GetVideosHandler).Methods("GET")
+	r.HandleFunc("/videos/top", handlers.SaveTopVideoHandler).Methods("POST")
+	r.HandleFunc("/videos/top", handlers.GetTopVideosHandler).Methods("GET")
+	r.HandleFunc("/profile", handlers.GetProfileHandler).Methods("GET")
+	r.HandleFunc("/posts", handlers.CreatePostHandler).Methods("POST")
+	r.HandleFunc("/posts", handlers.GetPostsHandler).Methods("GET")
+	r.HandleFunc("/comments/like", handlers.LikeCommentHandler).Methods("POST")
+	r.HandleFunc("/posts/like", handlers.LikePostHandler).Methods("POST")
+	r.HandleFunc("/posts/flag", handlers.FlagPostHandler).Methods("POST")
+	r.HandleFunc("/comments/flag", handlers.FlagCommentHandler).Methods("POST")
+	r.HandleFunc("/posts/flag", handlers.GetFlaggedPostsHandler).Methods("GET")
+	r.HandleFunc("/comments/flag", handlers.GetFlaggedCommentsHandler).Methods("GET")
+	r.HandleFunc("/posts/comment", handlers.AddCommentHandler).Methods("POST")
+	r.HandleFunc("/posts/tags", handlers.GetPostsByTagsHandler).Methods("GET")
+	r.HandleFunc("/posts/username", handlers.GetPostsByUsernameHandler).Methods("GET")
+	r.HandleFunc("/custom-notif", handlers.CustomNotifHandler).Methods("POST")
+	r.HandleFunc("/tips", handlers.SaveTipHandler).Methods("POST")
+	r.HandleFunc("/tips", handlers.GetTipsHandler).Methods("GET")
+	r.HandleFunc("/contest", handlers.SaveContestHandler).Methods("POST")
+	r.HandleFunc("/contest", handlers.GetContestHandler).Methods("GET")
+	r.HandleFunc("/profile_image", handlers.GetProfileImageHandler).Methods("GET")
 
 	// Start server
 
-	fmt.Println("Server started on port 8081")
-	log.Fatal(http.ListenAndServe(":8081", r))
+	slog.Info("Server started", "port", 8081)
+	logging.Fatal("Server stopped", "error", http.ListenAndServe(":8081", r))
 }
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"backend/logging"
+	"backend/repository"
+	"backend/utils"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Identity describes the authenticated caller of a request.
+type Identity struct {
+	UID      string
+	Username string
+	Role     string
+	// TwoFactorEnabled is set when the account has TOTP enrolled.
+	TwoFactorEnabled bool
+	// SecondFactor is set when the token was issued after a TOTP check.
+	SecondFactor bool
+	// EmailVerified is set once the account's email address is verified.
+	EmailVerified bool
+	// SessionID identifies the server-issued session of the token; it is
+	// empty for Firebase ID tokens.
+	SessionID string
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the given identity.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFrom returns the identity stored in ctx by Authenticate, if any.
+func IdentityFrom(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// TokenVerifier checks a bearer token. It returns the identity the token
+// proves, of which at least the UID must be set.
+type TokenVerifier interface {
+	VerifyToken(ctx context.Context, token string) (Identity, error)
+}
+
+// TokenVerifierFunc adapts an ordinary function to the TokenVerifier interface.
+type TokenVerifierFunc func(ctx context.Context, token string) (Identity, error)
+
+// VerifyToken calls f(ctx, token).
+func (f TokenVerifierFunc) VerifyToken(ctx context.Context, token string) (Identity, error) {
+	return f(ctx, token)
+}
+
+// FirebaseVerifier verifies Firebase ID tokens.
+var FirebaseVerifier = TokenVerifierFunc(func(ctx context.Context, token string) (Identity, error) {
+	decoded, err := utils.VerifyIDToken(token)
+	if err != nil {
+		return Identity{}, err
+	}
+	verified, _ := decoded.Claims["email_verified"].(bool)
+	return Identity{UID: decoded.UID, EmailVerified: verified}, nil
+})
+
+// StaticVerifier maps fixed tokens to UIDs. It lets tests authenticate
+// requests with fake tokens.
+type StaticVerifier map[string]string
+
+// VerifyToken returns the identity of the UID registered for token.
+func (v StaticVerifier) VerifyToken(ctx context.Context, token string) (Identity, error) {
+	uid, ok := v[token]
+	if !ok {
+		return Identity{}, errors.New("Invalid or expired token")
+	}
+	return Identity{UID: uid}, nil
+}
+
+// ChainVerifier tries each verifier in order and accepts the token as soon
+// as one of them does.
+func ChainVerifier(verifiers ...TokenVerifier) TokenVerifier {
+	return TokenVerifierFunc(func(ctx context.Context, token string) (Identity, error) {
+		err := errors.New("Invalid or expired token")
+		for _, v := range verifiers {
+			var id Identity
+			if id, err = v.VerifyToken(ctx, token); err == nil {
+				return id, nil
+			}
+		}
+		return Identity{}, err
+	})
+}
+
+// Authenticate verifies the "Authorization: Bearer <token>" header and stores
+// the caller's UID, username and role in the request context. Requests
+// without the header pass through anonymously; requests with an invalid
+// token are rejected with 401.
+func Authenticate(verifier TokenVerifier, users repository.UserStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok || strings.TrimSpace(token) == "" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			id, err := verifier.VerifyToken(r.Context(), strings.TrimSpace(token))
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			// Load username and role from the stored profile
+			profile, err := users.Get(r.Context(), id.UID)
+			if err != nil && !errors.Is(err, repository.ErrNotFound) {
+				slog.ErrorContext(r.Context(), "Failed to load profile", "uid", id.UID, "error", err)
+				http.Error(w, "Failed to load user profile", http.StatusInternalServerError)
+				return
+			}
+			id.Username = profile.Username
+			id.Role = profile.Role
+			id.TwoFactorEnabled = profile.TwoFactorEnabled
+			id.EmailVerified = id.EmailVerified || profile.EmailVerified
+
+			ctx := logging.With(WithIdentity(r.Context(), id), "uid", id.UID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Roles understood by the authorization policy.
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleExpert    = "expert"
+	RoleParent    = "parent"
+)
+
+// Policy maps a route, written as "METHOD /path/template", to the roles
+// allowed to call it. An empty role list admits any authenticated user.
+// Routes missing from the policy are public.
+type Policy map[string][]string
+
+// Allows reports whether a caller with the given role may use the route.
+func (p Policy) Allows(route, role string) bool {
+	roles := p[route]
+	if len(roles) == 0 {
+		return true
+	}
+	for _, allowed := range roles {
+		if allowed == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize enforces policy on every matched route. Anonymous callers of a
+// protected route get 401 and callers without an allowed role get 403.
+func Authorize(policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if route == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			template, err := route.GetPathTemplate()
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := r.Method + " " + template
+			if _, protected := policy[key]; !protected {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			id, ok := IdentityFrom(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !policy.Allows(key, id.Role) {
+				Forbidden(w, "Your role is not allowed to perform this action")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Forbidden writes the 403 response shared by every endpoint.
+func Forbidden(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   "forbidden",
+		"message": message,
+	})
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// RequireTwoFactor enforces two-factor authentication. Callers who enrolled
+// must present a token issued after a TOTP check, and callers whose role is
+// listed in roles must enrol before using anything but the exempt routes
+// (written as "METHOD /path/template", like Policy keys).
+func RequireTwoFactor(roles []string, exempt ...string) func(http.Handler) http.Handler {
+	mandatory := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		mandatory[role] = true
+	}
+	skip := make(map[string]bool, len(exempt))
+	for _, route := range exempt {
+		skip[route] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, ok := IdentityFrom(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil && skip[r.Method+" "+template] {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			switch {
+			case id.TwoFactorEnabled && !id.SecondFactor:
+				Forbidden(w, "Sign in with your authenticator code to continue")
+			case !id.TwoFactorEnabled && mandatory[id.Role]:
+				Forbidden(w, "Two-factor authentication must be enabled for your role")
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
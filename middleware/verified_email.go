@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// RequireVerifiedEmail keeps signed-in callers whose email address is not
+// verified out of the given routes (written as "METHOD /path/template", like
+// Policy keys). Anonymous requests are left to Authorize.
+func RequireVerifiedEmail(routes ...string) func(http.Handler) http.Handler {
+	guarded := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		guarded[route] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, ok := IdentityFrom(r.Context())
+			if !ok || id.EmailVerified {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil && guarded[r.Method+" "+template] {
+					Forbidden(w, "Verify your email address to continue")
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
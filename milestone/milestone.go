@@ -0,0 +1,160 @@
+// Package milestone loads the developmental milestone catalogue and works
+// out a child's progress against it.
+//
+// The catalogue is a JSON array of model.Milestone kept in a file the content
+// team edits (data/milestones.json by default). It is validated and copied
+// into the database at startup.
+package milestone
+
+import (
+	"backend/model"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Statuses of a milestone for a given child.
+const (
+	StatusAchieved = "achieved"
+	StatusOverdue  = "overdue"  // Older than OverdueMonths and not achieved
+	StatusDue      = "due"      // Between AgeMonths and OverdueMonths
+	StatusUpcoming = "upcoming" // Younger than AgeMonths
+)
+
+// Categories lists the valid milestone categories.
+var Categories = []string{model.MilestoneMotor, model.MilestoneLanguage, model.MilestoneSocial, model.MilestoneCognitive}
+
+// Load reads and validates the catalogue file at path.
+func Load(path string) ([]model.Milestone, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var milestones []model.Milestone
+	if err := json.Unmarshal(data, &milestones); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := Validate(milestones); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return milestones, nil
+}
+
+// Validate checks that every milestone has a unique ID, a known category, a
+// title and sensible ages.
+func Validate(milestones []model.Milestone) error {
+	seen := make(map[string]bool, len(milestones))
+	for i, m := range milestones {
+		switch {
+		case m.ID == "":
+			return fmt.Errorf("milestone %d has no id", i)
+		case seen[m.ID]:
+			return fmt.Errorf("duplicate milestone id %q", m.ID)
+		case !validCategory(m.Category):
+			return fmt.Errorf("milestone %q has unknown category %q", m.ID, m.Category)
+		case m.Title == "":
+			return fmt.Errorf("milestone %q has no title", m.ID)
+		case m.AgeMonths < 0 || m.OverdueMonths < m.AgeMonths:
+			return fmt.Errorf("milestone %q must have 0 <= age_months <= overdue_months", m.ID)
+		}
+		seen[m.ID] = true
+	}
+	return nil
+}
+
+func validCategory(category string) bool {
+	for _, c := range Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// Entry is a milestone together with its status for one child.
+type Entry struct {
+	model.Milestone
+	Status     string      `json:"status"`
+	AchievedOn *model.Date `json:"achieved_on,omitempty"`
+}
+
+// Summary counts the milestones of one category.
+type Summary struct {
+	Total    int `json:"total"`
+	Achieved int `json:"achieved"`
+	Overdue  int `json:"overdue"`
+}
+
+// Progress is a child's standing against the whole catalogue.
+type Progress struct {
+	AgeMonths  int                `json:"age_months"`
+	Milestones []Entry            `json:"milestones"`
+	Categories map[string]Summary `json:"categories"`
+	Overdue    int                `json:"overdue"`
+}
+
+// Track works out the status of every milestone for a child born on dob, as
+// of now. achievements is keyed by milestone ID; achievements of milestones
+// no longer in the catalogue are ignored.
+func Track(catalogue map[string]model.Milestone, achievements map[string]model.Achievement, dob model.Date, now time.Time) Progress {
+	age := dob.AgeInMonths(now)
+	p := Progress{
+		AgeMonths:  age,
+		Milestones: make([]Entry, 0, len(catalogue)),
+		Categories: make(map[string]Summary, len(Categories)),
+	}
+	for _, c := range Categories {
+		p.Categories[c] = Summary{}
+	}
+
+	for id, m := range catalogue {
+		m.ID = id
+		e := Entry{Milestone: m}
+		if a, ok := achievements[id]; ok {
+			achievedOn := a.AchievedOn
+			e.Status = StatusAchieved
+			e.AchievedOn = &achievedOn
+		} else {
+			e.Status = status(m, age)
+		}
+
+		s := p.Categories[m.Category]
+		s.Total++
+		switch e.Status {
+		case StatusAchieved:
+			s.Achieved++
+		case StatusOverdue:
+			s.Overdue++
+			p.Overdue++
+		}
+		p.Categories[m.Category] = s
+		p.Milestones = append(p.Milestones, e)
+	}
+
+	sort.Slice(p.Milestones, func(i, j int) bool {
+		a, b := p.Milestones[i], p.Milestones[j]
+		if a.AgeMonths != b.AgeMonths {
+			return a.AgeMonths < b.AgeMonths
+		}
+		if a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		return a.ID < b.ID
+	})
+	return p
+}
+
+// status returns the status of a milestone that has not been achieved by a
+// child of the given age in months.
+func status(m model.Milestone, age int) string {
+	switch {
+	case age > m.OverdueMonths:
+		return StatusOverdue
+	case age >= m.AgeMonths:
+		return StatusDue
+	default:
+		return StatusUpcoming
+	}
+}
@@ -0,0 +1,11 @@
+package model
+
+// Child is one child of a parent account, stored under children/<uid>/<id>.
+type Child struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"` // Name or nickname
+	DOB       Date   `json:"dob"`
+	Gender    string `json:"gender"`           // 'male', 'female', or 'others'
+	Avatar    int    `json:"avatar,omitempty"` // Optional avatar number (1-10)
+	CreatedAt int64  `json:"created_at"`
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DateLayout is the format of dates in requests and in the database.
+const DateLayout = "2006-01-02"
+
+// Date is a calendar date without a time of day, stored as "YYYY-MM-DD".
+type Date struct {
+	time.Time
+}
+
+// ParseDate parses a "YYYY-MM-DD" date.
+func ParseDate(s string) (Date, error) {
+	t, err := time.Parse(DateLayout, s)
+	if err != nil {
+		return Date{}, err
+	}
+	return Date{t}, nil
+}
+
+// String returns the date as "YYYY-MM-DD", or "" for the zero date.
+func (d Date) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Format(DateLayout)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Empty strings and null decode
+// to the zero date.
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var s *string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == nil || *s == "" {
+		*d = Date{}
+		return nil
+	}
+	parsed, err := ParseDate(*s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// AgeInMonths returns the number of whole months from d to at, e.g. the age
+// of a child born on d.
+func (d Date) AgeInMonths(at time.Time) int {
+	months := (at.Year()-d.Year())*12 + int(at.Month()-d.Month())
+	if at.Day() < d.Day() {
+		months--
+	}
+	return months
+}
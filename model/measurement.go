@@ -0,0 +1,12 @@
+package model
+
+// Measurement is a growth measurement of a child, stored under
+// growth/<uid>/<child id>/<id>. Values that were not measured are zero.
+type Measurement struct {
+	ID         string  `json:"id"`
+	Date       Date    `json:"date"`
+	WeightKG   float64 `json:"weight_kg,omitempty"`
+	HeightCM   float64 `json:"height_cm,omitempty"` // Length lying down below 2 years
+	HeadCM     float64 `json:"head_cm,omitempty"`   // Head circumference
+	RecordedAt int64   `json:"recorded_at"`
+}
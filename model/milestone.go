@@ -0,0 +1,27 @@
+package model
+
+// Milestone categories.
+const (
+	MilestoneMotor     = "motor"
+	MilestoneLanguage  = "language"
+	MilestoneSocial    = "social"
+	MilestoneCognitive = "cognitive"
+)
+
+// Milestone is an entry of the developmental milestone catalogue, stored
+// under milestones/<id>.
+type Milestone struct {
+	ID            string `json:"id"`
+	Category      string `json:"category"` // 'motor', 'language', 'social' or 'cognitive'
+	Title         string `json:"title"`
+	Description   string `json:"description,omitempty"`
+	AgeMonths     int    `json:"age_months"`     // Age by which most children reach it
+	OverdueMonths int    `json:"overdue_months"` // Age after which it is flagged as overdue
+}
+
+// Achievement records when a child reached a milestone, stored under
+// milestone_achievements/<uid>/<child id>/<milestone id>.
+type Achievement struct {
+	AchievedOn Date  `json:"achieved_on"`
+	RecordedAt int64 `json:"recorded_at"`
+}
@@ -0,0 +1,24 @@
+package model
+
+// Notification types, also sent to the app as the "type" data field.
+const (
+	NotificationComment      = "comment"      // Someone commented on the user's post
+	NotificationLike         = "like"         // Someone liked the user's post or comment
+	NotificationReply        = "reply"        // An admin or expert replied
+	NotificationVideo        = "video"        // A new video was posted
+	NotificationVaccination  = "vaccination"  // A vaccine dose is due or overdue
+	NotificationAnnouncement = "announcement" // Written by an admin at /custom-notif
+)
+
+// Notification is an entry of a user's inbox, stored under
+// notifications/<uid>/<id>.
+type Notification struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	Category  string            `json:"category,omitempty"` // Empty for announcements
+	Title     string            `json:"title"`
+	Body      string            `json:"body"`
+	Data      map[string]string `json:"data,omitempty"` // e.g. the post to open
+	Read      bool              `json:"read"`
+	CreatedAt int64             `json:"created_at"`
+}
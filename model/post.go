@@ -4,6 +4,7 @@ package model
 type Post struct {
 	ID           string             `json:"id"`
 	Username     string             `json:"username"` // Replaced userID with username
+	UID          string             `json:"uid"`      // Author's UID, stable across username changes
 	Title        string             `json:"title"`
 	Content      string             `json:"content"`
 	ImageURL     string             `json:"image_url"`
@@ -22,8 +23,10 @@ type Post struct {
 type Comment struct {
 	ID        string          `json:"id"`
 	Username  string          `json:"username"` // Replaced userID with username
+	UID       string          `json:"uid"`      // Author's UID, stable across username changes
 	Content   string          `json:"content"`
 	CreatedAt int64           `json:"created_at"`
+	ParentID  string          `json:"parent_id,omitempty"` // Comment this one replies to
 	IsAdmin   bool            `json:"is_admin"`
 	Role      string          `json:"role"`
 	Flags     map[string]bool `json:"flags"` // Stores usernames who flagged the comment
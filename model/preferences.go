@@ -0,0 +1,163 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Notification categories users can turn on or off.
+const (
+	CategoryComments  = "comments"  // Comments and replies on the user's posts
+	CategoryLikes     = "likes"     // Likes of the user's posts and comments
+	CategoryVideos    = "videos"    // New videos
+	CategoryTips      = "tips"      // The tip of the day
+	CategoryContests  = "contests"  // Contest announcements
+	CategoryReminders = "reminders" // Vaccination reminders
+)
+
+// Notification channels.
+const (
+	ChannelPush  = "push"
+	ChannelEmail = "email"
+	ChannelInbox = "inbox"
+)
+
+// NotificationCategories lists every category.
+var NotificationCategories = []string{CategoryComments, CategoryLikes, CategoryVideos, CategoryTips, CategoryContests, CategoryReminders}
+
+// NotificationChannels lists every channel.
+var NotificationChannels = []string{ChannelPush, ChannelEmail, ChannelInbox}
+
+// ClockLayout is the format of quiet hour times.
+const ClockLayout = "15:04"
+
+// Limits of followed tags.
+const (
+	MaxFollowedTags = 20
+	MaxTagLength    = 30
+)
+
+// NotificationPreferences is the notification settings of a user, stored
+// under notification_preferences/<uid>.
+type NotificationPreferences struct {
+	// Channels turns each channel of each category on or off, e.g.
+	// {"likes": {"email": false}}. Missing entries take their default: push
+	// and inbox are on, email only for reminders.
+	Channels   map[string]map[string]bool `json:"channels"`
+	QuietHours QuietHours                 `json:"quiet_hours"`
+	// Digest subscribes to the weekly email digest.
+	Digest bool `json:"digest"`
+	// Tags are the post tags the user follows; the digest shows their top
+	// posts.
+	Tags      []string `json:"tags,omitempty"`
+	UpdatedAt int64    `json:"updated_at,omitempty"`
+}
+
+// QuietHours is a daily period in which nothing is pushed. Start and End
+// are "HH:MM" in Timezone; a period that ends before it starts runs over
+// midnight.
+type QuietHours struct {
+	Enabled  bool   `json:"enabled"`
+	Start    string `json:"start"`    // e.g. "22:00"
+	End      string `json:"end"`      // e.g. "07:00"
+	Timezone string `json:"timezone"` // IANA name, e.g. "Asia/Kolkata"
+}
+
+// Enabled reports whether the user receives notifications of category
+// through channel. Notifications without a category cannot be turned off.
+func (p NotificationPreferences) Enabled(category, channel string) bool {
+	if category == "" {
+		return true
+	}
+	if on, ok := p.Channels[category][channel]; ok {
+		return on
+	}
+	return channel != ChannelEmail || category == CategoryReminders
+}
+
+// WithDefaults returns p with every category and channel filled in.
+func (p NotificationPreferences) WithDefaults() NotificationPreferences {
+	channels := make(map[string]map[string]bool, len(NotificationCategories))
+	for _, category := range NotificationCategories {
+		channels[category] = make(map[string]bool, len(NotificationChannels))
+		for _, channel := range NotificationChannels {
+			channels[category][channel] = p.Enabled(category, channel)
+		}
+	}
+	p.Channels = channels
+	return p
+}
+
+// Validate checks that p only names known categories and channels, that it
+// follows a reasonable number of tags and that its quiet hours are well
+// formed.
+func (p NotificationPreferences) Validate() error {
+	for category, channels := range p.Channels {
+		if !slices.Contains(NotificationCategories, category) {
+			return fmt.Errorf("unknown category %q", category)
+		}
+		for channel := range channels {
+			if !slices.Contains(NotificationChannels, channel) {
+				return fmt.Errorf("unknown channel %q", channel)
+			}
+		}
+	}
+
+	if len(p.Tags) > MaxFollowedTags {
+		return fmt.Errorf("at most %d tags can be followed", MaxFollowedTags)
+	}
+	for _, tag := range p.Tags {
+		if tag == "" || len(tag) > MaxTagLength || strings.TrimSpace(tag) != tag {
+			return fmt.Errorf("invalid tag %q", tag)
+		}
+	}
+
+	q := p.QuietHours
+	if !q.Enabled {
+		return nil
+	}
+	start, err := time.Parse(ClockLayout, q.Start)
+	if err != nil {
+		return errors.New("quiet hours start must be HH:MM")
+	}
+	end, err := time.Parse(ClockLayout, q.End)
+	if err != nil {
+		return errors.New("quiet hours end must be HH:MM")
+	}
+	if start.Equal(end) {
+		return errors.New("quiet hours must not start and end at the same time")
+	}
+	if q.Timezone == "" {
+		return errors.New("quiet hours need a timezone")
+	}
+	if _, err := time.LoadLocation(q.Timezone); err != nil || q.Timezone == "Local" {
+		return fmt.Errorf("unknown timezone %q", q.Timezone)
+	}
+	return nil
+}
+
+// Active reports whether t falls within the quiet hours. Malformed quiet
+// hours are never active.
+func (q QuietHours) Active(t time.Time) bool {
+	if !q.Enabled {
+		return false
+	}
+	start, err1 := time.Parse(ClockLayout, q.Start)
+	end, err2 := time.Parse(ClockLayout, q.End)
+	loc, err3 := time.LoadLocation(q.Timezone)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return false
+	}
+
+	local := t.In(loc)
+	now := local.Hour()*60 + local.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	if from < to {
+		return now >= from && now < to
+	}
+	return now >= from || now < to
+}
@@ -1,15 +1,21 @@
 package model
 
 type User struct {
-	Email        string `json:"email"`
-	Password     string `json:"password"`
-	Role         string `json:"role"`
-	PhoneNumber  string `json:"phone_number"` // Unique phone number
-	Name         string `json:"name"`
-	Gender       string `json:"gender"` // Should be 'male', 'female', or 'others'
-	City         string `json:"city"`
-	ChildDOB     string `json:"child_dob"` // Child's date of birth
-	Username     string `json:"username"`  // Unique username
-	Age          int    `json:"age"`
-	ProfileImage int    `json:"profile_image"`
+	Email            string `json:"email"`
+	Password         string `json:"password"`
+	Role             string `json:"role"`
+	PhoneNumber      string `json:"phone_number"` // Unique phone number
+	Name             string `json:"name"`
+	Gender           string `json:"gender"` // Should be 'male', 'female', or 'others'
+	City             string `json:"city"`
+	ChildDOB         string `json:"child_dob"` // Legacy; moved to the children collection
+	Username         string `json:"username"`  // Unique username
+	Age              int    `json:"age"`
+	ProfileImage     int    `json:"profile_image"`
+	Language         string `json:"language,omitempty"` // Language of emails; see mail.Languages
+	TwoFactorEnabled bool   `json:"two_factor_enabled"` // Set once TOTP enrolment is confirmed
+	EmailVerified    bool   `json:"email_verified"`     // Copied from the auth account at login
+	// VerificationSentAt is when the first verification email was sent, in
+	// Unix seconds; the verification grace period runs from it.
+	VerificationSentAt int64 `json:"verification_sent_at,omitempty"`
 }
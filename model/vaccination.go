@@ -0,0 +1,8 @@
+package model
+
+// Vaccination records a vaccine dose given to a child, stored under
+// vaccinations/<uid>/<child id>/<dose id>.
+type Vaccination struct {
+	GivenOn    Date  `json:"given_on"`
+	RecordedAt int64 `json:"recorded_at"`
+}
@@ -11,4 +11,5 @@ type Video struct {
 	Thumbnail   string   `json:"thumbnail"`
 	Rank        int      `json:"rank"`
 	Citations   string   `json:"Citations"`
+	CreatedAt   int64    `json:"created_at,omitempty"` // Unset for videos saved before it was recorded
 }
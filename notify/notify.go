@@ -0,0 +1,209 @@
+// Package notify delivers notifications to users through the channels they
+// chose in their notification preferences.
+//
+// Every notification belongs to a category, such as comments or reminders,
+// and each category can be turned on or off per channel: the in-app inbox,
+// push and email. Nothing is pushed during the user's quiet hours; the
+// inbox and email are not affected by them. Broadcasts, such as new videos,
+// are pushed to each user's devices in turn so the same rules apply.
+package notify
+
+import (
+	"backend/model"
+	"backend/push"
+	"backend/repository"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// batchSize is the number of users read per page when broadcasting.
+const batchSize = 500
+
+// Message is a notification to deliver.
+type Message struct {
+	// Category selects the preferences that apply. Messages without one,
+	// like admin announcements, cannot be turned off.
+	Category string
+	// Type is one of the model.Notification types.
+	Type  string
+	Title string
+	Body  string
+	// Data is delivered to the app alongside the notification, e.g. the
+	// screen to open.
+	Data map[string]string
+	// HighPriority wakes the device immediately.
+	HighPriority bool
+	// Email sends the message to the given address in the user's language.
+	// Messages without it are not emailed.
+	Email func(to, lang string) error
+}
+
+// Dispatcher sends messages through the inbox, push and email according to
+// each user's preferences.
+type Dispatcher struct {
+	repos  *repository.Repositories
+	pusher *push.Notifier
+	// Now returns the current time; tests may replace it.
+	Now func() time.Time
+}
+
+// NewDispatcher returns a Dispatcher that pushes through pusher.
+func NewDispatcher(repos *repository.Repositories, pusher *push.Notifier) *Dispatcher {
+	return &Dispatcher{repos: repos, pusher: pusher, Now: time.Now}
+}
+
+// Send delivers m to uid through every channel the user enabled for its
+// category and returns the channels that delivered it. Users without
+// devices, or without any enabled channel, are skipped silently; an error
+// is only returned when every channel that was tried failed.
+func (d *Dispatcher) Send(ctx context.Context, uid string, m Message) ([]string, error) {
+	prefs, err := d.repos.Prefs.Get(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	now := d.Now()
+
+	var delivered, errs []string
+	if prefs.Enabled(m.Category, model.ChannelInbox) {
+		if _, err := d.repos.Inbox.Add(ctx, uid, entry(m, now)); err != nil {
+			errs = append(errs, model.ChannelInbox+": "+err.Error())
+		} else {
+			delivered = append(delivered, model.ChannelInbox)
+		}
+	}
+	if wantsPush(prefs, m, now) {
+		err := d.pusher.SendToUser(ctx, uid, notification(m))
+		switch {
+		case err == nil:
+			delivered = append(delivered, model.ChannelPush)
+		case err == push.ErrNoDevices || errors.Is(err, push.ErrNotConfigured):
+			// Nowhere to push to
+		default:
+			errs = append(errs, model.ChannelPush+": "+err.Error())
+		}
+	}
+	if m.Email != nil && prefs.Enabled(m.Category, model.ChannelEmail) {
+		account, err := d.repos.Accounts.Get(ctx, uid)
+		if err == nil {
+			var profile model.User
+			if profile, err = d.repos.Users.Get(ctx, uid); err == nil {
+				err = m.Email(account.Email, profile.Language)
+			}
+		}
+		if err != nil {
+			errs = append(errs, model.ChannelEmail+": "+err.Error())
+		} else {
+			delivered = append(delivered, model.ChannelEmail)
+		}
+	}
+
+	if len(delivered) == 0 && len(errs) > 0 {
+		return nil, fmt.Errorf("no channel delivered the notification (%s)", strings.Join(errs, "; "))
+	}
+	if len(errs) > 0 {
+		slog.WarnContext(ctx, "Notification only partly delivered", "uid", uid, "errors", strings.Join(errs, "; "))
+	}
+	return delivered, nil
+}
+
+// SendToUsers sends m to each user and returns the number of users it
+// reached through at least one channel. A failure for one user is logged
+// and does not stop the others; the failures are returned joined.
+func (d *Dispatcher) SendToUsers(ctx context.Context, uids []string, m Message) (int, error) {
+	reached := 0
+	var errs []error
+	for _, uid := range uids {
+		delivered, err := d.Send(ctx, uid, m)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to notify user", "target", uid, "error", err)
+			errs = append(errs, fmt.Errorf("user %s: %w", uid, err))
+			continue
+		}
+		if len(delivered) > 0 {
+			reached++
+		}
+	}
+	return reached, errors.Join(errs...)
+}
+
+// Broadcast delivers m to every user. It is stored once for all inboxes,
+// where SyncInbox leaves it out for users who turned its category off
+// there, and pushed to the devices of each user who keeps push on for the
+// category and is not in their quiet hours. A failed push is logged and
+// does not stop the others; only failing to store m is returned.
+func (d *Dispatcher) Broadcast(ctx context.Context, m Message) error {
+	now := d.Now()
+	if _, err := d.repos.Inbox.Broadcast(ctx, entry(m, now)); err != nil {
+		return err
+	}
+
+	cursor := ""
+	for {
+		uids, err := d.repos.Devices.UsersAfter(ctx, cursor, batchSize)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list users to push a broadcast to", "error", err)
+			return nil
+		}
+		for _, uid := range uids {
+			prefs, err := d.repos.Prefs.Get(ctx, uid)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to push broadcast", "target", uid, "error", err)
+				continue
+			}
+			if !wantsPush(prefs, m, now) {
+				continue
+			}
+			err = d.pusher.SendToUser(ctx, uid, notification(m))
+			switch {
+			case err == nil || err == push.ErrNoDevices:
+			case errors.Is(err, push.ErrNotConfigured):
+				// Nowhere to push to
+				return nil
+			default:
+				slog.ErrorContext(ctx, "Failed to push broadcast", "target", uid, "error", err)
+			}
+		}
+		if len(uids) < batchSize {
+			return nil
+		}
+		cursor = uids[len(uids)-1]
+	}
+}
+
+// SyncInbox copies the broadcasts uid has not seen into their inbox,
+// leaving out categories they turned off there. On the first sync, those
+// created at or after since are copied.
+func (d *Dispatcher) SyncInbox(ctx context.Context, uid string, since time.Time) error {
+	prefs, err := d.repos.Prefs.Get(ctx, uid)
+	if err != nil {
+		return err
+	}
+	return d.repos.Inbox.Sync(ctx, uid, since.Unix(), func(n model.Notification) bool {
+		return prefs.Enabled(n.Category, model.ChannelInbox)
+	})
+}
+
+// wantsPush reports whether m may be pushed to a user with the given
+// preferences at now.
+func wantsPush(prefs model.NotificationPreferences, m Message, now time.Time) bool {
+	return prefs.Enabled(m.Category, model.ChannelPush) && !prefs.QuietHours.Active(now)
+}
+
+func notification(m Message) push.Notification {
+	return push.Notification{Type: m.Type, Title: m.Title, Body: m.Body, Data: m.Data, HighPriority: m.HighPriority}
+}
+
+func entry(m Message, now time.Time) model.Notification {
+	return model.Notification{
+		Type:      m.Type,
+		Category:  m.Category,
+		Title:     m.Title,
+		Body:      m.Body,
+		Data:      m.Data,
+		CreatedAt: now.Unix(),
+	}
+}
@@ -0,0 +1,268 @@
+package notify
+
+import (
+	"backend/model"
+	"backend/push"
+	"backend/repository"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/messaging"
+)
+
+// fakeClient records the device tokens pushed to and fails those in errs.
+type fakeClient struct {
+	mu     sync.Mutex
+	errs   map[string]error
+	tokens []string
+}
+
+func (c *fakeClient) Send(ctx context.Context, m *messaging.Message) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.errs[m.Token]; err != nil {
+		return "", err
+	}
+	c.tokens = append(c.tokens, m.Token)
+	return "projects/test/messages/1", nil
+}
+
+func (c *fakeClient) pushed() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tokens := append([]string(nil), c.tokens...)
+	sort.Strings(tokens)
+	return tokens
+}
+
+// noon is the time the tests run at, in UTC.
+var noon = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// quiet are quiet hours that include noon.
+var quiet = model.QuietHours{Enabled: true, Start: "11:00", End: "13:00", Timezone: "UTC"}
+
+// setup returns a Dispatcher on memory stores and users with a device
+// named after each of them and the given preferences.
+func setup(t *testing.T, client *fakeClient, prefs map[string]model.NotificationPreferences) (*Dispatcher, *repository.Repositories) {
+	t.Helper()
+	ctx := context.Background()
+	repos := repository.NewMemory()
+	for uid, p := range prefs {
+		if err := repos.Devices.Register(ctx, uid, repository.Device{Token: "token-" + uid}); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Prefs.Save(ctx, uid, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := NewDispatcher(repos, push.NewNotifier(client, repos.Devices))
+	d.Now = func() time.Time { return noon }
+	return d, repos
+}
+
+func inboxTypes(t *testing.T, repos *repository.Repositories, uid string) []string {
+	t.Helper()
+	list, err := repos.Inbox.List(context.Background(), uid, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := []string{}
+	for _, n := range list {
+		types = append(types, n.Type)
+	}
+	return types
+}
+
+func TestSendFollowsPreferences(t *testing.T) {
+	comment := Message{Category: model.CategoryComments, Type: model.NotificationComment, Title: "New comment"}
+	announcement := Message{Type: model.NotificationAnnouncement, Title: "Maintenance"}
+
+	tests := []struct {
+		name          string
+		prefs         model.NotificationPreferences
+		m             Message
+		wantDelivered []string
+		wantPushed    []string
+		wantInbox     []string
+	}{
+		{
+			name:          "defaults",
+			m:             comment,
+			wantDelivered: []string{"inbox", "push"},
+			wantPushed:    []string{"token-uid-1"},
+			wantInbox:     []string{"comment"},
+		},
+		{
+			name:          "push turned off",
+			prefs:         model.NotificationPreferences{Channels: map[string]map[string]bool{"comments": {"push": false}}},
+			m:             comment,
+			wantDelivered: []string{"inbox"},
+			wantInbox:     []string{"comment"},
+		},
+		{
+			name:          "inbox turned off",
+			prefs:         model.NotificationPreferences{Channels: map[string]map[string]bool{"comments": {"inbox": false}}},
+			m:             comment,
+			wantDelivered: []string{"push"},
+			wantPushed:    []string{"token-uid-1"},
+			wantInbox:     []string{},
+		},
+		{
+			name:      "everything turned off",
+			prefs:     model.NotificationPreferences{Channels: map[string]map[string]bool{"comments": {"inbox": false, "push": false}}},
+			m:         comment,
+			wantInbox: []string{},
+		},
+		{
+			name:          "other category turned off",
+			prefs:         model.NotificationPreferences{Channels: map[string]map[string]bool{"likes": {"inbox": false, "push": false}}},
+			m:             comment,
+			wantDelivered: []string{"inbox", "push"},
+			wantPushed:    []string{"token-uid-1"},
+			wantInbox:     []string{"comment"},
+		},
+		{
+			name:          "quiet hours hold back the push only",
+			prefs:         model.NotificationPreferences{QuietHours: quiet},
+			m:             comment,
+			wantDelivered: []string{"inbox"},
+			wantInbox:     []string{"comment"},
+		},
+		{
+			name:          "quiet hours outside now",
+			prefs:         model.NotificationPreferences{QuietHours: model.QuietHours{Enabled: true, Start: "22:00", End: "07:00", Timezone: "UTC"}},
+			m:             comment,
+			wantDelivered: []string{"inbox", "push"},
+			wantPushed:    []string{"token-uid-1"},
+			wantInbox:     []string{"comment"},
+		},
+		{
+			name:          "announcements cannot be turned off",
+			prefs:         model.NotificationPreferences{Channels: map[string]map[string]bool{"comments": {"inbox": false, "push": false}}},
+			m:             announcement,
+			wantDelivered: []string{"inbox", "push"},
+			wantPushed:    []string{"token-uid-1"},
+			wantInbox:     []string{"announcement"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeClient{}
+			d, repos := setup(t, client, map[string]model.NotificationPreferences{"uid-1": tt.prefs})
+
+			delivered, err := d.Send(context.Background(), "uid-1", tt.m)
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			if fmt.Sprint(delivered) != fmt.Sprint(tt.wantDelivered) {
+				t.Errorf("delivered through %v, want %v", delivered, tt.wantDelivered)
+			}
+			if got := client.pushed(); fmt.Sprint(got) != fmt.Sprint(tt.wantPushed) {
+				t.Errorf("pushed to %v, want %v", got, tt.wantPushed)
+			}
+			if got := inboxTypes(t, repos, "uid-1"); fmt.Sprint(got) != fmt.Sprint(tt.wantInbox) {
+				t.Errorf("inbox = %v, want %v", got, tt.wantInbox)
+			}
+		})
+	}
+}
+
+func TestSendToUsersContinuesAfterFailure(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeClient{}
+	emailOnly := model.NotificationPreferences{Channels: map[string]map[string]bool{"reminders": {"inbox": false, "push": false}}}
+	d, repos := setup(t, client, nil)
+
+	var uids []string
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		account, err := repos.Accounts.Create(ctx, email, "secret123", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Users.Update(ctx, account.UID, map[string]interface{}{"email": email}); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Prefs.Save(ctx, account.UID, emailOnly); err != nil {
+			t.Fatal(err)
+		}
+		uids = append(uids, account.UID)
+	}
+
+	var sent []string
+	m := Message{
+		Category: model.CategoryReminders,
+		Type:     model.NotificationVaccination,
+		Title:    "Dose due",
+		Email: func(to, lang string) error {
+			if to == "b@example.com" {
+				return errors.New("mailbox full")
+			}
+			sent = append(sent, to)
+			return nil
+		},
+	}
+	reached, err := d.SendToUsers(ctx, uids, m)
+	if reached != 2 {
+		t.Errorf("reached %d users, want 2", reached)
+	}
+	if err == nil {
+		t.Error("SendToUsers did not report the failure")
+	}
+	if fmt.Sprint(sent) != "[a@example.com c@example.com]" {
+		t.Errorf("emailed %v, want the users before and after the failure", sent)
+	}
+}
+
+func TestBroadcastFollowsPreferences(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeClient{errs: map[string]error{"token-uid-4": errors.New("connection reset")}}
+	d, repos := setup(t, client, map[string]model.NotificationPreferences{
+		"uid-1": {},
+		"uid-2": {Channels: map[string]map[string]bool{"videos": {"push": false}}},
+		"uid-3": {QuietHours: quiet},
+		"uid-4": {},
+		"uid-5": {Channels: map[string]map[string]bool{"videos": {"inbox": false}}},
+	})
+
+	err := d.Broadcast(ctx, Message{Category: model.CategoryVideos, Type: model.NotificationVideo, Title: "New video"})
+	if err != nil {
+		t.Fatalf("Broadcast: %v", err)
+	}
+	if got := client.pushed(); fmt.Sprint(got) != "[token-uid-1 token-uid-5]" {
+		t.Errorf("pushed to %v, want the users who allow it now", got)
+	}
+
+	for uid, want := range map[string]string{"uid-2": "[video]", "uid-3": "[video]", "uid-5": "[]"} {
+		if err := d.SyncInbox(ctx, uid, noon.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if got := inboxTypes(t, repos, uid); fmt.Sprint(got) != want {
+			t.Errorf("inbox of %s = %v, want %s", uid, got, want)
+		}
+	}
+}
+
+func TestBroadcastWithoutPush(t *testing.T) {
+	ctx := context.Background()
+	repos := repository.NewMemory()
+	if err := repos.Devices.Register(ctx, "uid-1", repository.Device{Token: "token-uid-1"}); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDispatcher(repos, push.NewNotifier(nil, repos.Devices))
+
+	if err := d.Broadcast(ctx, Message{Type: model.NotificationAnnouncement, Title: "Maintenance"}); err != nil {
+		t.Fatalf("Broadcast: %v", err)
+	}
+	if err := d.SyncInbox(ctx, "uid-1", time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if got := inboxTypes(t, repos, "uid-1"); fmt.Sprint(got) != "[announcement]" {
+		t.Errorf("inbox = %v, want the announcement", got)
+	}
+}
@@ -0,0 +1,191 @@
+// Package outbox queues outgoing email in the database and sends it in the
+// background, so a slow or failing mail server never holds up a request.
+//
+// Outbox.Send only stores the message. Workers pick up due messages and
+// hand them to the real Mailer; a message that fails is retried with
+// exponential backoff, and one that is rejected outright or still fails
+// after MaxAttempts is moved to the dead letters for an admin to look at.
+package outbox
+
+import (
+	"backend/mail"
+	"backend/repository"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Default policy values.
+const (
+	DefaultWorkers     = 2
+	DefaultMaxAttempts = 10
+	DefaultRetryBase   = time.Minute
+	DefaultRetryMax    = 6 * time.Hour
+	DefaultLease       = 5 * time.Minute
+)
+
+// batchSize is the number of due messages read per batch.
+const batchSize = 100
+
+// pollInterval is how often the queue is checked for due messages.
+const pollInterval = 5 * time.Second
+
+// Outbox is a Mailer that queues messages and sends them through another
+// Mailer.
+type Outbox struct {
+	store  repository.OutboxStore
+	mailer mail.Mailer
+	// Workers is the number of messages sent at the same time.
+	Workers int
+	// MaxAttempts is how often a message is tried before it is given up.
+	MaxAttempts int
+	// RetryBase is the wait after the first failure; it doubles with every
+	// further failure up to RetryMax.
+	RetryBase time.Duration
+	RetryMax  time.Duration
+	// Lease is how long a worker holds a message it is sending before
+	// another may pick it up.
+	Lease time.Duration
+	// Now returns the current time; tests may replace it.
+	Now func() time.Time
+
+	wake chan struct{}
+}
+
+// New returns an Outbox with the default policy that sends through mailer.
+func New(store repository.OutboxStore, mailer mail.Mailer) *Outbox {
+	return &Outbox{
+		store:       store,
+		mailer:      mailer,
+		Workers:     DefaultWorkers,
+		MaxAttempts: DefaultMaxAttempts,
+		RetryBase:   DefaultRetryBase,
+		RetryMax:    DefaultRetryMax,
+		Lease:       DefaultLease,
+		Now:         time.Now,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Send queues m for delivery.
+func (o *Outbox) Send(ctx context.Context, m mail.Message) error {
+	_, err := o.store.Enqueue(ctx, repository.OutboxEntry{
+		To:            m.To,
+		Subject:       m.Subject,
+		HTML:          m.HTML,
+		Text:          m.Text,
+		Headers:       m.Headers,
+		NextAttemptAt: o.Now().Unix(),
+		CreatedAt:     o.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	// Send right away rather than at the next poll
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run sends queued messages with Workers workers until ctx is cancelled.
+func (o *Outbox) Run(ctx context.Context) {
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < o.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				o.attempt(ctx, id)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		o.dispatch(ctx, jobs)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// dispatch hands every due message to the workers.
+func (o *Outbox) dispatch(ctx context.Context, jobs chan<- string) {
+	for {
+		due, err := o.store.Due(ctx, o.Now().Unix(), batchSize)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list queued emails", "error", err)
+			return
+		}
+		for _, e := range due {
+			select {
+			case jobs <- e.ID:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if len(due) < batchSize {
+			return
+		}
+	}
+}
+
+// attempt sends one queued message and records the outcome.
+func (o *Outbox) attempt(ctx context.Context, id string) {
+	now := o.Now()
+	e, err := o.store.Claim(ctx, id, now.Unix(), now.Add(o.Lease).Unix())
+	if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrConflict) {
+		// Sent or picked up by another worker meanwhile
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to claim queued email", "id", id, "error", err)
+		return
+	}
+
+	err = o.mailer.Send(ctx, mail.Message{To: e.To, Subject: e.Subject, HTML: e.HTML, Text: e.Text, Headers: e.Headers})
+	if err == nil {
+		if err := o.store.Delete(ctx, id); err != nil {
+			slog.ErrorContext(ctx, "Failed to remove sent email from the queue", "id", id, "error", err)
+		}
+		return
+	}
+
+	e.Attempts++
+	e.LastError = err.Error()
+	if mail.Permanent(err) || e.Attempts >= o.MaxAttempts {
+		e.FailedAt = o.Now().Unix()
+		if err := o.store.Bury(ctx, e); err != nil {
+			slog.ErrorContext(ctx, "Failed to move email to the dead letters", "id", id, "error", err)
+			return
+		}
+		slog.ErrorContext(ctx, "Gave up on email", "id", id, "attempts", e.Attempts, "error", err)
+		return
+	}
+	next := o.Now().Add(o.backoff(e.Attempts))
+	if err := o.store.Retry(ctx, id, e.Attempts, next.Unix(), e.LastError); err != nil {
+		slog.ErrorContext(ctx, "Failed to schedule retry of email", "id", id, "error", err)
+		return
+	}
+	slog.WarnContext(ctx, "Failed to send email, will retry", "id", id, "next_attempt", next.UTC(), "error", err)
+}
+
+// backoff returns the wait after the given number of failed attempts.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	d := o.RetryBase
+	for i := 1; i < attempts && d < o.RetryMax; i++ {
+		d *= 2
+	}
+	return min(d, o.RetryMax)
+}
@@ -0,0 +1,174 @@
+package outbox
+
+import (
+	"backend/mail"
+	"backend/repository"
+	"context"
+	"errors"
+	"net/textproto"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMailer fails the next len(errs) sends with those errors, in order,
+// and records the messages it accepts.
+type fakeMailer struct {
+	mu    sync.Mutex
+	errs  []error
+	calls int
+	sent  []mail.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls++
+	if len(m.errs) > 0 {
+		err := m.errs[0]
+		m.errs = m.errs[1:]
+		return err
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var (
+	errTransient = errors.New("connection refused")
+	errRejected  = &textproto.Error{Code: 550, Msg: "mailbox unavailable"}
+)
+
+// setup returns an Outbox on a memory store whose clock is at *now, with
+// one queued message, and the ID of that message.
+func setup(t *testing.T, mailer *fakeMailer, now *time.Time) (*Outbox, repository.OutboxStore, string) {
+	t.Helper()
+	store := repository.NewMemory().Outbox
+	o := New(store, mailer)
+	o.Now = func() time.Time { return *now }
+	msg := mail.Message{To: "parent@example.com", Subject: "Reset your password", HTML: "<a href=\"https://example.com/reset?token=abc\">Reset</a>", Text: "https://example.com/reset?token=abc"}
+	if err := o.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	due, err := store.Due(context.Background(), now.Unix(), 10)
+	if err != nil || len(due) != 1 {
+		t.Fatalf("Due = %v, %v, want the queued message", due, err)
+	}
+	return o, store, due[0].ID
+}
+
+func TestBackoff(t *testing.T) {
+	o := New(nil, nil)
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, 6 * time.Hour},
+		{50, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := o.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestAttemptRetriesAfterBackoff(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mailer := &fakeMailer{errs: []error{errTransient, errTransient}}
+	o, store, id := setup(t, mailer, &now)
+
+	o.attempt(ctx, id)
+	o.attempt(ctx, id)
+	if mailer.calls != 1 {
+		t.Fatalf("mailer called %d times, want 1 before the backoff ends", mailer.calls)
+	}
+	due, err := store.Due(ctx, now.Add(time.Minute).Unix(), 10)
+	if err != nil || len(due) != 1 {
+		t.Fatalf("Due after 1m = %v, %v, want the message", due, err)
+	}
+	if e := due[0]; e.Attempts != 1 || e.LastError != errTransient.Error() || e.NextAttemptAt != now.Add(time.Minute).Unix() {
+		t.Errorf("after one failure: %+v", e)
+	}
+
+	now = now.Add(time.Minute)
+	o.attempt(ctx, id)
+	if due, _ := store.Due(ctx, now.Add(2*time.Minute-time.Second).Unix(), 10); len(due) != 0 {
+		t.Errorf("message due %v after the second failure, want a 2m wait", due)
+	}
+
+	now = now.Add(2 * time.Minute)
+	o.attempt(ctx, id)
+	if len(mailer.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(mailer.sent))
+	}
+	if due, _ := store.Due(ctx, now.Add(24*time.Hour).Unix(), 10); len(due) != 0 {
+		t.Errorf("sent message still queued: %v", due)
+	}
+}
+
+func TestAttemptLeasesMessage(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mailer := &fakeMailer{}
+	o, store, id := setup(t, mailer, &now)
+
+	// Another worker holds the message
+	if _, err := store.Claim(ctx, id, now.Unix(), now.Add(o.Lease).Unix()); err != nil {
+		t.Fatal(err)
+	}
+	o.attempt(ctx, id)
+	if mailer.calls != 0 {
+		t.Fatalf("leased message sent by a second worker")
+	}
+
+	now = now.Add(o.Lease)
+	o.attempt(ctx, id)
+	if len(mailer.sent) != 1 {
+		t.Errorf("message not sent after the lease ran out")
+	}
+}
+
+func TestAttemptBuries(t *testing.T) {
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+	}{
+		{"rejected", []error{errRejected}, 1},
+		{"out of attempts", []error{errTransient, errTransient, errTransient}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			mailer := &fakeMailer{errs: tt.errs}
+			o, store, id := setup(t, mailer, &now)
+			o.MaxAttempts = 3
+
+			for i := 0; i < len(tt.errs); i++ {
+				o.attempt(ctx, id)
+				now = now.Add(o.RetryMax)
+			}
+
+			if due, _ := store.Due(ctx, now.Unix(), 10); len(due) != 0 {
+				t.Errorf("buried message still queued: %v", due)
+			}
+			dead, err := store.DeadLetters(ctx, 10)
+			if err != nil || len(dead) != 1 {
+				t.Fatalf("DeadLetters = %v, %v, want the message", dead, err)
+			}
+			e := dead[0]
+			if e.ID != id || e.To != "parent@example.com" || e.Subject != "Reset your password" || e.Attempts != tt.wantAttempts || e.LastError != tt.errs[len(tt.errs)-1].Error() || e.FailedAt == 0 {
+				t.Errorf("dead letter = %+v", e)
+			}
+			if e.HTML != "" || e.Text != "" || e.Headers != nil {
+				t.Errorf("dead letter kept its body: %+v", e)
+			}
+		})
+	}
+}
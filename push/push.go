@@ -0,0 +1,130 @@
+// Package push sends FCM notifications to the devices users registered at
+// /devices.
+//
+// Messages go out one per device token through the FCM HTTP v1 API. Tokens
+// that FCM reports as unregistered, or that belong to another project, are
+// removed from the DeviceStore as they are found.
+package push
+
+import (
+	"backend/repository"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"firebase.google.com/go/messaging"
+)
+
+var (
+	// ErrNotConfigured is returned when no FCM client is available, e.g.
+	// when running with in-memory storage.
+	ErrNotConfigured = errors.New("push notifications are not configured")
+	// ErrNoDevices is returned when a user has no device that accepted the
+	// notification.
+	ErrNoDevices = errors.New("no registered device received the notification")
+)
+
+// Client sends a single FCM message. *messaging.Client implements it; tests
+// may provide a fake.
+type Client interface {
+	Send(ctx context.Context, message *messaging.Message) (string, error)
+}
+
+// Notification is the content of a push notification.
+type Notification struct {
+	// Type is one of the model.Notification types and is delivered to the
+	// app as the "type" data field.
+	Type  string
+	Title string
+	Body  string
+	// Data is delivered to the app alongside the notification, e.g. the
+	// screen to open.
+	Data map[string]string
+	// HighPriority wakes the device immediately, e.g. for expert answers.
+	HighPriority bool
+}
+
+// Notifier sends notifications through client to the devices in devices.
+type Notifier struct {
+	client  Client
+	devices repository.DeviceStore
+}
+
+// NewNotifier returns a Notifier. client may be nil, in which case every
+// send fails with ErrNotConfigured.
+func NewNotifier(client Client, devices repository.DeviceStore) *Notifier {
+	return &Notifier{client: client, devices: devices}
+}
+
+// SendToUser sends n to every device of uid. It returns ErrNoDevices when
+// none of them received it.
+func (p *Notifier) SendToUser(ctx context.Context, uid string, n Notification) error {
+	if p.client == nil {
+		return ErrNotConfigured
+	}
+	devices, err := p.devices.List(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	var rejected []string
+	var lastErr error
+	delivered := 0
+	for _, d := range devices {
+		m := message(n)
+		m.Token = d.Token
+		_, err := p.client.Send(ctx, m)
+		switch {
+		case err == nil:
+			delivered++
+		case messaging.IsRegistrationTokenNotRegistered(err) || messaging.IsMismatchedCredential(err):
+			p.remove(ctx, uid, d.Token)
+		case messaging.IsInvalidArgument(err):
+			// Only a bad token if the same message reached another device
+			rejected = append(rejected, d.Token)
+			lastErr = err
+		default:
+			lastErr = err
+		}
+	}
+	if delivered > 0 {
+		for _, token := range rejected {
+			p.remove(ctx, uid, token)
+		}
+		return nil
+	}
+	if lastErr != nil {
+		return fmt.Errorf("%w: %v", ErrNoDevices, lastErr)
+	}
+	return ErrNoDevices
+}
+
+func (p *Notifier) remove(ctx context.Context, uid, token string) {
+	if err := p.devices.Remove(ctx, token); err != nil {
+		slog.ErrorContext(ctx, "Failed to remove invalid push token", "uid", uid, "error", err)
+	}
+}
+
+func message(n Notification) *messaging.Message {
+	data := make(map[string]string, len(n.Data)+1)
+	for k, v := range n.Data {
+		data[k] = v
+	}
+	if n.Type != "" {
+		data["type"] = n.Type
+	}
+	m := &messaging.Message{
+		Notification: &messaging.Notification{
+			Title: n.Title,
+			Body:  n.Body,
+		},
+		Data: data,
+	}
+	if n.HighPriority {
+		m.Android = &messaging.AndroidConfig{Priority: "high"}
+		m.APNS = &messaging.APNSConfig{Headers: map[string]string{"apns-priority": "10"}}
+		m.Webpush = &messaging.WebpushConfig{Headers: map[string]string{"Urgency": "high"}}
+	}
+	return m
+}
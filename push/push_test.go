@@ -0,0 +1,218 @@
+package push
+
+import (
+	"backend/repository"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"sync"
+	"testing"
+
+	firebase "firebase.google.com/go"
+	"firebase.google.com/go/messaging"
+	"google.golang.org/api/option"
+)
+
+// fakeClient records the messages sent to it and fails the tokens in errs.
+type fakeClient struct {
+	mu   sync.Mutex
+	errs map[string]error
+	sent []*messaging.Message
+}
+
+func (c *fakeClient) Send(ctx context.Context, m *messaging.Message) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.errs[m.Token]; err != nil {
+		return "", err
+	}
+	c.sent = append(c.sent, m)
+	return "projects/test/messages/1", nil
+}
+
+func (c *fakeClient) tokens() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var tokens []string
+	for _, m := range c.sent {
+		tokens = append(tokens, m.Token)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// redirect sends every request to the test server at target.
+type redirect struct{ target *url.URL }
+
+func (rt redirect) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = rt.target.Scheme, rt.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// fcmError returns the error the FCM client reports when the FCM API
+// answers with the given HTTP status and FCM error code.
+func fcmError(t *testing.T, status int, code string) error {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"error": {"status": %q, "details": [{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": %q}]}}`, code, code)
+	}))
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+
+	ctx := context.Background()
+	app, err := firebase.NewApp(ctx, &firebase.Config{ProjectID: "test"}, option.WithHTTPClient(&http.Client{Transport: redirect{target}}))
+	if err != nil {
+		t.Fatalf("NewApp: %v", err)
+	}
+	client, err := app.Messaging(ctx)
+	if err != nil {
+		t.Fatalf("Messaging: %v", err)
+	}
+	_, err = client.Send(ctx, &messaging.Message{Token: "token"})
+	if err == nil {
+		t.Fatalf("FCM error %s was not reported", code)
+	}
+	return err
+}
+
+func TestSendToUser(t *testing.T) {
+	unregistered := fcmError(t, http.StatusNotFound, "UNREGISTERED")
+	mismatched := fcmError(t, http.StatusForbidden, "SENDER_ID_MISMATCH")
+	invalid := fcmError(t, http.StatusBadRequest, "INVALID_ARGUMENT")
+	if !messaging.IsRegistrationTokenNotRegistered(unregistered) || !messaging.IsMismatchedCredential(mismatched) || !messaging.IsInvalidArgument(invalid) {
+		t.Fatalf("unexpected FCM errors: %v, %v, %v", unregistered, mismatched, invalid)
+	}
+
+	tests := []struct {
+		name          string
+		devices       []string
+		errs          map[string]error
+		wantErr       error
+		wantDelivered []string
+		wantKept      []string
+	}{
+		{
+			name:          "fans out to every device",
+			devices:       []string{"a", "b", "c"},
+			wantDelivered: []string{"a", "b", "c"},
+			wantKept:      []string{"a", "b", "c"},
+		},
+		{
+			name:          "prunes unregistered tokens",
+			devices:       []string{"a", "b"},
+			errs:          map[string]error{"b": unregistered},
+			wantDelivered: []string{"a"},
+			wantKept:      []string{"a"},
+		},
+		{
+			name:     "prunes tokens of another project",
+			devices:  []string{"a"},
+			errs:     map[string]error{"a": mismatched},
+			wantErr:  ErrNoDevices,
+			wantKept: []string{},
+		},
+		{
+			name:          "prunes invalid tokens when another device received the message",
+			devices:       []string{"a", "b"},
+			errs:          map[string]error{"a": invalid},
+			wantDelivered: []string{"b"},
+			wantKept:      []string{"b"},
+		},
+		{
+			name:     "keeps invalid tokens when the message itself may be at fault",
+			devices:  []string{"a", "b"},
+			errs:     map[string]error{"a": invalid, "b": invalid},
+			wantErr:  ErrNoDevices,
+			wantKept: []string{"a", "b"},
+		},
+		{
+			name:     "keeps tokens on other errors",
+			devices:  []string{"a"},
+			errs:     map[string]error{"a": errors.New("connection reset")},
+			wantErr:  ErrNoDevices,
+			wantKept: []string{"a"},
+		},
+		{
+			name:     "user without devices",
+			wantErr:  ErrNoDevices,
+			wantKept: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			devices := repository.NewMemory().Devices
+			for _, token := range tt.devices {
+				if err := devices.Register(ctx, "uid-1", repository.Device{Token: token}); err != nil {
+					t.Fatalf("Register: %v", err)
+				}
+			}
+			client := &fakeClient{errs: tt.errs}
+			n := NewNotifier(client, devices)
+
+			err := n.SendToUser(ctx, "uid-1", Notification{Type: "comment", Title: "New comment", Body: "Hi"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SendToUser error = %v, want %v", err, tt.wantErr)
+			}
+			if got := client.tokens(); fmt.Sprint(got) != fmt.Sprint(tt.wantDelivered) {
+				t.Errorf("delivered to %v, want %v", got, tt.wantDelivered)
+			}
+
+			list, err := devices.List(ctx, "uid-1")
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			kept := []string{}
+			for _, d := range list {
+				kept = append(kept, d.Token)
+			}
+			sort.Strings(kept)
+			if fmt.Sprint(kept) != fmt.Sprint(tt.wantKept) {
+				t.Errorf("devices kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestSendToUserMessage(t *testing.T) {
+	ctx := context.Background()
+	devices := repository.NewMemory().Devices
+	if err := devices.Register(ctx, "uid-1", repository.Device{Token: "a"}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	client := &fakeClient{}
+	n := NewNotifier(client, devices)
+
+	err := n.SendToUser(ctx, "uid-1", Notification{
+		Type:         "expert_answer",
+		Title:        "An expert answered",
+		Body:         "Read the answer",
+		Data:         map[string]string{"post_id": "p1"},
+		HighPriority: true,
+	})
+	if err != nil {
+		t.Fatalf("SendToUser: %v", err)
+	}
+	m := client.sent[0]
+	if m.Notification.Title != "An expert answered" || m.Data["type"] != "expert_answer" || m.Data["post_id"] != "p1" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if m.Android == nil || m.Android.Priority != "high" {
+		t.Errorf("high priority message without Android priority: %+v", m.Android)
+	}
+}
+
+func TestNotConfigured(t *testing.T) {
+	n := NewNotifier(nil, repository.NewMemory().Devices)
+	if err := n.SendToUser(context.Background(), "uid-1", Notification{}); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("SendToUser error = %v, want ErrNotConfigured", err)
+	}
+}
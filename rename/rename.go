@@ -0,0 +1,254 @@
+// Package rename propagates username changes to the content that still
+// refers to users by username: posts, comments and their like and flag maps.
+//
+// Jobs are stored in the database and run one at a time, oldest first, by a
+// background Worker. A job walks the posts in ID order and saves its cursor
+// after every batch, so a restart resumes it where it stopped. Each post is
+// rewritten with a single multi-path update and rewriting is idempotent, so
+// repeating a batch after a crash is harmless. The old username stays
+// reserved for the user until the job has finished, and for good if the user
+// has meanwhile taken it back.
+package rename
+
+import (
+	"backend/model"
+	"backend/repository"
+	"backend/utils"
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultBatchSize is the number of posts read per batch.
+const DefaultBatchSize = 100
+
+// pollInterval is how often the worker looks for jobs it was not woken for,
+// e.g. jobs queued by another server instance or left pending by an error.
+const pollInterval = time.Minute
+
+// Worker runs rename jobs in the background.
+type Worker struct {
+	jobs      repository.RenameJobStore
+	posts     repository.PostStore
+	usernames repository.UsernameStore
+	users     repository.UserStore
+	wake      chan struct{}
+	// BatchSize is the number of posts processed between progress updates.
+	BatchSize int
+	// Now returns the current time; tests may replace it.
+	Now func() time.Time
+}
+
+// NewWorker returns a Worker with the default batch size.
+func NewWorker(jobs repository.RenameJobStore, posts repository.PostStore, usernames repository.UsernameStore, users repository.UserStore) *Worker {
+	return &Worker{
+		jobs:      jobs,
+		posts:     posts,
+		usernames: usernames,
+		users:     users,
+		wake:      make(chan struct{}, 1),
+		BatchSize: DefaultBatchSize,
+		Now:       time.Now,
+	}
+}
+
+// Enqueue records a job rewriting oldName to newName for uid and wakes the
+// worker.
+func (w *Worker) Enqueue(ctx context.Context, uid, oldName, newName string) (repository.RenameJob, error) {
+	now := w.Now().Unix()
+	job := repository.RenameJob{
+		ID:          uuid.New().String(),
+		UID:         uid,
+		OldUsername: oldName,
+		NewUsername: newName,
+		Status:      repository.RenameJobPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := w.jobs.Create(ctx, job); err != nil {
+		return repository.RenameJob{}, err
+	}
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Run processes pending jobs until ctx is cancelled, starting with any left
+// over from a previous run.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		w.runPending(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runPending runs every pending job in creation order. When a job fails, the
+// later jobs of the same user wait so renames are applied in order.
+func (w *Worker) runPending(ctx context.Context) {
+	pending, err := w.jobs.ListPending(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list rename jobs", "error", err)
+		return
+	}
+	jobs := make([]repository.RenameJob, 0, len(pending))
+	for id, job := range pending {
+		job.ID = id
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].CreatedAt != jobs[j].CreatedAt {
+			return jobs[i].CreatedAt < jobs[j].CreatedAt
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+
+	blocked := make(map[string]bool)
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			return
+		}
+		if blocked[job.UID] {
+			continue
+		}
+		if err := w.run(ctx, job); err != nil {
+			slog.WarnContext(ctx, "Rename job failed, will retry", "job_id", job.ID, "error", err)
+			blocked[job.UID] = true
+			if err := w.jobs.Update(ctx, job.ID, map[string]interface{}{
+				"error":      err.Error(),
+				"updated_at": w.Now().Unix(),
+			}); err != nil {
+				slog.ErrorContext(ctx, "Failed to record error of rename job", "job_id", job.ID, "error", err)
+			}
+		}
+	}
+}
+
+// run rewrites the posts after the job's cursor, saving progress after each
+// batch, and then releases the old username unless the user still needs it.
+func (w *Worker) run(ctx context.Context, job repository.RenameJob) error {
+	for {
+		page, err := w.posts.ListAfter(ctx, job.Cursor, w.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, post := range page {
+			if fields := renameFields(post, job); len(fields) > 0 {
+				if err := w.posts.Update(ctx, post.ID, fields); err != nil {
+					return err
+				}
+				job.PostsUpdated++
+			}
+			job.PostsScanned++
+		}
+		job.Cursor = page[len(page)-1].ID
+		if err := w.jobs.Update(ctx, job.ID, map[string]interface{}{
+			"cursor":        job.Cursor,
+			"posts_scanned": job.PostsScanned,
+			"posts_updated": job.PostsUpdated,
+			"updated_at":    w.Now().Unix(),
+		}); err != nil {
+			return err
+		}
+		if len(page) < w.BatchSize {
+			break
+		}
+	}
+
+	if name, err := utils.NormalizeUsername(job.OldUsername); err == nil {
+		inUse, err := w.stillUsed(ctx, job, name)
+		if err != nil {
+			return err
+		}
+		if !inUse {
+			if err := w.usernames.Release(ctx, name, job.UID); err != nil {
+				return err
+			}
+		}
+	}
+	return w.jobs.Update(ctx, job.ID, map[string]interface{}{
+		"status":     repository.RenameJobDone,
+		"error":      nil,
+		"updated_at": w.Now().Unix(),
+	})
+}
+
+// stillUsed reports whether the job's user has taken name back since the job
+// was queued: it is their current username or the target of one of their
+// pending renames.
+func (w *Worker) stillUsed(ctx context.Context, job repository.RenameJob, name string) (bool, error) {
+	user, err := w.users.Get(ctx, job.UID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return false, err
+	}
+	if current, err := utils.NormalizeUsername(user.Username); err == nil && current == name {
+		return true, nil
+	}
+
+	pending, err := w.jobs.ListPending(ctx)
+	if err != nil {
+		return false, err
+	}
+	for id, other := range pending {
+		if id == job.ID || other.UID != job.UID {
+			continue
+		}
+		if target, err := utils.NormalizeUsername(other.NewUsername); err == nil && target == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// renameFields returns the multi-path update that moves every reference to
+// the old username in post to the new one. It is empty when there is
+// nothing left to rewrite.
+func renameFields(post model.Post, job repository.RenameJob) map[string]interface{} {
+	fields := make(map[string]interface{})
+	// Posts that record a UID are only rewritten for their own author
+	if post.Username == job.OldUsername && (post.UID == "" || post.UID == job.UID) {
+		fields["username"] = job.NewUsername
+	}
+	renameVoter(fields, "", "likes", "like_count", post.Likes, post.LikeCount, job)
+	renameVoter(fields, "", "flags", "flag_count", post.Flags, post.FlagCount, job)
+
+	for id, comment := range post.Comments {
+		prefix := "comments/" + id + "/"
+		if comment.Username == job.OldUsername && (comment.UID == "" || comment.UID == job.UID) {
+			fields[prefix+"username"] = job.NewUsername
+		}
+		renameVoter(fields, prefix, "likes", "like_count", comment.Likes, comment.LikeCount, job)
+		renameVoter(fields, prefix, "flags", "flag_count", comment.Flags, comment.FlagCount, job)
+	}
+	return fields
+}
+
+// renameVoter moves the old username's entry of a likes or flags map to the
+// new username. When both are present the duplicate is dropped and the
+// counter lowered.
+func renameVoter(fields map[string]interface{}, prefix, set, counter string, voters map[string]bool, count int, job repository.RenameJob) {
+	if !voters[job.OldUsername] {
+		return
+	}
+	fields[prefix+set+"/"+job.OldUsername] = nil
+	if voters[job.NewUsername] {
+		fields[prefix+counter] = max(count-1, 0)
+	} else {
+		fields[prefix+set+"/"+job.NewUsername] = true
+	}
+}
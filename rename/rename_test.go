@@ -0,0 +1,131 @@
+package rename
+
+import (
+	"backend/model"
+	"backend/repository"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// setup returns a Worker on memory stores whose clock advances one second
+// per call, so jobs queued in a row run in that order, and the user uid-1
+// named alice with a post.
+func setup(t *testing.T) (*Worker, *repository.Repositories) {
+	t.Helper()
+	ctx := context.Background()
+	repos := repository.NewMemory()
+	w := NewWorker(repos.RenameJobs, repos.Posts, repos.Usernames, repos.Users)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	w.Now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	if err := repos.Users.Update(ctx, "uid-1", map[string]interface{}{"username": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Usernames.Claim(ctx, "alice", "uid-1"); err != nil {
+		t.Fatal(err)
+	}
+	post := model.Post{ID: "p1", Username: "alice", UID: "uid-1", Likes: map[string]bool{"alice": true}, LikeCount: 1}
+	if err := repos.Posts.Save(ctx, post); err != nil {
+		t.Fatal(err)
+	}
+	return w, repos
+}
+
+// rename changes the username of uid-1 the way ChangeUsernameHandler does.
+func rename(t *testing.T, w *Worker, repos *repository.Repositories, from, to string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := repos.Usernames.Claim(ctx, to, "uid-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Users.Update(ctx, "uid-1", map[string]interface{}{"username": to}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Enqueue(ctx, "uid-1", from, to); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertOwner(t *testing.T, repos *repository.Repositories, name, want string) {
+	t.Helper()
+	got, err := repos.Usernames.Lookup(context.Background(), name)
+	if want == "" {
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Lookup(%q) = %q, %v, want released", name, got, err)
+		}
+		return
+	}
+	if err != nil || got != want {
+		t.Errorf("Lookup(%q) = %q, %v, want %q", name, got, err, want)
+	}
+}
+
+func TestRunRewritesPostsAndReleasesOldName(t *testing.T) {
+	ctx := context.Background()
+	w, repos := setup(t)
+	rename(t, w, repos, "alice", "bob")
+
+	w.runPending(ctx)
+
+	post, err := repos.Posts.Get(ctx, "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Username != "bob" || !post.Likes["bob"] || post.Likes["alice"] || post.LikeCount != 1 {
+		t.Errorf("post not rewritten: %+v", post)
+	}
+	assertOwner(t, repos, "alice", "")
+	assertOwner(t, repos, "bob", "uid-1")
+}
+
+func TestRunKeepsNameTakenBack(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("renamed back before the job ran", func(t *testing.T) {
+		w, repos := setup(t)
+		rename(t, w, repos, "alice", "bob")
+		rename(t, w, repos, "bob", "alice")
+
+		w.runPending(ctx)
+
+		assertOwner(t, repos, "alice", "uid-1")
+		assertOwner(t, repos, "bob", "")
+		post, err := repos.Posts.Get(ctx, "p1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if post.Username != "alice" || !post.Likes["alice"] {
+			t.Errorf("post not renamed back: %+v", post)
+		}
+	})
+
+	t.Run("rename back still pending", func(t *testing.T) {
+		w, repos := setup(t)
+		rename(t, w, repos, "alice", "bob")
+		rename(t, w, repos, "bob", "carol")
+		rename(t, w, repos, "carol", "alice")
+		// The profile moves on before the job renaming back to alice runs
+		if err := repos.Users.Update(ctx, "uid-1", map[string]interface{}{"username": "dave"}); err != nil {
+			t.Fatal(err)
+		}
+
+		pending, err := repos.RenameJobs.ListPending(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for id, job := range pending {
+			if job.OldUsername == "alice" {
+				job.ID = id
+				if err := w.run(ctx, job); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		assertOwner(t, repos, "alice", "uid-1")
+	})
+}
@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"firebase.google.com/go/auth"
+	"github.com/google/uuid"
+)
+
+// Account is the authentication record of a user.
+type Account struct {
+	UID           string
+	Email         string
+	EmailVerified bool
+	// CreatedAt is the creation time of the account in Unix seconds.
+	CreatedAt int64
+}
+
+// AccountStore manages authentication accounts.
+type AccountStore interface {
+	// Create registers a new account and returns it.
+	Create(ctx context.Context, email, password, displayName string) (Account, error)
+	// Get returns the account with the given UID.
+	Get(ctx context.Context, uid string) (Account, error)
+	// GetByEmail looks an account up by its email address.
+	GetByEmail(ctx context.Context, email string) (Account, error)
+	// Delete removes the account with the given UID.
+	Delete(ctx context.Context, uid string) error
+	// UpdatePassword sets a new password for the account.
+	UpdatePassword(ctx context.Context, uid, password string) error
+	// SetEmailVerified marks the account's email address as verified or not.
+	SetEmailVerified(ctx context.Context, uid string, verified bool) error
+}
+
+type firebaseAccountStore struct {
+	client *auth.Client
+}
+
+func (s *firebaseAccountStore) Create(ctx context.Context, email, password, displayName string) (Account, error) {
+	params := (&auth.UserToCreate{}).
+		Email(email).
+		Password(password).
+		DisplayName(displayName)
+
+	u, err := s.client.CreateUser(ctx, params)
+	if err != nil {
+		if auth.IsEmailAlreadyExists(err) {
+			return Account{}, ErrEmailExists
+		}
+		if auth.IsInvalidEmail(err) {
+			return Account{}, ErrInvalidEmail
+		}
+		return Account{}, err
+	}
+	return accountFromRecord(u), nil
+}
+
+func (s *firebaseAccountStore) Get(ctx context.Context, uid string) (Account, error) {
+	u, err := s.client.GetUser(ctx, uid)
+	if err != nil {
+		if auth.IsUserNotFound(err) {
+			return Account{}, ErrNotFound
+		}
+		return Account{}, err
+	}
+	return accountFromRecord(u), nil
+}
+
+func (s *firebaseAccountStore) GetByEmail(ctx context.Context, email string) (Account, error) {
+	u, err := s.client.GetUserByEmail(ctx, email)
+	if err != nil {
+		if auth.IsUserNotFound(err) {
+			return Account{}, ErrNotFound
+		}
+		return Account{}, err
+	}
+	return accountFromRecord(u), nil
+}
+
+func (s *firebaseAccountStore) Delete(ctx context.Context, uid string) error {
+	err := s.client.DeleteUser(ctx, uid)
+	if auth.IsUserNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *firebaseAccountStore) UpdatePassword(ctx context.Context, uid, password string) error {
+	_, err := s.client.UpdateUser(ctx, uid, (&auth.UserToUpdate{}).Password(password))
+	if auth.IsUserNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *firebaseAccountStore) SetEmailVerified(ctx context.Context, uid string, verified bool) error {
+	_, err := s.client.UpdateUser(ctx, uid, (&auth.UserToUpdate{}).EmailVerified(verified))
+	if auth.IsUserNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func accountFromRecord(u *auth.UserRecord) Account {
+	a := Account{
+		UID:           u.UID,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+	}
+	if u.UserMetadata != nil {
+		a.CreatedAt = u.UserMetadata.CreationTimestamp / 1000
+	}
+	return a
+}
+
+type memoryAccount struct {
+	Account
+	password string
+}
+
+type memoryAccountStore struct {
+	mu       sync.Mutex
+	accounts map[string]*memoryAccount
+}
+
+func newMemoryAccountStore() *memoryAccountStore {
+	return &memoryAccountStore{accounts: make(map[string]*memoryAccount)}
+}
+
+func (s *memoryAccountStore) Create(ctx context.Context, email, password, displayName string) (Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.findByEmail(email) != nil {
+		return Account{}, ErrEmailExists
+	}
+	a := &memoryAccount{
+		Account:  Account{UID: uuid.New().String(), Email: email, CreatedAt: time.Now().Unix()},
+		password: password,
+	}
+	s.accounts[a.UID] = a
+	return a.Account, nil
+}
+
+func (s *memoryAccountStore) Get(ctx context.Context, uid string) (Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.accounts[uid]
+	if !ok {
+		return Account{}, ErrNotFound
+	}
+	return a.Account, nil
+}
+
+func (s *memoryAccountStore) GetByEmail(ctx context.Context, email string) (Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.findByEmail(email)
+	if a == nil {
+		return Account{}, ErrNotFound
+	}
+	return a.Account, nil
+}
+
+func (s *memoryAccountStore) Delete(ctx context.Context, uid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.accounts[uid]; !ok {
+		return ErrNotFound
+	}
+	delete(s.accounts, uid)
+	return nil
+}
+
+func (s *memoryAccountStore) UpdatePassword(ctx context.Context, uid, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.accounts[uid]
+	if !ok {
+		return ErrNotFound
+	}
+	a.password = password
+	return nil
+}
+
+func (s *memoryAccountStore) SetEmailVerified(ctx context.Context, uid string, verified bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.accounts[uid]
+	if !ok {
+		return ErrNotFound
+	}
+	a.EmailVerified = verified
+	return nil
+}
+
+func (s *memoryAccountStore) findByEmail(email string) *memoryAccount {
+	for _, a := range s.accounts {
+		if strings.EqualFold(a.Email, email) {
+			return a
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryAccountStore(t *testing.T) {
+	ctx := context.Background()
+	accounts := NewMemory().Accounts
+
+	a, err := accounts.Create(ctx, "Parent@Example.com", "secret-password", "parent")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if a.UID == "" || a.EmailVerified || a.CreatedAt == 0 {
+		t.Errorf("new account = %+v", a)
+	}
+	if _, err := accounts.Create(ctx, "parent@example.com", "other-password", "parent"); !errors.Is(err, ErrEmailExists) {
+		t.Errorf("Create with the same email in another case = %v, want ErrEmailExists", err)
+	}
+
+	if err := accounts.SetEmailVerified(ctx, a.UID, true); err != nil {
+		t.Fatalf("SetEmailVerified: %v", err)
+	}
+	if got, err := accounts.GetByEmail(ctx, "parent@example.com"); err != nil || !got.EmailVerified || got.UID != a.UID {
+		t.Errorf("GetByEmail after verification = %+v, %v", got, err)
+	}
+	if err := accounts.SetEmailVerified(ctx, "missing", true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetEmailVerified of a missing account = %v, want ErrNotFound", err)
+	}
+
+	if err := accounts.Delete(ctx, a.UID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := accounts.Get(ctx, a.UID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+
+	"firebase.google.com/go/db"
+)
+
+// AuditEntry records a sensitive action for later review.
+type AuditEntry struct {
+	Action    string            `json:"action"`
+	ActorUID  string            `json:"actor_uid"`
+	TargetUID string            `json:"target_uid"`
+	Details   map[string]string `json:"details,omitempty"`
+	CreatedAt int64             `json:"created_at"`
+}
+
+// AuditStore appends entries to the audit_log list.
+type AuditStore interface {
+	// Record appends an entry.
+	Record(ctx context.Context, entry AuditEntry) error
+}
+
+type firebaseAuditStore struct {
+	db *db.Client
+}
+
+func (s *firebaseAuditStore) Record(ctx context.Context, entry AuditEntry) error {
+	_, err := s.db.NewRef("audit_log").Push(ctx, entry)
+	return err
+}
+
+type memoryAuditStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryAuditStore) Record(ctx context.Context, entry AuditEntry) error {
+	_, err := s.tree.Push("audit_log", entry)
+	return err
+}
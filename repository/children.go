@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"backend/model"
+	"context"
+	"sort"
+
+	"firebase.google.com/go/db"
+)
+
+// ChildStore manages the child profiles stored under children/<uid>/<id>.
+type ChildStore interface {
+	// List returns every child of the given parent.
+	List(ctx context.Context, uid string) (map[string]model.Child, error)
+	// Get returns a single child of the given parent.
+	Get(ctx context.Context, uid, id string) (model.Child, error)
+	// Save writes the whole child, replacing any previous version.
+	Save(ctx context.Context, uid string, child model.Child) error
+	// Delete removes a single child of the given parent.
+	Delete(ctx context.Context, uid, id string) error
+	// DeleteAll removes every child of the given parent.
+	DeleteAll(ctx context.Context, uid string) error
+	// ListAfter returns the children of up to limit parents whose UIDs sort
+	// after afterUID, in UID order. An empty afterUID starts from the first.
+	ListAfter(ctx context.Context, afterUID string, limit int) ([]Family, error)
+}
+
+// Family is a parent with their children keyed by child ID.
+type Family struct {
+	UID      string
+	Children map[string]model.Child
+}
+
+type firebaseChildStore struct {
+	db *db.Client
+}
+
+func (s *firebaseChildStore) List(ctx context.Context, uid string) (map[string]model.Child, error) {
+	var children map[string]model.Child
+	if err := s.db.NewRef("children/"+uid).Get(ctx, &children); err != nil {
+		return nil, err
+	}
+	return children, nil
+}
+
+func (s *firebaseChildStore) Get(ctx context.Context, uid, id string) (model.Child, error) {
+	var child *model.Child
+	if err := s.db.NewRef("children/"+uid+"/"+id).Get(ctx, &child); err != nil {
+		return model.Child{}, err
+	}
+	if child == nil {
+		return model.Child{}, ErrNotFound
+	}
+	return *child, nil
+}
+
+func (s *firebaseChildStore) Save(ctx context.Context, uid string, child model.Child) error {
+	return s.db.NewRef("children/"+uid+"/"+child.ID).Set(ctx, child)
+}
+
+func (s *firebaseChildStore) Delete(ctx context.Context, uid, id string) error {
+	return s.db.NewRef("children/" + uid + "/" + id).Delete(ctx)
+}
+
+func (s *firebaseChildStore) DeleteAll(ctx context.Context, uid string) error {
+	return s.db.NewRef("children/" + uid).Delete(ctx)
+}
+
+func (s *firebaseChildStore) ListAfter(ctx context.Context, afterUID string, limit int) ([]Family, error) {
+	query := s.db.NewRef("children").OrderByKey()
+	if afterUID != "" {
+		// StartAt is inclusive, so fetch one extra parent to make up for afterUID
+		query = query.StartAt(afterUID)
+	}
+	var children map[string]map[string]model.Child
+	if err := query.LimitToFirst(limit+1).Get(ctx, &children); err != nil {
+		return nil, err
+	}
+	return familiesAfter(children, afterUID, limit), nil
+}
+
+type memoryChildStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryChildStore) List(ctx context.Context, uid string) (map[string]model.Child, error) {
+	var children map[string]model.Child
+	if err := s.tree.Get("children/"+uid, &children); err != nil {
+		return nil, err
+	}
+	return children, nil
+}
+
+func (s *memoryChildStore) Get(ctx context.Context, uid, id string) (model.Child, error) {
+	var child *model.Child
+	if err := s.tree.Get("children/"+uid+"/"+id, &child); err != nil {
+		return model.Child{}, err
+	}
+	if child == nil {
+		return model.Child{}, ErrNotFound
+	}
+	return *child, nil
+}
+
+func (s *memoryChildStore) Save(ctx context.Context, uid string, child model.Child) error {
+	return s.tree.Set("children/"+uid+"/"+child.ID, child)
+}
+
+func (s *memoryChildStore) Delete(ctx context.Context, uid, id string) error {
+	return s.tree.Delete("children/" + uid + "/" + id)
+}
+
+func (s *memoryChildStore) DeleteAll(ctx context.Context, uid string) error {
+	return s.tree.Delete("children/" + uid)
+}
+
+func (s *memoryChildStore) ListAfter(ctx context.Context, afterUID string, limit int) ([]Family, error) {
+	var children map[string]map[string]model.Child
+	if err := s.tree.Get("children", &children); err != nil {
+		return nil, err
+	}
+	return familiesAfter(children, afterUID, limit), nil
+}
+
+// familiesAfter returns up to limit families with UIDs greater than
+// afterUID, sorted by UID.
+func familiesAfter(children map[string]map[string]model.Child, afterUID string, limit int) []Family {
+	uids := make([]string, 0, len(children))
+	for uid := range children {
+		if uid > afterUID {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Strings(uids)
+	if len(uids) > limit {
+		uids = uids[:limit]
+	}
+
+	page := make([]Family, len(uids))
+	for i, uid := range uids {
+		page[i] = Family{UID: uid, Children: children[uid]}
+	}
+	return page
+}
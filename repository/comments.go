@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"backend/model"
+	"context"
+
+	"firebase.google.com/go/db"
+)
+
+// CommentStore manages the comments nested under posts/<id>/comments.
+type CommentStore interface {
+	// List returns every comment of the given post.
+	List(ctx context.Context, postID string) (map[string]model.Comment, error)
+	// Get returns a single comment of the given post.
+	Get(ctx context.Context, postID, commentID string) (model.Comment, error)
+	// Save writes the whole comment, replacing any previous version.
+	Save(ctx context.Context, postID string, comment model.Comment) error
+}
+
+type firebaseCommentStore struct {
+	db *db.Client
+}
+
+func (s *firebaseCommentStore) List(ctx context.Context, postID string) (map[string]model.Comment, error) {
+	var comments map[string]model.Comment
+	if err := s.db.NewRef("posts/"+postID+"/comments").Get(ctx, &comments); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+func (s *firebaseCommentStore) Get(ctx context.Context, postID, commentID string) (model.Comment, error) {
+	var comment *model.Comment
+	if err := s.db.NewRef("posts/"+postID+"/comments/"+commentID).Get(ctx, &comment); err != nil {
+		return model.Comment{}, err
+	}
+	if comment == nil {
+		return model.Comment{}, ErrNotFound
+	}
+	return *comment, nil
+}
+
+func (s *firebaseCommentStore) Save(ctx context.Context, postID string, comment model.Comment) error {
+	return s.db.NewRef("posts/"+postID+"/comments/"+comment.ID).Set(ctx, comment)
+}
+
+type memoryCommentStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryCommentStore) List(ctx context.Context, postID string) (map[string]model.Comment, error) {
+	var comments map[string]model.Comment
+	if err := s.tree.Get("posts/"+postID+"/comments", &comments); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+func (s *memoryCommentStore) Get(ctx context.Context, postID, commentID string) (model.Comment, error) {
+	var comment *model.Comment
+	if err := s.tree.Get("posts/"+postID+"/comments/"+commentID, &comment); err != nil {
+		return model.Comment{}, err
+	}
+	if comment == nil {
+		return model.Comment{}, ErrNotFound
+	}
+	return *comment, nil
+}
+
+func (s *memoryCommentStore) Save(ctx context.Context, postID string, comment model.Comment) error {
+	return s.tree.Set("posts/"+postID+"/comments/"+comment.ID, comment)
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"backend/model"
+	"context"
+
+	"firebase.google.com/go/db"
+)
+
+// ContestStore manages the current contest stored under contest.
+type ContestStore interface {
+	// Replace overwrites the current contest.
+	Replace(ctx context.Context, contest model.Contest) error
+	// Get returns the current contest.
+	Get(ctx context.Context) (model.Contest, error)
+}
+
+type firebaseContestStore struct {
+	db *db.Client
+}
+
+func (s *firebaseContestStore) Replace(ctx context.Context, contest model.Contest) error {
+	return s.db.NewRef("contest").Set(ctx, contest)
+}
+
+func (s *firebaseContestStore) Get(ctx context.Context) (model.Contest, error) {
+	var contest model.Contest
+	if err := s.db.NewRef("contest").Get(ctx, &contest); err != nil {
+		return model.Contest{}, err
+	}
+	return contest, nil
+}
+
+type memoryContestStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryContestStore) Replace(ctx context.Context, contest model.Contest) error {
+	return s.tree.Set("contest", contest)
+}
+
+func (s *memoryContestStore) Get(ctx context.Context) (model.Contest, error) {
+	var contest model.Contest
+	if err := s.tree.Get("contest", &contest); err != nil {
+		return model.Contest{}, err
+	}
+	return contest, nil
+}
@@ -0,0 +1,192 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"firebase.google.com/go/db"
+)
+
+// Data export states.
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	// ExportExpired marks an export whose archive was removed after the
+	// download link expired.
+	ExportExpired = "expired"
+)
+
+// DataExport is a user's request for a copy of their data. Each user has at
+// most one; a new request replaces the previous one.
+type DataExport struct {
+	UID         string `json:"uid"`
+	Status      string `json:"status"`
+	RequestedAt int64  `json:"requested_at"`
+	ReadyAt     int64  `json:"ready_at,omitempty"`
+	ExpiresAt   int64  `json:"expires_at,omitempty"`
+	// TokenHash is the hash of the download token emailed to the user.
+	TokenHash string `json:"token_hash,omitempty"`
+	Error     string `json:"error,omitempty"` // Last error; the export is retried
+}
+
+// DataExportStore manages the exports stored under data_exports/<uid> and
+// their archives under data_export_archives/<uid>.
+type DataExportStore interface {
+	// Request atomically starts a new export for uid. It returns ErrConflict
+	// when the previous one was requested after notBefore (Unix seconds).
+	Request(ctx context.Context, export DataExport, notBefore int64) error
+	// Get returns the export of uid.
+	Get(ctx context.Context, uid string) (DataExport, error)
+	// Update writes the given fields, leaving the others untouched.
+	Update(ctx context.Context, uid string, fields map[string]interface{}) error
+	// ListByStatus returns every export in the given state.
+	ListByStatus(ctx context.Context, status string) (map[string]DataExport, error)
+	// SaveArchive stores the finished archive of uid.
+	SaveArchive(ctx context.Context, uid, archive string) error
+	// Archive returns the stored archive of uid.
+	Archive(ctx context.Context, uid string) (string, error)
+	// DeleteArchive removes the stored archive of uid.
+	DeleteArchive(ctx context.Context, uid string) error
+	// Delete removes the export of uid together with its archive.
+	Delete(ctx context.Context, uid string) error
+}
+
+type firebaseDataExportStore struct {
+	db *db.Client
+}
+
+func (s *firebaseDataExportStore) Request(ctx context.Context, export DataExport, notBefore int64) error {
+	return s.db.NewRef("data_exports/"+export.UID).Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		var current *DataExport
+		if err := node.Unmarshal(&current); err != nil {
+			return nil, err
+		}
+		return requestExport(current, export, notBefore)
+	})
+}
+
+func (s *firebaseDataExportStore) Get(ctx context.Context, uid string) (DataExport, error) {
+	var export *DataExport
+	if err := s.db.NewRef("data_exports/"+uid).Get(ctx, &export); err != nil {
+		return DataExport{}, err
+	}
+	if export == nil {
+		return DataExport{}, ErrNotFound
+	}
+	return *export, nil
+}
+
+func (s *firebaseDataExportStore) Update(ctx context.Context, uid string, fields map[string]interface{}) error {
+	return s.db.NewRef("data_exports/"+uid).Update(ctx, fields)
+}
+
+func (s *firebaseDataExportStore) ListByStatus(ctx context.Context, status string) (map[string]DataExport, error) {
+	var exports map[string]DataExport
+	if err := s.db.NewRef("data_exports").OrderByChild("status").EqualTo(status).Get(ctx, &exports); err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+func (s *firebaseDataExportStore) SaveArchive(ctx context.Context, uid, archive string) error {
+	return s.db.NewRef("data_export_archives/"+uid).Set(ctx, archive)
+}
+
+func (s *firebaseDataExportStore) Archive(ctx context.Context, uid string) (string, error) {
+	var archive string
+	if err := s.db.NewRef("data_export_archives/"+uid).Get(ctx, &archive); err != nil {
+		return "", err
+	}
+	if archive == "" {
+		return "", ErrNotFound
+	}
+	return archive, nil
+}
+
+func (s *firebaseDataExportStore) DeleteArchive(ctx context.Context, uid string) error {
+	return s.db.NewRef("data_export_archives/" + uid).Delete(ctx)
+}
+
+func (s *firebaseDataExportStore) Delete(ctx context.Context, uid string) error {
+	return s.db.NewRef("/").Update(ctx, map[string]interface{}{
+		"data_exports/" + uid:         nil,
+		"data_export_archives/" + uid: nil,
+	})
+}
+
+type memoryDataExportStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryDataExportStore) Request(ctx context.Context, export DataExport, notBefore int64) error {
+	return s.tree.Transaction("data_exports/"+export.UID, func(raw json.RawMessage) (interface{}, error) {
+		var current *DataExport
+		if err := json.Unmarshal(raw, &current); err != nil {
+			return nil, err
+		}
+		return requestExport(current, export, notBefore)
+	})
+}
+
+func (s *memoryDataExportStore) Get(ctx context.Context, uid string) (DataExport, error) {
+	var export *DataExport
+	if err := s.tree.Get("data_exports/"+uid, &export); err != nil {
+		return DataExport{}, err
+	}
+	if export == nil {
+		return DataExport{}, ErrNotFound
+	}
+	return *export, nil
+}
+
+func (s *memoryDataExportStore) Update(ctx context.Context, uid string, fields map[string]interface{}) error {
+	return s.tree.Update("data_exports/"+uid, fields)
+}
+
+func (s *memoryDataExportStore) ListByStatus(ctx context.Context, status string) (map[string]DataExport, error) {
+	var exports map[string]DataExport
+	if err := s.tree.Get("data_exports", &exports); err != nil {
+		return nil, err
+	}
+	matching := make(map[string]DataExport)
+	for uid, export := range exports {
+		if export.Status == status {
+			matching[uid] = export
+		}
+	}
+	return matching, nil
+}
+
+func (s *memoryDataExportStore) SaveArchive(ctx context.Context, uid, archive string) error {
+	return s.tree.Set("data_export_archives/"+uid, archive)
+}
+
+func (s *memoryDataExportStore) Archive(ctx context.Context, uid string) (string, error) {
+	var archive string
+	if err := s.tree.Get("data_export_archives/"+uid, &archive); err != nil {
+		return "", err
+	}
+	if archive == "" {
+		return "", ErrNotFound
+	}
+	return archive, nil
+}
+
+func (s *memoryDataExportStore) DeleteArchive(ctx context.Context, uid string) error {
+	return s.tree.Delete("data_export_archives/" + uid)
+}
+
+func (s *memoryDataExportStore) Delete(ctx context.Context, uid string) error {
+	return s.tree.Update("/", map[string]interface{}{
+		"data_exports/" + uid:         nil,
+		"data_export_archives/" + uid: nil,
+	})
+}
+
+// requestExport is the transaction body shared by both Request implementations.
+func requestExport(current *DataExport, export DataExport, notBefore int64) (interface{}, error) {
+	if current != nil && current.RequestedAt > notBefore {
+		return nil, ErrConflict
+	}
+	return export, nil
+}
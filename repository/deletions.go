@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"firebase.google.com/go/db"
+)
+
+// Account deletion modes.
+const (
+	// DeletionAnonymize keeps the user's posts and comments under
+	// DeletedUsername.
+	DeletionAnonymize = "anonymize"
+	// DeletionPurge removes the user's posts and comments.
+	DeletionPurge = "delete"
+)
+
+// Account deletion states.
+const (
+	DeletionScheduled = "scheduled"
+	DeletionDone      = "done"
+)
+
+// DeletedUsername replaces the author of anonymized content. It is not a
+// valid username, so nobody can claim it.
+const DeletedUsername = "deleted user"
+
+// AccountDeletion is a requested account deletion. It runs once ExecuteAt has
+// passed and can be cancelled until then. Cursor records the last post
+// processed so an interrupted deletion resumes where it stopped.
+type AccountDeletion struct {
+	UID         string `json:"uid"`
+	Username    string `json:"username"`
+	RequestedBy string `json:"requested_by"`
+	Mode        string `json:"mode"`
+	Status      string `json:"status"`
+	ExecuteAt   int64  `json:"execute_at"`
+	Cursor      string `json:"cursor"`
+	Error       string `json:"error,omitempty"` // Last error; the deletion is retried
+	CreatedAt   int64  `json:"created_at"`
+	UpdatedAt   int64  `json:"updated_at"`
+}
+
+// DeletionStore manages the deletions stored under account_deletions/<uid>.
+type DeletionStore interface {
+	// Create stores a new deletion. It returns ErrConflict when the user
+	// already has one.
+	Create(ctx context.Context, d AccountDeletion) error
+	// Get returns the deletion of uid.
+	Get(ctx context.Context, uid string) (AccountDeletion, error)
+	// Update writes the given fields, leaving the others untouched.
+	Update(ctx context.Context, uid string, fields map[string]interface{}) error
+	// Delete removes the deletion of uid, cancelling it.
+	Delete(ctx context.Context, uid string) error
+	// ListScheduled returns every deletion that has not run yet.
+	ListScheduled(ctx context.Context) (map[string]AccountDeletion, error)
+}
+
+type firebaseDeletionStore struct {
+	db *db.Client
+}
+
+func (s *firebaseDeletionStore) Create(ctx context.Context, d AccountDeletion) error {
+	return s.db.NewRef("account_deletions/"+d.UID).Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		var current *AccountDeletion
+		if err := node.Unmarshal(&current); err != nil {
+			return nil, err
+		}
+		return createDeletion(current, d)
+	})
+}
+
+func (s *firebaseDeletionStore) Get(ctx context.Context, uid string) (AccountDeletion, error) {
+	var d *AccountDeletion
+	if err := s.db.NewRef("account_deletions/"+uid).Get(ctx, &d); err != nil {
+		return AccountDeletion{}, err
+	}
+	if d == nil {
+		return AccountDeletion{}, ErrNotFound
+	}
+	return *d, nil
+}
+
+func (s *firebaseDeletionStore) Update(ctx context.Context, uid string, fields map[string]interface{}) error {
+	return s.db.NewRef("account_deletions/"+uid).Update(ctx, fields)
+}
+
+func (s *firebaseDeletionStore) Delete(ctx context.Context, uid string) error {
+	return s.db.NewRef("account_deletions/" + uid).Delete(ctx)
+}
+
+func (s *firebaseDeletionStore) ListScheduled(ctx context.Context) (map[string]AccountDeletion, error) {
+	var deletions map[string]AccountDeletion
+	if err := s.db.NewRef("account_deletions").OrderByChild("status").EqualTo(DeletionScheduled).Get(ctx, &deletions); err != nil {
+		return nil, err
+	}
+	return deletions, nil
+}
+
+type memoryDeletionStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryDeletionStore) Create(ctx context.Context, d AccountDeletion) error {
+	return s.tree.Transaction("account_deletions/"+d.UID, func(raw json.RawMessage) (interface{}, error) {
+		var current *AccountDeletion
+		if err := json.Unmarshal(raw, &current); err != nil {
+			return nil, err
+		}
+		return createDeletion(current, d)
+	})
+}
+
+func (s *memoryDeletionStore) Get(ctx context.Context, uid string) (AccountDeletion, error) {
+	var d *AccountDeletion
+	if err := s.tree.Get("account_deletions/"+uid, &d); err != nil {
+		return AccountDeletion{}, err
+	}
+	if d == nil {
+		return AccountDeletion{}, ErrNotFound
+	}
+	return *d, nil
+}
+
+func (s *memoryDeletionStore) Update(ctx context.Context, uid string, fields map[string]interface{}) error {
+	return s.tree.Update("account_deletions/"+uid, fields)
+}
+
+func (s *memoryDeletionStore) Delete(ctx context.Context, uid string) error {
+	return s.tree.Delete("account_deletions/" + uid)
+}
+
+func (s *memoryDeletionStore) ListScheduled(ctx context.Context) (map[string]AccountDeletion, error) {
+	var deletions map[string]AccountDeletion
+	if err := s.tree.Get("account_deletions", &deletions); err != nil {
+		return nil, err
+	}
+	scheduled := make(map[string]AccountDeletion)
+	for uid, d := range deletions {
+		if d.Status == DeletionScheduled {
+			scheduled[uid] = d
+		}
+	}
+	return scheduled, nil
+}
+
+// createDeletion is the transaction body shared by both Create implementations.
+func createDeletion(current *AccountDeletion, d AccountDeletion) (interface{}, error) {
+	if current != nil && current.Status == DeletionScheduled {
+		return nil, ErrConflict
+	}
+	return d, nil
+}
@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"backend/utils"
+	"context"
+	"sort"
+
+	"firebase.google.com/go/db"
+)
+
+// Device is an FCM registration token of a signed-in app install, stored
+// under devices/<uid>/<token hash>. device_owners/<token hash> maps each
+// token back to its user, so a token belongs to one user at a time.
+type Device struct {
+	Token     string `json:"token"`
+	Platform  string `json:"platform,omitempty"` // 'android', 'ios' or 'web'
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// DeviceStore manages the push tokens of each user.
+type DeviceStore interface {
+	// Register stores the token for uid, taking it over from any other user
+	// who registered it on the same install before.
+	Register(ctx context.Context, uid string, d Device) error
+	// Unregister removes a token of uid. It returns ErrNotFound when uid does
+	// not hold the token.
+	Unregister(ctx context.Context, uid, token string) error
+	// List returns the devices of uid keyed by token hash.
+	List(ctx context.Context, uid string) (map[string]Device, error)
+	// Remove deletes a token FCM rejected, whoever holds it.
+	Remove(ctx context.Context, token string) error
+	// DeleteAll removes every device of uid.
+	DeleteAll(ctx context.Context, uid string) error
+	// UsersAfter returns up to limit UIDs of users with devices that sort
+	// after afterUID, in order. An empty afterUID starts from the first.
+	UsersAfter(ctx context.Context, afterUID string, limit int) ([]string, error)
+}
+
+// registerFields is the root update shared by both Register implementations.
+func registerFields(owner, uid string, d Device) map[string]interface{} {
+	hash := utils.HashToken(d.Token)
+	fields := map[string]interface{}{
+		"devices/" + uid + "/" + hash: d,
+		"device_owners/" + hash:       uid,
+	}
+	if owner != "" && owner != uid {
+		fields["devices/"+owner+"/"+hash] = nil
+	}
+	return fields
+}
+
+// removeFields is the root update that drops the token with the given hash
+// from its owner.
+func removeFields(owner, hash string) map[string]interface{} {
+	return map[string]interface{}{
+		"devices/" + owner + "/" + hash: nil,
+		"device_owners/" + hash:         nil,
+	}
+}
+
+// usersAfter returns up to limit UIDs of devices greater than afterUID,
+// sorted.
+func usersAfter(devices map[string]map[string]Device, afterUID string, limit int) []string {
+	uids := make([]string, 0, len(devices))
+	for uid := range devices {
+		if uid > afterUID {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Strings(uids)
+	if len(uids) > limit {
+		uids = uids[:limit]
+	}
+	return uids
+}
+
+type firebaseDeviceStore struct {
+	db *db.Client
+}
+
+func (s *firebaseDeviceStore) owner(ctx context.Context, hash string) (string, error) {
+	var owner string
+	err := s.db.NewRef("device_owners/"+hash).Get(ctx, &owner)
+	return owner, err
+}
+
+func (s *firebaseDeviceStore) Register(ctx context.Context, uid string, d Device) error {
+	owner, err := s.owner(ctx, utils.HashToken(d.Token))
+	if err != nil {
+		return err
+	}
+	return s.db.NewRef("/").Update(ctx, registerFields(owner, uid, d))
+}
+
+func (s *firebaseDeviceStore) Unregister(ctx context.Context, uid, token string) error {
+	hash := utils.HashToken(token)
+	owner, err := s.owner(ctx, hash)
+	if err != nil {
+		return err
+	}
+	if owner != uid {
+		return ErrNotFound
+	}
+	return s.db.NewRef("/").Update(ctx, removeFields(owner, hash))
+}
+
+func (s *firebaseDeviceStore) List(ctx context.Context, uid string) (map[string]Device, error) {
+	var devices map[string]Device
+	if err := s.db.NewRef("devices/"+uid).Get(ctx, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+func (s *firebaseDeviceStore) Remove(ctx context.Context, token string) error {
+	hash := utils.HashToken(token)
+	owner, err := s.owner(ctx, hash)
+	if err != nil || owner == "" {
+		return err
+	}
+	return s.db.NewRef("/").Update(ctx, removeFields(owner, hash))
+}
+
+func (s *firebaseDeviceStore) DeleteAll(ctx context.Context, uid string) error {
+	devices, err := s.List(ctx, uid)
+	if err != nil {
+		return err
+	}
+	fields := map[string]interface{}{"devices/" + uid: nil}
+	for hash := range devices {
+		fields["device_owners/"+hash] = nil
+	}
+	return s.db.NewRef("/").Update(ctx, fields)
+}
+
+func (s *firebaseDeviceStore) UsersAfter(ctx context.Context, afterUID string, limit int) ([]string, error) {
+	query := s.db.NewRef("devices").OrderByKey()
+	if afterUID != "" {
+		// StartAt is inclusive, so fetch one extra user to make up for afterUID
+		query = query.StartAt(afterUID)
+	}
+	var devices map[string]map[string]Device
+	if err := query.LimitToFirst(limit+1).Get(ctx, &devices); err != nil {
+		return nil, err
+	}
+	return usersAfter(devices, afterUID, limit), nil
+}
+
+type memoryDeviceStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryDeviceStore) owner(hash string) (string, error) {
+	var owner string
+	err := s.tree.Get("device_owners/"+hash, &owner)
+	return owner, err
+}
+
+func (s *memoryDeviceStore) Register(ctx context.Context, uid string, d Device) error {
+	owner, err := s.owner(utils.HashToken(d.Token))
+	if err != nil {
+		return err
+	}
+	return s.tree.Update("/", registerFields(owner, uid, d))
+}
+
+func (s *memoryDeviceStore) Unregister(ctx context.Context, uid, token string) error {
+	hash := utils.HashToken(token)
+	owner, err := s.owner(hash)
+	if err != nil {
+		return err
+	}
+	if owner != uid {
+		return ErrNotFound
+	}
+	return s.tree.Update("/", removeFields(owner, hash))
+}
+
+func (s *memoryDeviceStore) List(ctx context.Context, uid string) (map[string]Device, error) {
+	var devices map[string]Device
+	if err := s.tree.Get("devices/"+uid, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+func (s *memoryDeviceStore) Remove(ctx context.Context, token string) error {
+	hash := utils.HashToken(token)
+	owner, err := s.owner(hash)
+	if err != nil || owner == "" {
+		return err
+	}
+	return s.tree.Update("/", removeFields(owner, hash))
+}
+
+func (s *memoryDeviceStore) DeleteAll(ctx context.Context, uid string) error {
+	devices, err := s.List(ctx, uid)
+	if err != nil {
+		return err
+	}
+	fields := map[string]interface{}{"devices/" + uid: nil}
+	for hash := range devices {
+		fields["device_owners/"+hash] = nil
+	}
+	return s.tree.Update("/", fields)
+}
+
+func (s *memoryDeviceStore) UsersAfter(ctx context.Context, afterUID string, limit int) ([]string, error) {
+	var devices map[string]map[string]Device
+	if err := s.tree.Get("devices", &devices); err != nil {
+		return nil, err
+	}
+	return usersAfter(devices, afterUID, limit), nil
+}
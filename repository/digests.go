@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"firebase.google.com/go/db"
+)
+
+// DigestStore records the weekly digests sent to each user under
+// digests/<uid>/<week>, where week is the date the digest went out for.
+type DigestStore interface {
+	// Claim records that the digest of week is being sent to uid. It returns
+	// ErrConflict when it was claimed before.
+	Claim(ctx context.Context, uid, week string, at int64) error
+	// Release withdraws a claim after a failed send so it can be retried.
+	Release(ctx context.Context, uid, week string) error
+	// DeleteAll removes the digest history of uid.
+	DeleteAll(ctx context.Context, uid string) error
+}
+
+// claimDigest is the transaction body shared by both Claim implementations.
+func claimDigest(claimed int64, at int64) (interface{}, error) {
+	if claimed != 0 {
+		return nil, ErrConflict
+	}
+	return at, nil
+}
+
+type firebaseDigestStore struct {
+	db *db.Client
+}
+
+func (s *firebaseDigestStore) Claim(ctx context.Context, uid, week string, at int64) error {
+	return s.db.NewRef("digests/"+uid+"/"+week).Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		var claimed int64
+		if err := node.Unmarshal(&claimed); err != nil {
+			return nil, err
+		}
+		return claimDigest(claimed, at)
+	})
+}
+
+func (s *firebaseDigestStore) Release(ctx context.Context, uid, week string) error {
+	return s.db.NewRef("digests/" + uid + "/" + week).Delete(ctx)
+}
+
+func (s *firebaseDigestStore) DeleteAll(ctx context.Context, uid string) error {
+	return s.db.NewRef("digests/" + uid).Delete(ctx)
+}
+
+type memoryDigestStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryDigestStore) Claim(ctx context.Context, uid, week string, at int64) error {
+	return s.tree.Transaction("digests/"+uid+"/"+week, func(current json.RawMessage) (interface{}, error) {
+		var claimed int64
+		if err := json.Unmarshal(current, &claimed); err != nil {
+			return nil, err
+		}
+		return claimDigest(claimed, at)
+	})
+}
+
+func (s *memoryDigestStore) Release(ctx context.Context, uid, week string) error {
+	return s.tree.Delete("digests/" + uid + "/" + week)
+}
+
+func (s *memoryDigestStore) DeleteAll(ctx context.Context, uid string) error {
+	return s.tree.Delete("digests/" + uid)
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"backend/model"
+	"context"
+
+	"firebase.google.com/go/db"
+)
+
+// GrowthStore manages the growth measurements of each child under
+// growth/<uid>/<child id>/<id>.
+type GrowthStore interface {
+	// List returns every measurement of a child keyed by ID.
+	List(ctx context.Context, uid, childID string) (map[string]model.Measurement, error)
+	// Save writes a measurement, replacing any previous version.
+	Save(ctx context.Context, uid, childID string, m model.Measurement) error
+	// Delete removes a single measurement.
+	Delete(ctx context.Context, uid, childID, id string) error
+	// DeleteChild removes every measurement of a child.
+	DeleteChild(ctx context.Context, uid, childID string) error
+	// DeleteAll removes the measurements of every child of the given parent.
+	DeleteAll(ctx context.Context, uid string) error
+}
+
+func growthPath(uid, childID string) string {
+	return "growth/" + uid + "/" + childID
+}
+
+type firebaseGrowthStore struct {
+	db *db.Client
+}
+
+func (s *firebaseGrowthStore) List(ctx context.Context, uid, childID string) (map[string]model.Measurement, error) {
+	var measurements map[string]model.Measurement
+	if err := s.db.NewRef(growthPath(uid, childID)).Get(ctx, &measurements); err != nil {
+		return nil, err
+	}
+	return measurements, nil
+}
+
+func (s *firebaseGrowthStore) Save(ctx context.Context, uid, childID string, m model.Measurement) error {
+	return s.db.NewRef(growthPath(uid, childID)+"/"+m.ID).Set(ctx, m)
+}
+
+func (s *firebaseGrowthStore) Delete(ctx context.Context, uid, childID, id string) error {
+	return s.db.NewRef(growthPath(uid, childID) + "/" + id).Delete(ctx)
+}
+
+func (s *firebaseGrowthStore) DeleteChild(ctx context.Context, uid, childID string) error {
+	return s.db.NewRef(growthPath(uid, childID)).Delete(ctx)
+}
+
+func (s *firebaseGrowthStore) DeleteAll(ctx context.Context, uid string) error {
+	return s.db.NewRef("growth/" + uid).Delete(ctx)
+}
+
+type memoryGrowthStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryGrowthStore) List(ctx context.Context, uid, childID string) (map[string]model.Measurement, error) {
+	var measurements map[string]model.Measurement
+	if err := s.tree.Get(growthPath(uid, childID), &measurements); err != nil {
+		return nil, err
+	}
+	return measurements, nil
+}
+
+func (s *memoryGrowthStore) Save(ctx context.Context, uid, childID string, m model.Measurement) error {
+	return s.tree.Set(growthPath(uid, childID)+"/"+m.ID, m)
+}
+
+func (s *memoryGrowthStore) Delete(ctx context.Context, uid, childID, id string) error {
+	return s.tree.Delete(growthPath(uid, childID) + "/" + id)
+}
+
+func (s *memoryGrowthStore) DeleteChild(ctx context.Context, uid, childID string) error {
+	return s.tree.Delete(growthPath(uid, childID))
+}
+
+func (s *memoryGrowthStore) DeleteAll(ctx context.Context, uid string) error {
+	return s.tree.Delete("growth/" + uid)
+}
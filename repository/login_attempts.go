@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"firebase.google.com/go/db"
+)
+
+// LoginAttempts counts recent failed logins for one key (an email or an IP).
+// Records stop counting once ExpiresAt has passed.
+type LoginAttempts struct {
+	Failures    int   `json:"failures"`
+	LastFailure int64 `json:"last_failure"`
+	NextAllowed int64 `json:"next_allowed"`
+	LockedUntil int64 `json:"locked_until"`
+	ExpiresAt   int64 `json:"expires_at"`
+}
+
+// LoginAttemptStore manages the counters stored under login_attempts/<key>.
+// Keys must be valid database keys, so callers pass hashes.
+type LoginAttemptStore interface {
+	// Get returns the counters for key, or the zero value when there are
+	// none or they expired before now.
+	Get(ctx context.Context, key string, now int64) (LoginAttempts, error)
+	// Update atomically replaces the counters for key with fn's result. fn
+	// receives the zero value when the counters are missing or expired.
+	Update(ctx context.Context, key string, now int64, fn func(LoginAttempts) LoginAttempts) (LoginAttempts, error)
+	// Delete clears the counters for key.
+	Delete(ctx context.Context, key string) error
+}
+
+type firebaseLoginAttemptStore struct {
+	db *db.Client
+}
+
+func (s *firebaseLoginAttemptStore) Get(ctx context.Context, key string, now int64) (LoginAttempts, error) {
+	var attempts *LoginAttempts
+	if err := s.db.NewRef("login_attempts/"+key).Get(ctx, &attempts); err != nil {
+		return LoginAttempts{}, err
+	}
+	return liveAttempts(attempts, now), nil
+}
+
+func (s *firebaseLoginAttemptStore) Update(ctx context.Context, key string, now int64, fn func(LoginAttempts) LoginAttempts) (LoginAttempts, error) {
+	var next LoginAttempts
+	err := s.db.NewRef("login_attempts/"+key).Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		var attempts *LoginAttempts
+		if err := node.Unmarshal(&attempts); err != nil {
+			return nil, err
+		}
+		next = fn(liveAttempts(attempts, now))
+		return next, nil
+	})
+	return next, err
+}
+
+func (s *firebaseLoginAttemptStore) Delete(ctx context.Context, key string) error {
+	return s.db.NewRef("login_attempts/" + key).Delete(ctx)
+}
+
+type memoryLoginAttemptStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryLoginAttemptStore) Get(ctx context.Context, key string, now int64) (LoginAttempts, error) {
+	var attempts *LoginAttempts
+	if err := s.tree.Get("login_attempts/"+key, &attempts); err != nil {
+		return LoginAttempts{}, err
+	}
+	return liveAttempts(attempts, now), nil
+}
+
+func (s *memoryLoginAttemptStore) Update(ctx context.Context, key string, now int64, fn func(LoginAttempts) LoginAttempts) (LoginAttempts, error) {
+	var next LoginAttempts
+	err := s.tree.Transaction("login_attempts/"+key, func(current json.RawMessage) (interface{}, error) {
+		var attempts *LoginAttempts
+		if err := json.Unmarshal(current, &attempts); err != nil {
+			return nil, err
+		}
+		next = fn(liveAttempts(attempts, now))
+		return next, nil
+	})
+	return next, err
+}
+
+func (s *memoryLoginAttemptStore) Delete(ctx context.Context, key string) error {
+	return s.tree.Delete("login_attempts/" + key)
+}
+
+// liveAttempts drops counters whose TTL has passed.
+func liveAttempts(attempts *LoginAttempts, now int64) LoginAttempts {
+	if attempts == nil || now >= attempts.ExpiresAt {
+		return LoginAttempts{}
+	}
+	return *attempts
+}
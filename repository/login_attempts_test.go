@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryLoginAttemptStore(t *testing.T) {
+	ctx := context.Background()
+	attempts := NewMemory().Attempts
+	fail := func(a LoginAttempts) LoginAttempts {
+		a.Failures++
+		a.ExpiresAt = 200
+		return a
+	}
+
+	for i := 1; i <= 2; i++ {
+		got, err := attempts.Update(ctx, "key", 100, fail)
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if got.Failures != i {
+			t.Errorf("Update %d returned %d failures", i, got.Failures)
+		}
+	}
+	if got, err := attempts.Get(ctx, "key", 199); err != nil || got.Failures != 2 {
+		t.Errorf("Get before expiry = %+v, %v", got, err)
+	}
+
+	// Expired counters read and update as if they were missing
+	if got, err := attempts.Get(ctx, "key", 200); err != nil || got != (LoginAttempts{}) {
+		t.Errorf("Get at expiry = %+v, %v", got, err)
+	}
+	if got, err := attempts.Update(ctx, "key", 250, fail); err != nil || got.Failures != 1 {
+		t.Errorf("Update after expiry = %+v, %v", got, err)
+	}
+
+	if err := attempts.Delete(ctx, "key"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got, err := attempts.Get(ctx, "key", 100); err != nil || got != (LoginAttempts{}) {
+		t.Errorf("Get after Delete = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryTree is an in-process JSON tree that mimics the Realtime Database
+// data model: values are addressed by slash separated paths, writing null or
+// an empty object removes the node, and reads of missing paths yield null.
+type memoryTree struct {
+	mu   sync.Mutex
+	root map[string]interface{}
+	seq  int64
+}
+
+func newMemoryTree() *memoryTree {
+	return &memoryTree{root: make(map[string]interface{})}
+}
+
+// Get decodes the value stored at path into v.
+func (t *memoryTree) Get(path string, v interface{}) error {
+	t.mu.Lock()
+	data, err := json.Marshal(t.lookup(splitPath(path)))
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Set replaces the value stored at path.
+func (t *memoryTree) Set(path string, v interface{}) error {
+	value, err := normalize(v)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.put(splitPath(path), value)
+	return nil
+}
+
+// Update writes every key of fields relative to path in a single step. Keys
+// may themselves contain slashes to address deeper children.
+func (t *memoryTree) Update(path string, fields map[string]interface{}) error {
+	values := make(map[string]interface{}, len(fields))
+	for key, v := range fields {
+		value, err := normalize(v)
+		if err != nil {
+			return err
+		}
+		values[key] = value
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	base := splitPath(path)
+	for key, value := range values {
+		t.put(append(append([]string{}, base...), splitPath(key)...), value)
+	}
+	return nil
+}
+
+// Delete removes the node at path.
+func (t *memoryTree) Delete(path string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.put(splitPath(path), nil)
+	return nil
+}
+
+// Push stores v under a new chronologically ordered child key of path.
+func (t *memoryTree) Push(path string, v interface{}) (string, error) {
+	value, err := normalize(v)
+	if err != nil {
+		return "", err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	key := fmt.Sprintf("-%016x%06x", time.Now().UnixNano(), t.seq)
+	t.put(append(splitPath(path), key), value)
+	return key, nil
+}
+
+// Transaction atomically replaces the value at path with the result of fn.
+// fn receives the current value encoded as JSON; returning an error aborts
+// the transaction without writing.
+func (t *memoryTree) Transaction(path string, fn func(current json.RawMessage) (interface{}, error)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := splitPath(path)
+	data, err := json.Marshal(t.lookup(parts))
+	if err != nil {
+		return err
+	}
+	next, err := fn(data)
+	if err != nil {
+		return err
+	}
+	value, err := normalize(next)
+	if err != nil {
+		return err
+	}
+	t.put(parts, value)
+	return nil
+}
+
+func (t *memoryTree) lookup(parts []string) interface{} {
+	var node interface{} = t.root
+	for _, part := range parts {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		node = m[part]
+	}
+	return node
+}
+
+// put stores value at parts, creating intermediate nodes and pruning any
+// parents left empty by a removal.
+func (t *memoryTree) put(parts []string, value interface{}) {
+	if len(parts) == 0 {
+		if m, ok := value.(map[string]interface{}); ok {
+			t.root = m
+		} else {
+			t.root = make(map[string]interface{})
+		}
+		return
+	}
+	parents := make([]map[string]interface{}, 0, len(parts))
+	node := t.root
+	for _, part := range parts[:len(parts)-1] {
+		parents = append(parents, node)
+		child, ok := node[part].(map[string]interface{})
+		if !ok {
+			if value == nil {
+				return
+			}
+			child = make(map[string]interface{})
+			node[part] = child
+		}
+		node = child
+	}
+	last := parts[len(parts)-1]
+	if value == nil {
+		delete(node, last)
+	} else {
+		node[last] = value
+	}
+	for i := len(parents) - 1; i >= 0 && len(node) == 0; i-- {
+		delete(parents[i], parts[i])
+		node = parents[i]
+	}
+}
+
+// normalize converts v into the generic JSON representation stored in the
+// tree, dropping nulls and empty objects the same way the database does.
+func normalize(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return prune(value), nil
+}
+
+func prune(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if pruned := prune(child); pruned == nil {
+				delete(value, key)
+			} else {
+				value[key] = pruned
+			}
+		}
+		if len(value) == 0 {
+			return nil
+		}
+		return value
+	case []interface{}:
+		if len(value) == 0 {
+			return nil
+		}
+		return value
+	default:
+		return value
+	}
+}
+
+func splitPath(path string) []string {
+	var parts []string
+	for _, part := range strings.Split(path, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
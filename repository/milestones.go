@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"backend/model"
+	"context"
+
+	"firebase.google.com/go/db"
+)
+
+// MilestoneStore manages the milestone catalogue under milestones/<id> and the
+// milestones reached by each child under
+// milestone_achievements/<uid>/<child id>/<milestone id>.
+type MilestoneStore interface {
+	// Catalogue returns every milestone keyed by ID.
+	Catalogue(ctx context.Context) (map[string]model.Milestone, error)
+	// Seed replaces the whole catalogue.
+	Seed(ctx context.Context, milestones []model.Milestone) error
+	// Achievements returns the milestones reached by a child keyed by milestone ID.
+	Achievements(ctx context.Context, uid, childID string) (map[string]model.Achievement, error)
+	// Achieve records that a child reached a milestone.
+	Achieve(ctx context.Context, uid, childID, milestoneID string, a model.Achievement) error
+	// Unachieve removes a milestone recorded by mistake.
+	Unachieve(ctx context.Context, uid, childID, milestoneID string) error
+	// DeleteChild removes every achievement of a child.
+	DeleteChild(ctx context.Context, uid, childID string) error
+	// DeleteAll removes the achievements of every child of the given parent.
+	DeleteAll(ctx context.Context, uid string) error
+}
+
+func achievementPath(uid, childID string) string {
+	return "milestone_achievements/" + uid + "/" + childID
+}
+
+// catalogue keys milestones by ID for storage.
+func catalogue(milestones []model.Milestone) map[string]model.Milestone {
+	byID := make(map[string]model.Milestone, len(milestones))
+	for _, m := range milestones {
+		byID[m.ID] = m
+	}
+	return byID
+}
+
+type firebaseMilestoneStore struct {
+	db *db.Client
+}
+
+func (s *firebaseMilestoneStore) Catalogue(ctx context.Context) (map[string]model.Milestone, error) {
+	var milestones map[string]model.Milestone
+	if err := s.db.NewRef("milestones").Get(ctx, &milestones); err != nil {
+		return nil, err
+	}
+	return milestones, nil
+}
+
+func (s *firebaseMilestoneStore) Seed(ctx context.Context, milestones []model.Milestone) error {
+	return s.db.NewRef("milestones").Set(ctx, catalogue(milestones))
+}
+
+func (s *firebaseMilestoneStore) Achievements(ctx context.Context, uid, childID string) (map[string]model.Achievement, error) {
+	var achievements map[string]model.Achievement
+	if err := s.db.NewRef(achievementPath(uid, childID)).Get(ctx, &achievements); err != nil {
+		return nil, err
+	}
+	return achievements, nil
+}
+
+func (s *firebaseMilestoneStore) Achieve(ctx context.Context, uid, childID, milestoneID string, a model.Achievement) error {
+	return s.db.NewRef(achievementPath(uid, childID)+"/"+milestoneID).Set(ctx, a)
+}
+
+func (s *firebaseMilestoneStore) Unachieve(ctx context.Context, uid, childID, milestoneID string) error {
+	return s.db.NewRef(achievementPath(uid, childID) + "/" + milestoneID).Delete(ctx)
+}
+
+func (s *firebaseMilestoneStore) DeleteChild(ctx context.Context, uid, childID string) error {
+	return s.db.NewRef(achievementPath(uid, childID)).Delete(ctx)
+}
+
+func (s *firebaseMilestoneStore) DeleteAll(ctx context.Context, uid string) error {
+	return s.db.NewRef("milestone_achievements/" + uid).Delete(ctx)
+}
+
+type memoryMilestoneStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryMilestoneStore) Catalogue(ctx context.Context) (map[string]model.Milestone, error) {
+	var milestones map[string]model.Milestone
+	if err := s.tree.Get("milestones", &milestones); err != nil {
+		return nil, err
+	}
+	return milestones, nil
+}
+
+func (s *memoryMilestoneStore) Seed(ctx context.Context, milestones []model.Milestone) error {
+	return s.tree.Set("milestones", catalogue(milestones))
+}
+
+func (s *memoryMilestoneStore) Achievements(ctx context.Context, uid, childID string) (map[string]model.Achievement, error) {
+	var achievements map[string]model.Achievement
+	if err := s.tree.Get(achievementPath(uid, childID), &achievements); err != nil {
+		return nil, err
+	}
+	return achievements, nil
+}
+
+func (s *memoryMilestoneStore) Achieve(ctx context.Context, uid, childID, milestoneID string, a model.Achievement) error {
+	return s.tree.Set(achievementPath(uid, childID)+"/"+milestoneID, a)
+}
+
+func (s *memoryMilestoneStore) Unachieve(ctx context.Context, uid, childID, milestoneID string) error {
+	return s.tree.Delete(achievementPath(uid, childID) + "/" + milestoneID)
+}
+
+func (s *memoryMilestoneStore) DeleteChild(ctx context.Context, uid, childID string) error {
+	return s.tree.Delete(achievementPath(uid, childID))
+}
+
+func (s *memoryMilestoneStore) DeleteAll(ctx context.Context, uid string) error {
+	return s.tree.Delete("milestone_achievements/" + uid)
+}
@@ -0,0 +1,277 @@
+package repository
+
+import (
+	"backend/model"
+	"context"
+	"sort"
+
+	"firebase.google.com/go/db"
+)
+
+// syncLimit is the most broadcasts copied into an inbox at once; older ones
+// are skipped.
+const syncLimit = 50
+
+// NotificationStore manages the inbox of each user under
+// notifications/<uid>/<id>. Notifications meant for everyone are stored once
+// under notification_broadcasts and copied into an inbox by Sync, which
+// remembers the last copied broadcast in notification_sync/<uid>.
+type NotificationStore interface {
+	// Add stores a notification in the inbox of uid and returns its ID.
+	Add(ctx context.Context, uid string, n model.Notification) (string, error)
+	// Broadcast stores a notification for every user and returns its ID.
+	Broadcast(ctx context.Context, n model.Notification) (string, error)
+	// Sync copies the broadcasts uid has not seen, and keep accepts, into
+	// their inbox. The first sync copies those created at or after since.
+	Sync(ctx context.Context, uid string, since int64, keep func(model.Notification) bool) error
+	// List returns up to limit notifications of uid older than beforeID,
+	// newest first. An empty beforeID starts from the newest.
+	List(ctx context.Context, uid, beforeID string, limit int) ([]model.Notification, error)
+	// UnreadCount returns the number of unread notifications of uid.
+	UnreadCount(ctx context.Context, uid string) (int, error)
+	// MarkRead marks one notification read. It returns ErrNotFound when uid
+	// has no such notification.
+	MarkRead(ctx context.Context, uid, id string) error
+	// MarkAllRead marks every notification of uid read.
+	MarkAllRead(ctx context.Context, uid string) error
+	// DeleteAll removes the inbox of uid.
+	DeleteAll(ctx context.Context, uid string) error
+}
+
+// syncFields is the root update that copies the broadcasts after cursor, or
+// from since on the first sync, that keep accepts into the inbox of uid. It
+// returns nil when there are no new broadcasts.
+func syncFields(uid, cursor string, since int64, broadcasts map[string]model.Notification, keep func(model.Notification) bool) map[string]interface{} {
+	ids := make([]string, 0, len(broadcasts))
+	for id, n := range broadcasts {
+		if (cursor != "" && id > cursor) || (cursor == "" && n.CreatedAt >= since) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Strings(ids)
+	if len(ids) > syncLimit {
+		ids = ids[len(ids)-syncLimit:]
+	}
+
+	fields := map[string]interface{}{"notification_sync/" + uid: ids[len(ids)-1]}
+	for _, id := range ids {
+		n := broadcasts[id]
+		if !keep(n) {
+			continue
+		}
+		n.ID = ""
+		n.Read = false
+		fields["notifications/"+uid+"/"+id] = n
+	}
+	return fields
+}
+
+// notificationsBefore returns up to limit notifications with IDs less than
+// beforeID, newest first. The map keys are the notification IDs.
+func notificationsBefore(notifications map[string]model.Notification, beforeID string, limit int) []model.Notification {
+	ids := make([]string, 0, len(notifications))
+	for id := range notifications {
+		if beforeID == "" || id < beforeID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	list := make([]model.Notification, 0, len(ids))
+	for _, id := range ids {
+		n := notifications[id]
+		n.ID = id
+		list = append(list, n)
+	}
+	return list
+}
+
+// readFields marks every unread notification in notifications read.
+func readFields(notifications map[string]model.Notification) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for id, n := range notifications {
+		if !n.Read {
+			fields[id+"/read"] = true
+		}
+	}
+	return fields
+}
+
+type firebaseNotificationStore struct {
+	db *db.Client
+}
+
+func (s *firebaseNotificationStore) Add(ctx context.Context, uid string, n model.Notification) (string, error) {
+	n.ID = ""
+	ref, err := s.db.NewRef("notifications/"+uid).Push(ctx, n)
+	if err != nil {
+		return "", err
+	}
+	return ref.Key, nil
+}
+
+func (s *firebaseNotificationStore) Broadcast(ctx context.Context, n model.Notification) (string, error) {
+	n.ID = ""
+	ref, err := s.db.NewRef("notification_broadcasts").Push(ctx, n)
+	if err != nil {
+		return "", err
+	}
+	return ref.Key, nil
+}
+
+func (s *firebaseNotificationStore) Sync(ctx context.Context, uid string, since int64, keep func(model.Notification) bool) error {
+	var cursor string
+	if err := s.db.NewRef("notification_sync/"+uid).Get(ctx, &cursor); err != nil {
+		return err
+	}
+	var query *db.Query
+	if cursor != "" {
+		// StartAt is inclusive; syncFields skips the cursor itself
+		query = s.db.NewRef("notification_broadcasts").OrderByKey().StartAt(cursor)
+	} else {
+		query = s.db.NewRef("notification_broadcasts").OrderByChild("created_at").StartAt(since)
+	}
+	var broadcasts map[string]model.Notification
+	if err := query.LimitToLast(syncLimit+1).Get(ctx, &broadcasts); err != nil {
+		return err
+	}
+	fields := syncFields(uid, cursor, since, broadcasts, keep)
+	if fields == nil {
+		return nil
+	}
+	return s.db.NewRef("/").Update(ctx, fields)
+}
+
+func (s *firebaseNotificationStore) List(ctx context.Context, uid, beforeID string, limit int) ([]model.Notification, error) {
+	query := s.db.NewRef("notifications/" + uid).OrderByKey()
+	if beforeID != "" {
+		// EndAt is inclusive, so fetch one extra notification to make up for beforeID
+		query = query.EndAt(beforeID)
+	}
+	var notifications map[string]model.Notification
+	if err := query.LimitToLast(limit+1).Get(ctx, &notifications); err != nil {
+		return nil, err
+	}
+	return notificationsBefore(notifications, beforeID, limit), nil
+}
+
+func (s *firebaseNotificationStore) unread(ctx context.Context, uid string) (map[string]model.Notification, error) {
+	var notifications map[string]model.Notification
+	err := s.db.NewRef("notifications/"+uid).OrderByChild("read").EqualTo(false).Get(ctx, &notifications)
+	return notifications, err
+}
+
+func (s *firebaseNotificationStore) UnreadCount(ctx context.Context, uid string) (int, error) {
+	notifications, err := s.unread(ctx, uid)
+	return len(notifications), err
+}
+
+func (s *firebaseNotificationStore) MarkRead(ctx context.Context, uid, id string) error {
+	var n *model.Notification
+	if err := s.db.NewRef("notifications/"+uid+"/"+id).Get(ctx, &n); err != nil {
+		return err
+	}
+	if n == nil {
+		return ErrNotFound
+	}
+	return s.db.NewRef("notifications/"+uid+"/"+id).Update(ctx, map[string]interface{}{"read": true})
+}
+
+func (s *firebaseNotificationStore) MarkAllRead(ctx context.Context, uid string) error {
+	notifications, err := s.unread(ctx, uid)
+	if err != nil || len(notifications) == 0 {
+		return err
+	}
+	return s.db.NewRef("notifications/"+uid).Update(ctx, readFields(notifications))
+}
+
+func (s *firebaseNotificationStore) DeleteAll(ctx context.Context, uid string) error {
+	return s.db.NewRef("/").Update(ctx, map[string]interface{}{
+		"notifications/" + uid:     nil,
+		"notification_sync/" + uid: nil,
+	})
+}
+
+type memoryNotificationStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryNotificationStore) Add(ctx context.Context, uid string, n model.Notification) (string, error) {
+	n.ID = ""
+	return s.tree.Push("notifications/"+uid, n)
+}
+
+func (s *memoryNotificationStore) Broadcast(ctx context.Context, n model.Notification) (string, error) {
+	n.ID = ""
+	return s.tree.Push("notification_broadcasts", n)
+}
+
+func (s *memoryNotificationStore) Sync(ctx context.Context, uid string, since int64, keep func(model.Notification) bool) error {
+	var cursor string
+	if err := s.tree.Get("notification_sync/"+uid, &cursor); err != nil {
+		return err
+	}
+	var broadcasts map[string]model.Notification
+	if err := s.tree.Get("notification_broadcasts", &broadcasts); err != nil {
+		return err
+	}
+	fields := syncFields(uid, cursor, since, broadcasts, keep)
+	if fields == nil {
+		return nil
+	}
+	return s.tree.Update("/", fields)
+}
+
+func (s *memoryNotificationStore) all(uid string) (map[string]model.Notification, error) {
+	var notifications map[string]model.Notification
+	err := s.tree.Get("notifications/"+uid, &notifications)
+	return notifications, err
+}
+
+func (s *memoryNotificationStore) List(ctx context.Context, uid, beforeID string, limit int) ([]model.Notification, error) {
+	notifications, err := s.all(uid)
+	if err != nil {
+		return nil, err
+	}
+	return notificationsBefore(notifications, beforeID, limit), nil
+}
+
+func (s *memoryNotificationStore) UnreadCount(ctx context.Context, uid string) (int, error) {
+	notifications, err := s.all(uid)
+	if err != nil {
+		return 0, err
+	}
+	return len(readFields(notifications)), nil
+}
+
+func (s *memoryNotificationStore) MarkRead(ctx context.Context, uid, id string) error {
+	var n *model.Notification
+	if err := s.tree.Get("notifications/"+uid+"/"+id, &n); err != nil {
+		return err
+	}
+	if n == nil {
+		return ErrNotFound
+	}
+	return s.tree.Update("notifications/"+uid+"/"+id, map[string]interface{}{"read": true})
+}
+
+func (s *memoryNotificationStore) MarkAllRead(ctx context.Context, uid string) error {
+	notifications, err := s.all(uid)
+	if err != nil {
+		return err
+	}
+	return s.tree.Update("notifications/"+uid, readFields(notifications))
+}
+
+func (s *memoryNotificationStore) DeleteAll(ctx context.Context, uid string) error {
+	return s.tree.Update("/", map[string]interface{}{
+		"notifications/" + uid:     nil,
+		"notification_sync/" + uid: nil,
+	})
+}
@@ -0,0 +1,254 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"firebase.google.com/go/db"
+)
+
+// OutboxEntry is an email waiting to be sent, stored under mail_outbox/<id>.
+// Emails that could not be sent are moved to mail_dead_letters/<id> without
+// their body and headers, which may hold live action links.
+type OutboxEntry struct {
+	ID            string            `json:"id,omitempty"`
+	To            string            `json:"to"`
+	Subject       string            `json:"subject"`
+	HTML          string            `json:"html"`
+	Text          string            `json:"text,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Attempts      int               `json:"attempts"`
+	NextAttemptAt int64             `json:"next_attempt_at"` // Also pushed back while an attempt is under way
+	LastError     string            `json:"last_error,omitempty"`
+	CreatedAt     int64             `json:"created_at"`
+	FailedAt      int64             `json:"failed_at,omitempty"` // Set on dead letters
+}
+
+// OutboxStore manages the queue of outgoing email and its dead letters.
+type OutboxStore interface {
+	// Enqueue adds an email to the queue and returns its ID.
+	Enqueue(ctx context.Context, e OutboxEntry) (string, error)
+	// Due returns up to limit queued emails whose next attempt is at or
+	// before now, earliest first.
+	Due(ctx context.Context, now int64, limit int) ([]OutboxEntry, error)
+	// Claim reserves a due email until the given time so that no other
+	// worker picks it up, and returns it. It returns ErrNotFound when the
+	// email is gone and ErrConflict when it is not due.
+	Claim(ctx context.Context, id string, now, until int64) (OutboxEntry, error)
+	// Retry records a failed attempt and when to make the next one.
+	Retry(ctx context.Context, id string, attempts int, next int64, lastError string) error
+	// Delete removes a sent email from the queue.
+	Delete(ctx context.Context, id string) error
+	// Bury moves an email from the queue to the dead letters, dropping its
+	// body and headers.
+	Bury(ctx context.Context, e OutboxEntry) error
+	// DeadLetters returns up to limit dead letters, most recent failure
+	// first.
+	DeadLetters(ctx context.Context, limit int) ([]OutboxEntry, error)
+	// DeleteDead removes a dead letter.
+	DeleteDead(ctx context.Context, id string) error
+}
+
+// claimEntry is the transaction body shared by both Claim implementations.
+func claimEntry(current *OutboxEntry, now, until int64) (interface{}, error) {
+	if current == nil {
+		return nil, ErrNotFound
+	}
+	if current.NextAttemptAt > now {
+		return nil, ErrConflict
+	}
+	current.NextAttemptAt = until
+	return current, nil
+}
+
+// buryFields is the root update that moves e to the dead letters.
+func buryFields(e OutboxEntry) map[string]interface{} {
+	id := e.ID
+	e.ID = ""
+	e.NextAttemptAt = 0
+	e.HTML = ""
+	e.Text = ""
+	e.Headers = nil
+	return map[string]interface{}{
+		"mail_outbox/" + id:       nil,
+		"mail_dead_letters/" + id: e,
+	}
+}
+
+// dueEntries returns up to limit entries due at or before now, earliest
+// first. The map keys are the entry IDs.
+func dueEntries(entries map[string]OutboxEntry, now int64, limit int) []OutboxEntry {
+	list := make([]OutboxEntry, 0, len(entries))
+	for id, e := range entries {
+		if e.NextAttemptAt <= now {
+			e.ID = id
+			list = append(list, e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].NextAttemptAt != list[j].NextAttemptAt {
+			return list[i].NextAttemptAt < list[j].NextAttemptAt
+		}
+		return list[i].ID < list[j].ID
+	})
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+// deadLetters returns up to limit dead letters, most recent failure first.
+func deadLetters(entries map[string]OutboxEntry, limit int) []OutboxEntry {
+	list := make([]OutboxEntry, 0, len(entries))
+	for id, e := range entries {
+		e.ID = id
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].FailedAt != list[j].FailedAt {
+			return list[i].FailedAt > list[j].FailedAt
+		}
+		return list[i].ID > list[j].ID
+	})
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+func retryFields(attempts int, next int64, lastError string) map[string]interface{} {
+	return map[string]interface{}{
+		"attempts":        attempts,
+		"next_attempt_at": next,
+		"last_error":      lastError,
+	}
+}
+
+type firebaseOutboxStore struct {
+	db *db.Client
+}
+
+func (s *firebaseOutboxStore) Enqueue(ctx context.Context, e OutboxEntry) (string, error) {
+	e.ID = ""
+	ref, err := s.db.NewRef("mail_outbox").Push(ctx, e)
+	if err != nil {
+		return "", err
+	}
+	return ref.Key, nil
+}
+
+func (s *firebaseOutboxStore) Due(ctx context.Context, now int64, limit int) ([]OutboxEntry, error) {
+	var entries map[string]OutboxEntry
+	err := s.db.NewRef("mail_outbox").OrderByChild("next_attempt_at").EndAt(now).LimitToFirst(limit).Get(ctx, &entries)
+	if err != nil {
+		return nil, err
+	}
+	return dueEntries(entries, now, limit), nil
+}
+
+func (s *firebaseOutboxStore) Claim(ctx context.Context, id string, now, until int64) (OutboxEntry, error) {
+	var claimed OutboxEntry
+	err := s.db.NewRef("mail_outbox/"+id).Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		var current *OutboxEntry
+		if err := node.Unmarshal(&current); err != nil {
+			return nil, err
+		}
+		v, err := claimEntry(current, now, until)
+		if err == nil {
+			claimed = *current
+		}
+		return v, err
+	})
+	if err != nil {
+		return OutboxEntry{}, err
+	}
+	claimed.ID = id
+	return claimed, nil
+}
+
+func (s *firebaseOutboxStore) Retry(ctx context.Context, id string, attempts int, next int64, lastError string) error {
+	return s.db.NewRef("mail_outbox/"+id).Update(ctx, retryFields(attempts, next, lastError))
+}
+
+func (s *firebaseOutboxStore) Delete(ctx context.Context, id string) error {
+	return s.db.NewRef("mail_outbox/" + id).Delete(ctx)
+}
+
+func (s *firebaseOutboxStore) Bury(ctx context.Context, e OutboxEntry) error {
+	return s.db.NewRef("/").Update(ctx, buryFields(e))
+}
+
+func (s *firebaseOutboxStore) DeadLetters(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	var entries map[string]OutboxEntry
+	err := s.db.NewRef("mail_dead_letters").OrderByChild("failed_at").LimitToLast(limit).Get(ctx, &entries)
+	if err != nil {
+		return nil, err
+	}
+	return deadLetters(entries, limit), nil
+}
+
+func (s *firebaseOutboxStore) DeleteDead(ctx context.Context, id string) error {
+	return s.db.NewRef("mail_dead_letters/" + id).Delete(ctx)
+}
+
+type memoryOutboxStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryOutboxStore) Enqueue(ctx context.Context, e OutboxEntry) (string, error) {
+	e.ID = ""
+	return s.tree.Push("mail_outbox", e)
+}
+
+func (s *memoryOutboxStore) Due(ctx context.Context, now int64, limit int) ([]OutboxEntry, error) {
+	var entries map[string]OutboxEntry
+	if err := s.tree.Get("mail_outbox", &entries); err != nil {
+		return nil, err
+	}
+	return dueEntries(entries, now, limit), nil
+}
+
+func (s *memoryOutboxStore) Claim(ctx context.Context, id string, now, until int64) (OutboxEntry, error) {
+	var claimed OutboxEntry
+	err := s.tree.Transaction("mail_outbox/"+id, func(current json.RawMessage) (interface{}, error) {
+		var existing *OutboxEntry
+		if err := json.Unmarshal(current, &existing); err != nil {
+			return nil, err
+		}
+		v, err := claimEntry(existing, now, until)
+		if err == nil {
+			claimed = *existing
+		}
+		return v, err
+	})
+	if err != nil {
+		return OutboxEntry{}, err
+	}
+	claimed.ID = id
+	return claimed, nil
+}
+
+func (s *memoryOutboxStore) Retry(ctx context.Context, id string, attempts int, next int64, lastError string) error {
+	return s.tree.Update("mail_outbox/"+id, retryFields(attempts, next, lastError))
+}
+
+func (s *memoryOutboxStore) Delete(ctx context.Context, id string) error {
+	return s.tree.Delete("mail_outbox/" + id)
+}
+
+func (s *memoryOutboxStore) Bury(ctx context.Context, e OutboxEntry) error {
+	return s.tree.Update("/", buryFields(e))
+}
+
+func (s *memoryOutboxStore) DeadLetters(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	var entries map[string]OutboxEntry
+	if err := s.tree.Get("mail_dead_letters", &entries); err != nil {
+		return nil, err
+	}
+	return deadLetters(entries, limit), nil
+}
+
+func (s *memoryOutboxStore) DeleteDead(ctx context.Context, id string) error {
+	return s.tree.Delete("mail_dead_letters/" + id)
+}
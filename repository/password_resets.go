@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"firebase.google.com/go/db"
+)
+
+// PasswordReset is a pending password reset. It is keyed by the hash of the
+// token emailed to the user, so the token itself is never stored.
+type PasswordReset struct {
+	TokenHash string `json:"token_hash"`
+	UID       string `json:"uid"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// PasswordResetStore manages the resets stored under password_resets/<hash>.
+type PasswordResetStore interface {
+	// Create stores a new pending reset.
+	Create(ctx context.Context, reset PasswordReset) error
+	// Consume atomically removes and returns the reset with the given token
+	// hash, so each token can be used only once.
+	Consume(ctx context.Context, tokenHash string) (PasswordReset, error)
+	// DeleteAll removes every pending reset of uid.
+	DeleteAll(ctx context.Context, uid string) error
+}
+
+type firebasePasswordResetStore struct {
+	db *db.Client
+}
+
+func (s *firebasePasswordResetStore) Create(ctx context.Context, reset PasswordReset) error {
+	return s.db.NewRef("password_resets/"+reset.TokenHash).Set(ctx, reset)
+}
+
+func (s *firebasePasswordResetStore) Consume(ctx context.Context, tokenHash string) (PasswordReset, error) {
+	var consumed *PasswordReset
+	err := s.db.NewRef("password_resets/"+tokenHash).Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		consumed = nil
+		if err := node.Unmarshal(&consumed); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return PasswordReset{}, err
+	}
+	if consumed == nil {
+		return PasswordReset{}, ErrNotFound
+	}
+	return *consumed, nil
+}
+
+func (s *firebasePasswordResetStore) DeleteAll(ctx context.Context, uid string) error {
+	var resets map[string]PasswordReset
+	if err := s.db.NewRef("password_resets").OrderByChild("uid").EqualTo(uid).Get(ctx, &resets); err != nil {
+		return err
+	}
+	if len(resets) == 0 {
+		return nil
+	}
+	fields := make(map[string]interface{}, len(resets))
+	for hash := range resets {
+		fields[hash] = nil
+	}
+	return s.db.NewRef("password_resets").Update(ctx, fields)
+}
+
+type memoryPasswordResetStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryPasswordResetStore) Create(ctx context.Context, reset PasswordReset) error {
+	return s.tree.Set("password_resets/"+reset.TokenHash, reset)
+}
+
+func (s *memoryPasswordResetStore) Consume(ctx context.Context, tokenHash string) (PasswordReset, error) {
+	var consumed *PasswordReset
+	err := s.tree.Transaction("password_resets/"+tokenHash, func(current json.RawMessage) (interface{}, error) {
+		if err := json.Unmarshal(current, &consumed); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return PasswordReset{}, err
+	}
+	if consumed == nil {
+		return PasswordReset{}, ErrNotFound
+	}
+	return *consumed, nil
+}
+
+func (s *memoryPasswordResetStore) DeleteAll(ctx context.Context, uid string) error {
+	var resets map[string]PasswordReset
+	if err := s.tree.Get("password_resets", &resets); err != nil {
+		return err
+	}
+	fields := make(map[string]interface{})
+	for hash, reset := range resets {
+		if reset.UID == uid {
+			fields[hash] = nil
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return s.tree.Update("password_resets", fields)
+}
@@ -0,0 +1,220 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"firebase.google.com/go/db"
+)
+
+// PendingLike collects the likes of a post or comment that its author has
+// not been told about yet, stored under pending_likes/<key>.
+type PendingLike struct {
+	UID       string           `json:"uid"` // Author to notify
+	PostID    string           `json:"post_id"`
+	CommentID string           `json:"comment_id,omitempty"` // Empty for likes of the post itself
+	Title     string           `json:"title"`                // Title of the post
+	Likers    map[string]int64 `json:"likers"`               // Username to time of the like
+	FirstAt   int64            `json:"first_at"`
+}
+
+// PendingLikeStore manages the likes waiting to be announced in one
+// notification.
+type PendingLikeStore interface {
+	// Add records that username liked the target of like at the given time.
+	// The first like of an entry also stores the rest of like.
+	Add(ctx context.Context, key string, like PendingLike, username string, at int64) error
+	// Remove drops the like of username, and the entry with its last like.
+	Remove(ctx context.Context, key, username string) error
+	// Due returns up to limit entries whose first like is at or before the
+	// given time, keyed by key.
+	Due(ctx context.Context, before int64, limit int) (map[string]PendingLike, error)
+	// Take removes an entry and returns it. It returns ErrNotFound when the
+	// entry is gone.
+	Take(ctx context.Context, key string) (PendingLike, error)
+	// DeleteAll removes the entries meant for uid.
+	DeleteAll(ctx context.Context, uid string) error
+}
+
+// addLike is the transaction body shared by both Add implementations.
+func addLike(current *PendingLike, like PendingLike, username string, at int64) interface{} {
+	if current == nil {
+		like.Likers = map[string]int64{}
+		like.FirstAt = at
+		current = &like
+	}
+	if current.Likers == nil {
+		current.Likers = map[string]int64{}
+	}
+	current.Likers[username] = at
+	return current
+}
+
+// removeLike is the transaction body shared by both Remove implementations.
+func removeLike(current *PendingLike, username string) interface{} {
+	if current == nil {
+		return nil
+	}
+	delete(current.Likers, username)
+	if len(current.Likers) == 0 {
+		return nil
+	}
+	return current
+}
+
+// dueLikes returns up to limit entries of likes started at or before the
+// given time, oldest first.
+func dueLikes(likes map[string]PendingLike, before int64, limit int) map[string]PendingLike {
+	keys := make([]string, 0, len(likes))
+	for key, like := range likes {
+		if like.FirstAt <= before {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return likes[keys[i]].FirstAt < likes[keys[j]].FirstAt })
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	due := make(map[string]PendingLike, len(keys))
+	for _, key := range keys {
+		due[key] = likes[key]
+	}
+	return due
+}
+
+type firebasePendingLikeStore struct {
+	db *db.Client
+}
+
+func (s *firebasePendingLikeStore) Add(ctx context.Context, key string, like PendingLike, username string, at int64) error {
+	return s.db.NewRef("pending_likes/"+key).Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		var current *PendingLike
+		if err := node.Unmarshal(&current); err != nil {
+			return nil, err
+		}
+		return addLike(current, like, username, at), nil
+	})
+}
+
+func (s *firebasePendingLikeStore) Remove(ctx context.Context, key, username string) error {
+	return s.db.NewRef("pending_likes/"+key).Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		var current *PendingLike
+		if err := node.Unmarshal(&current); err != nil {
+			return nil, err
+		}
+		return removeLike(current, username), nil
+	})
+}
+
+func (s *firebasePendingLikeStore) Due(ctx context.Context, before int64, limit int) (map[string]PendingLike, error) {
+	var likes map[string]PendingLike
+	err := s.db.NewRef("pending_likes").OrderByChild("first_at").EndAt(before).LimitToFirst(limit).Get(ctx, &likes)
+	if err != nil {
+		return nil, err
+	}
+	return dueLikes(likes, before, limit), nil
+}
+
+func (s *firebasePendingLikeStore) Take(ctx context.Context, key string) (PendingLike, error) {
+	var taken *PendingLike
+	err := s.db.NewRef("pending_likes/"+key).Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		taken = nil
+		if err := node.Unmarshal(&taken); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return PendingLike{}, err
+	}
+	if taken == nil {
+		return PendingLike{}, ErrNotFound
+	}
+	return *taken, nil
+}
+
+func (s *firebasePendingLikeStore) DeleteAll(ctx context.Context, uid string) error {
+	var likes map[string]PendingLike
+	if err := s.db.NewRef("pending_likes").OrderByChild("uid").EqualTo(uid).Get(ctx, &likes); err != nil {
+		return err
+	}
+	if len(likes) == 0 {
+		return nil
+	}
+	fields := make(map[string]interface{}, len(likes))
+	for key := range likes {
+		fields[key] = nil
+	}
+	return s.db.NewRef("pending_likes").Update(ctx, fields)
+}
+
+type memoryPendingLikeStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryPendingLikeStore) Add(ctx context.Context, key string, like PendingLike, username string, at int64) error {
+	return s.tree.Transaction("pending_likes/"+key, func(current json.RawMessage) (interface{}, error) {
+		var existing *PendingLike
+		if err := json.Unmarshal(current, &existing); err != nil {
+			return nil, err
+		}
+		return addLike(existing, like, username, at), nil
+	})
+}
+
+func (s *memoryPendingLikeStore) Remove(ctx context.Context, key, username string) error {
+	return s.tree.Transaction("pending_likes/"+key, func(current json.RawMessage) (interface{}, error) {
+		var existing *PendingLike
+		if err := json.Unmarshal(current, &existing); err != nil {
+			return nil, err
+		}
+		return removeLike(existing, username), nil
+	})
+}
+
+func (s *memoryPendingLikeStore) all() (map[string]PendingLike, error) {
+	var likes map[string]PendingLike
+	err := s.tree.Get("pending_likes", &likes)
+	return likes, err
+}
+
+func (s *memoryPendingLikeStore) Due(ctx context.Context, before int64, limit int) (map[string]PendingLike, error) {
+	likes, err := s.all()
+	if err != nil {
+		return nil, err
+	}
+	return dueLikes(likes, before, limit), nil
+}
+
+func (s *memoryPendingLikeStore) Take(ctx context.Context, key string) (PendingLike, error) {
+	var taken *PendingLike
+	err := s.tree.Transaction("pending_likes/"+key, func(current json.RawMessage) (interface{}, error) {
+		if err := json.Unmarshal(current, &taken); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return PendingLike{}, err
+	}
+	if taken == nil {
+		return PendingLike{}, ErrNotFound
+	}
+	return *taken, nil
+}
+
+func (s *memoryPendingLikeStore) DeleteAll(ctx context.Context, uid string) error {
+	likes, err := s.all()
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]interface{})
+	for key, like := range likes {
+		if like.UID == uid {
+			fields[key] = nil
+		}
+	}
+	return s.tree.Update("pending_likes", fields)
+}
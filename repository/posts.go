@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"backend/model"
+	"context"
+	"sort"
+
+	"firebase.google.com/go/db"
+)
+
+// PostQuery selects a page of posts ordered by created_at.
+type PostQuery struct {
+	// StartAt, when set, is the smallest created_at value to include.
+	StartAt *int64
+	// Limit is the maximum number of posts to return.
+	Limit int
+}
+
+// PostStore manages the posts stored under posts/<id>.
+type PostStore interface {
+	// Get returns the post with the given ID, including its comments.
+	Get(ctx context.Context, id string) (model.Post, error)
+	// Save writes the whole post, replacing any previous version.
+	Save(ctx context.Context, post model.Post) error
+	// Update writes the given post fields, leaving the others untouched.
+	Update(ctx context.Context, id string, fields map[string]interface{}) error
+	// List returns a page of posts ordered by created_at.
+	List(ctx context.Context, q PostQuery) (map[string]model.Post, error)
+	// All returns every post.
+	All(ctx context.Context) (map[string]model.Post, error)
+	// Delete removes the post and its comments.
+	Delete(ctx context.Context, id string) error
+	// ListAfter returns up to limit posts whose IDs sort after afterID, in
+	// ID order. An empty afterID starts from the first post.
+	ListAfter(ctx context.Context, afterID string, limit int) ([]model.Post, error)
+}
+
+type firebasePostStore struct {
+	db *db.Client
+}
+
+func (s *firebasePostStore) Get(ctx context.Context, id string) (model.Post, error) {
+	var post *model.Post
+	if err := s.db.NewRef("posts/"+id).Get(ctx, &post); err != nil {
+		return model.Post{}, err
+	}
+	if post == nil {
+		return model.Post{}, ErrNotFound
+	}
+	return *post, nil
+}
+
+func (s *firebasePostStore) Save(ctx context.Context, post model.Post) error {
+	return s.db.NewRef("posts/"+post.ID).Set(ctx, post)
+}
+
+func (s *firebasePostStore) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	return s.db.NewRef("posts/"+id).Update(ctx, fields)
+}
+
+func (s *firebasePostStore) List(ctx context.Context, q PostQuery) (map[string]model.Post, error) {
+	query := s.db.NewRef("posts").OrderByChild("created_at")
+	if q.StartAt != nil {
+		query = query.StartAt(*q.StartAt)
+	}
+	query = query.LimitToFirst(q.Limit)
+
+	var posts map[string]model.Post
+	if err := query.Get(ctx, &posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+func (s *firebasePostStore) All(ctx context.Context) (map[string]model.Post, error) {
+	var posts map[string]model.Post
+	if err := s.db.NewRef("posts").Get(ctx, &posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+func (s *firebasePostStore) Delete(ctx context.Context, id string) error {
+	return s.db.NewRef("posts/" + id).Delete(ctx)
+}
+
+func (s *firebasePostStore) ListAfter(ctx context.Context, afterID string, limit int) ([]model.Post, error) {
+	query := s.db.NewRef("posts").OrderByKey()
+	if afterID != "" {
+		// StartAt is inclusive, so fetch one extra post to make up for afterID
+		query = query.StartAt(afterID)
+	}
+	var posts map[string]model.Post
+	if err := query.LimitToFirst(limit+1).Get(ctx, &posts); err != nil {
+		return nil, err
+	}
+	return postsAfter(posts, afterID, limit), nil
+}
+
+type memoryPostStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryPostStore) Get(ctx context.Context, id string) (model.Post, error) {
+	var post *model.Post
+	if err := s.tree.Get("posts/"+id, &post); err != nil {
+		return model.Post{}, err
+	}
+	if post == nil {
+		return model.Post{}, ErrNotFound
+	}
+	return *post, nil
+}
+
+func (s *memoryPostStore) Save(ctx context.Context, post model.Post) error {
+	return s.tree.Set("posts/"+post.ID, post)
+}
+
+func (s *memoryPostStore) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	return s.tree.Update("posts/"+id, fields)
+}
+
+func (s *memoryPostStore) List(ctx context.Context, q PostQuery) (map[string]model.Post, error) {
+	posts, err := s.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(posts))
+	for id, post := range posts {
+		if q.StartAt == nil || post.CreatedAt >= *q.StartAt {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return posts[ids[i]].CreatedAt < posts[ids[j]].CreatedAt
+	})
+	if q.Limit > 0 && len(ids) > q.Limit {
+		ids = ids[:q.Limit]
+	}
+
+	page := make(map[string]model.Post, len(ids))
+	for _, id := range ids {
+		page[id] = posts[id]
+	}
+	return page, nil
+}
+
+func (s *memoryPostStore) All(ctx context.Context) (map[string]model.Post, error) {
+	var posts map[string]model.Post
+	if err := s.tree.Get("posts", &posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+func (s *memoryPostStore) Delete(ctx context.Context, id string) error {
+	return s.tree.Delete("posts/" + id)
+}
+
+func (s *memoryPostStore) ListAfter(ctx context.Context, afterID string, limit int) ([]model.Post, error) {
+	posts, err := s.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return postsAfter(posts, afterID, limit), nil
+}
+
+// postsAfter returns up to limit posts with IDs greater than afterID, sorted
+// by ID. The map keys are the post IDs.
+func postsAfter(posts map[string]model.Post, afterID string, limit int) []model.Post {
+	ids := make([]string, 0, len(posts))
+	for id := range posts {
+		if id > afterID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	page := make([]model.Post, len(ids))
+	for i, id := range ids {
+		page[i] = posts[id]
+		page[i].ID = id
+	}
+	return page
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"backend/model"
+	"context"
+	"sort"
+
+	"firebase.google.com/go/db"
+)
+
+// PreferenceStore manages the notification preferences of each user under
+// notification_preferences/<uid>.
+type PreferenceStore interface {
+	// Get returns the preferences of uid, or the zero value when they never
+	// changed them.
+	Get(ctx context.Context, uid string) (model.NotificationPreferences, error)
+	// Save replaces the preferences of uid.
+	Save(ctx context.Context, uid string, p model.NotificationPreferences) error
+	// Delete removes the preferences of uid.
+	Delete(ctx context.Context, uid string) error
+	// ListAfter returns the preferences of up to limit users whose UIDs
+	// sort after afterUID, in UID order. An empty afterUID starts from the
+	// first.
+	ListAfter(ctx context.Context, afterUID string, limit int) ([]UserPreferences, error)
+}
+
+// UserPreferences is the notification preferences of one user.
+type UserPreferences struct {
+	UID         string
+	Preferences model.NotificationPreferences
+}
+
+// preferencesAfter returns up to limit entries with UIDs greater than
+// afterUID, sorted by UID.
+func preferencesAfter(prefs map[string]model.NotificationPreferences, afterUID string, limit int) []UserPreferences {
+	uids := make([]string, 0, len(prefs))
+	for uid := range prefs {
+		if uid > afterUID {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Strings(uids)
+	if len(uids) > limit {
+		uids = uids[:limit]
+	}
+
+	list := make([]UserPreferences, 0, len(uids))
+	for _, uid := range uids {
+		list = append(list, UserPreferences{UID: uid, Preferences: prefs[uid]})
+	}
+	return list
+}
+
+type firebasePreferenceStore struct {
+	db *db.Client
+}
+
+func (s *firebasePreferenceStore) Get(ctx context.Context, uid string) (model.NotificationPreferences, error) {
+	var p model.NotificationPreferences
+	err := s.db.NewRef("notification_preferences/"+uid).Get(ctx, &p)
+	return p, err
+}
+
+func (s *firebasePreferenceStore) Save(ctx context.Context, uid string, p model.NotificationPreferences) error {
+	return s.db.NewRef("notification_preferences/"+uid).Set(ctx, p)
+}
+
+func (s *firebasePreferenceStore) Delete(ctx context.Context, uid string) error {
+	return s.db.NewRef("notification_preferences/" + uid).Delete(ctx)
+}
+
+func (s *firebasePreferenceStore) ListAfter(ctx context.Context, afterUID string, limit int) ([]UserPreferences, error) {
+	query := s.db.NewRef("notification_preferences").OrderByKey()
+	if afterUID != "" {
+		// StartAt is inclusive, so fetch one extra user to make up for afterUID
+		query = query.StartAt(afterUID)
+	}
+	var prefs map[string]model.NotificationPreferences
+	if err := query.LimitToFirst(limit+1).Get(ctx, &prefs); err != nil {
+		return nil, err
+	}
+	return preferencesAfter(prefs, afterUID, limit), nil
+}
+
+type memoryPreferenceStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryPreferenceStore) Get(ctx context.Context, uid string) (model.NotificationPreferences, error) {
+	var p model.NotificationPreferences
+	err := s.tree.Get("notification_preferences/"+uid, &p)
+	return p, err
+}
+
+func (s *memoryPreferenceStore) Save(ctx context.Context, uid string, p model.NotificationPreferences) error {
+	return s.tree.Set("notification_preferences/"+uid, p)
+}
+
+func (s *memoryPreferenceStore) Delete(ctx context.Context, uid string) error {
+	return s.tree.Delete("notification_preferences/" + uid)
+}
+
+func (s *memoryPreferenceStore) ListAfter(ctx context.Context, afterUID string, limit int) ([]UserPreferences, error) {
+	var prefs map[string]model.NotificationPreferences
+	if err := s.tree.Get("notification_preferences", &prefs); err != nil {
+		return nil, err
+	}
+	return preferencesAfter(prefs, afterUID, limit), nil
+}
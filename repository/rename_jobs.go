@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+
+	"firebase.google.com/go/db"
+)
+
+// Rename job states.
+const (
+	RenameJobPending = "pending"
+	RenameJobDone    = "done"
+)
+
+// RenameJob rewrites the references to a user's old username in posts,
+// comments, likes and flags after a username change. Cursor records the last
+// post processed so an interrupted job resumes where it stopped.
+type RenameJob struct {
+	ID           string `json:"id"`
+	UID          string `json:"uid"`
+	OldUsername  string `json:"old_username"`
+	NewUsername  string `json:"new_username"`
+	Status       string `json:"status"`
+	Cursor       string `json:"cursor"`
+	PostsScanned int    `json:"posts_scanned"`
+	PostsUpdated int    `json:"posts_updated"`
+	Error        string `json:"error,omitempty"` // Last error; the job is retried
+	CreatedAt    int64  `json:"created_at"`
+	UpdatedAt    int64  `json:"updated_at"`
+}
+
+// RenameJobStore manages the jobs stored under rename_jobs/<id>.
+type RenameJobStore interface {
+	// Create stores a new job.
+	Create(ctx context.Context, job RenameJob) error
+	// Get returns the job with the given ID.
+	Get(ctx context.Context, id string) (RenameJob, error)
+	// Update writes the given job fields, leaving the others untouched.
+	Update(ctx context.Context, id string, fields map[string]interface{}) error
+	// ListPending returns every job that has not finished yet.
+	ListPending(ctx context.Context) (map[string]RenameJob, error)
+	// DeleteAll removes every job of uid.
+	DeleteAll(ctx context.Context, uid string) error
+}
+
+type firebaseRenameJobStore struct {
+	db *db.Client
+}
+
+func (s *firebaseRenameJobStore) Create(ctx context.Context, job RenameJob) error {
+	return s.db.NewRef("rename_jobs/"+job.ID).Set(ctx, job)
+}
+
+func (s *firebaseRenameJobStore) Get(ctx context.Context, id string) (RenameJob, error) {
+	var job *RenameJob
+	if err := s.db.NewRef("rename_jobs/"+id).Get(ctx, &job); err != nil {
+		return RenameJob{}, err
+	}
+	if job == nil {
+		return RenameJob{}, ErrNotFound
+	}
+	return *job, nil
+}
+
+func (s *firebaseRenameJobStore) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	return s.db.NewRef("rename_jobs/"+id).Update(ctx, fields)
+}
+
+func (s *firebaseRenameJobStore) ListPending(ctx context.Context) (map[string]RenameJob, error) {
+	var jobs map[string]RenameJob
+	if err := s.db.NewRef("rename_jobs").OrderByChild("status").EqualTo(RenameJobPending).Get(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (s *firebaseRenameJobStore) DeleteAll(ctx context.Context, uid string) error {
+	var jobs map[string]RenameJob
+	if err := s.db.NewRef("rename_jobs").OrderByChild("uid").EqualTo(uid).Get(ctx, &jobs); err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	fields := make(map[string]interface{}, len(jobs))
+	for id := range jobs {
+		fields[id] = nil
+	}
+	return s.db.NewRef("rename_jobs").Update(ctx, fields)
+}
+
+type memoryRenameJobStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryRenameJobStore) Create(ctx context.Context, job RenameJob) error {
+	return s.tree.Set("rename_jobs/"+job.ID, job)
+}
+
+func (s *memoryRenameJobStore) Get(ctx context.Context, id string) (RenameJob, error) {
+	var job *RenameJob
+	if err := s.tree.Get("rename_jobs/"+id, &job); err != nil {
+		return RenameJob{}, err
+	}
+	if job == nil {
+		return RenameJob{}, ErrNotFound
+	}
+	return *job, nil
+}
+
+func (s *memoryRenameJobStore) Update(ctx context.Context, id string, fields map[string]interface{}) error {
+	return s.tree.Update("rename_jobs/"+id, fields)
+}
+
+func (s *memoryRenameJobStore) ListPending(ctx context.Context) (map[string]RenameJob, error) {
+	var jobs map[string]RenameJob
+	if err := s.tree.Get("rename_jobs", &jobs); err != nil {
+		return nil, err
+	}
+	pending := make(map[string]RenameJob)
+	for id, job := range jobs {
+		if job.Status == RenameJobPending {
+			pending[id] = job
+		}
+	}
+	return pending, nil
+}
+
+func (s *memoryRenameJobStore) DeleteAll(ctx context.Context, uid string) error {
+	var jobs map[string]RenameJob
+	if err := s.tree.Get("rename_jobs", &jobs); err != nil {
+		return err
+	}
+	fields := make(map[string]interface{})
+	for id, job := range jobs {
+		if job.UID == uid {
+			fields[id] = nil
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return s.tree.Update("rename_jobs", fields)
+}
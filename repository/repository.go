@@ -0,0 +1,119 @@
+// Package repository defines the storage interfaces used by the HTTP handlers,
+// together with a Firebase-backed implementation and an in-memory one for
+// local development and tests.
+package repository
+
+import (
+	"errors"
+
+	"firebase.google.com/go/auth"
+	"firebase.google.com/go/db"
+)
+
+var (
+	// ErrNotFound is returned when the requested record does not exist.
+	ErrNotFound = errors.New("record not found")
+	// ErrEmailExists is returned when an account with the same email already exists.
+	ErrEmailExists = errors.New("email already exists")
+	// ErrInvalidEmail is returned when the auth backend rejects an email address.
+	ErrInvalidEmail = errors.New("invalid email address")
+	// ErrUsernameTaken is returned when another user already holds a username.
+	ErrUsernameTaken = errors.New("username already taken")
+	// ErrConflict is returned when a conditional write finds unexpected data.
+	ErrConflict = errors.New("record was modified concurrently")
+)
+
+// Repositories bundles every store the handlers depend on.
+type Repositories struct {
+	Accounts   AccountStore
+	Users      UserStore
+	Posts      PostStore
+	Comments   CommentStore
+	Videos     VideoStore
+	Tips       TipStore
+	Contest    ContestStore
+	Sessions   SessionStore
+	Resets     PasswordResetStore
+	Attempts   LoginAttemptStore
+	TwoFactor  TwoFactorStore
+	Usernames  UsernameStore
+	RenameJobs RenameJobStore
+	Deletions  DeletionStore
+	Audit      AuditStore
+	Exports    DataExportStore
+	Children   ChildStore
+	Milestones MilestoneStore
+	Growth     GrowthStore
+	Vaccines   VaccinationStore
+	Devices    DeviceStore
+	Inbox      NotificationStore
+	Prefs      PreferenceStore
+	LikeQueue  PendingLikeStore
+	Digests    DigestStore
+	Outbox     OutboxStore
+}
+
+// NewFirebase returns repositories backed by Firebase Auth and the Realtime Database.
+func NewFirebase(authClient *auth.Client, dbClient *db.Client) *Repositories {
+	return &Repositories{
+		Accounts:   &firebaseAccountStore{client: authClient},
+		Users:      &firebaseUserStore{db: dbClient},
+		Posts:      &firebasePostStore{db: dbClient},
+		Comments:   &firebaseCommentStore{db: dbClient},
+		Videos:     &firebaseVideoStore{db: dbClient},
+		Tips:       &firebaseTipStore{db: dbClient},
+		Contest:    &firebaseContestStore{db: dbClient},
+		Sessions:   &firebaseSessionStore{db: dbClient},
+		Resets:     &firebasePasswordResetStore{db: dbClient},
+		Attempts:   &firebaseLoginAttemptStore{db: dbClient},
+		TwoFactor:  &firebaseTwoFactorStore{db: dbClient},
+		Usernames:  &firebaseUsernameStore{db: dbClient},
+		RenameJobs: &firebaseRenameJobStore{db: dbClient},
+		Deletions:  &firebaseDeletionStore{db: dbClient},
+		Audit:      &firebaseAuditStore{db: dbClient},
+		Exports:    &firebaseDataExportStore{db: dbClient},
+		Children:   &firebaseChildStore{db: dbClient},
+		Milestones: &firebaseMilestoneStore{db: dbClient},
+		Growth:     &firebaseGrowthStore{db: dbClient},
+		Vaccines:   &firebaseVaccinationStore{db: dbClient},
+		Devices:    &firebaseDeviceStore{db: dbClient},
+		Inbox:      &firebaseNotificationStore{db: dbClient},
+		Prefs:      &firebasePreferenceStore{db: dbClient},
+		LikeQueue:  &firebasePendingLikeStore{db: dbClient},
+		Digests:    &firebaseDigestStore{db: dbClient},
+		Outbox:     &firebaseOutboxStore{db: dbClient},
+	}
+}
+
+// NewMemory returns repositories that keep all data in process memory.
+func NewMemory() *Repositories {
+	tree := newMemoryTree()
+	return &Repositories{
+		Accounts:   newMemoryAccountStore(),
+		Users:      &memoryUserStore{tree: tree},
+		Posts:      &memoryPostStore{tree: tree},
+		Comments:   &memoryCommentStore{tree: tree},
+		Videos:     &memoryVideoStore{tree: tree},
+		Tips:       &memoryTipStore{tree: tree},
+		Contest:    &memoryContestStore{tree: tree},
+		Sessions:   &memorySessionStore{tree: tree},
+		Resets:     &memoryPasswordResetStore{tree: tree},
+		Attempts:   &memoryLoginAttemptStore{tree: tree},
+		TwoFactor:  &memoryTwoFactorStore{tree: tree},
+		Usernames:  &memoryUsernameStore{tree: tree},
+		RenameJobs: &memoryRenameJobStore{tree: tree},
+		Deletions:  &memoryDeletionStore{tree: tree},
+		Audit:      &memoryAuditStore{tree: tree},
+		Exports:    &memoryDataExportStore{tree: tree},
+		Children:   &memoryChildStore{tree: tree},
+		Milestones: &memoryMilestoneStore{tree: tree},
+		Growth:     &memoryGrowthStore{tree: tree},
+		Vaccines:   &memoryVaccinationStore{tree: tree},
+		Devices:    &memoryDeviceStore{tree: tree},
+		Inbox:      &memoryNotificationStore{tree: tree},
+		Prefs:      &memoryPreferenceStore{tree: tree},
+		LikeQueue:  &memoryPendingLikeStore{tree: tree},
+		Digests:    &memoryDigestStore{tree: tree},
+		Outbox:     &memoryOutboxStore{tree: tree},
+	}
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"firebase.google.com/go/db"
+)
+
+// Session is a signed-in device. It holds the hash of the refresh token that
+// is currently valid for the device.
+type Session struct {
+	ID        string `json:"id"`
+	UID       string `json:"uid"`
+	Device    string `json:"device"`
+	TokenHash string `json:"token_hash"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+	Revoked   bool   `json:"revoked"`
+	// MFA is set when the session was started after a TOTP check.
+	MFA bool `json:"mfa"`
+	// PreviousHashes are the hashes of the refresh tokens rotated out,
+	// oldest first, kept to tell a replayed token from a wrong one.
+	PreviousHashes []string `json:"previous_hashes,omitempty"`
+}
+
+// maxPreviousHashes bounds Session.PreviousHashes.
+const maxPreviousHashes = 20
+
+// SessionStore manages the sessions stored under sessions/<id>.
+type SessionStore interface {
+	// Create stores a new session.
+	Create(ctx context.Context, s Session) error
+	// Get returns the session with the given ID.
+	Get(ctx context.Context, id string) (Session, error)
+	// Rotate replaces the refresh token hash of a session, but only if the
+	// current hash still equals oldHash. It returns ErrConflict otherwise.
+	Rotate(ctx context.Context, id, oldHash, newHash string, expiresAt int64) error
+	// Revoke marks a session as revoked.
+	Revoke(ctx context.Context, id string) error
+	// ListByUser returns every session of the given user.
+	ListByUser(ctx context.Context, uid string) (map[string]Session, error)
+	// DeleteAll removes every session of the given user.
+	DeleteAll(ctx context.Context, uid string) error
+}
+
+type firebaseSessionStore struct {
+	db *db.Client
+}
+
+func (s *firebaseSessionStore) Create(ctx context.Context, session Session) error {
+	return s.db.NewRef("sessions/"+session.ID).Set(ctx, session)
+}
+
+func (s *firebaseSessionStore) Get(ctx context.Context, id string) (Session, error) {
+	var session *Session
+	if err := s.db.NewRef("sessions/"+id).Get(ctx, &session); err != nil {
+		return Session{}, err
+	}
+	if session == nil {
+		return Session{}, ErrNotFound
+	}
+	return *session, nil
+}
+
+func (s *firebaseSessionStore) Rotate(ctx context.Context, id, oldHash, newHash string, expiresAt int64) error {
+	return s.db.NewRef("sessions/"+id).Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		var session *Session
+		if err := node.Unmarshal(&session); err != nil {
+			return nil, err
+		}
+		return rotateSession(session, oldHash, newHash, expiresAt)
+	})
+}
+
+func (s *firebaseSessionStore) Revoke(ctx context.Context, id string) error {
+	return s.db.NewRef("sessions/"+id).Update(ctx, map[string]interface{}{"revoked": true})
+}
+
+func (s *firebaseSessionStore) ListByUser(ctx context.Context, uid string) (map[string]Session, error) {
+	var sessions map[string]Session
+	if err := s.db.NewRef("sessions").OrderByChild("uid").EqualTo(uid).Get(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *firebaseSessionStore) DeleteAll(ctx context.Context, uid string) error {
+	sessions, err := s.ListByUser(ctx, uid)
+	if err != nil || len(sessions) == 0 {
+		return err
+	}
+	fields := make(map[string]interface{}, len(sessions))
+	for id := range sessions {
+		fields[id] = nil
+	}
+	return s.db.NewRef("sessions").Update(ctx, fields)
+}
+
+type memorySessionStore struct {
+	tree *memoryTree
+}
+
+func (s *memorySessionStore) Create(ctx context.Context, session Session) error {
+	return s.tree.Set("sessions/"+session.ID, session)
+}
+
+func (s *memorySessionStore) Get(ctx context.Context, id string) (Session, error) {
+	var session *Session
+	if err := s.tree.Get("sessions/"+id, &session); err != nil {
+		return Session{}, err
+	}
+	if session == nil {
+		return Session{}, ErrNotFound
+	}
+	return *session, nil
+}
+
+func (s *memorySessionStore) Rotate(ctx context.Context, id, oldHash, newHash string, expiresAt int64) error {
+	return s.tree.Transaction("sessions/"+id, func(current json.RawMessage) (interface{}, error) {
+		var session *Session
+		if err := json.Unmarshal(current, &session); err != nil {
+			return nil, err
+		}
+		return rotateSession(session, oldHash, newHash, expiresAt)
+	})
+}
+
+func (s *memorySessionStore) Revoke(ctx context.Context, id string) error {
+	return s.tree.Update("sessions/"+id, map[string]interface{}{"revoked": true})
+}
+
+func (s *memorySessionStore) ListByUser(ctx context.Context, uid string) (map[string]Session, error) {
+	var sessions map[string]Session
+	if err := s.tree.Get("sessions", &sessions); err != nil {
+		return nil, err
+	}
+	owned := make(map[string]Session)
+	for id, session := range sessions {
+		if session.UID == uid {
+			owned[id] = session
+		}
+	}
+	return owned, nil
+}
+
+func (s *memorySessionStore) DeleteAll(ctx context.Context, uid string) error {
+	sessions, err := s.ListByUser(ctx, uid)
+	if err != nil || len(sessions) == 0 {
+		return err
+	}
+	fields := make(map[string]interface{}, len(sessions))
+	for id := range sessions {
+		fields[id] = nil
+	}
+	return s.tree.Update("sessions", fields)
+}
+
+// rotateSession is the transaction body shared by both Rotate implementations.
+func rotateSession(session *Session, oldHash, newHash string, expiresAt int64) (interface{}, error) {
+	if session == nil {
+		return nil, ErrNotFound
+	}
+	if session.Revoked || session.TokenHash != oldHash {
+		return nil, ErrConflict
+	}
+	session.PreviousHashes = append(session.PreviousHashes, oldHash)
+	if n := len(session.PreviousHashes); n > maxPreviousHashes {
+		session.PreviousHashes = session.PreviousHashes[n-maxPreviousHashes:]
+	}
+	session.TokenHash = newHash
+	session.ExpiresAt = expiresAt
+	return session, nil
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"backend/model"
+	"context"
+
+	"firebase.google.com/go/db"
+)
+
+// TipStore manages the tip of the day stored under tips.
+type TipStore interface {
+	// Replace removes every existing tip and stores the given one.
+	Replace(ctx context.Context, tip model.Tip) error
+	// List returns the stored tips keyed by their push ID.
+	List(ctx context.Context) (map[string]model.Tip, error)
+}
+
+type firebaseTipStore struct {
+	db *db.Client
+}
+
+func (s *firebaseTipStore) Replace(ctx context.Context, tip model.Tip) error {
+	if err := s.db.NewRef("tips").Delete(ctx); err != nil {
+		return err
+	}
+	_, err := s.db.NewRef("tips").Push(ctx, tip)
+	return err
+}
+
+func (s *firebaseTipStore) List(ctx context.Context) (map[string]model.Tip, error) {
+	var tips map[string]model.Tip
+	if err := s.db.NewRef("tips").Get(ctx, &tips); err != nil {
+		return nil, err
+	}
+	return tips, nil
+}
+
+type memoryTipStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryTipStore) Replace(ctx context.Context, tip model.Tip) error {
+	if err := s.tree.Delete("tips"); err != nil {
+		return err
+	}
+	_, err := s.tree.Push("tips", tip)
+	return err
+}
+
+func (s *memoryTipStore) List(ctx context.Context) (map[string]model.Tip, error) {
+	var tips map[string]model.Tip
+	if err := s.tree.Get("tips", &tips); err != nil {
+		return nil, err
+	}
+	return tips, nil
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"firebase.google.com/go/db"
+)
+
+// TwoFactor is a user's TOTP enrolment.
+type TwoFactor struct {
+	// Secret is the active TOTP secret once Enabled is set.
+	Secret string `json:"secret"`
+	// PendingSecret is the secret handed out by setup and not yet confirmed.
+	PendingSecret string `json:"pending_secret"`
+	Enabled       bool   `json:"enabled"`
+	// RecoveryCodes maps the hash of each unused recovery code to true.
+	RecoveryCodes map[string]bool `json:"recovery_codes"`
+	// LastStep is the last TOTP time step accepted, to stop code replays.
+	LastStep int64 `json:"last_step"`
+}
+
+// TwoFactorStore manages the enrolments stored under two_factor/<uid>.
+type TwoFactorStore interface {
+	// Get returns the enrolment of uid, or the zero value if there is none.
+	Get(ctx context.Context, uid string) (TwoFactor, error)
+	// Update atomically replaces the enrolment of uid with fn's result.
+	// Returning an error from fn aborts the update.
+	Update(ctx context.Context, uid string, fn func(TwoFactor) (TwoFactor, error)) error
+	// Delete removes the enrolment of uid.
+	Delete(ctx context.Context, uid string) error
+}
+
+type firebaseTwoFactorStore struct {
+	db *db.Client
+}
+
+func (s *firebaseTwoFactorStore) Get(ctx context.Context, uid string) (TwoFactor, error) {
+	var tf TwoFactor
+	if err := s.db.NewRef("two_factor/"+uid).Get(ctx, &tf); err != nil {
+		return TwoFactor{}, err
+	}
+	return tf, nil
+}
+
+func (s *firebaseTwoFactorStore) Update(ctx context.Context, uid string, fn func(TwoFactor) (TwoFactor, error)) error {
+	return s.db.NewRef("two_factor/"+uid).Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		var tf TwoFactor
+		if err := node.Unmarshal(&tf); err != nil {
+			return nil, err
+		}
+		return fn(tf)
+	})
+}
+
+func (s *firebaseTwoFactorStore) Delete(ctx context.Context, uid string) error {
+	return s.db.NewRef("two_factor/" + uid).Delete(ctx)
+}
+
+type memoryTwoFactorStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryTwoFactorStore) Get(ctx context.Context, uid string) (TwoFactor, error) {
+	var tf TwoFactor
+	if err := s.tree.Get("two_factor/"+uid, &tf); err != nil {
+		return TwoFactor{}, err
+	}
+	return tf, nil
+}
+
+func (s *memoryTwoFactorStore) Update(ctx context.Context, uid string, fn func(TwoFactor) (TwoFactor, error)) error {
+	return s.tree.Transaction("two_factor/"+uid, func(current json.RawMessage) (interface{}, error) {
+		var tf TwoFactor
+		if err := json.Unmarshal(current, &tf); err != nil {
+			return nil, err
+		}
+		return fn(tf)
+	})
+}
+
+func (s *memoryTwoFactorStore) Delete(ctx context.Context, uid string) error {
+	return s.tree.Delete("two_factor/" + uid)
+}
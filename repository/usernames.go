@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"backend/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"firebase.google.com/go/db"
+)
+
+// UsernameStore manages the usernames/<name> → uid index that keeps
+// usernames unique. Names are stored in their normalized form.
+type UsernameStore interface {
+	// Claim reserves name for uid. It returns ErrUsernameTaken when another
+	// user holds the name; claiming a name uid already holds succeeds.
+	Claim(ctx context.Context, name, uid string) error
+	// Release frees name if it is held by uid.
+	Release(ctx context.Context, name, uid string) error
+	// Lookup returns the UID holding name.
+	Lookup(ctx context.Context, name string) (string, error)
+	// Backfilled reports whether the names of existing profiles were added
+	// to the index.
+	Backfilled(ctx context.Context) (bool, error)
+	// MarkBackfilled records that the names of existing profiles were added
+	// to the index.
+	MarkBackfilled(ctx context.Context) error
+}
+
+// backfilledPath records that BackfillUsernames has completed.
+const backfilledPath = "migrations/usernames_index"
+
+// BackfillUsernames adds the usernames of profiles created before the
+// usernames index existed to the index, so they cannot be claimed by anyone
+// else. It does nothing once it has completed. Names are claimed in UID
+// order; profiles whose username is not valid in its normalized form, or
+// normalizes to a name another user holds, are left out and returned keyed
+// by UID so they can be resolved by hand.
+func (r *Repositories) BackfillUsernames(ctx context.Context) (map[string]string, error) {
+	done, err := r.Usernames.Backfilled(ctx)
+	if err != nil || done {
+		return nil, err
+	}
+	users, err := r.Users.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	uids := make([]string, 0, len(users))
+	for uid := range users {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+
+	skipped := make(map[string]string)
+	for _, uid := range uids {
+		username := users[uid].Username
+		if username == "" {
+			continue
+		}
+		name, err := utils.NormalizeUsername(username)
+		if err != nil {
+			skipped[uid] = username
+			continue
+		}
+		if err := r.Usernames.Claim(ctx, name, uid); errors.Is(err, ErrUsernameTaken) {
+			skipped[uid] = username
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return skipped, r.Usernames.MarkBackfilled(ctx)
+}
+
+type firebaseUsernameStore struct {
+	db *db.Client
+}
+
+func (s *firebaseUsernameStore) Claim(ctx context.Context, name, uid string) error {
+	return s.db.NewRef("usernames/"+name).Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		var owner string
+		if err := node.Unmarshal(&owner); err != nil {
+			return nil, err
+		}
+		return claimUsername(owner, uid)
+	})
+}
+
+func (s *firebaseUsernameStore) Release(ctx context.Context, name, uid string) error {
+	return s.db.NewRef("usernames/"+name).Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		var owner string
+		if err := node.Unmarshal(&owner); err != nil {
+			return nil, err
+		}
+		return releaseUsername(owner, uid), nil
+	})
+}
+
+func (s *firebaseUsernameStore) Lookup(ctx context.Context, name string) (string, error) {
+	var uid string
+	if err := s.db.NewRef("usernames/"+name).Get(ctx, &uid); err != nil {
+		return "", err
+	}
+	if uid == "" {
+		return "", ErrNotFound
+	}
+	return uid, nil
+}
+
+func (s *firebaseUsernameStore) Backfilled(ctx context.Context) (bool, error) {
+	var done bool
+	if err := s.db.NewRef(backfilledPath).Get(ctx, &done); err != nil {
+		return false, err
+	}
+	return done, nil
+}
+
+func (s *firebaseUsernameStore) MarkBackfilled(ctx context.Context) error {
+	return s.db.NewRef(backfilledPath).Set(ctx, true)
+}
+
+type memoryUsernameStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryUsernameStore) Claim(ctx context.Context, name, uid string) error {
+	return s.tree.Transaction("usernames/"+name, func(current json.RawMessage) (interface{}, error) {
+		var owner string
+		if err := json.Unmarshal(current, &owner); err != nil {
+			return nil, err
+		}
+		return claimUsername(owner, uid)
+	})
+}
+
+func (s *memoryUsernameStore) Release(ctx context.Context, name, uid string) error {
+	return s.tree.Transaction("usernames/"+name, func(current json.RawMessage) (interface{}, error) {
+		var owner string
+		if err := json.Unmarshal(current, &owner); err != nil {
+			return nil, err
+		}
+		return releaseUsername(owner, uid), nil
+	})
+}
+
+func (s *memoryUsernameStore) Lookup(ctx context.Context, name string) (string, error) {
+	var uid string
+	if err := s.tree.Get("usernames/"+name, &uid); err != nil {
+		return "", err
+	}
+	if uid == "" {
+		return "", ErrNotFound
+	}
+	return uid, nil
+}
+
+func (s *memoryUsernameStore) Backfilled(ctx context.Context) (bool, error) {
+	var done bool
+	if err := s.tree.Get(backfilledPath, &done); err != nil {
+		return false, err
+	}
+	return done, nil
+}
+
+func (s *memoryUsernameStore) MarkBackfilled(ctx context.Context) error {
+	return s.tree.Set(backfilledPath, true)
+}
+
+// claimUsername is the transaction body shared by both Claim implementations.
+func claimUsername(owner, uid string) (interface{}, error) {
+	if owner != "" && owner != uid {
+		return nil, ErrUsernameTaken
+	}
+	return uid, nil
+}
+
+// releaseUsername is the transaction body shared by both Release
+// implementations. Returning nil deletes the entry.
+func releaseUsername(owner, uid string) interface{} {
+	if owner != uid {
+		return owner
+	}
+	return nil
+}
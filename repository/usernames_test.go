@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBackfillUsernames(t *testing.T) {
+	ctx := context.Background()
+	repos := NewMemory()
+	profiles := map[string]string{
+		"uid-1": "Alice",
+		"uid-2": "alice", // Same name as uid-1 once normalized
+		"uid-3": "bob",
+		"uid-4": "no spaces allowed",
+		"uid-5": "",
+	}
+	for uid, username := range profiles {
+		if err := repos.Users.Update(ctx, uid, map[string]interface{}{"username": username}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Names claimed since the index was introduced are kept
+	if err := repos.Usernames.Claim(ctx, "bob", "uid-6"); err != nil {
+		t.Fatal(err)
+	}
+
+	skipped, err := repos.BackfillUsernames(ctx)
+	if err != nil {
+		t.Fatalf("BackfillUsernames: %v", err)
+	}
+	want := map[string]string{"uid-2": "alice", "uid-3": "bob", "uid-4": "no spaces allowed"}
+	if !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped = %v, want %v", skipped, want)
+	}
+	for name, owner := range map[string]string{"alice": "uid-1", "bob": "uid-6"} {
+		if uid, err := repos.Usernames.Lookup(ctx, name); err != nil || uid != owner {
+			t.Errorf("Lookup(%q) = %q, %v, want %q", name, uid, err, owner)
+		}
+	}
+	if err := repos.Usernames.Claim(ctx, "alice", "uid-7"); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("Claim of a backfilled name = %v, want ErrUsernameTaken", err)
+	}
+
+	// Later runs do nothing
+	if err := repos.Users.Update(ctx, "uid-8", map[string]interface{}{"username": "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	if skipped, err := repos.BackfillUsernames(ctx); err != nil || len(skipped) != 0 {
+		t.Fatalf("second BackfillUsernames = %v, %v", skipped, err)
+	}
+	if _, err := repos.Usernames.Lookup(ctx, "carol"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second run indexed carol: %v", err)
+	}
+}
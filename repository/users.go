@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"backend/model"
+	"context"
+
+	"firebase.google.com/go/db"
+)
+
+// UserStore manages the profile stored under users/<uid>.
+type UserStore interface {
+	// Get returns the profile of the given user.
+	Get(ctx context.Context, uid string) (model.User, error)
+	// HashedPassword returns the bcrypt hash stored for the user.
+	HashedPassword(ctx context.Context, uid string) (string, error)
+	// Update writes the given profile fields, leaving the others untouched.
+	Update(ctx context.Context, uid string, fields map[string]interface{}) error
+	// Delete removes the user's profile.
+	Delete(ctx context.Context, uid string) error
+	// FindByUsername returns the UID and profile of the user with the given username.
+	FindByUsername(ctx context.Context, username string) (string, model.User, error)
+	// FindByPhone returns the UID and profile of the user with the given phone number.
+	FindByPhone(ctx context.Context, phone string) (string, model.User, error)
+	// All returns every profile keyed by UID.
+	All(ctx context.Context) (map[string]model.User, error)
+}
+
+type firebaseUserStore struct {
+	db *db.Client
+}
+
+func (s *firebaseUserStore) Get(ctx context.Context, uid string) (model.User, error) {
+	var user *model.User
+	if err := s.db.NewRef("users/"+uid).Get(ctx, &user); err != nil {
+		return model.User{}, err
+	}
+	if user == nil {
+		return model.User{}, ErrNotFound
+	}
+	return *user, nil
+}
+
+func (s *firebaseUserStore) HashedPassword(ctx context.Context, uid string) (string, error) {
+	var hashedPassword string
+	if err := s.db.NewRef("users/"+uid+"/hashed_password").Get(ctx, &hashedPassword); err != nil {
+		return "", err
+	}
+	if hashedPassword == "" {
+		return "", ErrNotFound
+	}
+	return hashedPassword, nil
+}
+
+func (s *firebaseUserStore) Update(ctx context.Context, uid string, fields map[string]interface{}) error {
+	return s.db.NewRef("users/"+uid).Update(ctx, fields)
+}
+
+func (s *firebaseUserStore) Delete(ctx context.Context, uid string) error {
+	return s.db.NewRef("users/" + uid).Delete(ctx)
+}
+
+func (s *firebaseUserStore) FindByUsername(ctx context.Context, username string) (string, model.User, error) {
+	return s.findBy(ctx, "username", username)
+}
+
+func (s *firebaseUserStore) FindByPhone(ctx context.Context, phone string) (string, model.User, error) {
+	return s.findBy(ctx, "phone_number", phone)
+}
+
+func (s *firebaseUserStore) All(ctx context.Context) (map[string]model.User, error) {
+	var users map[string]model.User
+	if err := s.db.NewRef("users").Get(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *firebaseUserStore) findBy(ctx context.Context, child, value string) (string, model.User, error) {
+	var users map[string]model.User
+	err := s.db.NewRef("users").
+		OrderByChild(child).
+		EqualTo(value).
+		LimitToFirst(1).
+		Get(ctx, &users)
+	if err != nil {
+		return "", model.User{}, err
+	}
+	for uid, user := range users {
+		return uid, user, nil
+	}
+	return "", model.User{}, ErrNotFound
+}
+
+type memoryUserStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryUserStore) Get(ctx context.Context, uid string) (model.User, error) {
+	var user *model.User
+	if err := s.tree.Get("users/"+uid, &user); err != nil {
+		return model.User{}, err
+	}
+	if user == nil {
+		return model.User{}, ErrNotFound
+	}
+	return *user, nil
+}
+
+func (s *memoryUserStore) HashedPassword(ctx context.Context, uid string) (string, error) {
+	var hashedPassword string
+	if err := s.tree.Get("users/"+uid+"/hashed_password", &hashedPassword); err != nil {
+		return "", err
+	}
+	if hashedPassword == "" {
+		return "", ErrNotFound
+	}
+	return hashedPassword, nil
+}
+
+func (s *memoryUserStore) Update(ctx context.Context, uid string, fields map[string]interface{}) error {
+	return s.tree.Update("users/"+uid, fields)
+}
+
+func (s *memoryUserStore) Delete(ctx context.Context, uid string) error {
+	return s.tree.Delete("users/" + uid)
+}
+
+func (s *memoryUserStore) FindByUsername(ctx context.Context, username string) (string, model.User, error) {
+	return s.findBy(func(u model.User) bool { return u.Username == username })
+}
+
+func (s *memoryUserStore) FindByPhone(ctx context.Context, phone string) (string, model.User, error) {
+	return s.findBy(func(u model.User) bool { return u.PhoneNumber == phone })
+}
+
+func (s *memoryUserStore) All(ctx context.Context) (map[string]model.User, error) {
+	var users map[string]model.User
+	if err := s.tree.Get("users", &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *memoryUserStore) findBy(match func(model.User) bool) (string, model.User, error) {
+	var users map[string]model.User
+	if err := s.tree.Get("users", &users); err != nil {
+		return "", model.User{}, err
+	}
+	for uid, user := range users {
+		if match(user) {
+			return uid, user, nil
+		}
+	}
+	return "", model.User{}, ErrNotFound
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"backend/model"
+	"context"
+
+	"firebase.google.com/go/db"
+)
+
+// VaccinationStore manages the doses given to each child under
+// vaccinations/<uid>/<child id>/<dose id>, and the reminders already sent
+// about them under vaccination_reminders/<uid>/<child id>/<dose id>/<kind>.
+type VaccinationStore interface {
+	// Given returns the doses given to a child keyed by dose ID.
+	Given(ctx context.Context, uid, childID string) (map[string]model.Vaccination, error)
+	// Record marks a dose as given.
+	Record(ctx context.Context, uid, childID, doseID string, v model.Vaccination) error
+	// Unrecord removes a dose recorded by mistake.
+	Unrecord(ctx context.Context, uid, childID, doseID string) error
+	// Reminders returns the Unix times reminders were sent, keyed by dose ID
+	// and then by kind.
+	Reminders(ctx context.Context, uid, childID string) (map[string]map[string]int64, error)
+	// MarkReminded records reminders as sent, keyed by "<dose id>/<kind>".
+	MarkReminded(ctx context.Context, uid, childID string, sent map[string]int64) error
+	// DeleteChild removes the doses and reminders of a child.
+	DeleteChild(ctx context.Context, uid, childID string) error
+	// DeleteAll removes the doses and reminders of every child of the given parent.
+	DeleteAll(ctx context.Context, uid string) error
+}
+
+func vaccinationPath(uid, childID string) string {
+	return "vaccinations/" + uid + "/" + childID
+}
+
+func reminderPath(uid, childID string) string {
+	return "vaccination_reminders/" + uid + "/" + childID
+}
+
+// remindedFields turns "<dose id>/<kind>" keys into an update of the
+// vaccination_reminders root.
+func remindedFields(uid, childID string, sent map[string]int64) map[string]interface{} {
+	fields := make(map[string]interface{}, len(sent))
+	for key, at := range sent {
+		fields[uid+"/"+childID+"/"+key] = at
+	}
+	return fields
+}
+
+type firebaseVaccinationStore struct {
+	db *db.Client
+}
+
+func (s *firebaseVaccinationStore) Given(ctx context.Context, uid, childID string) (map[string]model.Vaccination, error) {
+	var given map[string]model.Vaccination
+	if err := s.db.NewRef(vaccinationPath(uid, childID)).Get(ctx, &given); err != nil {
+		return nil, err
+	}
+	return given, nil
+}
+
+func (s *firebaseVaccinationStore) Record(ctx context.Context, uid, childID, doseID string, v model.Vaccination) error {
+	return s.db.NewRef(vaccinationPath(uid, childID)+"/"+doseID).Set(ctx, v)
+}
+
+func (s *firebaseVaccinationStore) Unrecord(ctx context.Context, uid, childID, doseID string) error {
+	return s.db.NewRef(vaccinationPath(uid, childID) + "/" + doseID).Delete(ctx)
+}
+
+func (s *firebaseVaccinationStore) Reminders(ctx context.Context, uid, childID string) (map[string]map[string]int64, error) {
+	var reminders map[string]map[string]int64
+	if err := s.db.NewRef(reminderPath(uid, childID)).Get(ctx, &reminders); err != nil {
+		return nil, err
+	}
+	return reminders, nil
+}
+
+func (s *firebaseVaccinationStore) MarkReminded(ctx context.Context, uid, childID string, sent map[string]int64) error {
+	return s.db.NewRef("vaccination_reminders").Update(ctx, remindedFields(uid, childID, sent))
+}
+
+func (s *firebaseVaccinationStore) DeleteChild(ctx context.Context, uid, childID string) error {
+	return s.db.NewRef("/").Update(ctx, map[string]interface{}{
+		vaccinationPath(uid, childID): nil,
+		reminderPath(uid, childID):    nil,
+	})
+}
+
+func (s *firebaseVaccinationStore) DeleteAll(ctx context.Context, uid string) error {
+	return s.db.NewRef("/").Update(ctx, map[string]interface{}{
+		"vaccinations/" + uid:          nil,
+		"vaccination_reminders/" + uid: nil,
+	})
+}
+
+type memoryVaccinationStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryVaccinationStore) Given(ctx context.Context, uid, childID string) (map[string]model.Vaccination, error) {
+	var given map[string]model.Vaccination
+	if err := s.tree.Get(vaccinationPath(uid, childID), &given); err != nil {
+		return nil, err
+	}
+	return given, nil
+}
+
+func (s *memoryVaccinationStore) Record(ctx context.Context, uid, childID, doseID string, v model.Vaccination) error {
+	return s.tree.Set(vaccinationPath(uid, childID)+"/"+doseID, v)
+}
+
+func (s *memoryVaccinationStore) Unrecord(ctx context.Context, uid, childID, doseID string) error {
+	return s.tree.Delete(vaccinationPath(uid, childID) + "/" + doseID)
+}
+
+func (s *memoryVaccinationStore) Reminders(ctx context.Context, uid, childID string) (map[string]map[string]int64, error) {
+	var reminders map[string]map[string]int64
+	if err := s.tree.Get(reminderPath(uid, childID), &reminders); err != nil {
+		return nil, err
+	}
+	return reminders, nil
+}
+
+func (s *memoryVaccinationStore) MarkReminded(ctx context.Context, uid, childID string, sent map[string]int64) error {
+	return s.tree.Update("vaccination_reminders", remindedFields(uid, childID, sent))
+}
+
+func (s *memoryVaccinationStore) DeleteChild(ctx context.Context, uid, childID string) error {
+	return s.tree.Update("/", map[string]interface{}{
+		vaccinationPath(uid, childID): nil,
+		reminderPath(uid, childID):    nil,
+	})
+}
+
+func (s *memoryVaccinationStore) DeleteAll(ctx context.Context, uid string) error {
+	return s.tree.Update("/", map[string]interface{}{
+		"vaccinations/" + uid:          nil,
+		"vaccination_reminders/" + uid: nil,
+	})
+}
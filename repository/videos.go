@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"backend/model"
+	"context"
+
+	"firebase.google.com/go/db"
+)
+
+// VideoStore manages the videos stored under videos/<id> and top_videos/<id>.
+type VideoStore interface {
+	// Save writes a video under the given ID.
+	Save(ctx context.Context, id string, video model.Video) error
+	// List returns every video.
+	List(ctx context.Context) (map[string]model.Video, error)
+	// SaveTop writes a top video under the given ID.
+	SaveTop(ctx context.Context, id string, video model.Video) error
+	// ListTop returns every top video.
+	ListTop(ctx context.Context) (map[string]model.Video, error)
+}
+
+type firebaseVideoStore struct {
+	db *db.Client
+}
+
+func (s *firebaseVideoStore) Save(ctx context.Context, id string, video model.Video) error {
+	return s.db.NewRef("videos/"+id).Set(ctx, video)
+}
+
+func (s *firebaseVideoStore) List(ctx context.Context) (map[string]model.Video, error) {
+	var videos map[string]model.Video
+	if err := s.db.NewRef("videos").Get(ctx, &videos); err != nil {
+		return nil, err
+	}
+	return videos, nil
+}
+
+func (s *firebaseVideoStore) SaveTop(ctx context.Context, id string, video model.Video) error {
+	return s.db.NewRef("top_videos/"+id).Set(ctx, video)
+}
+
+func (s *firebaseVideoStore) ListTop(ctx context.Context) (map[string]model.Video, error) {
+	var videos map[string]model.Video
+	if err := s.db.NewRef("top_videos").Get(ctx, &videos); err != nil {
+		return nil, err
+	}
+	return videos, nil
+}
+
+type memoryVideoStore struct {
+	tree *memoryTree
+}
+
+func (s *memoryVideoStore) Save(ctx context.Context, id string, video model.Video) error {
+	return s.tree.Set("videos/"+id, video)
+}
+
+func (s *memoryVideoStore) List(ctx context.Context) (map[string]model.Video, error) {
+	var videos map[string]model.Video
+	if err := s.tree.Get("videos", &videos); err != nil {
+		return nil, err
+	}
+	return videos, nil
+}
+
+func (s *memoryVideoStore) SaveTop(ctx context.Context, id string, video model.Video) error {
+	return s.tree.Set("top_videos/"+id, video)
+}
+
+func (s *memoryVideoStore) ListTop(ctx context.Context) (map[string]model.Video, error) {
+	var videos map[string]model.Video
+	if err := s.tree.Get("top_videos", &videos); err != nil {
+		return nil, err
+	}
+	return videos, nil
+}
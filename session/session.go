@@ -0,0 +1,372 @@
+// Package session issues the access and refresh tokens returned by /login.
+//
+// Access tokens are short-lived HS256 JWTs. Refresh tokens are opaque
+// "<session id>.<secret>" strings whose hash is stored server-side, one
+// session per signed-in device. Every refresh rotates the secret; presenting
+// an already rotated secret revokes the whole session, while a secret that
+// was never issued is only rejected.
+package session
+
+import (
+	"backend/middleware"
+	"backend/repository"
+	"backend/utils"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidToken is returned for malformed, forged or unknown tokens.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpired is returned for tokens past their expiry time.
+	ErrExpired = errors.New("token expired")
+	// ErrRevoked is returned for tokens of a revoked session.
+	ErrRevoked = errors.New("session revoked")
+	// ErrReused is returned when a refresh token that was already rotated is
+	// presented again. The session is revoked when this happens.
+	ErrReused = errors.New("refresh token reused")
+)
+
+// challengePurpose marks tokens that only prove the password step of a
+// two-factor login.
+const challengePurpose = "2fa"
+
+// unsubscribePurpose marks the tokens of emailed unsubscribe links.
+const unsubscribePurpose = "unsubscribe"
+
+// unsubscribeTTL is how long an unsubscribe link keeps working.
+const unsubscribeTTL = 365 * 24 * time.Hour
+
+// Default token lifetimes.
+const (
+	DefaultAccessTTL    = 15 * time.Minute
+	DefaultRefreshTTL   = 30 * 24 * time.Hour
+	DefaultChallengeTTL = 5 * time.Minute
+)
+
+// Tokens is the credential pair handed to a client.
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// Claims are the fields carried by an access token.
+type Claims struct {
+	Subject   string `json:"sub"`
+	SessionID string `json:"sid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	// MFA is set when the session passed a TOTP check.
+	MFA bool `json:"mfa,omitempty"`
+	// Purpose is empty for access tokens, "2fa" for login challenges and
+	// "unsubscribe" for unsubscribe links.
+	Purpose string `json:"pur,omitempty"`
+}
+
+// Manager issues, refreshes and revokes session tokens.
+type Manager struct {
+	store        repository.SessionStore
+	secret       []byte
+	AccessTTL    time.Duration
+	RefreshTTL   time.Duration
+	ChallengeTTL time.Duration
+	// Now returns the current time; tests may replace it.
+	Now func() time.Time
+}
+
+// NewManager returns a Manager that signs access tokens with secret and keeps
+// sessions in store.
+func NewManager(store repository.SessionStore, secret []byte) *Manager {
+	return &Manager{
+		store:        store,
+		secret:       secret,
+		AccessTTL:    DefaultAccessTTL,
+		RefreshTTL:   DefaultRefreshTTL,
+		ChallengeTTL: DefaultChallengeTTL,
+		Now:          time.Now,
+	}
+}
+
+// Issue starts a new session for uid on the named device. mfa records
+// whether the user passed a TOTP check.
+func (m *Manager) Issue(ctx context.Context, uid, device string, mfa bool) (Tokens, error) {
+	secret, err := utils.RandomToken()
+	if err != nil {
+		return Tokens{}, err
+	}
+	now := m.Now()
+	s := repository.Session{
+		ID:        uuid.New().String(),
+		UID:       uid,
+		Device:    device,
+		TokenHash: utils.HashToken(secret),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(m.RefreshTTL).Unix(),
+		MFA:       mfa,
+	}
+	if err := m.store.Create(ctx, s); err != nil {
+		return Tokens{}, err
+	}
+	return m.tokens(s, secret)
+}
+
+// Refresh exchanges a refresh token for a new token pair, rotating the
+// refresh token. Reusing an old refresh token revokes the session; a
+// secret that never belonged to the session is rejected with
+// ErrInvalidToken, as the session ID alone is no secret.
+func (m *Manager) Refresh(ctx context.Context, refreshToken string) (Tokens, error) {
+	s, secret, err := m.lookup(ctx, refreshToken)
+	if err != nil {
+		return Tokens{}, err
+	}
+	hash := utils.HashToken(secret)
+	if s.TokenHash != hash {
+		if slices.Contains(s.PreviousHashes, hash) {
+			return Tokens{}, m.reused(ctx, s.ID)
+		}
+		return Tokens{}, ErrInvalidToken
+	}
+
+	next, err := utils.RandomToken()
+	if err != nil {
+		return Tokens{}, err
+	}
+	expiresAt := m.Now().Add(m.RefreshTTL).Unix()
+	err = m.store.Rotate(ctx, s.ID, s.TokenHash, utils.HashToken(next), expiresAt)
+	if errors.Is(err, repository.ErrConflict) {
+		// Another request rotated the token first, so this one is a replay
+		return Tokens{}, m.reused(ctx, s.ID)
+	}
+	if err != nil {
+		return Tokens{}, err
+	}
+	s.ExpiresAt = expiresAt
+	return m.tokens(s, next)
+}
+
+// Owner returns the UID of the live session refreshToken belongs to. It
+// does not check the secret; Refresh does.
+func (m *Manager) Owner(ctx context.Context, refreshToken string) (string, error) {
+	s, _, err := m.lookup(ctx, refreshToken)
+	if err != nil {
+		return "", err
+	}
+	return s.UID, nil
+}
+
+// Revoke ends the session identified by refreshToken. The caller must own it.
+func (m *Manager) Revoke(ctx context.Context, uid, refreshToken string) error {
+	id, _, ok := strings.Cut(refreshToken, ".")
+	if !ok {
+		return ErrInvalidToken
+	}
+	s, err := m.store.Get(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && s.UID != uid) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	return m.store.Revoke(ctx, s.ID)
+}
+
+// RevokeAll ends every session of uid.
+func (m *Manager) RevokeAll(ctx context.Context, uid string) error {
+	return m.RevokeOthers(ctx, uid, "")
+}
+
+// RevokeOthers ends every session of uid except the one with ID keep.
+func (m *Manager) RevokeOthers(ctx context.Context, uid, keep string) error {
+	sessions, err := m.store.ListByUser(ctx, uid)
+	if err != nil {
+		return err
+	}
+	for id, s := range sessions {
+		if s.Revoked || id == keep {
+			continue
+		}
+		if err := m.store.Revoke(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify checks an access token and returns its claims. Tokens of revoked
+// sessions are rejected.
+func (m *Manager) Verify(ctx context.Context, token string) (Claims, error) {
+	claims, err := m.parse(token)
+	if err != nil {
+		return Claims{}, err
+	}
+	if claims.Purpose != "" {
+		return Claims{}, ErrInvalidToken
+	}
+	s, err := m.store.Get(ctx, claims.SessionID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return Claims{}, ErrInvalidToken
+	}
+	if err != nil {
+		return Claims{}, err
+	}
+	if s.Revoked {
+		return Claims{}, ErrRevoked
+	}
+	return claims, nil
+}
+
+// VerifyToken implements middleware.TokenVerifier.
+func (m *Manager) VerifyToken(ctx context.Context, token string) (middleware.Identity, error) {
+	claims, err := m.Verify(ctx, token)
+	if err != nil {
+		return middleware.Identity{}, err
+	}
+	return middleware.Identity{UID: claims.Subject, SecondFactor: claims.MFA, SessionID: claims.SessionID}, nil
+}
+
+// IssueChallenge returns a short-lived token proving that uid passed the
+// password step of a two-factor login.
+func (m *Manager) IssueChallenge(uid string) (string, error) {
+	now := m.Now()
+	return m.sign(Claims{
+		Subject:   uid,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(m.ChallengeTTL).Unix(),
+		Purpose:   challengePurpose,
+	})
+}
+
+// VerifyChallenge checks a token from IssueChallenge and returns its UID.
+func (m *Manager) VerifyChallenge(token string) (string, error) {
+	claims, err := m.parse(token)
+	if err != nil {
+		return "", err
+	}
+	if claims.Purpose != challengePurpose {
+		return "", ErrInvalidToken
+	}
+	return claims.Subject, nil
+}
+
+// IssueUnsubscribe returns a token for the unsubscribe link of emails sent
+// to uid.
+func (m *Manager) IssueUnsubscribe(uid string) (string, error) {
+	now := m.Now()
+	return m.sign(Claims{
+		Subject:   uid,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(unsubscribeTTL).Unix(),
+		Purpose:   unsubscribePurpose,
+	})
+}
+
+// VerifyUnsubscribe checks a token from IssueUnsubscribe and returns its UID.
+func (m *Manager) VerifyUnsubscribe(token string) (string, error) {
+	claims, err := m.parse(token)
+	if err != nil {
+		return "", err
+	}
+	if claims.Purpose != unsubscribePurpose {
+		return "", ErrInvalidToken
+	}
+	return claims.Subject, nil
+}
+
+func (m *Manager) lookup(ctx context.Context, refreshToken string) (repository.Session, string, error) {
+	id, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || id == "" || secret == "" {
+		return repository.Session{}, "", ErrInvalidToken
+	}
+	s, err := m.store.Get(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return repository.Session{}, "", ErrInvalidToken
+	}
+	if err != nil {
+		return repository.Session{}, "", err
+	}
+	if s.Revoked {
+		return repository.Session{}, "", ErrRevoked
+	}
+	if m.Now().Unix() >= s.ExpiresAt {
+		return repository.Session{}, "", ErrExpired
+	}
+	return s, secret, nil
+}
+
+func (m *Manager) reused(ctx context.Context, id string) error {
+	if err := m.store.Revoke(ctx, id); err != nil {
+		return err
+	}
+	return ErrReused
+}
+
+func (m *Manager) tokens(s repository.Session, secret string) (Tokens, error) {
+	now := m.Now()
+	access, err := m.sign(Claims{
+		Subject:   s.UID,
+		SessionID: s.ID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(m.AccessTTL).Unix(),
+		MFA:       s.MFA,
+	})
+	if err != nil {
+		return Tokens{}, err
+	}
+	return Tokens{
+		AccessToken:  access,
+		RefreshToken: s.ID + "." + secret,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(m.AccessTTL / time.Second),
+	}, nil
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+func (m *Manager) sign(c Claims) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + m.signature(unsigned), nil
+}
+
+func (m *Manager) parse(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return Claims{}, ErrInvalidToken
+	}
+	unsigned := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(m.signature(unsigned))) {
+		return Claims{}, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	var c Claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	if m.Now().Unix() >= c.ExpiresAt {
+		return Claims{}, ErrExpired
+	}
+	return c, nil
+}
+
+func (m *Manager) signature(unsigned string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
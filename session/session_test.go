@@ -0,0 +1,205 @@
+package session
+
+import (
+	"backend/repository"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestManager returns a Manager on the memory SessionStore whose clock
+// is *now.
+func newTestManager(now *time.Time) (*Manager, repository.SessionStore) {
+	store := repository.NewMemory().Sessions
+	m := NewManager(store, []byte("test-secret"))
+	m.Now = func() time.Time { return *now }
+	return m, store
+}
+
+func TestRefresh(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		// token returns the refresh token to present, given the tokens of
+		// the initial login and of one refresh made with them.
+		token       func(first, second Tokens) string
+		advance     time.Duration
+		wantErr     error
+		wantRevoked bool
+	}{
+		{
+			name:  "current token rotates",
+			token: func(first, second Tokens) string { return second.RefreshToken },
+		},
+		{
+			name:        "rotated token is reuse",
+			token:       func(first, second Tokens) string { return first.RefreshToken },
+			wantErr:     ErrReused,
+			wantRevoked: true,
+		},
+		{
+			name: "wrong secret is rejected",
+			token: func(first, second Tokens) string {
+				id, _, _ := strings.Cut(second.RefreshToken, ".")
+				return id + ".not-the-secret"
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "unknown session",
+			token:   func(first, second Tokens) string { return "missing.secret" },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "malformed token",
+			token:   func(first, second Tokens) string { return "no-separator" },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "expired session",
+			token:   func(first, second Tokens) string { return second.RefreshToken },
+			advance: DefaultRefreshTTL + time.Second,
+			wantErr: ErrExpired,
+		},
+		{
+			name:    "still valid just before expiry",
+			token:   func(first, second Tokens) string { return second.RefreshToken },
+			advance: DefaultRefreshTTL - time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			now := start
+			m, store := newTestManager(&now)
+
+			first, err := m.Issue(ctx, "uid-1", "phone", false)
+			if err != nil {
+				t.Fatalf("Issue: %v", err)
+			}
+			second, err := m.Refresh(ctx, first.RefreshToken)
+			if err != nil {
+				t.Fatalf("first Refresh: %v", err)
+			}
+
+			now = now.Add(tt.advance)
+			got, err := m.Refresh(ctx, tt.token(first, second))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Refresh error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (got.RefreshToken == second.RefreshToken || got.AccessToken == "") {
+				t.Errorf("Refresh did not rotate the tokens")
+			}
+
+			id, _, _ := strings.Cut(first.RefreshToken, ".")
+			s, err := store.Get(ctx, id)
+			if err != nil {
+				t.Fatalf("Get session: %v", err)
+			}
+			if s.Revoked != tt.wantRevoked {
+				t.Errorf("session revoked = %v, want %v", s.Revoked, tt.wantRevoked)
+			}
+		})
+	}
+}
+
+func TestRefreshAfterWrongSecretKeepsSession(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	m, _ := newTestManager(&now)
+
+	tokens, err := m.Issue(ctx, "uid-1", "phone", false)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	id, _, _ := strings.Cut(tokens.RefreshToken, ".")
+	if _, err := m.Refresh(ctx, id+".guessed"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Refresh with a guessed secret = %v, want ErrInvalidToken", err)
+	}
+	if _, err := m.Refresh(ctx, tokens.RefreshToken); err != nil {
+		t.Fatalf("Refresh with the real token after a guess: %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		revoke  bool
+		wantErr error
+	}{
+		{name: "fresh token"},
+		{name: "expired token", advance: DefaultAccessTTL + time.Second, wantErr: ErrExpired},
+		{name: "revoked session", revoke: true, wantErr: ErrRevoked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			now := start
+			m, _ := newTestManager(&now)
+
+			tokens, err := m.Issue(ctx, "uid-1", "phone", true)
+			if err != nil {
+				t.Fatalf("Issue: %v", err)
+			}
+			if tt.revoke {
+				if err := m.Revoke(ctx, "uid-1", tokens.RefreshToken); err != nil {
+					t.Fatalf("Revoke: %v", err)
+				}
+			}
+
+			now = now.Add(tt.advance)
+			claims, err := m.Verify(ctx, tokens.AccessToken)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (claims.Subject != "uid-1" || !claims.MFA) {
+				t.Errorf("Verify claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestRevokeOthers(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	m, _ := newTestManager(&now)
+
+	current, err := m.Issue(ctx, "uid-1", "phone", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := m.Issue(ctx, "uid-1", "tablet", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger, err := m.Issue(ctx, "uid-2", "phone", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := m.Verify(ctx, current.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RevokeOthers(ctx, "uid-1", claims.SessionID); err != nil {
+		t.Fatalf("RevokeOthers: %v", err)
+	}
+
+	if _, err := m.Verify(ctx, current.AccessToken); err != nil {
+		t.Errorf("current session: %v", err)
+	}
+	if _, err := m.Verify(ctx, other.AccessToken); !errors.Is(err, ErrRevoked) {
+		t.Errorf("other session = %v, want ErrRevoked", err)
+	}
+	if _, err := m.Verify(ctx, stranger.AccessToken); err != nil {
+		t.Errorf("another user's session: %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+package utils
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+)
+
+// ValidateEmail checks that email is a single bare address such as
+// "name@example.com".
+func ValidateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || !strings.Contains(email[strings.LastIndex(email, "@")+1:], ".") {
+		return errors.New("Invalid email address")
+	}
+	return nil
+}
@@ -1,9 +1,10 @@
 package utils
 
 import (
+	"backend/logging"
 	"context"
 	"errors"
-	"log"
+	"log/slog"
 	"os"
 
 	firebase "firebase.google.com/go"
@@ -15,43 +16,43 @@ import (
 )
 
 var (
-	FirebaseAuth *auth.Client
-	FirebaseDB   *db.Client
-	fcmClient    *messaging.Client
+	FirebaseAuth      *auth.Client
+	FirebaseDB        *db.Client
+	FirebaseMessaging *messaging.Client
 )
 
 func InitFirebase() {
 	err := godotenv.Load()
 	if err != nil {
-		log.Fatalf("Error loading .env file: %v\n", err)
+		logging.Fatal("Error loading .env file", "error", err)
 	}
 
 	opt := option.WithCredentialsFile("firebase.json")
 	app, err := firebase.NewApp(context.Background(), nil, opt)
 	if err != nil {
-		log.Fatalf("Error initializing Firebase app: %v\n", err)
+		logging.Fatal("Error initializing Firebase app", "error", err)
 	}
 
 	FirebaseAuth, err = app.Auth(context.Background())
 	if err != nil {
-		log.Fatalf("Error initializing Firebase Auth client: %v\n", err)
+		logging.Fatal("Error initializing Firebase Auth client", "error", err)
 	}
 
 	// Get the database URL from the environment variables
 	databaseURL := os.Getenv("FIREBASE_DATABASE_URL")
 	if databaseURL == "" {
-		log.Fatalf("FIREBASE_DATABASE_URL not set in .env file")
+		logging.Fatal("FIREBASE_DATABASE_URL not set in .env file")
 	}
 
 	// Initialize the Firebase Database client with the database URL
 	FirebaseDB, err = app.DatabaseWithURL(context.Background(), databaseURL)
 	if err != nil {
-		log.Fatalf("Error initializing Firebase Database client: %v\n", err)
+		logging.Fatal("Error initializing Firebase Database client", "error", err)
 	}
 
-	fcmClient, err = app.Messaging(context.Background())
+	FirebaseMessaging, err = app.Messaging(context.Background())
 	if err != nil {
-		log.Fatalf("Error initializing Firebase Cloud Messaging client: %v\n", err)
+		logging.Fatal("Error initializing Firebase Cloud Messaging client", "error", err)
 	}
 }
 
@@ -59,26 +60,10 @@ func VerifyIDToken(idToken string) (*auth.Token, error) {
 	// Verify the token using the Firebase Auth client
 	token, err := FirebaseAuth.VerifyIDToken(context.Background(), idToken)
 	if err != nil {
-		log.Printf("Error verifying ID token: %v", err)
+		slog.Error("Error verifying ID token", "error", err)
 		return nil, errors.New("Invalid or expired token")
 	}
 
 	// Return the decoded token (which contains the user's UID and other claims)
 	return token, nil
 }
-
-func SendNotificationToTopic(topic, title, body string) error {
-	message := &messaging.Message{
-		Notification: &messaging.Notification{
-			Title: title,
-			Body:  body,
-		},
-		Topic: topic,
-	}
-
-	_, err := fcmClient.Send(context.Background(), message)
-	if err != nil {
-		return err
-	}
-	return nil
-}
@@ -1,147 +1,236 @@
 package utils
 
 import (
+	"backend/mail"
 	"context"
+	"errors"
 	"fmt"
-	"net/smtp"
 	"os"
+	"strings"
+	"time"
 
 	"firebase.google.com/go/auth"
 )
 
+// ErrAuthNotInitialized is returned by the action-link helpers when Firebase Auth
+// has not been initialized, e.g. when running with in-memory storage.
+var ErrAuthNotInitialized = errors.New("Firebase Auth is not initialized")
+
+// AppBaseURL returns the public URL of the web app used in emailed links. It
+// defaults to https://wegrowparenting.com and can be overridden with APP_BASE_URL.
+func AppBaseURL() string {
+	if url := os.Getenv("APP_BASE_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return "https://wegrowparenting.com"
+}
+
+// Mailer sends the emails of the helpers in this file. It is set at
+// startup, normally to the outbox so that sending never blocks a request.
+var Mailer mail.Mailer
+
+// ErrMailNotInitialized is returned by SendEmail when Mailer is not set.
+var ErrMailNotInitialized = errors.New("mailer is not initialized")
+
 // SendEmail Function to send email
 func SendEmail(to, subject, body string) error {
-	// SMTP configuration
-	smtpHost := "smtp.gmail.com"
-	smtpPort := 587
-	smtpUsername := os.Getenv("SMTP_USERNAME")
-	smtpPassword := os.Getenv("SMTP_PASSWORD")
-	from := smtpUsername
-
-	// Constructing email headers
-	headers := make(map[string]string)
-	headers["From"] = from
-	headers["To"] = to
-	headers["Subject"] = subject
-	headers["MIME-Version"] = "1.0"
-	headers["Content-Type"] = "text/html; charset=\"utf-8\""
-
-	// Compose the email message
-	var msg string
-	for key, value := range headers {
-		msg += fmt.Sprintf("%s: %s\r\n", key, value)
-	}
-	msg += "\r\n" + body
-
-	// Connect to the SMTP server with TLS
-	auth := smtp.PlainAuth("", smtpUsername, smtpPassword, smtpHost)
-	err := smtp.SendMail(fmt.Sprintf("%s:%d", smtpHost, smtpPort), auth, from, []string{to}, []byte(msg))
+	return send(mail.Message{To: to, Subject: subject, HTML: body})
+}
+
+// sendTemplate renders the email template name in lang and sends it to to.
+func sendTemplate(to, lang, name string, data interface{}) error {
+	m, err := mail.Render(to, lang, name, data)
 	if err != nil {
-		return fmt.Errorf("failed to send email: %v", err)
+		return fmt.Errorf("failed to render %s email: %v", name, err)
 	}
+	return send(m)
+}
 
+func send(m mail.Message) error {
+	if Mailer == nil {
+		return ErrMailNotInitialized
+	}
+	if err := Mailer.Send(context.Background(), m); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
 	return nil
 }
 
-func SendVerificationEmail(user *auth.UserRecord) error {
+// LinkEmail is the data of the emails that carry a single link.
+type LinkEmail struct {
+	Link string
+}
+
+// verificationLink returns a Firebase email verification link for email.
+func verificationLink(email string) (string, error) {
+	if FirebaseAuth == nil {
+		return "", ErrAuthNotInitialized
+	}
+
 	// Generate email verification link with settings
 	settings := &auth.ActionCodeSettings{
-		URL:             "https://wegrowparenting.com",
+		URL:             AppBaseURL(),
 		HandleCodeInApp: true,
 	}
-	// Send email with the verification link
-	link, err := FirebaseAuth.EmailVerificationLinkWithSettings(context.Background(), user.Email, settings)
+	link, err := FirebaseAuth.EmailVerificationLinkWithSettings(context.Background(), email, settings)
+	if err != nil {
+		return "", fmt.Errorf("error generating email verification link: %v", err)
+	}
+	return link, nil
+}
+
+// SendVerificationEmail emails a Firebase email verification link to email
+// in the language lang.
+func SendVerificationEmail(email, lang string) error {
+	link, err := verificationLink(email)
 	if err != nil {
-		return fmt.Errorf("error generating email verification link: %v", err)
-	}
-	// Log the verification link
-	fmt.Printf("Verification link for user %s: %s\n", user.Email, link)
-
-	// Construct the email body with the new content
-	body := fmt.Sprintf(`
-		<p>To get started, please confirm your email address by clicking the button below:</p>
-		<p><a href="%s">🔗 Verify Your Email</a></p>
-		<p>Once your email is verified, you’ll gain full access to our platform and resources.</p>
-		<p>Best regards,</p>
-		<p>The We Grow Team</>
-	`, link)
-
-	// Call the sendEmail function to send the email
-	err = SendEmail(user.Email, "Confirm Your Email ID", body)
+		return err
+	}
+
+	err = sendTemplate(email, lang, "verify_email", LinkEmail{Link: link})
 	if err != nil {
 		return fmt.Errorf("error sending email: %v", err)
 	}
+	return nil
+}
 
-	fmt.Println("Verification email sent successfully")
+// SendPasswordResetEmail emails the given server-side reset link to the user
+func SendPasswordResetEmail(email, lang, link string) error {
+	err := sendTemplate(email, lang, "password_reset", LinkEmail{Link: link})
+	if err != nil {
+		return fmt.Errorf("error sending password reset email: %v", err)
+	}
 	return nil
 }
 
-func SendPasswordResetEmail(email string) error {
-	// Generate password reset link
-	settings := &auth.ActionCodeSettings{
-		URL:             "https://wegrowparenting.com",
-		HandleCodeInApp: true,
+// ResendVerificationEmail sends another verification email to an existing
+// account.
+func ResendVerificationEmail(email, lang string) error {
+	if FirebaseAuth == nil {
+		return ErrAuthNotInitialized
 	}
 
-	link, err := FirebaseAuth.PasswordResetLinkWithSettings(context.Background(), email, settings)
+	_, err := FirebaseAuth.GetUserByEmail(context.Background(), email)
 	if err != nil {
-		return fmt.Errorf("error generating password reset link: %v", err)
+		return fmt.Errorf("error fetching user data: %v", err)
 	}
-	// Log the password reset link
-	fmt.Printf("Password reset link for user %s: %s\n", email, link)
+	return SendVerificationEmail(email, lang)
+}
 
-	// Construct the email body with engaging content
-	body := fmt.Sprintf(`
-		<p>We understand that sometimes passwords slip our minds. No worries! You can reset your password quickly by clicking the link below.</p>
-		<p><a href="%s">🔗 Reset Your Password</a></p>
-		<p>Need further assistance? Feel free to reach out to our support team. We’re always here to help!</p>
-		<p>Warm regards,<br/>We Grow Team</p>
-	`, link)
+// AccountLockedEmail is the data of the account_locked email.
+type AccountLockedEmail struct {
+	Until time.Time
+}
 
-	// Call the sendEmail function to send the email
-	err = SendEmail(email, "Reset Your Password - We Grow", body)
+// SendAccountLockedEmail tells the owner that their account was locked after
+// repeated failed sign-in attempts.
+func SendAccountLockedEmail(email, lang string, until time.Time) error {
+	err := sendTemplate(email, lang, "account_locked", AccountLockedEmail{Until: until})
 	if err != nil {
-		return fmt.Errorf("error sending password reset email: %v", err)
+		return fmt.Errorf("error sending account locked email: %v", err)
 	}
+	return nil
+}
 
-	fmt.Println("Password reset email sent successfully")
+// DataExportEmail is the data of the data_export email.
+type DataExportEmail struct {
+	Link    string
+	Expires time.Time
+}
 
+// SendDataExportEmail sends the user the download link of their data export.
+func SendDataExportEmail(email, lang, link string, expires time.Time) error {
+	err := sendTemplate(email, lang, "data_export", DataExportEmail{Link: link, Expires: expires})
+	if err != nil {
+		return fmt.Errorf("error sending data export email: %v", err)
+	}
 	return nil
 }
 
-func ResendVerificationEmail(email string) error {
-	_, err := FirebaseAuth.GetUserByEmail(context.Background(), email)
+// DueDose is a dose in a vaccination reminder that is due soon.
+type DueDose struct {
+	Name string
+	On   time.Time // Zero when the dose is due now
+}
+
+// VaccinationReminderEmail is the data of the vaccination_reminder email.
+type VaccinationReminderEmail struct {
+	Child   string // Empty when the child has no name
+	Due     []DueDose
+	Overdue []string
+}
+
+// SendVaccinationReminderEmail reminds a parent of the doses their child is
+// due for soon and the doses that are overdue.
+func SendVaccinationReminderEmail(email, lang string, r VaccinationReminderEmail) error {
+	err := sendTemplate(email, lang, "vaccination_reminder", r)
 	if err != nil {
-		return fmt.Errorf("error fetching user data: %v", err)
+		return fmt.Errorf("error sending vaccination reminder email: %v", err)
 	}
+	return nil
+}
 
-	// Generate email verification link with settings
-	settings := &auth.ActionCodeSettings{
-		URL:             "https://wegrowparenting.com",
-		HandleCodeInApp: true,
+// DigestItem is one entry of the weekly digest.
+type DigestItem struct {
+	Title  string
+	Text   string
+	Author string
+}
+
+// DigestEmail is the data of the digest email. Empty sections are left out.
+type DigestEmail struct {
+	Username        string
+	Comments        []DigestItem // Authored by the commenter; the title is the post's
+	MoreComments    int          // New comments left out of Comments
+	TopPosts        []DigestItem
+	Videos          []DigestItem // Authored by the creator
+	Tip             *DigestItem
+	Contest         *DigestItem
+	AppURL          string
+	UnsubscribeLink string
+}
+
+// SendDigestEmail sends the weekly digest of community activity.
+func SendDigestEmail(email, lang string, d DigestEmail) error {
+	if d.AppURL == "" {
+		d.AppURL = AppBaseURL()
 	}
-	link, err := FirebaseAuth.EmailVerificationLinkWithSettings(context.Background(), email, settings)
-	if err != nil {
-		return fmt.Errorf("error generating email verification link: %v", err)
-	}
-	// Log the verification link
-	fmt.Printf("Verification link for user %s: %s\n", email, link)
-
-	// Construct the email body with the new content
-	body := fmt.Sprintf(`
-		<p>To get started, please confirm your email address by clicking the button below:</p>
-		<p><a href="%s">🔗 Verify Your Email</a></p>
-		<p>Once your email is verified, you’ll gain full access to our platform and resources.</p>
-		<p>Best regards,</p>
-		<p>The We Grow Team</>
-	`, link)
-
-	// Call the sendEmail function to send the email
-	err = SendEmail(email, "Confirm Your Email ID", body)
+	err := sendTemplate(email, lang, "digest", d)
 	if err != nil {
-		return fmt.Errorf("error sending verification email: %v", err)
+		return fmt.Errorf("error sending digest email: %v", err)
 	}
-
-	fmt.Println("Verification email sent successfully")
 	return nil
 }
+
+// PreviewEmail renders the email template name in lang with sample data.
+func PreviewEmail(name, lang string) (mail.Message, error) {
+	link := AppBaseURL() + "/example?token=preview"
+	week := time.Now().Add(7 * 24 * time.Hour)
+	samples := map[string]interface{}{
+		"verify_email":   LinkEmail{Link: link},
+		"password_reset": LinkEmail{Link: link},
+		"account_locked": AccountLockedEmail{Until: time.Now().Add(15 * time.Minute)},
+		"data_export":    DataExportEmail{Link: link, Expires: time.Now().Add(48 * time.Hour)},
+		"vaccination_reminder": VaccinationReminderEmail{
+			Child:   "Aarav",
+			Due:     []DueDose{{Name: "OPV (1)"}, {Name: "Pentavalent (1)", On: week}},
+			Overdue: []string{"BCG"},
+		},
+		"digest": DigestEmail{
+			Username:        "asha",
+			Comments:        []DigestItem{{Title: "Naps at 6 months", Text: "We moved to two naps and it helped a lot.", Author: "ravi"}},
+			MoreComments:    2,
+			TopPosts:        []DigestItem{{Title: "Weaning foods that worked for us", Text: "Ragi porridge was a hit…", Author: "meera"}},
+			Videos:          []DigestItem{{Title: "Tummy time basics", Author: "Dr. Rao"}},
+			Tip:             &DigestItem{Title: "Read aloud", Text: "Ten minutes of reading a day builds vocabulary."},
+			Contest:         &DigestItem{Title: "Monsoon photo contest", Text: "Share your little one's best monsoon moment."},
+			AppURL:          AppBaseURL(),
+			UnsubscribeLink: link,
+		},
+	}
+	data, ok := samples[name]
+	if !ok {
+		return mail.Message{}, mail.ErrUnknownTemplate
+	}
+	return mail.Render("preview@example.com", lang, name, data)
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// Password length limits. bcrypt ignores everything past 72 bytes.
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+// ValidatePassword checks a new password against the strength policy and
+// returns an error describing the first rule it breaks.
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return errors.New("Password must be at least 8 characters long")
+	}
+	if len(password) > MaxPasswordLength {
+		return errors.New("Password must be at most 72 bytes long")
+	}
+	if strings.TrimSpace(password) != password {
+		return errors.New("Password must not start or end with whitespace")
+	}
+
+	var hasLetter, hasDigit bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLetter(c):
+			hasLetter = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return errors.New("Password must contain at least one letter and one digit")
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// usernamePattern allows 3 to 20 lowercase letters, digits and underscores,
+// starting with a letter or digit. Dots are left out because usernames are
+// used as database keys.
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_]{2,19}$`)
+
+// reservedUsernames may not be claimed by anyone. Underscores are ignored
+// when matching, so "we_grow" is reserved too.
+var reservedUsernames = map[string]bool{
+	"admin":     true,
+	"wegrow":    true,
+	"moderator": true,
+}
+
+// NormalizeUsername returns the canonical, lowercase form of username that is
+// stored in profiles and the usernames index, or an error describing why it
+// is not allowed.
+func NormalizeUsername(username string) (string, error) {
+	name := strings.ToLower(strings.TrimSpace(username))
+	if !usernamePattern.MatchString(name) {
+		return "", errors.New("Username must be 3-20 characters of letters, digits or underscores")
+	}
+	if reservedUsernames[strings.ReplaceAll(name, "_", "")] {
+		return "", errors.New("Username is reserved")
+	}
+	return name, nil
+}
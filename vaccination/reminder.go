@@ -0,0 +1,164 @@
+package vaccination
+
+import (
+	"backend/model"
+	"backend/notify"
+	"backend/repository"
+	"backend/utils"
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Reminder kinds, recorded per dose so that each is sent once.
+const (
+	KindDue     = "due"
+	KindOverdue = "overdue"
+)
+
+// DefaultRemindBefore is how long before its due date a dose is announced.
+const DefaultRemindBefore = 7 * 24 * time.Hour
+
+// batchSize is the number of parents read per batch.
+const batchSize = 100
+
+// pollInterval is how often the worker looks for reminders to send.
+const pollInterval = time.Hour
+
+// Worker sends vaccination reminders. Sent reminders are stored with the
+// child, so a restart never repeats them.
+type Worker struct {
+	repos    *repository.Repositories
+	schedule *Schedule
+	notifier *notify.Dispatcher
+	// RemindBefore is how long before its due date a dose is announced.
+	RemindBefore time.Duration
+	// Now returns the current time; tests may replace it.
+	Now func() time.Time
+}
+
+// NewWorker returns a Worker that reminds parents of the doses in schedule
+// through the channels they enabled for reminders.
+func NewWorker(repos *repository.Repositories, schedule *Schedule, notifier *notify.Dispatcher) *Worker {
+	return &Worker{
+		repos:        repos,
+		schedule:     schedule,
+		notifier:     notifier,
+		RemindBefore: DefaultRemindBefore,
+		Now:          time.Now,
+	}
+}
+
+// Run sends reminders every pollInterval until ctx is cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		w.runOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) runOnce(ctx context.Context) {
+	cursor := ""
+	for {
+		page, err := w.repos.Children.ListAfter(ctx, cursor, batchSize)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list children for vaccination reminders", "error", err)
+			return
+		}
+		for _, family := range page {
+			for id, child := range family.Children {
+				if ctx.Err() != nil {
+					return
+				}
+				if err := w.remind(ctx, family.UID, id, child); err != nil {
+					slog.WarnContext(ctx, "Failed to send vaccination reminder, will retry", "child_id", id, "error", err)
+				}
+			}
+		}
+		if len(page) < batchSize {
+			return
+		}
+		cursor = page[len(page)-1].UID
+	}
+}
+
+// remind sends one reminder listing every dose of a child that became due
+// soon or overdue since the last one.
+func (w *Worker) remind(ctx context.Context, uid, childID string, child model.Child) error {
+	given, err := w.repos.Vaccines.Given(ctx, uid, childID)
+	if err != nil {
+		return err
+	}
+	sent, err := w.repos.Vaccines.Reminders(ctx, uid, childID)
+	if err != nil {
+		return err
+	}
+
+	now := w.Now()
+	var due, overdue []string
+	email := utils.VaccinationReminderEmail{Child: child.Name}
+	marks := make(map[string]int64)
+	for _, e := range w.schedule.Plan(child.DOB, given, now) {
+		switch {
+		case e.Status == StatusOverdue && sent[e.ID][KindOverdue] == 0:
+			overdue = append(overdue, e.Name())
+			email.Overdue = append(email.Overdue, e.Name())
+			marks[e.ID+"/"+KindOverdue] = now.Unix()
+		case (e.Status == StatusDue || e.Status == StatusUpcoming) && sent[e.ID][KindDue] == 0 &&
+			e.DueOn.Sub(now) <= w.RemindBefore:
+			if e.Status == StatusDue {
+				due = append(due, e.Name()+" now")
+				email.Due = append(email.Due, utils.DueDose{Name: e.Name()})
+			} else {
+				due = append(due, e.Name()+" on "+e.DueOn.Format("2 Jan"))
+				email.Due = append(email.Due, utils.DueDose{Name: e.Name(), On: e.DueOn.Time})
+			}
+			marks[e.ID+"/"+KindDue] = now.Unix()
+		}
+	}
+	if len(marks) == 0 {
+		return nil
+	}
+
+	name := child.Name
+	if name == "" {
+		name = "your child"
+	}
+	if err := w.send(ctx, uid, name, due, overdue, email); err != nil {
+		return err
+	}
+	return w.repos.Vaccines.MarkReminded(ctx, uid, childID, marks)
+}
+
+// send delivers a reminder to the parent.
+func (w *Worker) send(ctx context.Context, uid, name string, due, overdue []string, email utils.VaccinationReminderEmail) error {
+	_, err := w.notifier.Send(ctx, uid, notify.Message{
+		Category: model.CategoryReminders,
+		Type:     model.NotificationVaccination,
+		Title:    "Vaccination reminder for " + name,
+		Body:     summary(due, overdue),
+		Email: func(to, lang string) error {
+			return utils.SendVaccinationReminderEmail(to, lang, email)
+		},
+	})
+	return err
+}
+
+// summary returns the body of the notification.
+func summary(due, overdue []string) string {
+	var parts []string
+	if len(due) > 0 {
+		parts = append(parts, "Due soon: "+strings.Join(due, ", ")+".")
+	}
+	if len(overdue) > 0 {
+		parts = append(parts, "Overdue: "+strings.Join(overdue, ", ")+".")
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,184 @@
+// Package vaccination builds personal immunization schedules from a national
+// schedule file and reminds parents of upcoming and overdue doses.
+//
+// The schedule file (data/vaccines.json by default) lists every dose with
+// the age it is due at and the age after which it counts as overdue, written
+// as a number and a unit: "0d", "6w", "9m" or "5y".
+package vaccination
+
+import (
+	"backend/model"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Statuses of a dose for a given child.
+const (
+	StatusGiven    = "given"
+	StatusOverdue  = "overdue"  // Past its overdue age and not given
+	StatusDue      = "due"      // Between its due and overdue ages
+	StatusUpcoming = "upcoming" // Not due yet
+)
+
+// Age is an age in days, weeks, months or years.
+type Age struct {
+	N    int
+	Unit byte // 'd', 'w', 'm' or 'y'
+}
+
+var agePattern = regexp.MustCompile(`^(\d+)([dwmy])$`)
+
+// ParseAge parses an age such as "6w".
+func ParseAge(s string) (Age, error) {
+	m := agePattern.FindStringSubmatch(s)
+	if m == nil {
+		return Age{}, fmt.Errorf("invalid age %q, expected a number followed by d, w, m or y", s)
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return Age{}, err
+	}
+	return Age{N: n, Unit: m[2][0]}, nil
+}
+
+// String returns the age in the form accepted by ParseAge.
+func (a Age) String() string {
+	return strconv.Itoa(a.N) + string(a.Unit)
+}
+
+// From returns the date a child born on dob reaches the age.
+func (a Age) From(dob model.Date) model.Date {
+	switch a.Unit {
+	case 'w':
+		return model.Date{Time: dob.AddDate(0, 0, 7*a.N)}
+	case 'm':
+		return model.Date{Time: dob.AddDate(0, a.N, 0)}
+	case 'y':
+		return model.Date{Time: dob.AddDate(a.N, 0, 0)}
+	}
+	return model.Date{Time: dob.AddDate(0, 0, a.N)}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (a Age) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (a *Age) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseAge(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// Dose is one dose of the national schedule.
+type Dose struct {
+	ID          string `json:"id"`
+	Vaccine     string `json:"vaccine"`
+	Dose        string `json:"dose"` // e.g. "Dose 1" or "Booster"
+	Due         Age    `json:"due"`
+	Overdue     Age    `json:"overdue"`
+	Description string `json:"description,omitempty"`
+}
+
+// Name returns the vaccine and dose, e.g. "OPV (Dose 1)".
+func (d Dose) Name() string {
+	if d.Dose == "" {
+		return d.Vaccine
+	}
+	return d.Vaccine + " (" + d.Dose + ")"
+}
+
+// Schedule is a national immunization schedule.
+type Schedule struct {
+	Name  string `json:"name"`
+	Doses []Dose `json:"doses"`
+}
+
+// Dose returns the dose with the given ID.
+func (s *Schedule) Dose(id string) (Dose, bool) {
+	for _, d := range s.Doses {
+		if d.ID == id {
+			return d, true
+		}
+	}
+	return Dose{}, false
+}
+
+// Load reads and validates the schedule file at path.
+func Load(path string) (*Schedule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Schedule
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// Any date of birth works for comparing ages
+	dob := model.Date{Time: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	seen := make(map[string]bool, len(s.Doses))
+	for i, d := range s.Doses {
+		switch {
+		case d.ID == "":
+			return nil, fmt.Errorf("%s: dose %d has no id", path, i)
+		case seen[d.ID]:
+			return nil, fmt.Errorf("%s: duplicate dose id %q", path, d.ID)
+		case d.Vaccine == "":
+			return nil, fmt.Errorf("%s: dose %q has no vaccine", path, d.ID)
+		case d.Due.Unit == 0 || d.Overdue.Unit == 0:
+			return nil, fmt.Errorf("%s: dose %q needs due and overdue ages", path, d.ID)
+		case d.Overdue.From(dob).Before(d.Due.From(dob).Time):
+			return nil, fmt.Errorf("%s: dose %q is overdue before it is due", path, d.ID)
+		}
+		seen[d.ID] = true
+	}
+	return &s, nil
+}
+
+// Entry is a dose of a child's personal schedule.
+type Entry struct {
+	Dose
+	DueOn     model.Date  `json:"due_on"`
+	OverdueOn model.Date  `json:"overdue_on"`
+	Status    string      `json:"status"`
+	GivenOn   *model.Date `json:"given_on,omitempty"`
+}
+
+// Plan returns the personal schedule of a child born on dob as of now, in
+// the order of the schedule file. given is keyed by dose ID.
+func (s *Schedule) Plan(dob model.Date, given map[string]model.Vaccination, now time.Time) []Entry {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	entries := make([]Entry, len(s.Doses))
+	for i, d := range s.Doses {
+		e := Entry{Dose: d, DueOn: d.Due.From(dob), OverdueOn: d.Overdue.From(dob)}
+		if v, ok := given[d.ID]; ok {
+			givenOn := v.GivenOn
+			e.Status = StatusGiven
+			e.GivenOn = &givenOn
+		} else {
+			switch {
+			case today.After(e.OverdueOn.Time):
+				e.Status = StatusOverdue
+			case !today.Before(e.DueOn.Time):
+				e.Status = StatusDue
+			default:
+				e.Status = StatusUpcoming
+			}
+		}
+		entries[i] = e
+	}
+	return entries
+}